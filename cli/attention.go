@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"time"
+)
+
+const (
+	attentionModeRworkspaces = "rworkspaces"
+	attentionModeCommand     = "command"
+	attentionModeDisabled    = "disabled"
+
+	defaultRworkspacesSocket = "/tmp/rlocal/rworkspaces/sock"
+	attentionID              = "wacli"
+	attentionCommandTimeout  = 10 * time.Second
+)
+
+// AttentionNotifier draws the user's attention when a message is broadcast.
+type AttentionNotifier interface {
+	Notify(msg *Message) error
+}
+
+func newAttentionNotifier(config Config) AttentionNotifier {
+	switch config.AttentionMode {
+	case attentionModeRworkspaces:
+		return &rworkspacesNotifier{socketPath: config.AttentionSocket}
+	case attentionModeCommand:
+		if config.AttentionCommand == "" {
+			fmt.Fprintf(os.Stderr, "ATTENTION_MODE=command without ATTENTION_COMMAND, attention disabled\n")
+			return nil
+		}
+		return &commandNotifier{command: config.AttentionCommand}
+	case attentionModeDisabled:
+		return nil
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown ATTENTION_MODE %q, attention disabled\n", config.AttentionMode)
+		return nil
+	}
+}
+
+// rworkspacesNotifier asks rworkspaces to flag the wacli-tui window.
+type rworkspacesNotifier struct {
+	socketPath string
+}
+
+func (n *rworkspacesNotifier) Notify(msg *Message) error {
+	conn, err := net.Dial("unix", n.socketPath)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	payload := map[string]interface{}{
+		"id":      attentionID,
+		"command": []string{"toggle-window", "show", "wacli-tui"},
+	}
+	data, _ := json.Marshal(payload)
+	_, err = conn.Write([]byte(fmt.Sprintf("add_attention_by_cmd %s", data)))
+	if err != nil {
+		return err
+	}
+
+	// Read server response before closing to avoid ConnectionResetError on server
+	buf := make([]byte, 256)
+	conn.Read(buf)
+	return nil
+}
+
+// commandNotifier runs a shell command with the message JSON on stdin.
+type commandNotifier struct {
+	command string
+}
+
+func (n *commandNotifier) Notify(msg *Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), attentionCommandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", n.command)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
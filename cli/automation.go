@@ -0,0 +1,94 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+const automationStoppedKey = "automation_stopped_at"
+
+var errAutomationStopped = errors.New("automated sending is stopped, send resume_automation to re-enable it")
+
+// privilegedActions need a token without chat restrictions, and must be
+// listed explicitly on tokens restricted to certain actions.
+var privilegedActions = map[string]bool{
+	"panic_stop":        true,
+	"resume_automation": true,
+	"autoreply":         true,
+	"merge_chats":       true,
+	"post_status":       true,
+	"status_views":      true,
+	"set_avatar":        true,
+	"set_name":          true,
+	"set_about":         true,
+	"join_via_link":     true,
+	"accept_invite":     true,
+}
+
+// AutomationState reports whether the kill switch is engaged.
+type AutomationState struct {
+	Stopped   bool  `json:"stopped"`
+	StoppedAt int64 `json:"stopped_at,omitempty"`
+}
+
+// loadAutomationState restores the kill switch, so a restart doesn't
+// re-enable a bot that was stopped for misbehaving.
+func (a *App) loadAutomationState() error {
+	var value string
+	err := a.msgDB.QueryRow("SELECT value FROM daemon_state WHERE key = ?", automationStoppedKey).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	stoppedAt, _ := strconv.ParseInt(value, 10, 64)
+	a.automationStoppedAt.Store(stoppedAt)
+	return nil
+}
+
+func (a *App) automationState() AutomationState {
+	stoppedAt := a.automationStoppedAt.Load()
+	return AutomationState{Stopped: stoppedAt != 0, StoppedAt: stoppedAt}
+}
+
+// automationAllowed is checked by everything that sends without a user
+// asking for it, right before sending. Receiving is never affected.
+func (a *App) automationAllowed(source string) error {
+	if a.automationStoppedAt.Load() != 0 {
+		return fmt.Errorf("%s: %w", source, errAutomationStopped)
+	}
+	return nil
+}
+
+// setAutomationStopped engages or releases the kill switch and tells
+// socket clients about it.
+func (a *App) setAutomationStopped(stopped bool) (AutomationState, error) {
+	if !stopped {
+		if _, err := a.msgDB.Exec("DELETE FROM daemon_state WHERE key = ?", automationStoppedKey); err != nil {
+			return a.automationState(), err
+		}
+		a.automationStoppedAt.Store(0)
+		fmt.Println("Automated sending resumed")
+		a.broadcastEvent("automation_resumed", a.automationState())
+		return a.automationState(), nil
+	}
+
+	// Engage in memory first: stopping must work even if the database
+	// can't be written
+	stoppedAt := time.Now().Unix()
+	if !a.automationStoppedAt.CompareAndSwap(0, stoppedAt) {
+		return a.automationState(), nil
+	}
+	fmt.Fprintln(os.Stderr, "Automated sending stopped by panic_stop")
+	a.broadcastEvent("automation_stopped", a.automationState())
+
+	_, err := a.msgDB.Exec(
+		"INSERT OR REPLACE INTO daemon_state (key, value) VALUES (?, ?)",
+		automationStoppedKey, strconv.FormatInt(stoppedAt, 10),
+	)
+	return a.automationState(), err
+}
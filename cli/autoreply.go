@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+const autoReplyStateKey = "autoreply"
+
+// AutoReplyState is the away mode. Text is a text/template executed with
+// the incoming message's fields plus Until; a zero Until never expires.
+type AutoReplyState struct {
+	Enabled bool   `json:"enabled"`
+	Text    string `json:"text"`
+	Until   int64  `json:"until,omitempty"`
+}
+
+// autoReplier holds the away mode state. Sending happens under mu so two
+// quick messages from one chat get a single reply.
+type autoReplier struct {
+	mu    sync.Mutex
+	state AutoReplyState
+	tmpl  *template.Template
+}
+
+// autoReplyData is what the template sees: {{.SenderName}}, {{.ChatName}},
+// {{.Text}} and the other message fields, and {{.Until}} as "15:04" or
+// "Mon 15:04" when the away mode ends on a later day.
+type autoReplyData struct {
+	*Message
+	Until string
+}
+
+// loadAutoReply restores the away mode set through the socket, falling
+// back to AUTOREPLY_TEXT.
+func (a *App) loadAutoReply() error {
+	var value string
+	err := a.msgDB.QueryRow("SELECT value FROM daemon_state WHERE key = ?", autoReplyStateKey).Scan(&value)
+	state := AutoReplyState{Enabled: a.cfg().AutoReplyText != "", Text: a.cfg().AutoReplyText}
+	if err == nil {
+		if err := json.Unmarshal([]byte(value), &state); err != nil {
+			return err
+		}
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+
+	tmpl, err := parseAutoReply(state.Text)
+	if err != nil {
+		return err
+	}
+	a.autoReply.state, a.autoReply.tmpl = state, tmpl
+	return nil
+}
+
+func parseAutoReply(text string) (*template.Template, error) {
+	tmpl, err := template.New("autoreply").Funcs(webhookFuncs).Funcs(sendTemplateFuncs("", time.Time{})).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse auto-reply template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// setAutoReply enables the away mode with text, or the configured text
+// when empty, for duration or until disabled. undo disables it.
+func (a *App) setAutoReply(text string, duration string, undo bool) (AutoReplyState, error) {
+	a.autoReply.mu.Lock()
+	defer a.autoReply.mu.Unlock()
+
+	state := AutoReplyState{}
+	if !undo {
+		if text == "" {
+			text = a.cfg().AutoReplyText
+		}
+		if strings.TrimSpace(text) == "" {
+			return a.autoReply.state, fmt.Errorf("autoreply needs a text, or AUTOREPLY_TEXT set")
+		}
+		state = AutoReplyState{Enabled: true, Text: text}
+		if duration != "" {
+			d, err := time.ParseDuration(duration)
+			if err != nil || d <= 0 {
+				return a.autoReply.state, fmt.Errorf("invalid duration %q", duration)
+			}
+			state.Until = time.Now().Add(d).Unix()
+		}
+	}
+
+	tmpl, err := parseAutoReply(state.Text)
+	if err != nil {
+		return a.autoReply.state, err
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return a.autoReply.state, err
+	}
+	_, err = a.msgDB.Exec("INSERT OR REPLACE INTO daemon_state (key, value) VALUES (?, ?)", autoReplyStateKey, string(data))
+	if err != nil {
+		return a.autoReply.state, err
+	}
+
+	a.autoReply.state, a.autoReply.tmpl = state, tmpl
+	return state, nil
+}
+
+func (a *App) autoReplyState() AutoReplyState {
+	a.autoReply.mu.Lock()
+	defer a.autoReply.mu.Unlock()
+	return a.autoReply.state
+}
+
+// maybeAutoReply answers an incoming message while the away mode is on,
+// once per chat within AUTOREPLY_COOLDOWN. Excluded chats and status
+// updates are never answered, and groups only when the message mentions
+// or replies to you and AUTOREPLY_GROUPS is set.
+func (a *App) maybeAutoReply(msg *Message) {
+	a.autoReply.mu.Lock()
+	defer a.autoReply.mu.Unlock()
+
+	state := a.autoReply.state
+	if !state.Enabled || (state.Until != 0 && time.Now().Unix() >= state.Until) {
+		return
+	}
+	// Stay quiet instead of failing on every message while stopped
+	if a.automationAllowed("autoreply") != nil {
+		return
+	}
+	if a.cfg().AutoReplyExclude[msg.ChatJID] {
+		return
+	}
+	jid, err := types.ParseJID(msg.ChatJID)
+	if err != nil || jid.Server == types.BroadcastServer {
+		return
+	}
+	if msg.IsGroup && !(a.cfg().AutoReplyGroups && (msg.IsMentioned || msg.IsReplyToMe)) {
+		return
+	}
+
+	var repliedAt int64
+	err = a.msgDB.QueryRow("SELECT replied_at FROM autoreplies WHERE chat_jid = ?", msg.ChatJID).Scan(&repliedAt)
+	if err != nil && err != sql.ErrNoRows {
+		fmt.Fprintf(os.Stderr, "Failed to check auto-reply cooldown: %v\n", err)
+		return
+	}
+	if time.Since(time.Unix(repliedAt, 0)) < a.cfg().AutoReplyCooldown {
+		return
+	}
+
+	data := autoReplyData{Message: msg}
+	if state.Until != 0 {
+		until := time.Unix(state.Until, 0)
+		data.Until = until.Format("15:04")
+		if until.Format("2006-01-02") != time.Now().Format("2006-01-02") {
+			data.Until = until.Format("Mon 15:04")
+		}
+	}
+	// {{name}} is whoever wrote, also in groups
+	tmpl, err := a.autoReply.tmpl.Clone()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to render auto-reply: %v\n", err)
+		return
+	}
+	tmpl.Funcs(sendTemplateFuncs(msg.SenderName, time.Now()))
+	var text bytes.Buffer
+	if err := tmpl.Execute(&text, data); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to render auto-reply: %v\n", err)
+		return
+	}
+
+	_, err = a.sendAutomated("autoreply", jid, &waE2E.Message{Conversation: proto.String(text.String())})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to send auto-reply to %s: %v\n", msg.ChatJID, err)
+		return
+	}
+	_, err = a.msgDB.Exec(
+		"INSERT OR REPLACE INTO autoreplies (chat_jid, replied_at) VALUES (?, ?)",
+		msg.ChatJID, time.Now().Unix(),
+	)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to record auto-reply: %v\n", err)
+	}
+	fmt.Printf("Auto-replied to %s\n", msg.ChatJID)
+}
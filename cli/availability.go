@@ -0,0 +1,181 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+const (
+	availabilityHistory       = 30 * 24 * time.Hour
+	availabilityLatencySample = 20
+	responsiveThreshold       = 0.5
+)
+
+// Availability estimates whether a contact is likely to answer soon. Score
+// runs from 0 to 1 and is the weighted mean of whichever signals are known:
+// presence, how recently they wrote, how fast they read your messages and
+// how much they usually write at this hour.
+type Availability struct {
+	JID              string   `json:"jid"`
+	Name             string   `json:"name"`
+	Score            float64  `json:"score"`
+	LikelyResponsive bool     `json:"likely_responsive"`
+	Online           *bool    `json:"online"`
+	LastSeen         int64    `json:"last_seen"`
+	LastMessageAt    int64    `json:"last_message_at"`
+	MedianReadDelay  *int64   `json:"median_read_delay_seconds"`
+	HourShare        *float64 `json:"hour_share"`
+	Signals          []string `json:"signals"`
+}
+
+// handlePresence remembers contacts' online state. WhatsApp only sends it
+// for contacts subscribed to, which the availability action does, and
+// while you are online yourself.
+func (a *App) handlePresence(evt *events.Presence) {
+	var lastSeen int64
+	if !evt.LastSeen.IsZero() {
+		lastSeen = evt.LastSeen.Unix()
+	}
+	if !evt.Unavailable {
+		lastSeen = time.Now().Unix()
+	}
+	_, err := a.msgDB.Exec(`
+		INSERT INTO contact_presence (jid, available, last_seen, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(jid) DO UPDATE SET available = excluded.available,
+			last_seen = MAX(last_seen, excluded.last_seen), updated_at = excluded.updated_at
+	`, evt.From.ToNonAD().String(), !evt.Unavailable, lastSeen, time.Now().Unix())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save presence: %v\n", err)
+	}
+}
+
+// availability scores a contact from what is stored about them, and
+// subscribes to their presence so later calls know whether they are online.
+func (a *App) availability(senderJID string) (*Availability, error) {
+	jid, err := types.ParseJID(senderJID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender JID: %w", err)
+	}
+	jid = jid.ToNonAD()
+	if jid.Server != types.DefaultUserServer && jid.Server != types.HiddenUserServer {
+		return nil, fmt.Errorf("availability is only known for contacts, not %s", jid)
+	}
+	// Presence arrives from now on; this call has to make do without it
+	if err := a.client.SubscribePresence(a.ctx, jid); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to subscribe to presence of %s: %v\n", jid, err)
+	}
+
+	now := time.Now()
+	result := &Availability{JID: jid.String(), Name: a.getContactName(jid), Signals: []string{}}
+	var weighted, weights float64
+	addSignal := func(name string, weight float64, score float64) {
+		result.Signals = append(result.Signals, name)
+		weighted += weight * score
+		weights += weight
+	}
+
+	var available bool
+	var lastSeen int64
+	err = a.msgDB.QueryRow("SELECT available, last_seen FROM contact_presence WHERE jid = ?", result.JID).Scan(&available, &lastSeen)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if err == nil {
+		result.Online = &available
+		result.LastSeen = lastSeen
+		if available {
+			addSignal("presence", 0.35, 1)
+		} else if lastSeen > 0 {
+			addSignal("presence", 0.35, decay(now.Sub(time.Unix(lastSeen, 0)), 10*time.Minute))
+		}
+	}
+
+	var lastMessage sql.NullInt64
+	err = a.msgDB.QueryRow(fmt.Sprintf("SELECT MAX(timestamp) FROM %s WHERE sender_jid = ?", messagesView), result.JID).Scan(&lastMessage)
+	if err != nil {
+		return nil, err
+	}
+	if lastMessage.Valid {
+		result.LastMessageAt = lastMessage.Int64
+		addSignal("recent_message", 0.25, decay(now.Sub(time.Unix(lastMessage.Int64, 0)), 30*time.Minute))
+	}
+
+	if delay, ok, err := a.medianReadDelay(result.JID); err != nil {
+		return nil, err
+	} else if ok {
+		result.MedianReadDelay = &delay
+		addSignal("read_delay", 0.15, decay(time.Duration(delay)*time.Second, 30*time.Minute))
+	}
+
+	if share, ok, err := a.hourShare(result.JID, now); err != nil {
+		return nil, err
+	} else if ok {
+		result.HourShare = &share
+		// Three hours out of 24 are an eighth of the day; writing that
+		// much or more around now counts as fully active
+		addSignal("usual_hours", 0.25, min(1, share*8))
+	}
+
+	if weights > 0 {
+		result.Score = weighted / weights
+	}
+	result.LikelyResponsive = result.Score >= responsiveThreshold
+	return result, nil
+}
+
+// decay maps how long ago something happened to 1 for just now, halving
+// every halfLife.
+func decay(ago time.Duration, halfLife time.Duration) float64 {
+	return math.Exp2(-max(0, ago.Seconds()) / halfLife.Seconds())
+}
+
+// medianReadDelay is the median time between sending a message in the
+// contact's chat and its read receipt, over your recent messages.
+func (a *App) medianReadDelay(jid string) (int64, bool, error) {
+	rows, err := a.msgDB.Query(`
+		SELECT status_at - timestamp FROM outgoing_messages
+		WHERE chat_jid = ? AND status IN ('read', 'played') AND status_at >= timestamp
+		ORDER BY timestamp DESC LIMIT ?
+	`, jid, availabilityLatencySample)
+	if err != nil {
+		return 0, false, err
+	}
+	defer rows.Close()
+
+	var delays []int64
+	for rows.Next() {
+		var delay int64
+		if err := rows.Scan(&delay); err != nil {
+			return 0, false, err
+		}
+		delays = append(delays, delay)
+	}
+	if err := rows.Err(); err != nil || len(delays) == 0 {
+		return 0, false, err
+	}
+	sort.Slice(delays, func(i, j int) bool { return delays[i] < delays[j] })
+	return delays[len(delays)/2], true, nil
+}
+
+// hourShare is the share of the contact's recent messages written within
+// an hour of the current local time of day.
+func (a *App) hourShare(jid string, now time.Time) (float64, bool, error) {
+	var total, near int
+	err := a.msgDB.QueryRow(fmt.Sprintf(`
+		SELECT COUNT(*), COALESCE(SUM(
+			((CAST(strftime('%%H', timestamp, 'unixepoch', 'localtime') AS INTEGER) - ? + 25) %% 24) <= 2
+		), 0)
+		FROM %s WHERE sender_jid = ? AND timestamp >= ?
+	`, messagesView), now.Hour(), jid, now.Add(-availabilityHistory).Unix()).Scan(&total, &near)
+	if err != nil || total == 0 {
+		return 0, false, err
+	}
+	return float64(near) / float64(total), true, nil
+}
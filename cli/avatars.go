@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+const (
+	avatarTimeout  = 30 * time.Second
+	maxAvatarBytes = 5 << 20
+	// WhatsApp shows profile pictures as squares of this size
+	avatarSize = 640
+)
+
+// Avatar is the result of get_avatar and set_avatar. Path is empty when
+// the chat has no picture or hides it from you.
+type Avatar struct {
+	ChatJID   string `json:"chat_jid"`
+	PictureID string `json:"picture_id"`
+	Path      string `json:"path"`
+	Hidden    bool   `json:"hidden,omitempty"`
+}
+
+// getAvatar downloads a contact's or group's profile picture to the media
+// directory, or its small preview. Files are named by picture ID, so an
+// unchanged picture is only downloaded once.
+func (a *App) getAvatar(chatJID string, preview bool) (*Avatar, error) {
+	jid, err := types.ParseJID(chatJID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JID: %w", err)
+	}
+	avatar := &Avatar{ChatJID: jid.String()}
+
+	info, err := a.client.GetProfilePictureInfo(a.ctx, jid, &whatsmeow.GetProfilePictureParams{Preview: preview})
+	switch {
+	case errors.Is(err, whatsmeow.ErrProfilePictureNotSet):
+		return avatar, nil
+	case errors.Is(err, whatsmeow.ErrProfilePictureUnauthorized):
+		avatar.Hidden = true
+		return avatar, nil
+	case err != nil:
+		return nil, err
+	case info == nil:
+		return avatar, nil
+	}
+	avatar.PictureID = info.ID
+
+	if !a.mediaDownloadsAllowed() {
+		return avatar, nil
+	}
+	dir := filepath.Join(a.cfg().MediaDir, "avatars")
+	path := filepath.Join(dir, fmt.Sprintf("%s_%s_%s.jpg", jid.User, jid.Server, info.ID))
+	if preview {
+		path = filepath.Join(dir, fmt.Sprintf("%s_%s_%s_preview.jpg", jid.User, jid.Server, info.ID))
+	}
+	if _, err := os.Stat(path); err == nil {
+		avatar.Path = path
+		return avatar, nil
+	}
+
+	data, _, err := fetchLimited(&http.Client{Timeout: avatarTimeout}, info.URL, maxAvatarBytes)
+	if err != nil {
+		return nil, fmt.Errorf("download failed: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, err
+	}
+	avatar.Path = path
+	return avatar, nil
+}
+
+// setAvatar replaces your own profile picture with a local image, cropped
+// to its center square and scaled with ffmpeg.
+func (a *App) setAvatar(path string) (*Avatar, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	filter := fmt.Sprintf("crop='min(iw,ih)':'min(iw,ih)',scale=%[1]d:%[1]d", avatarSize)
+	jpeg, err := a.ffmpeg("-i", path, "-vf", filter, "-frames:v", "1", "-q:v", "3", "-f", "mjpeg", "pipe:1")
+	if err != nil {
+		return nil, fmt.Errorf("convert failed: %w", err)
+	}
+
+	// An empty target is your own profile
+	id, err := a.client.SetGroupPhoto(a.ctx, types.EmptyJID, jpeg)
+	if err != nil {
+		return nil, fmt.Errorf("set avatar failed: %w", err)
+	}
+	avatar := &Avatar{PictureID: id}
+	if a.client.Store.ID != nil {
+		avatar.ChatJID = a.client.Store.ID.ToNonAD().String()
+	}
+	return avatar, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// BacklogSummary terminates a backlog replay.
+type BacklogSummary struct {
+	Messages      int   `json:"messages"`
+	Calls         int   `json:"calls"`
+	LastTimestamp int64 `json:"last_timestamp"`
+}
+
+// streamBacklog replays stored messages and calls from cmd.Since on, oldest
+// first, as the same message and call events a live client receives, then
+// answers with a BacklogSummary. Broadcasts are held back until the replay
+// is written, so a client that catches up on connect sees everything in
+// order.
+func (a *App) streamBacklog(client *socketClient, cmd *SocketCommand) {
+	client.hold()
+	defer client.release()
+
+	chats := client.tenantChats()
+	calls, err := a.backlogCalls(cmd.Since, chats)
+	if err != nil {
+		client.respondError(cmd, err)
+		return
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE timestamp >= ? ORDER BY timestamp, id",
+		strings.Join(recordColumns(&Message{}), ", "), messagesView)
+	rows, err := a.msgDB.Query(query, cmd.Since)
+	if err != nil {
+		client.respondError(cmd, err)
+		return
+	}
+	defer rows.Close()
+
+	summary := BacklogSummary{}
+	sendCallsUntil := func(ts int64) error {
+		for len(calls) > 0 && calls[0].Timestamp <= ts {
+			call := calls[0]
+			calls = calls[1:]
+			if err := client.send(SocketEvent{Type: "call", Action: cmd.Action, RequestID: cmd.RequestID, Data: &call}); err != nil {
+				return err
+			}
+			summary.Calls++
+			summary.LastTimestamp = max(summary.LastTimestamp, call.Timestamp)
+		}
+		return nil
+	}
+
+	for rows.Next() {
+		var msg Message
+		if err := scanRecord(rows, &msg); err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		if chats != nil && !containsString(chats, msg.ChatJID) {
+			continue
+		}
+		if sendCallsUntil(msg.Timestamp) != nil {
+			return
+		}
+		if client.send(SocketEvent{Type: messageEventType(&msg), Action: cmd.Action, RequestID: cmd.RequestID, Data: &msg}) != nil {
+			return
+		}
+		summary.Messages++
+		summary.LastTimestamp = max(summary.LastTimestamp, msg.Timestamp)
+	}
+	if err := rows.Err(); err != nil {
+		client.respondError(cmd, err)
+		return
+	}
+	if len(calls) > 0 && sendCallsUntil(calls[len(calls)-1].Timestamp) != nil {
+		return
+	}
+
+	client.respond(cmd, summary)
+}
+
+func (a *App) backlogCalls(since int64, chats []string) ([]Call, error) {
+	query := fmt.Sprintf("SELECT %s FROM calls WHERE timestamp >= ? ORDER BY timestamp, id",
+		strings.Join(recordColumns(&Call{}), ", "))
+	rows, err := a.msgDB.Query(query, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var calls []Call
+	for rows.Next() {
+		var call Call
+		if err := scanRecord(rows, &call); err != nil {
+			return nil, err
+		}
+		calls = append(calls, call)
+	}
+	return filterChats(calls, chats), rows.Err()
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultBotPrefix  = "!"
+	botCommandTimeout = 30 * time.Second
+	botReplyLimit     = 4000
+)
+
+// BotConfig routes incoming messages that start with Prefix to commands,
+// each run by an executable or a script's on_command. Chats and Senders
+// take JIDs or glob patterns and apply to every command, which can narrow
+// them further; empty lists allow everyone, except that exec commands must
+// be limited by one of them. RateLimit ("5/1m") caps how often one sender
+// may use the bot, or a single command.
+type BotConfig struct {
+	Prefix    string       `json:"prefix,omitempty"`
+	Chats     []string     `json:"chats,omitempty"`
+	Senders   []string     `json:"senders,omitempty"`
+	RateLimit string       `json:"rate_limit,omitempty"`
+	Commands  []BotCommand `json:"commands"`
+
+	limit  int
+	window time.Duration
+}
+
+type BotCommand struct {
+	Name      string   `json:"name"`
+	Help      string   `json:"help,omitempty"`
+	Exec      []string `json:"exec,omitempty"`
+	Script    string   `json:"script,omitempty"`
+	Chats     []string `json:"chats,omitempty"`
+	Senders   []string `json:"senders,omitempty"`
+	RateLimit string   `json:"rate_limit,omitempty"`
+
+	limit  int
+	window time.Duration
+}
+
+// botLimiter remembers when each sender last used the bot, for the rate
+// limits.
+type botLimiter struct {
+	mu   sync.Mutex
+	uses map[string][]time.Time
+}
+
+func loadBotConfig(path string) (*BotConfig, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var bot BotConfig
+	if err := json.Unmarshal(data, &bot); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	if bot.Prefix == "" {
+		bot.Prefix = defaultBotPrefix
+	}
+	if err := validatePatterns(append(bot.Chats, bot.Senders...)); err != nil {
+		return nil, err
+	}
+	if bot.limit, bot.window, err = parseBotRateLimit(bot.RateLimit); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for i := range bot.Commands {
+		c := &bot.Commands[i]
+		c.Name = strings.ToLower(c.Name)
+		if c.Name == "" || strings.ContainsAny(c.Name, " \t\n") {
+			return nil, fmt.Errorf("bot command %d needs a name without spaces", i+1)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("duplicate bot command %q", c.Name)
+		}
+		names[c.Name] = true
+		if (len(c.Exec) == 0) == (c.Script == "") {
+			return nil, fmt.Errorf("bot command %q needs either exec or script", c.Name)
+		}
+		if err := validatePatterns(append(c.Chats, c.Senders...)); err != nil {
+			return nil, fmt.Errorf("bot command %q: %w", c.Name, err)
+		}
+		// Anyone who can message the account would otherwise be able to
+		// run the executable with arguments of their choosing
+		if len(c.Exec) > 0 && len(bot.Chats)+len(bot.Senders)+len(c.Chats)+len(c.Senders) == 0 {
+			return nil, fmt.Errorf("bot command %q runs an executable and needs chats or senders", c.Name)
+		}
+		if c.limit, c.window, err = parseBotRateLimit(c.RateLimit); err != nil {
+			return nil, fmt.Errorf("bot command %q: %w", c.Name, err)
+		}
+	}
+	return &bot, nil
+}
+
+// parseBotRateLimit is parseRateLimit, but a malformed limit is an error
+// rather than no limit.
+func parseBotRateLimit(value string) (int, time.Duration, error) {
+	if value == "" {
+		return 0, 0, nil
+	}
+	limit, window := parseRateLimit(value)
+	if limit == 0 {
+		return 0, 0, fmt.Errorf("invalid rate_limit %q, want e.g. 5/1m", value)
+	}
+	return limit, window, nil
+}
+
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// matchesAny reports whether jid matches one of the patterns, or whether
+// there are none.
+func matchesAny(patterns []string, jid string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, jid); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *BotCommand) allowed(msg *Message) bool {
+	return matchesAny(c.Chats, msg.ChatJID) && matchesAny(c.Senders, msg.SenderJID)
+}
+
+// allow records a use of the bot by key and reports whether it stays
+// within limit uses per window.
+func (l *botLimiter) allow(key string, limit int, window time.Duration) bool {
+	if limit == 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.uses == nil {
+		l.uses = make(map[string][]time.Time)
+	}
+	now := time.Now()
+	recent := l.uses[key][:0]
+	for _, t := range l.uses[key] {
+		if now.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= limit {
+		l.uses[key] = recent
+		return false
+	}
+	l.uses[key] = append(recent, now)
+	return true
+}
+
+// handleBotCommand runs the command an incoming message asks for and
+// replies with its output. Messages from chats or senders the bot doesn't
+// serve, over a rate limit or while the kill switch is engaged are
+// ignored without a word, so strangers can't make the bot talk.
+func (a *App) handleBotCommand(msg *Message) {
+	bot := a.currentBot()
+	if bot == nil || !strings.HasPrefix(msg.Text, bot.Prefix) {
+		return
+	}
+	args := strings.Fields(strings.TrimPrefix(msg.Text, bot.Prefix))
+	if len(args) == 0 || !matchesAny(bot.Chats, msg.ChatJID) || !matchesAny(bot.Senders, msg.SenderJID) {
+		return
+	}
+	if a.automationAllowed("bot") != nil {
+		return
+	}
+	name := strings.ToLower(args[0])
+	args = args[1:]
+
+	var command *BotCommand
+	for i := range bot.Commands {
+		if bot.Commands[i].Name == name {
+			command = &bot.Commands[i]
+		}
+	}
+	if command == nil && name != "help" {
+		return
+	}
+	if command != nil && !command.allowed(msg) {
+		return
+	}
+	if !a.botLimiter.allow(msg.SenderJID, bot.limit, bot.window) {
+		fmt.Fprintf(os.Stderr, "Bot rate limit reached by %s\n", msg.SenderJID)
+		return
+	}
+	if command != nil && !a.botLimiter.allow(name+" "+msg.SenderJID, command.limit, command.window) {
+		fmt.Fprintf(os.Stderr, "Bot rate limit for %s reached by %s\n", name, msg.SenderJID)
+		return
+	}
+
+	var reply string
+	var err error
+	switch {
+	case command == nil:
+		reply = botHelp(bot, msg)
+	case command.Script != "":
+		reply, err = a.runScriptCommand(command.Script, name, args, msg)
+	default:
+		reply, err = runBotExec(command, args, msg)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Bot command %s failed: %v\n", name, err)
+		reply = fmt.Sprintf("%s%s failed", bot.Prefix, name)
+	}
+	reply = strings.TrimSpace(reply)
+	if reply == "" {
+		return
+	}
+	if runes := []rune(reply); len(runes) > botReplyLimit {
+		reply = string(runes[:botReplyLimit-3]) + "..."
+	}
+
+	jid, err := types.ParseJID(msg.ChatJID)
+	if err != nil {
+		return
+	}
+	_, err = a.sendAutomated("bot:"+name, jid, &waE2E.Message{ExtendedTextMessage: &waE2E.ExtendedTextMessage{
+		Text: proto.String(reply),
+		ContextInfo: &waE2E.ContextInfo{
+			StanzaID:    proto.String(msg.MessageID),
+			Participant: proto.String(msg.SenderJID),
+		},
+	}})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to send bot reply to %s: %v\n", msg.ChatJID, err)
+	}
+}
+
+// botHelp lists the commands the sender may use in the chat.
+func botHelp(bot *BotConfig, msg *Message) string {
+	var lines []string
+	for i := range bot.Commands {
+		c := &bot.Commands[i]
+		if !c.allowed(msg) {
+			continue
+		}
+		line := bot.Prefix + c.Name
+		if c.Help != "" {
+			line += " - " + c.Help
+		}
+		lines = append(lines, line)
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// runBotExec runs an exec command with the arguments appended, without a
+// shell, and the message as JSON on stdin. Its output is the reply.
+func runBotExec(command *BotCommand, args []string, msg *Message) (string, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), botCommandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command.Exec[0], append(command.Exec[1:], args...)...)
+	cmd.Env = []string{"WACLI_COMMAND=" + command.Name, "WACLI_CHAT_JID=" + msg.ChatJID, "WACLI_SENDER_JID=" + msg.SenderJID}
+	for _, key := range pluginEnv {
+		if value, ok := os.LookupEnv(key); ok {
+			cmd.Env = append(cmd.Env, key+"="+value)
+		}
+	}
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	return string(output), err
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// errChatCapped refuses a bulk send to a chat that already got
+// SEND_CHAT_LIMIT messages within the window.
+var errChatCapped = errors.New("per-chat send limit reached")
+
+// BulkSendRow reports one recipient of a send_bulk.
+type BulkSendRow struct {
+	ChatJID   string `json:"chat_jid"`
+	MessageID string `json:"message_id,omitempty"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+// BulkSendResult terminates a send_bulk.
+type BulkSendResult struct {
+	Sent   int `json:"sent"`
+	Queued int `json:"queued"`
+	Failed int `json:"failed"`
+}
+
+// parseRateLimit parses "N/duration" such as "10/1h". Anything else
+// disables the limit.
+func parseRateLimit(value string) (int, time.Duration) {
+	count, window, ok := strings.Cut(value, "/")
+	if !ok {
+		return 0, 0
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(count))
+	if err != nil || n <= 0 {
+		return 0, 0
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(window))
+	if err != nil || d <= 0 {
+		return 0, 0
+	}
+	return n, d
+}
+
+// checkChatCap applies SEND_CHAT_LIMIT, counting every message recorded as
+// sent to the chat within the window, from any device.
+func (a *App) checkChatCap(jid types.JID) error {
+	if a.cfg().SendChatLimit == 0 {
+		return nil
+	}
+	var sent int
+	err := a.msgDB.QueryRow(
+		"SELECT COUNT(*) FROM outgoing_messages WHERE chat_jid = ? AND timestamp >= ? AND status != ?",
+		a.canonicalChat(jid.String()), time.Now().Add(-a.cfg().SendChatWindow).Unix(), outgoingStatusFailed,
+	).Scan(&sent)
+	if err != nil {
+		return err
+	}
+	if sent >= a.cfg().SendChatLimit {
+		return fmt.Errorf("%w: %d in %s", errChatCapped, sent, a.cfg().SendChatWindow)
+	}
+	return nil
+}
+
+// streamBulkSend sends the same text to every chat in cmd.Chats, one after
+// the other in the bulk lane of the paced queue, writing a "row" per
+// recipient as it goes and a BulkSendResult at the end. It needs pacing
+// configured, since an unpaced fan-out is what gets accounts flagged.
+func (a *App) streamBulkSend(client *socketClient, cmd *SocketCommand) {
+	if a.sendQueue == nil {
+		client.respondError(cmd, fmt.Errorf("send_bulk needs SEND_INTERVAL to pace the sends"))
+		return
+	}
+	if len(cmd.Chats) == 0 || strings.TrimSpace(cmd.Text) == "" {
+		client.respondError(cmd, fmt.Errorf("send_bulk requires chats and text"))
+		return
+	}
+	chats := make([]string, len(cmd.Chats))
+	for i, chat := range cmd.Chats {
+		chats[i] = normalizeJID(chat)
+	}
+	if tenant := client.tenantChats(); tenant != nil {
+		for _, chat := range chats {
+			if !containsString(tenant, chat) {
+				client.respondError(cmd, fmt.Errorf("token %q may not access %s", client.token.Load().Name, chat))
+				return
+			}
+		}
+	}
+
+	var result BulkSendResult
+	for _, chat := range chats {
+		row := BulkSendRow{ChatJID: chat, Status: outgoingStatusSent}
+		text, err := a.renderSendText(cmd, chat)
+		var id types.MessageID
+		if err == nil {
+			id, err = a.sendMessage(chat, text, nil, priorityBulk)
+			a.logSent(client, cmd, chat, text, id, err)
+		}
+		var queued *queuedOfflineError
+		switch {
+		case errors.As(err, &queued):
+			row.MessageID, row.Status = queued.id, "queued"
+			result.Queued++
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Failed to send bulk message to %s: %v\n", chat, err)
+			row.Status, row.Error = outgoingStatusFailed, err.Error()
+			result.Failed++
+		default:
+			row.MessageID = id
+			result.Sent++
+		}
+		if client.send(SocketEvent{Type: "row", Action: cmd.Action, RequestID: cmd.RequestID, Data: row}) != nil {
+			return
+		}
+	}
+	client.respond(cmd, result)
+}
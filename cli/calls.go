@@ -3,9 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
-	"strings"
 
-	"go.mau.fi/whatsmeow/types"
 	"go.mau.fi/whatsmeow/types/events"
 )
 
@@ -24,17 +22,14 @@ func (a *App) handleCallOffer(evt *events.CallOffer) {
 	isGroup := !evt.BasicCallMeta.GroupJID.IsEmpty()
 	groupName := ""
 	if isGroup {
-		groupInfo, err := a.client.GetGroupInfo(a.ctx, evt.BasicCallMeta.GroupJID)
-		if err == nil {
-			groupName = groupInfo.Name
-		}
+		groupName = a.groupName(evt.BasicCallMeta.GroupJID)
 	}
 
 	call := &Call{
 		Timestamp:  evt.BasicCallMeta.Timestamp.Unix(),
 		CallID:     evt.BasicCallMeta.CallID,
 		CallerJID:  evt.BasicCallMeta.From.String(),
-		CallerName: a.getCallerName(evt.BasicCallMeta.From),
+		CallerName: a.getContactName(evt.BasicCallMeta.From),
 		IsGroup:    isGroup,
 		GroupJID:   evt.BasicCallMeta.GroupJID.String(),
 		GroupName:  groupName,
@@ -51,17 +46,14 @@ func (a *App) handleCallOfferNotice(evt *events.CallOfferNotice) {
 	isGroup := !evt.BasicCallMeta.GroupJID.IsEmpty()
 	groupName := ""
 	if isGroup {
-		groupInfo, err := a.client.GetGroupInfo(a.ctx, evt.BasicCallMeta.GroupJID)
-		if err == nil {
-			groupName = groupInfo.Name
-		}
+		groupName = a.groupName(evt.BasicCallMeta.GroupJID)
 	}
 
 	call := &Call{
 		Timestamp:  evt.BasicCallMeta.Timestamp.Unix(),
 		CallID:     evt.BasicCallMeta.CallID,
 		CallerJID:  evt.BasicCallMeta.From.String(),
-		CallerName: a.getCallerName(evt.BasicCallMeta.From),
+		CallerName: a.getContactName(evt.BasicCallMeta.From),
 		IsGroup:    isGroup,
 		GroupJID:   evt.BasicCallMeta.GroupJID.String(),
 		GroupName:  groupName,
@@ -74,50 +66,6 @@ func (a *App) handleCallOfferNotice(evt *events.CallOfferNotice) {
 	a.broadcastCall(call)
 }
 
-func (a *App) getCallerName(callerJID types.JID) string {
-	contact, err := a.client.Store.Contacts.GetContact(a.ctx, callerJID)
-	if err == nil && contact.Found {
-		if contact.PushName != "" {
-			return contact.PushName
-		}
-		if contact.FullName != "" {
-			return contact.FullName
-		}
-	}
-	return callerJID.User
-}
-
 func (a *App) saveCall(call *Call) error {
-	columns, placeholders, values := buildInsertParams(call)
-	query := fmt.Sprintf(
-		"INSERT INTO calls (%s) VALUES (%s)",
-		strings.Join(columns, ", "),
-		strings.Join(placeholders, ", "),
-	)
-
-	result, err := a.msgDB.Exec(query, values...)
-	if err != nil {
-		return err
-	}
-
-	call.ID, _ = result.LastInsertId()
-
-	var count int
-	err = a.msgDB.QueryRow("SELECT COUNT(*) FROM calls").Scan(&count)
-	if err != nil {
-		return err
-	}
-
-	if count > maxMessages {
-		_, err = a.msgDB.Exec(`
-			DELETE FROM calls WHERE id NOT IN (
-				SELECT id FROM calls ORDER BY timestamp DESC LIMIT ?
-			)
-		`, trimToCount)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return a.store.SaveCall(call)
 }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// A conversation can move to another JID: WhatsApp migrates direct chats
+// from phone number to LID addressing, and contacts change their number.
+// chat_aliases maps the old JIDs onto one canonical chat JID, and merging
+// rewrites what is already stored, so history, counts and unreplied checks
+// see a single conversation.
+
+const (
+	mergeSourceLID    = "lid"
+	mergeSourceManual = "manual"
+)
+
+// ChatMerge is the result of merge_chats and the payload of chats_merged.
+type ChatMerge struct {
+	FromJID  string `json:"from_jid"`
+	ToJID    string `json:"to_jid"`
+	Source   string `json:"source"`
+	Messages int64  `json:"messages"`
+}
+
+// canonicalChat returns the JID a chat's messages are stored under.
+func (a *App) canonicalChat(jid string) string {
+	var canonical string
+	err := a.msgDB.QueryRow("SELECT chat_jid FROM chat_aliases WHERE alias_jid = ?", jid).Scan(&canonical)
+	if err != nil {
+		return jid
+	}
+	return canonical
+}
+
+// linkAddressingAlt merges a direct chat's LID and phone number JIDs once a
+// message names both, keeping the phone number JID as the canonical one.
+func (a *App) linkAddressingAlt(msg *events.Message) {
+	if msg.Info.IsGroup {
+		return
+	}
+	alt := msg.Info.SenderAlt
+	if msg.Info.IsFromMe {
+		alt = msg.Info.RecipientAlt
+	}
+	lid, pn := msg.Info.Chat, alt
+	if lid.Server == types.DefaultUserServer {
+		lid, pn = alt, lid
+	}
+	if lid.Server != types.HiddenUserServer || pn.Server != types.DefaultUserServer {
+		return
+	}
+
+	from := a.canonicalChat(lid.ToNonAD().String())
+	to := a.canonicalChat(pn.ToNonAD().String())
+	if from == to {
+		return
+	}
+	if _, err := a.mergeChats(from, to, mergeSourceLID); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to merge %s into %s: %v\n", from, to, err)
+	}
+}
+
+// mergeChats makes from an alias of to and moves everything stored under
+// from, including its own aliases, over to to.
+func (a *App) mergeChats(from string, to string, source string) (*ChatMerge, error) {
+	if a.canonicalChat(from) == a.canonicalChat(to) {
+		return nil, fmt.Errorf("%s and %s are already one chat", from, to)
+	}
+	from, to = a.canonicalChat(from), a.canonicalChat(to)
+
+	_, err := a.msgDB.Exec(`
+		INSERT INTO chat_aliases (alias_jid, chat_jid, source, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(alias_jid) DO UPDATE SET chat_jid = excluded.chat_jid, source = excluded.source, created_at = excluded.created_at
+	`, from, to, source, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := a.msgDB.Exec("UPDATE chat_aliases SET chat_jid = ? WHERE chat_jid = ?", to, from); err != nil {
+		return nil, err
+	}
+
+	moved, err := a.updateMessageTables("UPDATE %s SET chat_jid = ? WHERE chat_jid = ?", to, from)
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range []string{"outgoing_messages", "reminders", "media_keys", "polls"} {
+		query := fmt.Sprintf("UPDATE %s SET chat_jid = ? WHERE chat_jid = ?", table)
+		if _, err := a.msgDB.Exec(query, to, from); err != nil {
+			return nil, err
+		}
+	}
+	// WHERE true keeps SQLite from reading ON CONFLICT as a join constraint
+	_, err = a.msgDB.Exec(`
+		INSERT INTO chat_activity (chat_jid, last_outgoing)
+		SELECT ?, last_outgoing FROM chat_activity WHERE chat_jid = ? AND true
+		ON CONFLICT(chat_jid) DO UPDATE SET last_outgoing = MAX(last_outgoing, excluded.last_outgoing)
+	`, to, from)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := a.msgDB.Exec("DELETE FROM chat_activity WHERE chat_jid = ?", from); err != nil {
+		return nil, err
+	}
+
+	merge := &ChatMerge{FromJID: from, ToJID: to, Source: source, Messages: moved}
+	a.broadcastEvent("chats_merged", merge)
+	return merge, nil
+}
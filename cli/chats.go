@@ -0,0 +1,44 @@
+package main
+
+import "fmt"
+
+type ChatSummary struct {
+	ChatJID       string `json:"chat_jid"`
+	ChatName      string `json:"chat_name"`
+	IsGroup       bool   `json:"is_group"`
+	MessageCount  int    `json:"message_count"`
+	LastTimestamp int64  `json:"last_timestamp"`
+	LastSender    string `json:"last_sender"`
+	LastText      string `json:"last_text"`
+}
+
+// listChats summarizes every chat in the archive, most recently active first.
+func (a *App) listChats() ([]ChatSummary, error) {
+	rows, err := a.msgDB.Query(fmt.Sprintf(`
+		SELECT m.chat_jid, m.chat_name, m.is_group, c.message_count, m.timestamp, m.sender_name, m.text, m.media_type, m.file_name
+		FROM %[1]s m
+		JOIN (
+			SELECT chat_jid, COUNT(*) AS message_count, MAX(id) AS last_id
+			FROM %[1]s GROUP BY chat_jid
+		) c ON m.id = c.last_id
+		ORDER BY m.timestamp DESC
+	`, messagesView))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chats := []ChatSummary{}
+	for rows.Next() {
+		var chat ChatSummary
+		var mediaType, fileName string
+		err := rows.Scan(&chat.ChatJID, &chat.ChatName, &chat.IsGroup, &chat.MessageCount,
+			&chat.LastTimestamp, &chat.LastSender, &chat.LastText, &mediaType, &fileName)
+		if err != nil {
+			return nil, err
+		}
+		chat.LastText = labelMedia(mediaType, fileName, chat.LastText)
+		chats = append(chats, chat)
+	}
+	return chats, rows.Err()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"go.mau.fi/whatsmeow/appstate"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// ChatSettings is the synced mute, archive and pin state of a chat.
+// MutedUntil is -1 for chats muted forever.
+type ChatSettings struct {
+	ChatJID    string `json:"chat_jid"`
+	MutedUntil int64  `json:"muted_until"`
+	Archived   bool   `json:"archived"`
+	Pinned     bool   `json:"pinned"`
+}
+
+// updateChatSettings pushes an app state patch for mute_chat, archive_chat
+// or pin_chat, so the change syncs to your other devices. undo unmutes,
+// unarchives or unpins. A mute without duration lasts forever.
+func (a *App) updateChatSettings(action string, chatJID string, duration string, undo bool) (ChatSettings, error) {
+	jid, err := types.ParseJID(chatJID)
+	if err != nil {
+		return ChatSettings{}, fmt.Errorf("invalid chat JID: %w", err)
+	}
+
+	var patch appstate.PatchInfo
+	switch action {
+	case "mute_chat":
+		var muteFor time.Duration
+		if duration != "" && !undo {
+			muteFor, err = time.ParseDuration(duration)
+			if err != nil || muteFor <= 0 {
+				return ChatSettings{}, fmt.Errorf("invalid duration %q", duration)
+			}
+		}
+		patch = appstate.BuildMute(jid, !undo, muteFor)
+	case "archive_chat":
+		patch = appstate.BuildArchive(jid, !undo, time.Time{}, nil)
+	case "pin_chat":
+		patch = appstate.BuildPin(jid, !undo)
+	default:
+		return ChatSettings{}, fmt.Errorf("unknown chat setting action %s", action)
+	}
+
+	if err := a.client.SendAppState(a.ctx, patch); err != nil {
+		return ChatSettings{}, fmt.Errorf("%s failed: %w", action, err)
+	}
+	return a.chatSettings(jid)
+}
+
+func (a *App) chatSettings(jid types.JID) (ChatSettings, error) {
+	settings, err := a.client.Store.ChatSettings.GetChatSettings(a.ctx, jid)
+	if err != nil {
+		return ChatSettings{}, err
+	}
+	result := ChatSettings{
+		ChatJID:  jid.String(),
+		Archived: settings.Archived,
+		Pinned:   settings.Pinned,
+	}
+	switch {
+	case settings.MutedUntil.Equal(store.MutedForever):
+		result.MutedUntil = -1
+	case !settings.MutedUntil.IsZero():
+		result.MutedUntil = settings.MutedUntil.Unix()
+	}
+	return result, nil
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// clientEvent is a SocketEvent as read back by socket clients, with the
+// payload left raw until the caller knows its type.
+type clientEvent struct {
+	Type      string          `json:"type"`
+	Action    string          `json:"action"`
+	RequestID string          `json:"request_id"`
+	Error     string          `json:"error"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// daemonConn is a connection to a running daemon used by the thin client
+// subcommands. Until listen is called, lines are only read while waiting
+// for a response.
+type daemonConn struct {
+	conn      net.Conn
+	scanner   *bufio.Scanner
+	requests  int
+	responses chan clientEvent
+}
+
+func dialDaemon(config Config) (*daemonConn, error) {
+	var conn net.Conn
+	var err error
+	if config.Remote != "" {
+		conn, err = dialRemote(config)
+	} else if conn, err = net.Dial("unix", config.SocketPath); err != nil {
+		err = fmt.Errorf("daemon not reachable at %s: %w", config.SocketPath, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	d := &daemonConn{conn: conn, scanner: scanner}
+
+	token := os.Getenv("WACLI_TOKEN")
+	if token == "" {
+		token = config.SocketToken
+	}
+	if token != "" {
+		if _, err := d.request(SocketCommand{Action: "auth", Token: token}, nil); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+func (d *daemonConn) Close() error {
+	return d.conn.Close()
+}
+
+// request sends a command and waits for its result, passing streamed rows
+// to onRow. Broadcast events arriving meanwhile are skipped.
+func (d *daemonConn) request(cmd SocketCommand, onRow func(json.RawMessage) error) (json.RawMessage, error) {
+	d.requests++
+	cmd.RequestID = fmt.Sprintf("cli-%d-%d", os.Getpid(), d.requests)
+
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := d.conn.Write(append(data, '\n')); err != nil {
+		return nil, err
+	}
+
+	for {
+		evt, err := d.next()
+		if err != nil {
+			return nil, err
+		}
+		if evt.RequestID != cmd.RequestID {
+			continue
+		}
+		switch evt.Type {
+		case "row":
+			if onRow != nil {
+				if err := onRow(evt.Data); err != nil {
+					return nil, err
+				}
+			}
+		case "result":
+			return evt.Data, nil
+		case "error":
+			return nil, fmt.Errorf("%s", evt.Error)
+		}
+	}
+}
+
+func (d *daemonConn) next() (clientEvent, error) {
+	if d.responses != nil {
+		evt, ok := <-d.responses
+		if !ok {
+			return clientEvent{}, fmt.Errorf("daemon closed the connection")
+		}
+		return evt, nil
+	}
+
+	for d.scanner.Scan() {
+		var evt clientEvent
+		if err := json.Unmarshal(d.scanner.Bytes(), &evt); err == nil {
+			return evt, nil
+		}
+	}
+	if err := d.scanner.Err(); err != nil {
+		return clientEvent{}, err
+	}
+	return clientEvent{}, fmt.Errorf("daemon closed the connection")
+}
+
+// listen keeps reading in the background so long-lived clients never leave
+// broadcasts unread, passing them to onEvent. Responses still go to request.
+func (d *daemonConn) listen(onEvent func(clientEvent)) {
+	d.responses = make(chan clientEvent, 16)
+	go func() {
+		defer close(d.responses)
+		for d.scanner.Scan() {
+			var evt clientEvent
+			if err := json.Unmarshal(d.scanner.Bytes(), &evt); err != nil {
+				continue
+			}
+			if evt.RequestID != "" {
+				d.responses <- evt
+			} else {
+				onEvent(evt)
+			}
+		}
+	}()
+}
+
+// parseInterspersed parses flags that may appear before, between or after
+// positional arguments and returns the positionals.
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// normalizeJID accepts a full JID or a bare phone number.
+func normalizeJID(s string) string {
+	if strings.Contains(s, "@") {
+		return s
+	}
+	return strings.TrimLeft(s, "+") + "@" + types.DefaultUserServer
+}
+
+func formatTimestamp(ts int64) string {
+	return time.Unix(ts, 0).Format("2006-01-02 15:04")
+}
+
+func exitOnError(err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runSend(config Config, args []string) {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	var mentions, vars stringList
+	fs.Var(&mentions, "mention", "JID to mention (repeatable)")
+	fs.Var(&vars, "var", "template variable as key=value (repeatable)")
+	useTemplate := fs.Bool("template", false, "expand {{name}}, {{date}} and other placeholders in the text")
+	positional := parseInterspersed(fs, args)
+	if len(positional) < 2 {
+		fmt.Fprintln(os.Stderr, "Usage: wacli send <jid> <text> [--mention JID]... [--template] [--var key=value]...")
+		os.Exit(1)
+	}
+	templateVars := make(map[string]string)
+	for _, v := range vars {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Invalid --var %q, want key=value\n", v)
+			os.Exit(1)
+		}
+		templateVars[key] = value
+	}
+
+	d, err := dialDaemon(config)
+	exitOnError(err)
+	defer d.Close()
+
+	data, err := d.request(SocketCommand{
+		Action:   "send",
+		ChatJID:  normalizeJID(positional[0]),
+		Text:     strings.Join(positional[1:], " "),
+		Mentions: mentions,
+		Template: *useTemplate,
+		Vars:     templateVars,
+	}, nil)
+	exitOnError(err)
+
+	var result SendResult
+	json.Unmarshal(data, &result)
+	fmt.Println(result.MessageID)
+	if result.Status == "queued" {
+		fmt.Fprintln(os.Stderr, "WhatsApp is disconnected, the message is queued until the daemon reconnects")
+	}
+}
+
+func runHistory(config Config, args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	limit := fs.Int("limit", defaultHistoryLimit, "number of messages")
+	asJSON := fs.Bool("json", false, "print raw JSON lines")
+	media := fs.String("media", "", "only messages with this media: image, video, audio, document, sticker, any or none")
+	positional := parseInterspersed(fs, args)
+	if len(positional) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: wacli history <jid> [--limit N] [--media TYPE] [--json]")
+		os.Exit(1)
+	}
+
+	d, err := dialDaemon(config)
+	exitOnError(err)
+	defer d.Close()
+
+	var messages []Message
+	_, err = d.request(SocketCommand{Action: "history", ChatJID: normalizeJID(positional[0]), Limit: *limit, MediaType: *media}, func(row json.RawMessage) error {
+		if *asJSON {
+			fmt.Println(string(row))
+			return nil
+		}
+		var msg Message
+		if err := json.Unmarshal(row, &msg); err != nil {
+			return err
+		}
+		messages = append(messages, msg)
+		return nil
+	})
+	exitOnError(err)
+
+	// Rows arrive newest first; print in reading order
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := messages[i]
+		fmt.Printf("%s  %s: %s\n", formatTimestamp(msg.Timestamp), msg.SenderName, msg.displayText())
+	}
+}
+
+func runChats(config Config, args []string) {
+	fs := flag.NewFlagSet("chats", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print raw JSON, same as --format json")
+	format := fs.String("format", "text", "text, json or tsv (JID, name and last message, for fzf or dmenu)")
+	fs.Parse(args)
+	if *asJSON {
+		*format = "json"
+	}
+	if *format != "text" && *format != "json" && *format != "tsv" {
+		fmt.Fprintln(os.Stderr, "Usage: wacli chats [--format text|json|tsv]")
+		os.Exit(1)
+	}
+
+	d, err := dialDaemon(config)
+	exitOnError(err)
+	defer d.Close()
+
+	data, err := d.request(SocketCommand{Action: "list_chats"}, nil)
+	exitOnError(err)
+	if *format == "json" {
+		fmt.Println(string(data))
+		return
+	}
+
+	var chats []ChatSummary
+	exitOnError(json.Unmarshal(data, &chats))
+	if *format == "tsv" {
+		writeChatsTSV(os.Stdout, chats)
+		return
+	}
+	for _, chat := range chats {
+		last := strings.ReplaceAll(truncateRunes(chat.LastText, 60), "\n", " ")
+		fmt.Printf("%s  %-30s %-40s %s\n", formatTimestamp(chat.LastTimestamp), truncateRunes(chat.ChatName, 30), chat.ChatJID, last)
+	}
+}
+
+func runStatus(config Config, args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	fs.Parse(args)
+
+	d, err := dialDaemon(config)
+	exitOnError(err)
+	defer d.Close()
+
+	data, err := d.request(SocketCommand{Action: "status"}, nil)
+	exitOnError(err)
+
+	var status map[string]interface{}
+	exitOnError(json.Unmarshal(data, &status))
+	out, _ := json.MarshalIndent(status, "", "  ")
+	fmt.Println(string(out))
+}
+
+func runUnreplied(config Config, args []string) {
+	fs := flag.NewFlagSet("unreplied", flag.ExitOnError)
+	olderThan := fs.String("older-than", "4h", "minimum age of the unanswered message")
+	asJSON := fs.Bool("json", false, "print raw JSON")
+	fs.Parse(args)
+
+	d, err := dialDaemon(config)
+	exitOnError(err)
+	defer d.Close()
+
+	data, err := d.request(SocketCommand{Action: "unreplied", OlderThan: *olderThan}, nil)
+	exitOnError(err)
+	if *asJSON {
+		fmt.Println(string(data))
+		return
+	}
+
+	var chats []UnrepliedChat
+	exitOnError(json.Unmarshal(data, &chats))
+	for _, chat := range chats {
+		last := strings.ReplaceAll(truncateRunes(chat.LastText, 60), "\n", " ")
+		fmt.Printf("%s  %-30s %s: %s\n", formatTimestamp(chat.LastTimestamp), truncateRunes(chat.ChatName, 30), chat.LastSender, last)
+	}
+}
+
+func runExport(config Config, args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", exportFormatJSON, "json, csv or txt")
+	since := fs.String("since", "", "first day (YYYY-MM-DD) or time (RFC 3339) to export")
+	until := fs.String("until", "", "last day (YYYY-MM-DD) or time (RFC 3339) to export")
+	output := fs.String("o", "", "write to this file instead of stdout")
+	positional := parseInterspersed(fs, args)
+	if len(positional) > 1 || !isExportFormat(*format) {
+		fmt.Fprintln(os.Stderr, "Usage: wacli export [jid] [--format json|csv|txt] [--since DATE] [--until DATE] [-o FILE]")
+		os.Exit(1)
+	}
+	cmd := SocketCommand{Action: "export", Format: *format}
+	if len(positional) == 1 {
+		cmd.ChatJID = normalizeJID(positional[0])
+	}
+	var err error
+	cmd.Since, err = parseExportTime(*since, false)
+	exitOnError(err)
+	cmd.Until, err = parseExportTime(*until, true)
+	exitOnError(err)
+
+	out := os.Stdout
+	if *output != "" {
+		out, err = os.Create(*output)
+		exitOnError(err)
+		defer out.Close()
+	}
+
+	d, err := dialDaemon(config)
+	exitOnError(err)
+	defer d.Close()
+
+	data, err := d.request(cmd, func(row json.RawMessage) error {
+		var chunk string
+		if err := json.Unmarshal(row, &chunk); err != nil {
+			return err
+		}
+		_, err := out.WriteString(chunk)
+		return err
+	})
+	exitOnError(err)
+
+	var result ExportResult
+	json.Unmarshal(data, &result)
+	fmt.Fprintf(os.Stderr, "Exported %d messages\n", result.Count)
+}
+
+// parseExportTime accepts a local date or an RFC 3339 time. A date used as
+// the end of a range includes that whole day.
+func parseExportTime(s string, end bool) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t.Unix(), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid date %q, want YYYY-MM-DD or RFC 3339", s)
+	}
+	return t.Unix(), nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Communities are parent groups with member groups linked under them.
+// Which community each joined group belongs to is loaded on connect and
+// reloaded whenever groups are joined, linked or unlinked, so stored group
+// messages can be tagged without a lookup per message.
+
+type Community struct {
+	JID         string `json:"jid"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Groups      int    `json:"groups"`
+}
+
+type CommunityGroup struct {
+	ChatJID string `json:"chat_jid"`
+	Name    string `json:"name"`
+	// The announcement group every community member is in
+	IsDefault bool `json:"is_default"`
+}
+
+type communityCache struct {
+	mu     sync.RWMutex
+	names  map[types.JID]string
+	parent map[types.JID]types.JID
+}
+
+// loadCommunities rebuilds the community cache from the joined groups and
+// returns the communities among them.
+func (a *App) loadCommunities() ([]Community, error) {
+	groups, err := a.client.GetJoinedGroups(a.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[types.JID]string)
+	parent := make(map[types.JID]types.JID)
+	byJID := make(map[types.JID]*Community)
+	for _, group := range groups {
+		if group.IsParent {
+			names[group.JID] = group.Name
+			byJID[group.JID] = &Community{JID: group.JID.String(), Name: group.Name, Description: group.Topic}
+		}
+		if !group.LinkedParentJID.IsEmpty() {
+			parent[group.JID] = group.LinkedParentJID
+		}
+	}
+	for _, parentJID := range parent {
+		if community, ok := byJID[parentJID]; ok {
+			community.Groups++
+		}
+	}
+
+	a.communities.mu.Lock()
+	a.communities.names = names
+	a.communities.parent = parent
+	a.communities.mu.Unlock()
+
+	communities := make([]Community, 0, len(byJID))
+	for _, community := range byJID {
+		communities = append(communities, *community)
+	}
+	sort.Slice(communities, func(i, j int) bool {
+		return communities[i].Name < communities[j].Name
+	})
+	return communities, nil
+}
+
+func (a *App) refreshCommunities() {
+	if _, err := a.loadCommunities(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load communities: %v\n", err)
+	}
+}
+
+// applyCommunity tags a group message with the community its group is
+// linked to.
+func (a *App) applyCommunity(message *Message, chatJID types.JID) {
+	a.communities.mu.RLock()
+	defer a.communities.mu.RUnlock()
+	parent, ok := a.communities.parent[chatJID]
+	if !ok {
+		return
+	}
+	message.CommunityJID = parent.String()
+	message.CommunityName = a.communities.names[parent]
+}
+
+// communityGroups lists the groups linked to a community, including ones
+// you haven't joined.
+func (a *App) communityGroups(chatJID string) ([]CommunityGroup, error) {
+	jid, err := types.ParseJID(chatJID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JID: %w", err)
+	}
+	targets, err := a.client.GetSubGroups(a.ctx, jid)
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]CommunityGroup, 0, len(targets))
+	for _, target := range targets {
+		groups = append(groups, CommunityGroup{
+			ChatJID:   target.JID.String(),
+			Name:      target.Name,
+			IsDefault: target.IsDefaultSubGroup,
+		})
+	}
+	return groups, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// completionCommands are the subcommands offered by shell completion.
+var completionCommands = []string{
+	"daemon", "login", "init", "tui", "send", "pick", "open", "history", "chats", "unreplied",
+	"export", "tail", "status", "repl", "token", "export-keys", "completion", "self-update",
+}
+
+// Chat arguments are completed by calling "wacli __complete-chats WORD",
+// which prints "JID<tab>name" lines for chats whose JID, alias or name
+// starts with WORD. The shells replace the word with the JID, so typing
+// part of a name or alias is enough.
+const bashCompletion = `_wacli() {
+	local line=${COMP_LINE:0:COMP_POINT}
+	local -a words
+	read -ra words <<<"$line"
+	[[ -z $line || $line == *[[:space:]] ]] && words+=("")
+	local last=$((${#words[@]} - 1))
+	local cur=${words[last]} prev=${words[last - 1]}
+
+	local cmd="" cmdIndex=0 i
+	for ((i = 1; i < last; i++)); do
+		case ${words[i]} in
+		--data-dir | --socket | --media-dir) ((i++)) ;;
+		-*) ;;
+		*)
+			cmd=${words[i]} cmdIndex=$i
+			break
+			;;
+		esac
+	done
+
+	if [[ -z $cmd ]]; then
+		COMPREPLY=($(compgen -W "@COMMANDS@" -- "$cur"))
+		return
+	fi
+	if [[ $prev == --chat || $prev == --mention ]] ||
+		[[ ($cmd == send || $cmd == history || $cmd == export) && $last -eq $((cmdIndex + 1)) ]]; then
+		local IFS=$'\n'
+		COMPREPLY=($(wacli __complete-chats "$cur" 2>/dev/null | cut -f1))
+		# Bash splits words at "@", so only replace the part after it
+		local trim=${cur%"${COMP_WORDS[COMP_CWORD]}"}
+		[[ -n $trim ]] && COMPREPLY=("${COMPREPLY[@]#"$trim"}")
+	fi
+}
+complete -o default -F _wacli wacli
+`
+
+const zshCompletion = `#compdef wacli
+
+_wacli_chats() {
+	local -a jids descriptions
+	local line
+	for line in ${(f)"$(wacli __complete-chats "$PREFIX" 2>/dev/null)"}; do
+		jids+=("${line%%$'\t'*}")
+		descriptions+=("${line%%$'\t'*} -- ${line#*$'\t'}")
+	done
+	# Matches may come from the alias or name, not the typed prefix
+	compadd -U -l -d descriptions -a jids
+}
+
+_wacli() {
+	local curcontext=$curcontext state line
+	_arguments -C \
+		'--data-dir[data directory]:directory:_files -/' \
+		'--socket[daemon socket path]:socket:_files' \
+		'--media-dir[media directory]:directory:_files -/' \
+		'1:command:(@COMMANDS@)' \
+		'*::argument:->args'
+
+	case $state in
+	args)
+		if [[ $words[CURRENT-1] == --chat || $words[CURRENT-1] == --mention ]]; then
+			_wacli_chats
+		elif [[ ($words[1] == send || $words[1] == history || $words[1] == export) && $CURRENT -eq 2 ]]; then
+			_wacli_chats
+		elif [[ $words[1] == completion && $CURRENT -eq 2 ]]; then
+			compadd bash zsh fish
+		else
+			_files
+		fi
+		;;
+	esac
+}
+
+_wacli "$@"
+`
+
+const fishCompletion = `function __wacli_complete_chat
+	set -l tokens (commandline -opc)
+	contains -- $tokens[-1] --chat --mention send history export
+end
+
+complete -c wacli -f
+complete -c wacli -n __fish_use_subcommand -l data-dir -r -a '(__fish_complete_directories)' -d 'data directory'
+complete -c wacli -n __fish_use_subcommand -l socket -r -F -d 'daemon socket path'
+complete -c wacli -n __fish_use_subcommand -l media-dir -r -a '(__fish_complete_directories)' -d 'media directory'
+complete -c wacli -n __fish_use_subcommand -a '@COMMANDS@'
+complete -c wacli -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish'
+complete -c wacli -n __wacli_complete_chat -a '(wacli __complete-chats (commandline -ct) 2>/dev/null)'
+`
+
+func runCompletion(args []string) {
+	scripts := map[string]string{"bash": bashCompletion, "zsh": zshCompletion, "fish": fishCompletion}
+	if len(args) != 1 || scripts[args[0]] == "" {
+		fmt.Fprintln(os.Stderr, "Usage: wacli completion bash|zsh|fish")
+		os.Exit(1)
+	}
+	fmt.Print(strings.ReplaceAll(scripts[args[0]], "@COMMANDS@", strings.Join(completionCommands, " ")))
+}
+
+// runCompleteChats prints chat completions for the shell scripts. It stays
+// silent when the daemon is unavailable so a stopped daemon only means no
+// suggestions.
+func runCompleteChats(config Config, args []string) {
+	fs := flag.NewFlagSet("__complete-chats", flag.ExitOnError)
+	fs.Parse(args)
+	word := strings.ToLower(fs.Arg(0))
+
+	d, err := dialDaemon(config)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	d.conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	data, err := d.request(SocketCommand{Action: "list_chats"}, nil)
+	if err != nil {
+		return
+	}
+	var chats []ChatSummary
+	if err := json.Unmarshal(data, &chats); err != nil {
+		return
+	}
+
+	aliasOf := make(map[string]string, len(chats))
+	for alias, jid := range chatAliases(chats) {
+		aliasOf[jid] = alias
+	}
+
+	for _, chat := range chats {
+		if !strings.HasPrefix(chat.ChatJID, word) &&
+			!strings.HasPrefix(aliasOf[chat.ChatJID], word) &&
+			!strings.HasPrefix(strings.ToLower(chat.ChatName), word) {
+			continue
+		}
+		name := strings.Join(strings.Fields(chat.ChatName), " ")
+		if alias := aliasOf[chat.ChatJID]; alias != "" {
+			name += " (" + alias + ")"
+		}
+		fmt.Printf("%s\t%s\n", chat.ChatJID, name)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+)
+
+const (
+	resumeCheckInterval = 10 * time.Second
+	resumeJumpThreshold = 30 * time.Second
+
+	// whatsmeow's own retries wait 2s longer after every failure, without
+	// limit; after this many reconnectWithBackoff takes over
+	autoReconnectAttempts = 3
+	reconnectBaseDelay    = time.Second
+	reconnectMaxDelay     = 5 * time.Minute
+)
+
+const (
+	connStateConnecting   = "connecting"
+	connStateConnected    = "connected"
+	connStateDisconnected = "disconnected"
+	connStateReconnecting = "reconnecting"
+	connStateLoggedOut    = "logged_out"
+)
+
+// ConnectionState is where the WhatsApp connection stands, reported by
+// status and broadcast as a connected, disconnected or reconnecting event
+// on every change.
+type ConnectionState struct {
+	State  string `json:"state"`
+	Reason string `json:"reason,omitempty"`
+	// Unix time the connection entered this state
+	Since int64 `json:"since"`
+	// Reconnect attempt, counting from 1
+	Attempt     int   `json:"attempt,omitempty"`
+	NextRetryAt int64 `json:"next_retry_at,omitempty"`
+}
+
+type connectionTracker struct {
+	mu    sync.Mutex
+	state ConnectionState
+	// Set by events announcing why the connection is about to drop
+	pendingReason string
+}
+
+func (a *App) connectionState() ConnectionState {
+	a.connection.mu.Lock()
+	defer a.connection.mu.Unlock()
+	return a.connection.state
+}
+
+// setConnectionState records a state and broadcasts it. Repeated reconnect
+// attempts keep the time the connection was lost.
+func (a *App) setConnectionState(state ConnectionState) {
+	a.connection.mu.Lock()
+	state.Since = time.Now().Unix()
+	if state.State == a.connection.state.State {
+		state.Since = a.connection.state.Since
+	}
+	a.connection.state = state
+	a.connection.mu.Unlock()
+
+	switch state.State {
+	case connStateConnected, connStateDisconnected, connStateReconnecting:
+		a.broadcastEvent(state.State, state)
+	}
+}
+
+// expectDisconnect remembers why the connection is about to drop, for the
+// disconnected event that follows.
+func (a *App) expectDisconnect(reason string) {
+	a.connection.mu.Lock()
+	a.connection.pendingReason = reason
+	a.connection.mu.Unlock()
+}
+
+// handleDisconnected reports an unexpected disconnect, which whatsmeow
+// answers by reconnecting.
+func (a *App) handleDisconnected() {
+	a.connection.mu.Lock()
+	reason := a.connection.pendingReason
+	a.connection.pendingReason = ""
+	a.connection.mu.Unlock()
+	if reason == "" {
+		reason = "connection closed"
+	}
+
+	a.setConnectionState(ConnectionState{State: connStateDisconnected, Reason: reason})
+	a.setConnectionState(ConnectionState{State: connStateReconnecting, Reason: reason, Attempt: 1})
+}
+
+// autoReconnectFailed is whatsmeow's AutoReconnectHook. It lets whatsmeow
+// retry a few times, then hands over to reconnectWithBackoff, whose delay
+// is capped.
+func (a *App) autoReconnectFailed(client *whatsmeow.Client, err error) bool {
+	attempt := client.AutoReconnectErrors
+	if attempt < autoReconnectAttempts {
+		next := time.Now().Add(time.Duration(attempt) * 2 * time.Second)
+		a.setConnectionState(ConnectionState{State: connStateReconnecting, Reason: err.Error(), Attempt: attempt + 1, NextRetryAt: next.Unix()})
+		return true
+	}
+	a.goSafe("reconnect", func() { a.reconnectWithBackoff(err.Error()) })
+	return false
+}
+
+// forceReconnect drops the current websocket and connects again. Connecting
+// again makes the server deliver anything queued while we were away.
+func (a *App) forceReconnect(reason string) {
+	if !a.reconnectMu.TryLock() {
+		return
+	}
+	defer a.reconnectMu.Unlock()
+
+	fmt.Printf("Reconnecting: %s\n", reason)
+	a.client.Disconnect()
+	a.reconnectLoop(reason)
+}
+
+func (a *App) reconnectWithBackoff(reason string) {
+	if !a.reconnectMu.TryLock() {
+		return
+	}
+	defer a.reconnectMu.Unlock()
+
+	fmt.Printf("Reconnecting with backoff: %s\n", reason)
+	a.reconnectLoop(reason)
+}
+
+// reconnectLoop connects until it succeeds, doubling the delay up to
+// reconnectMaxDelay with 20% jitter, since the network may take a while to
+// come back. It stops when the device is logged out.
+func (a *App) reconnectLoop(reason string) {
+	delay := reconnectBaseDelay
+	a.setConnectionState(ConnectionState{State: connStateReconnecting, Reason: reason, Attempt: 1})
+	for attempt := 1; ; attempt++ {
+		err := a.client.Connect()
+		if err == nil || errors.Is(err, whatsmeow.ErrAlreadyConnected) || a.client.Store.ID == nil {
+			return
+		}
+
+		wait := delay - delay/5 + rand.N(delay*2/5)
+		fmt.Fprintf(os.Stderr, "Reconnect attempt %d failed, retrying in %s: %v\n", attempt, wait.Round(time.Second), err)
+		a.setConnectionState(ConnectionState{State: connStateReconnecting, Reason: err.Error(), Attempt: attempt + 1, NextRetryAt: time.Now().Add(wait).Unix()})
+		time.Sleep(wait)
+		delay = min(delay*2, reconnectMaxDelay)
+	}
+}
+
+// watchResume detects system suspend by comparing wall clock and monotonic
+// time: the monotonic clock stops while suspended, so after resume the wall
+// clock has advanced much further than the ticker interval.
+func (a *App) watchResume() {
+	ticker := time.NewTicker(resumeCheckInterval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for now := range ticker.C {
+		wallElapsed := now.Round(0).Sub(last.Round(0))
+		monoElapsed := now.Sub(last)
+		last = now
+
+		if jump := wallElapsed - monoElapsed; jump > resumeJumpThreshold {
+			a.forceReconnect(fmt.Sprintf("resumed after %s asleep", jump.Round(time.Second)))
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// SenderInfo combines what WhatsApp knows about a contact with the local
+// notes and dates kept in the contact_info table.
+type SenderInfo struct {
+	JID          string            `json:"jid"`
+	Name         string            `json:"name"`
+	MessageCount int               `json:"message_count"`
+	LastSeen     int64             `json:"last_seen"`
+	Notes        string            `json:"notes"`
+	Dates        map[string]string `json:"dates"`
+}
+
+func (a *App) senderInfo(senderJID string) (SenderInfo, error) {
+	jid, err := types.ParseJID(senderJID)
+	if err != nil {
+		return SenderInfo{}, fmt.Errorf("invalid sender JID: %w", err)
+	}
+	jid = jid.ToNonAD()
+
+	info := SenderInfo{JID: a.canonicalSender(jid), Name: a.getContactName(jid)}
+
+	var lastSeen sql.NullInt64
+	err = a.msgDB.QueryRow(fmt.Sprintf(`
+		SELECT COUNT(*), MAX(timestamp) FROM %s WHERE sender_jid = ?
+	`, messagesView), info.JID).Scan(&info.MessageCount, &lastSeen)
+	if err != nil {
+		return SenderInfo{}, err
+	}
+	info.LastSeen = lastSeen.Int64
+
+	info.Notes, info.Dates, err = a.loadContactInfo(info.JID)
+	if err != nil {
+		return SenderInfo{}, err
+	}
+	return info, nil
+}
+
+func (a *App) loadContactInfo(jid string) (string, map[string]string, error) {
+	var notes, datesJSON string
+	err := a.msgDB.QueryRow(`SELECT notes, dates FROM contact_info WHERE jid = ?`, jid).Scan(&notes, &datesJSON)
+	if err == sql.ErrNoRows {
+		return "", map[string]string{}, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	dates := map[string]string{}
+	if err := json.Unmarshal([]byte(datesJSON), &dates); err != nil {
+		return "", nil, err
+	}
+	return notes, dates, nil
+}
+
+// setContactInfo updates the notes and dates of a contact. A nil notes
+// pointer keeps the stored notes; dates are merged by label and an empty
+// date removes its label.
+func (a *App) setContactInfo(senderJID string, notes *string, dates map[string]string) error {
+	jid, err := types.ParseJID(senderJID)
+	if err != nil {
+		return fmt.Errorf("invalid sender JID: %w", err)
+	}
+	key := a.canonicalSender(jid.ToNonAD())
+
+	for label, date := range dates {
+		if date != "" && !validContactDate(date) {
+			return fmt.Errorf("invalid date %q for %s, use YYYY-MM-DD or MM-DD", date, label)
+		}
+	}
+
+	storedNotes, storedDates, err := a.loadContactInfo(key)
+	if err != nil {
+		return err
+	}
+	if notes != nil {
+		storedNotes = *notes
+	}
+	for label, date := range dates {
+		if date == "" {
+			delete(storedDates, label)
+		} else {
+			storedDates[label] = date
+		}
+	}
+
+	datesJSON, err := json.Marshal(storedDates)
+	if err != nil {
+		return err
+	}
+	_, err = a.msgDB.Exec(`
+		INSERT OR REPLACE INTO contact_info (jid, notes, dates, updated_at) VALUES (?, ?, ?, ?)
+	`, key, storedNotes, string(datesJSON), time.Now().Unix())
+	return err
+}
+
+// validContactDate accepts full dates and yearless ones such as birthdays
+// with an unknown year.
+func validContactDate(date string) bool {
+	if _, err := time.Parse("2006-01-02", date); err == nil {
+		return true
+	}
+	_, err := time.Parse("01-02", date)
+	return err == nil
+}
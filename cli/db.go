@@ -0,0 +1,173 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DB wraps the message database so queries slower than the configured
+// budget are logged together with their query plan. With no budget set
+// the wrapper only forwards calls.
+type DB struct {
+	*sql.DB
+	slowQueryBudget time.Duration
+	writes          chan writeRequest
+}
+
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	result, err := db.DB.Exec(query, args...)
+	db.checkLatency(start, query, args)
+	return result, err
+}
+
+// Query returns rows that check the latency once they are read to the end
+// or closed, since SQLite does most of the work while stepping through them.
+func (db *DB) Query(query string, args ...interface{}) (*Rows, error) {
+	start := time.Now()
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		db.checkLatency(start, query, args)
+		return nil, err
+	}
+	return &Rows{Rows: rows, done: func() { db.checkLatency(start, query, args) }}, nil
+}
+
+// QueryRow returns a row that checks the latency when it is scanned.
+func (db *DB) QueryRow(query string, args ...interface{}) *Row {
+	start := time.Now()
+	row := db.DB.QueryRow(query, args...)
+	return &Row{Row: row, done: func() { db.checkLatency(start, query, args) }}
+}
+
+type Rows struct {
+	*sql.Rows
+	once sync.Once
+	done func()
+}
+
+func (r *Rows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.once.Do(r.done)
+	return false
+}
+
+func (r *Rows) Close() error {
+	err := r.Rows.Close()
+	r.once.Do(r.done)
+	return err
+}
+
+type Row struct {
+	*sql.Row
+	done func()
+}
+
+func (r *Row) Scan(dest ...interface{}) error {
+	err := r.Row.Scan(dest...)
+	r.done()
+	return err
+}
+
+func (db *DB) checkLatency(start time.Time, query string, args []interface{}) {
+	if db.slowQueryBudget <= 0 {
+		return
+	}
+	elapsed := time.Since(start)
+	if elapsed <= db.slowQueryBudget {
+		return
+	}
+
+	query = strings.Join(strings.Fields(query), " ")
+	fmt.Fprintf(os.Stderr, "Slow query (%s > %s): %s\n", elapsed.Round(time.Microsecond), db.slowQueryBudget, query)
+	for _, line := range db.explain(query, args) {
+		fmt.Fprintf(os.Stderr, "  plan: %s\n", line)
+	}
+}
+
+func (db *DB) explain(query string, args []interface{}) []string {
+	rows, err := db.DB.Query("EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		return []string{fmt.Sprintf("unavailable (%v)", err)}
+	}
+	defer rows.Close()
+
+	var plan []string
+	for rows.Next() {
+		var id, parent, notUsed int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notUsed, &detail); err != nil {
+			return plan
+		}
+		plan = append(plan, detail)
+	}
+	return plan
+}
+
+type columnMigration struct {
+	Table      string
+	Column     string
+	Definition string
+}
+
+// migrateColumns adds columns introduced after a table was first created.
+func migrateColumns(db *sql.DB, migrations []columnMigration) error {
+	for _, m := range migrations {
+		columns, err := tableColumns(db, m.Table)
+		if err != nil {
+			return err
+		}
+		exists := false
+		for _, col := range columns {
+			if col.Name == m.Column {
+				exists = true
+				break
+			}
+		}
+		if exists {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", m.Table, m.Column, m.Definition)); err != nil {
+			return fmt.Errorf("add column %s.%s: %w", m.Table, m.Column, err)
+		}
+	}
+	return nil
+}
+
+// recordColumns returns the column names of a record struct, using the same
+// json tags as buildInsertParams but including the id column.
+func recordColumns(record interface{}) []string {
+	t := reflect.TypeOf(record)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	var columns []string
+	for i := 0; i < t.NumField(); i++ {
+		if tag := t.Field(i).Tag.Get("json"); tag != "" {
+			columns = append(columns, tag)
+		}
+	}
+	return columns
+}
+
+// scanRecord scans a row selected with recordColumns into record.
+func scanRecord(rows *Rows, record interface{}) error {
+	v := reflect.ValueOf(record).Elem()
+	t := v.Type()
+
+	var dest []interface{}
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("json") != "" {
+			dest = append(dest, v.Field(i).Addr().Interface())
+		}
+	}
+	return rows.Scan(dest...)
+}
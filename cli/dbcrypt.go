@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// The message database can be encrypted with SQLCipher. go-sqlite3 links
+// its bundled SQLite by default, which ignores PRAGMA key, so encryption
+// needs a binary built against libsqlcipher (see CLAUDE.md); openMessageDB
+// refuses to run with a key otherwise rather than silently storing
+// plaintext.
+
+// messageDBKey returns the database key from WACLI_DB_KEY, or the first
+// line printed by WACLI_DB_KEY_COMMAND (e.g. "secret-tool lookup service
+// wacli" for the desktop keyring). Empty means unencrypted.
+func (c Config) messageDBKey() (string, error) {
+	if c.DBKey != "" || c.DBKeyCommand == "" {
+		return c.DBKey, nil
+	}
+	out, err := exec.Command("sh", "-c", c.DBKeyCommand).Output()
+	if err != nil {
+		return "", fmt.Errorf("WACLI_DB_KEY_COMMAND failed: %w", err)
+	}
+	key, _, _ := strings.Cut(string(out), "\n")
+	if key == "" {
+		return "", fmt.Errorf("WACLI_DB_KEY_COMMAND printed no key")
+	}
+	return key, nil
+}
+
+// keyedConnector opens SQLite connections that are unlocked with key
+// before use, as each pooled connection needs its own PRAGMA key.
+type keyedConnector struct {
+	driver *sqlite3.SQLiteDriver
+	dsn    string
+}
+
+func newKeyedConnector(dsn string, key string) *keyedConnector {
+	pragma := "PRAGMA key = '" + strings.ReplaceAll(key, "'", "''") + "'"
+	return &keyedConnector{
+		driver: &sqlite3.SQLiteDriver{ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			_, err := conn.Exec(pragma, nil)
+			return err
+		}},
+		dsn: dsn,
+	}
+}
+
+func (c *keyedConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *keyedConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// openMessageDB opens messages.db, unlocking it with the configured key.
+// An existing plaintext database is encrypted in place on first use.
+func openMessageDB(config Config) (*sql.DB, error) {
+	path := config.messageDBPath()
+	dsn := "file:" + path + "?_foreign_keys=on&_busy_timeout=5000"
+	key, err := config.messageDBKey()
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return sql.Open("sqlite3", dsn)
+	}
+
+	db := sql.OpenDB(newKeyedConnector(dsn, key))
+	var version string
+	if err := db.QueryRow("PRAGMA cipher_version").Scan(&version); err != nil || version == "" {
+		db.Close()
+		return nil, fmt.Errorf("a database key is set but wacli was built without SQLCipher")
+	}
+	if err := checkKey(db); err == nil {
+		return db, nil
+	}
+	db.Close()
+
+	if err := encryptMessageDB(path, key); err != nil {
+		return nil, fmt.Errorf("wrong database key, or %s could not be encrypted: %w", path, err)
+	}
+	db = sql.OpenDB(newKeyedConnector(dsn, key))
+	if err := checkKey(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// checkKey reads the schema, which fails with "file is not a database"
+// when the key doesn't match.
+func checkKey(db *sql.DB) error {
+	var count int
+	return db.QueryRow("SELECT COUNT(*) FROM sqlite_master").Scan(&count)
+}
+
+// encryptMessageDB converts a plaintext database to an encrypted copy and
+// replaces the original with it. The plaintext file is overwritten by the
+// rename, but may survive in filesystem snapshots and backups.
+func encryptMessageDB(path string, key string) error {
+	plain, err := sql.Open("sqlite3", "file:"+path)
+	if err != nil {
+		return err
+	}
+	defer plain.Close()
+	// A zero-length plaintext key reads the file unencrypted
+	if _, err := plain.Exec("PRAGMA key = ''"); err != nil {
+		return err
+	}
+	if err := checkKey(plain); err != nil {
+		return err
+	}
+
+	tmp := path + ".encrypting"
+	os.Remove(tmp)
+	if _, err := plain.Exec("ATTACH DATABASE ? AS encrypted KEY ?", tmp, key); err != nil {
+		return err
+	}
+	if _, err := plain.Exec("SELECT sqlcipher_export('encrypted')"); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if _, err := plain.Exec("DETACH DATABASE encrypted"); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	plain.Close()
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// The old journal belongs to the plaintext file
+	os.Remove(path + "-wal")
+	os.Remove(path + "-shm")
+	fmt.Printf("Encrypted %s\n", path)
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	writeBatchSize = 64
+	pruneInterval  = time.Minute
+)
+
+// writeRequest is an insert waiting for the writer goroutine.
+type writeRequest struct {
+	query string
+	args  []interface{}
+	done  chan writeResult
+}
+
+type writeResult struct {
+	id  int64
+	err error
+}
+
+// insert queues an INSERT for the writer goroutine and returns the new
+// row's ID once its batch is committed. Message and call inserts go
+// through here, so a burst of events doesn't have every handler fight for
+// SQLite's write lock.
+func (db *DB) insert(query string, args ...interface{}) (int64, error) {
+	done := make(chan writeResult, 1)
+	db.writes <- writeRequest{query: query, args: args, done: done}
+	res := <-done
+	return res.id, res.err
+}
+
+// runWriter commits inserts one batch at a time: whatever queued up while
+// the previous batch was written goes into a single transaction.
+func (db *DB) runWriter() {
+	for first := range db.writes {
+		batch := []writeRequest{first}
+	drain:
+		for len(batch) < writeBatchSize {
+			select {
+			case req := <-db.writes:
+				batch = append(batch, req)
+			default:
+				break drain
+			}
+		}
+		db.commit(batch)
+	}
+}
+
+// commit runs a batch in one transaction. A failing insert only fails its
+// own request, unless the transaction as a whole can't be committed.
+func (db *DB) commit(batch []writeRequest) {
+	results := make([]writeResult, len(batch))
+	tx, err := db.Begin()
+	if err == nil {
+		for i, req := range batch {
+			res, err := tx.Exec(req.query, req.args...)
+			if err != nil {
+				results[i].err = err
+				continue
+			}
+			results[i].id, _ = res.LastInsertId()
+		}
+		err = tx.Commit()
+	}
+	for i, req := range batch {
+		if err != nil {
+			results[i] = writeResult{err: err}
+		}
+		req.done <- results[i]
+	}
+}
+
+// watchPrune keeps the message and call tables at their size limit.
+// Partitioned storage is pruned a month at a time by rotatePartitions
+// instead.
+func (a *App) watchPrune() {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := a.pruneTables(false); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to prune message database: %v\n", err)
+		}
+	}
+}
+
+// pruneTables cuts tables holding more than maxMessages rows down to the
+// newest trimToCount. force trims regardless of the current size. Starred
+// messages and those of retained chats are kept and don't count.
+func (a *App) pruneTables(force bool) error {
+	type prunable struct {
+		table string
+		where string
+		args  []interface{}
+	}
+	tables := []prunable{{table: "calls", where: "1"}}
+	if !a.cfg().PartitionByMonth {
+		where, args := a.prunableMessages()
+		tables = append(tables, prunable{table: "messages", where: where, args: args})
+	}
+	for _, t := range tables {
+		if !force {
+			var count int
+			if err := a.msgDB.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", t.table, t.where), t.args...).Scan(&count); err != nil {
+				return err
+			}
+			if count <= maxMessages {
+				continue
+			}
+		}
+		args := append(append(append([]interface{}{}, t.args...), t.args...), trimToCount)
+		_, err := a.msgDB.Exec(fmt.Sprintf(`
+			DELETE FROM %[1]s WHERE %[2]s AND id NOT IN (
+				SELECT id FROM %[1]s WHERE %[2]s ORDER BY timestamp DESC LIMIT ?
+			)
+		`, t.table, t.where), args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
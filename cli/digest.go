@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	digestCheckInterval = time.Minute
+	digestMaxChats      = 20
+	digestSnippetLength = 80
+)
+
+type DigestChat struct {
+	ChatName     string
+	MessageCount int
+	MentionCount int
+	LastSender   string
+	LastText     string
+}
+
+// watchDigest sends the daily digest at DIGEST_TIME. The wall clock is
+// checked every minute rather than sleeping until the target, so a digest
+// missed during suspend goes out shortly after resume.
+func (a *App) watchDigest() {
+	if a.cfg().DigestTime == "" {
+		return
+	}
+	at, err := time.Parse("15:04", a.cfg().DigestTime)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid DIGEST_TIME %q, digest disabled\n", a.cfg().DigestTime)
+		return
+	}
+
+	next := nextDigestTime(time.Now(), at)
+	ticker := time.NewTicker(digestCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		if now.Before(next) {
+			continue
+		}
+		if err := a.sendDigest(now.Add(-24 * time.Hour)); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to send digest: %v\n", err)
+		}
+		next = nextDigestTime(now, at)
+	}
+}
+
+func nextDigestTime(now time.Time, at time.Time) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// sendDigest summarizes messages stored since the given time and sends the
+// summary to DIGEST_CHAT, or to yourself when unset.
+func (a *App) sendDigest(since time.Time) error {
+	chats, err := a.digestChats(since)
+	if err != nil {
+		return err
+	}
+	if len(chats) == 0 {
+		return nil
+	}
+
+	target := a.cfg().DigestChat
+	if target == "" {
+		if a.client.Store.ID == nil {
+			return fmt.Errorf("not logged in")
+		}
+		target = a.client.Store.ID.ToNonAD().String()
+	}
+
+	jid, err := types.ParseJID(target)
+	if err != nil {
+		return fmt.Errorf("invalid DIGEST_CHAT: %w", err)
+	}
+	_, err = a.sendAutomated("digest", jid, &waE2E.Message{Conversation: proto.String(formatDigest(chats))})
+	return err
+}
+
+func (a *App) digestChats(since time.Time) ([]DigestChat, error) {
+	rows, err := a.msgDB.Query(fmt.Sprintf(`
+		SELECT m.chat_name, c.message_count, c.mention_count, m.sender_name, m.text, m.media_type, m.file_name
+		FROM %[1]s m
+		JOIN (
+			SELECT chat_jid, COUNT(*) AS message_count,
+				SUM(is_mentioned OR is_reply_to_me) AS mention_count, MAX(id) AS last_id
+			FROM %[1]s WHERE timestamp >= ? GROUP BY chat_jid
+		) c ON m.id = c.last_id
+		ORDER BY c.mention_count DESC, c.message_count DESC
+		LIMIT ?
+	`, messagesView), since.Unix(), digestMaxChats)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chats []DigestChat
+	for rows.Next() {
+		var chat DigestChat
+		var mediaType, fileName string
+		if err := rows.Scan(&chat.ChatName, &chat.MessageCount, &chat.MentionCount, &chat.LastSender, &chat.LastText, &mediaType, &fileName); err != nil {
+			return nil, err
+		}
+		chat.LastText = labelMedia(mediaType, fileName, chat.LastText)
+		chats = append(chats, chat)
+	}
+	return chats, rows.Err()
+}
+
+func formatDigest(chats []DigestChat) string {
+	var b strings.Builder
+	b.WriteString("*wacli digest*\n")
+	for _, chat := range chats {
+		fmt.Fprintf(&b, "\n*%s* - %d message", chat.ChatName, chat.MessageCount)
+		if chat.MessageCount != 1 {
+			b.WriteString("s")
+		}
+		if chat.MentionCount > 0 {
+			fmt.Fprintf(&b, ", %d for you", chat.MentionCount)
+		}
+		text := strings.ReplaceAll(truncateRunes(chat.LastText, digestSnippetLength), "\n", " ")
+		fmt.Fprintf(&b, "\n%s: %s", chat.LastSender, text)
+	}
+	return b.String()
+}
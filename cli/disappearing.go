@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+)
+
+const expiredPurgeInterval = time.Minute
+
+// DisappearingTimer is the result of set_disappearing.
+type DisappearingTimer struct {
+	ChatJID string `json:"chat_jid"`
+	// Zero when disappearing messages are off
+	TimerSeconds int64 `json:"timer_seconds"`
+}
+
+// setDisappearing changes a chat's disappearing message timer. WhatsApp
+// only accepts off, 24h, 7d and 90d.
+func (a *App) setDisappearing(chatJID string, duration string) (*DisappearingTimer, error) {
+	jid, err := types.ParseJID(chatJID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chat JID: %w", err)
+	}
+	timer, ok := whatsmeow.ParseDisappearingTimerString(duration)
+	if !ok {
+		return nil, fmt.Errorf("invalid duration %q, want off, 24h, 7d or 90d", duration)
+	}
+	if err := a.client.SetDisappearingTimer(a.ctx, jid, timer, time.Now()); err != nil {
+		return nil, fmt.Errorf("set disappearing failed: %w", err)
+	}
+	return &DisappearingTimer{ChatJID: jid.String(), TimerSeconds: int64(timer.Seconds())}, nil
+}
+
+// applyExpiration records when a message sent with a disappearing timer
+// vanishes from WhatsApp.
+func applyExpiration(message *Message, msg *waE2E.Message) {
+	if expiration := getContextInfo(msg).GetExpiration(); expiration > 0 {
+		message.ExpiresAt = message.Timestamp + int64(expiration)
+	}
+}
+
+// watchExpiredMessages deletes stored messages once WhatsApp has made them
+// disappear, when PURGE_EXPIRED_MESSAGES is set.
+func (a *App) watchExpiredMessages() {
+	if !a.cfg().PurgeExpiredMessages {
+		return
+	}
+
+	ticker := time.NewTicker(expiredPurgeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		_, err := a.updateMessageTables("DELETE FROM %s WHERE expires_at > 0 AND expires_at <= ?", time.Now().Unix())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to purge expired messages: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Draft is a half-written message kept per chat, so a client such as the
+// TUI can pick it up again after a restart.
+type Draft struct {
+	ChatJID   string `json:"chat_jid"`
+	Text      string `json:"text"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+func (d Draft) scopeChatJID() string { return d.ChatJID }
+
+func (d Draft) redacted() interface{} {
+	d.Text = ""
+	return d
+}
+
+// saveDraft replaces a chat's draft; blank text deletes it.
+func (a *App) saveDraft(chatJID string, text string) (Draft, error) {
+	if jid, err := types.ParseJID(chatJID); err != nil || jid.IsEmpty() {
+		return Draft{}, fmt.Errorf("invalid JID %q", chatJID)
+	}
+	draft := Draft{ChatJID: a.canonicalChat(chatJID), Text: text, UpdatedAt: time.Now().Unix()}
+	if strings.TrimSpace(text) == "" {
+		draft.Text = ""
+		_, err := a.msgDB.Exec("DELETE FROM drafts WHERE chat_jid = ?", draft.ChatJID)
+		return draft, err
+	}
+	_, err := a.msgDB.Exec(
+		"INSERT OR REPLACE INTO drafts (chat_jid, text, updated_at) VALUES (?, ?, ?)",
+		draft.ChatJID, draft.Text, draft.UpdatedAt,
+	)
+	return draft, err
+}
+
+// getDraft returns a chat's draft, with empty text if there is none.
+func (a *App) getDraft(chatJID string) (Draft, error) {
+	draft := Draft{ChatJID: a.canonicalChat(chatJID)}
+	err := a.msgDB.QueryRow(
+		"SELECT text, updated_at FROM drafts WHERE chat_jid = ?", draft.ChatJID,
+	).Scan(&draft.Text, &draft.UpdatedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return Draft{}, err
+	}
+	return draft, nil
+}
+
+// listDrafts returns every draft, most recently edited first.
+func (a *App) listDrafts() ([]Draft, error) {
+	rows, err := a.msgDB.Query("SELECT chat_jid, text, updated_at FROM drafts ORDER BY updated_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	drafts := []Draft{}
+	for rows.Next() {
+		var draft Draft
+		if err := rows.Scan(&draft.ChatJID, &draft.Text, &draft.UpdatedAt); err != nil {
+			return nil, err
+		}
+		drafts = append(drafts, draft)
+	}
+	return drafts, rows.Err()
+}
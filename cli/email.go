@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+)
+
+const defaultEmailMaxAttachmentMB = 10
+
+// Messages that a rule with `email: true` matches are forwarded by mail
+// through SMTP_URL, with their media attached, as a fallback for when the
+// terminal is out of reach.
+
+// emailMessage mails a message to EMAIL_TO. It runs in the background and
+// failures are only logged.
+func (a *App) emailMessage(message *Message, msg *waE2E.Message) {
+	config := a.cfg()
+	if config.SMTPURL == "" || len(config.EmailTo) == 0 {
+		return
+	}
+
+	var attachment []byte
+	var filename string
+	if media, mediaType := getMediaMessage(msg); media != nil && int64(media.GetFileLength()) <= config.EmailMaxAttachment {
+		var err error
+		if message.MediaPath != "" {
+			attachment, err = os.ReadFile(message.MediaPath)
+			filename = filepath.Base(message.MediaPath)
+		} else {
+			attachment, err = a.client.Download(a.ctx, media)
+			filename = mediaType + mediaExtension(mediaType, media.GetMimetype())
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to fetch media for email: %v\n", err)
+			attachment = nil
+		}
+		if doc := msg.GetDocumentMessage(); doc != nil && doc.GetFileName() != "" {
+			filename = doc.GetFileName()
+		}
+	}
+
+	body, err := buildEmail(config, message, attachment, filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to build email: %v\n", err)
+		return
+	}
+	if err := sendMail(config, body); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to email message %s: %v\n", message.MessageID, err)
+	}
+}
+
+// mediaExtension picks a file extension for an attachment: the usual one
+// for images, videos, voice notes and stickers, else one for its mimetype.
+func mediaExtension(mediaType string, mimetype string) string {
+	if ext, ok := viewOnceExtensions[mediaType]; ok {
+		return ext
+	}
+	if mediaType == "sticker" {
+		return ".webp"
+	}
+	base, _, _ := strings.Cut(mimetype, ";")
+	if exts, err := mime.ExtensionsByType(base); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+func buildEmail(config Config, message *Message, attachment []byte, filename string) ([]byte, error) {
+	from := message.SenderName
+	if message.IsGroup {
+		from += " in " + message.ChatName
+	}
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "From: %s\r\n", config.EmailFrom)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(config.EmailTo, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "WhatsApp: "+from))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Unix(message.Timestamp, 0).Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s.%s@wacli>\r\n", message.MessageID, strings.ReplaceAll(message.ChatJID, "@", "."))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", writer.Boundary())
+
+	part, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	text := fmt.Sprintf("%s\n\n%s\n%s, %s\n", message.displayText(), from, message.ChatJID,
+		time.Unix(message.Timestamp, 0).Format("2006-01-02 15:04"))
+	if err := writeBase64(part, []byte(text)); err != nil {
+		return nil, err
+	}
+
+	if attachment != nil {
+		mimetype := message.Mimetype
+		if mimetype == "" {
+			mimetype = "application/octet-stream"
+		}
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mimetype},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": filename})},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeBase64(part, attachment); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeBase64 writes data base64 encoded in lines of 76 characters.
+func writeBase64(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		if _, err := fmt.Fprintf(w, "%s\r\n", encoded[:76]); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err := fmt.Fprintf(w, "%s\r\n", encoded)
+	return err
+}
+
+// sendMail delivers a mail through SMTP_URL: smtp:// upgrades to TLS with
+// STARTTLS when the server offers it, smtps:// connects with TLS.
+func sendMail(config Config, body []byte) error {
+	u, err := url.Parse(config.SMTPURL)
+	if err != nil {
+		return fmt.Errorf("invalid SMTP_URL: %w", err)
+	}
+	host := u.Hostname()
+	port := u.Port()
+	if port == "" {
+		port = "587"
+		if u.Scheme == "smtps" {
+			port = "465"
+		}
+	}
+
+	var auth smtp.Auth
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth = smtp.PlainAuth("", u.User.Username(), password, host)
+	}
+
+	addr := net.JoinHostPort(host, port)
+	if u.Scheme == "smtp" {
+		return smtp.SendMail(addr, auth, config.EmailFrom, config.EmailTo, body)
+	} else if u.Scheme != "smtps" {
+		return fmt.Errorf("SMTP_URL must be smtp:// or smtps://, not %s://", u.Scheme)
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: webhookTimeout}, "tcp", addr, &tls.Config{ServerName: host})
+	if err != nil {
+		return err
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(config.EmailFrom); err != nil {
+		return err
+	}
+	for _, to := range config.EmailTo {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
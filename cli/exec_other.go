@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+func execSelf(path string, argv []string) error {
+	return errors.New("restarting via exec is not supported on this platform")
+}
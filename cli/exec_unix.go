@@ -0,0 +1,12 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+func execSelf(path string, argv []string) error {
+	return syscall.Exec(path, argv, os.Environ())
+}
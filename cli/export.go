@@ -0,0 +1,283 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Export formats double as file extensions of scheduled exports.
+const (
+	exportFormatJSON = "json"
+	exportFormatCSV  = "csv"
+	exportFormatText = "txt"
+)
+
+// ExportOptions selects the messages of an export. An empty ChatJID exports
+// all chats; Since is inclusive and Until exclusive, zero meaning unbounded.
+// A non-nil Chats further limits the export to those chats. Redact drops
+// message contents as for redacted socket clients.
+type ExportOptions struct {
+	ChatJID string
+	Chats   []string
+	Since   int64
+	Until   int64
+	Format  string
+	Redact  bool
+}
+
+// ExportRecord is an exported message: the stored fields with names
+// resolved where they weren't known on arrival, the time in readable form
+// and what identifies its media. MediaPath is set for downloaded media;
+// MediaSHA256 matches the file_sha256 of export-keys otherwise.
+type ExportRecord struct {
+	Message
+	Time        string `json:"time"`
+	MediaType   string `json:"media_type,omitempty"`
+	Mimetype    string `json:"mimetype,omitempty"`
+	MediaSHA256 string `json:"media_sha256,omitempty"`
+}
+
+func isExportFormat(format string) bool {
+	switch format {
+	case exportFormatJSON, exportFormatCSV, exportFormatText:
+		return true
+	}
+	return false
+}
+
+// exportEncoder writes records in one format. close finishes the output
+// without closing the underlying writer.
+type exportEncoder interface {
+	encode(rec *ExportRecord) error
+	close() error
+}
+
+// exportMessages writes the stored messages matching opts to w, oldest
+// first, and returns how many were written. Rows are written as they are
+// read so exporting a large archive never holds it in memory.
+func (a *App) exportMessages(w io.Writer, opts ExportOptions) (int, error) {
+	if opts.Format == "" {
+		opts.Format = exportFormatJSON
+	}
+	var enc exportEncoder
+	switch opts.Format {
+	case exportFormatJSON:
+		enc = &jsonExport{w: w}
+	case exportFormatCSV:
+		enc = newCSVExport(w)
+	case exportFormatText:
+		enc = &textExport{w: w, allChats: opts.ChatJID == ""}
+	default:
+		return 0, fmt.Errorf("unknown export format %q", opts.Format)
+	}
+
+	var where []string
+	var args []interface{}
+	if opts.ChatJID != "" {
+		where = append(where, "chat_jid = ?")
+		args = append(args, opts.ChatJID)
+	}
+	if opts.Chats != nil {
+		cond, chatArgs := inChats(opts.Chats)
+		where = append(where, cond)
+		args = append(args, chatArgs...)
+	}
+	if opts.Since > 0 {
+		where = append(where, "timestamp >= ?")
+		args = append(args, opts.Since)
+	}
+	if opts.Until > 0 {
+		where = append(where, "timestamp < ?")
+		args = append(args, opts.Until)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(recordColumns(&Message{}), ", "), messagesView)
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY timestamp, id"
+
+	rows, err := a.msgDB.Query(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		var msg Message
+		if err := scanRecord(rows, &msg); err != nil {
+			return count, err
+		}
+		rec, err := a.exportRecord(msg, opts.Redact)
+		if err != nil {
+			return count, err
+		}
+		if err := enc.encode(rec); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+	return count, enc.close()
+}
+
+func (a *App) exportRecord(msg Message, redact bool) (*ExportRecord, error) {
+	if redact {
+		msg = msg.redacted().(Message)
+	}
+	if msg.SenderName == "" {
+		if jid, err := types.ParseJID(msg.SenderJID); err == nil {
+			msg.SenderName = a.getContactName(jid)
+		}
+	}
+	if msg.ChatName == "" {
+		if jid, err := types.ParseJID(msg.ChatJID); err == nil {
+			msg.ChatName = a.getContactName(jid)
+		}
+	}
+
+	rec := &ExportRecord{Message: msg, Time: time.Unix(msg.Timestamp, 0).Format(time.RFC3339)}
+	err := a.msgDB.QueryRow(
+		"SELECT media_type, mimetype, file_sha256 FROM media_keys WHERE message_id = ?", msg.MessageID,
+	).Scan(&rec.MediaType, &rec.Mimetype, &rec.MediaSHA256)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// jsonExport writes a JSON array with one record per line.
+type jsonExport struct {
+	w     io.Writer
+	count int
+}
+
+func (e *jsonExport) encode(rec *ExportRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if e.count == 0 {
+		sep = "[\n"
+	}
+	e.count++
+	_, err = io.WriteString(e.w, sep+string(data))
+	return err
+}
+
+func (e *jsonExport) close() error {
+	end := "\n]\n"
+	if e.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(e.w, end)
+	return err
+}
+
+// csvExport writes a header line and one line per message. Rows are
+// flushed one by one so streamed exports don't stall.
+type csvExport struct {
+	w *csv.Writer
+}
+
+var csvExportHeader = []string{
+	"time", "chat_jid", "chat_name", "sender_jid", "sender_name", "message_id", "text",
+	"quoted_message_id", "media_type", "media_path", "media_sha256",
+}
+
+func newCSVExport(w io.Writer) *csvExport {
+	e := &csvExport{w: csv.NewWriter(w)}
+	e.w.Write(csvExportHeader)
+	return e
+}
+
+func (e *csvExport) encode(rec *ExportRecord) error {
+	e.w.Write([]string{
+		rec.Time, rec.ChatJID, rec.ChatName, rec.SenderJID, rec.SenderName, rec.MessageID, rec.Text,
+		rec.QuotedMessageID, rec.MediaType, rec.MediaPath, rec.MediaSHA256,
+	})
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvExport) close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// textExport writes a chat log for reading, one message per paragraph.
+// Exports of all chats name the chat on every line.
+type textExport struct {
+	w        io.Writer
+	allChats bool
+}
+
+func (e *textExport) encode(rec *ExportRecord) error {
+	var line strings.Builder
+	line.WriteString(formatTimestamp(rec.Timestamp) + "  ")
+	if e.allChats && rec.IsGroup {
+		line.WriteString("[" + rec.ChatName + "] ")
+	}
+	line.WriteString(rec.SenderName + ": ")
+	line.WriteString(strings.ReplaceAll(rec.Text, "\n", "\n    "))
+	if rec.MediaType != "" {
+		ref := rec.MediaPath
+		if ref == "" {
+			ref = "sha256 " + rec.MediaSHA256
+		}
+		fmt.Fprintf(&line, " <%s: %s>", rec.MediaType, ref)
+	}
+	line.WriteString("\n")
+	_, err := io.WriteString(e.w, line.String())
+	return err
+}
+
+func (e *textExport) close() error {
+	return nil
+}
+
+type ExportResult struct {
+	Count int `json:"count"`
+}
+
+// socketExportWriter streams export output to a socket client as "row"
+// lines carrying chunks of the formatted text.
+type socketExportWriter struct {
+	client *socketClient
+	cmd    *SocketCommand
+}
+
+func (w socketExportWriter) Write(p []byte) (int, error) {
+	err := w.client.send(SocketEvent{Type: "row", Action: w.cmd.Action, RequestID: w.cmd.RequestID, Data: string(p)})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (a *App) streamExport(client *socketClient, cmd *SocketCommand) {
+	count, err := a.exportMessages(socketExportWriter{client: client, cmd: cmd}, ExportOptions{
+		ChatJID: cmd.ChatJID,
+		Chats:   client.tenantChats(),
+		Since:   cmd.Since,
+		Until:   cmd.Until,
+		Format:  cmd.Format,
+		Redact:  client.isRedacted(),
+	})
+	if err != nil {
+		client.respondError(cmd, err)
+		return
+	}
+	client.respond(cmd, ExportResult{Count: count})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const exportCheckInterval = time.Minute
+
+// ExportSchedule exports a chat, or all chats when ChatJID is empty, to Dir
+// once per Every ("daily", "weekly" or a duration such as "12h"). Each run
+// writes the messages of the period that just ended to its own file.
+type ExportSchedule struct {
+	Name    string `json:"name"`
+	ChatJID string `json:"chat_jid,omitempty"`
+	Every   string `json:"every"`
+	Dir     string `json:"dir"`
+	Format  string `json:"format,omitempty"`
+
+	interval time.Duration
+}
+
+func loadExportSchedules(path string) ([]ExportSchedule, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var schedules []ExportSchedule
+	if err := json.Unmarshal(data, &schedules); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	names := make(map[string]bool)
+	for i := range schedules {
+		s := &schedules[i]
+		if s.Name == "" || s.Dir == "" {
+			return nil, fmt.Errorf("export schedule %d needs a name and dir", i+1)
+		}
+		if names[s.Name] {
+			return nil, fmt.Errorf("duplicate export schedule %q", s.Name)
+		}
+		names[s.Name] = true
+
+		switch s.Every {
+		case "daily":
+			s.interval = 24 * time.Hour
+		case "weekly":
+			s.interval = 7 * 24 * time.Hour
+		default:
+			s.interval, err = time.ParseDuration(s.Every)
+			if err != nil || s.interval < time.Minute {
+				return nil, fmt.Errorf("export schedule %q: invalid every %q", s.Name, s.Every)
+			}
+		}
+		if s.Format == "" {
+			s.Format = exportFormatJSON
+		}
+		if !isExportFormat(s.Format) {
+			return nil, fmt.Errorf("export schedule %q: unknown format %q", s.Name, s.Format)
+		}
+	}
+	return schedules, nil
+}
+
+// watchExports runs scheduled exports. The end of the last exported period
+// is stored per schedule, so after downtime every missed period is
+// exported on startup.
+func (a *App) watchExports() {
+	ticker := time.NewTicker(exportCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		exports := a.currentExports()
+		for i := range exports {
+			if err := a.runScheduledExport(&exports[i], time.Now()); err != nil {
+				fmt.Fprintf(os.Stderr, "Scheduled export %s failed: %v\n", exports[i].Name, err)
+			}
+		}
+		<-ticker.C
+	}
+}
+
+func (a *App) runScheduledExport(s *ExportSchedule, now time.Time) error {
+	var periodEnd int64
+	err := a.msgDB.QueryRow("SELECT period_end FROM export_runs WHERE name = ?", s.Name).Scan(&periodEnd)
+	if err == sql.ErrNoRows {
+		// The first run covers the period leading up to now
+		periodEnd = now.Add(-s.interval).Unix()
+	} else if err != nil {
+		return err
+	}
+
+	interval := int64(s.interval / time.Second)
+	for periodEnd+interval <= now.Unix() {
+		start, end := periodEnd, periodEnd+interval
+		if err := a.exportPeriod(s, start, end); err != nil {
+			return err
+		}
+		_, err := a.msgDB.Exec(`
+			INSERT INTO export_runs (name, period_end) VALUES (?, ?)
+			ON CONFLICT(name) DO UPDATE SET period_end = excluded.period_end
+		`, s.Name, end)
+		if err != nil {
+			return err
+		}
+		periodEnd = end
+	}
+	return nil
+}
+
+// exportPeriod writes one period's file, named after the schedule and the
+// end of the period. The file only appears once complete.
+func (a *App) exportPeriod(s *ExportSchedule, start int64, end int64) error {
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.%s", s.Name, time.Unix(end, 0).Format("20060102-1504"), s.Format)
+	path := filepath.Join(s.Dir, name)
+
+	tmp, err := os.CreateTemp(s.Dir, "."+name+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	count, err := a.exportMessages(tmp, ExportOptions{ChatJID: s.ChatJID, Since: start, Until: end, Format: s.Format})
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	fmt.Printf("Exported %d messages to %s\n", count, path)
+	return nil
+}
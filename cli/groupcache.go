@@ -0,0 +1,136 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Group metadata is needed for every group message and call, and fetching
+// it is a round trip to the server. Fetched groups are kept in memory and
+// in group_cache, so they also survive restarts, until groupInfoTTL
+// passes or a GroupInfo event says the group changed. Concurrent lookups
+// of the same group share one fetch.
+
+const groupInfoTTL = 6 * time.Hour
+
+type cachedGroup struct {
+	info      *types.GroupInfo
+	fetchedAt time.Time
+}
+
+// groupFetch is a GetGroupInfo call in flight; done closes when it
+// returns.
+type groupFetch struct {
+	done chan struct{}
+	info *types.GroupInfo
+	err  error
+}
+
+type groupCache struct {
+	mu       sync.Mutex
+	byJID    map[types.JID]cachedGroup
+	inflight map[types.JID]*groupFetch
+}
+
+// groupInfo returns a group's metadata, from the cache when it's fresh.
+func (a *App) groupInfo(jid types.JID) (*types.GroupInfo, error) {
+	a.groups.mu.Lock()
+	if cached, ok := a.groups.byJID[jid]; ok && time.Since(cached.fetchedAt) < groupInfoTTL {
+		a.groups.mu.Unlock()
+		return cached.info, nil
+	}
+	if fetch, ok := a.groups.inflight[jid]; ok {
+		a.groups.mu.Unlock()
+		<-fetch.done
+		return fetch.info, fetch.err
+	}
+	fetch := &groupFetch{done: make(chan struct{})}
+	if a.groups.inflight == nil {
+		a.groups.inflight = make(map[types.JID]*groupFetch)
+	}
+	a.groups.inflight[jid] = fetch
+	a.groups.mu.Unlock()
+
+	fetch.info, fetch.err = a.loadGroupInfo(jid)
+
+	a.groups.mu.Lock()
+	delete(a.groups.inflight, jid)
+	a.groups.mu.Unlock()
+	close(fetch.done)
+	return fetch.info, fetch.err
+}
+
+// loadGroupInfo reads a group from group_cache, falling back to the server.
+func (a *App) loadGroupInfo(jid types.JID) (*types.GroupInfo, error) {
+	var data string
+	var fetchedAt int64
+	err := a.msgDB.QueryRow("SELECT info, fetched_at FROM group_cache WHERE jid = ?", jid.String()).Scan(&data, &fetchedAt)
+	if err == nil && time.Since(time.Unix(fetchedAt, 0)) < groupInfoTTL {
+		var info types.GroupInfo
+		if json.Unmarshal([]byte(data), &info) == nil {
+			a.rememberGroup(&info, time.Unix(fetchedAt, 0))
+			return &info, nil
+		}
+	} else if err != nil && err != sql.ErrNoRows {
+		fmt.Fprintf(os.Stderr, "Failed to read group cache: %v\n", err)
+	}
+
+	info, err := a.client.GetGroupInfo(a.ctx, jid)
+	if err != nil {
+		return nil, err
+	}
+	a.cacheGroup(info)
+	return info, nil
+}
+
+func (a *App) rememberGroup(info *types.GroupInfo, fetchedAt time.Time) {
+	a.groups.mu.Lock()
+	if a.groups.byJID == nil {
+		a.groups.byJID = make(map[types.JID]cachedGroup)
+	}
+	a.groups.byJID[info.JID] = cachedGroup{info: info, fetchedAt: fetchedAt}
+	a.groups.mu.Unlock()
+}
+
+// cacheGroup stores freshly fetched metadata, e.g. from GetGroupInfo or a
+// JoinedGroup event.
+func (a *App) cacheGroup(info *types.GroupInfo) {
+	now := time.Now()
+	a.rememberGroup(info, now)
+	data, err := json.Marshal(info)
+	if err == nil {
+		_, err = a.msgDB.Exec(
+			"INSERT OR REPLACE INTO group_cache (jid, info, fetched_at) VALUES (?, ?, ?)",
+			info.JID.String(), string(data), now.Unix(),
+		)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to cache group info: %v\n", err)
+	}
+}
+
+// forgetGroup drops a group whose metadata changed, so the next lookup
+// fetches it again.
+func (a *App) forgetGroup(jid types.JID) {
+	a.groups.mu.Lock()
+	delete(a.groups.byJID, jid)
+	a.groups.mu.Unlock()
+	if _, err := a.msgDB.Exec("DELETE FROM group_cache WHERE jid = ?", jid.String()); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to invalidate group cache: %v\n", err)
+	}
+}
+
+// groupName is the group's subject, empty if it can't be looked up.
+func (a *App) groupName(jid types.JID) string {
+	info, err := a.groupInfo(jid)
+	if err != nil {
+		return ""
+	}
+	return info.Name
+}
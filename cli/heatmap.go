@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	defaultHeatmapPeriod = 7 * 24 * time.Hour
+	maxHeatmapPeriod     = 366 * 24 * time.Hour
+)
+
+// Heatmap counts messages per chat and hour. Counts[i] of a chat covers the
+// hour starting at Start + i*3600, so every chat has Hours entries.
+type Heatmap struct {
+	Start int64         `json:"start"`
+	Hours int           `json:"hours"`
+	Chats []HeatmapChat `json:"chats"`
+}
+
+type HeatmapChat struct {
+	ChatJID  string `json:"chat_jid"`
+	ChatName string `json:"chat_name"`
+	Total    int    `json:"total"`
+	Counts   []int  `json:"counts"`
+}
+
+// heatmap counts incoming and outgoing messages between since and until,
+// for one chat or all of them, busiest chats first. Zero until means now
+// and zero since a week before until.
+func (a *App) heatmap(chatJID string, since int64, until int64) (*Heatmap, error) {
+	if until <= 0 {
+		until = time.Now().Unix()
+	}
+	if since <= 0 {
+		since = until - int64(defaultHeatmapPeriod/time.Second)
+	}
+	if since >= until {
+		return nil, fmt.Errorf("since must be before until")
+	}
+	if until-since > int64(maxHeatmapPeriod/time.Second) {
+		return nil, fmt.Errorf("period is longer than %d days", int(maxHeatmapPeriod.Hours()/24))
+	}
+
+	start := since - since%3600
+	hours := int((until - start + 3599) / 3600)
+
+	filter := "timestamp >= ? AND timestamp < ?"
+	filterArgs := []interface{}{since, until}
+	if chatJID != "" {
+		filter += " AND chat_jid = ?"
+		filterArgs = append(filterArgs, chatJID)
+	}
+	// The filter applies to both halves of the union
+	args := append([]interface{}{start}, filterArgs...)
+	args = append(args, filterArgs...)
+
+	rows, err := a.msgDB.Query(fmt.Sprintf(`
+		SELECT chat_jid, (timestamp - ?) / 3600 AS hour, COUNT(*)
+		FROM (
+			SELECT chat_jid, timestamp FROM %[1]s WHERE %[2]s
+			UNION ALL
+			SELECT chat_jid, timestamp FROM outgoing_messages WHERE %[2]s
+		)
+		GROUP BY chat_jid, hour
+	`, messagesView, filter), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byJID := make(map[string]*HeatmapChat)
+	for rows.Next() {
+		var jid string
+		var hour, count int
+		if err := rows.Scan(&jid, &hour, &count); err != nil {
+			return nil, err
+		}
+		chat := byJID[jid]
+		if chat == nil {
+			chat = &HeatmapChat{ChatJID: jid, Counts: make([]int, hours)}
+			byJID[jid] = chat
+		}
+		chat.Counts[hour] += count
+		chat.Total += count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	heatmap := &Heatmap{Start: start, Hours: hours, Chats: []HeatmapChat{}}
+	for _, chat := range byJID {
+		// Chats you only wrote in have no stored name
+		a.msgDB.QueryRow(fmt.Sprintf(
+			"SELECT chat_name FROM %s WHERE chat_jid = ? ORDER BY timestamp DESC LIMIT 1", messagesView,
+		), chat.ChatJID).Scan(&chat.ChatName)
+		heatmap.Chats = append(heatmap.Chats, *chat)
+	}
+	sort.Slice(heatmap.Chats, func(i, j int) bool {
+		if heatmap.Chats[i].Total != heatmap.Chats[j].Total {
+			return heatmap.Chats[i].Total > heatmap.Chats[j].Total
+		}
+		return heatmap.Chats[i].ChatJID < heatmap.Chats[j].ChatJID
+	})
+	return heatmap, nil
+}
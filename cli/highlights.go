@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Watch keywords highlight messages that matter without addressing you,
+// such as "invoice" or your name typed out instead of @-mentioned.
+// Highlighted messages are stored and draw attention even from muted
+// chats, and are broadcast once more as a "highlight" event.
+
+// parseWatchPatterns reads WATCH_KEYWORDS: comma separated keywords that
+// match case-insensitively anywhere in the text, or regexes written as
+// /pattern/.
+func parseWatchPatterns(value string) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		expr := "(?i)" + regexp.QuoteMeta(entry)
+		if len(entry) > 2 && strings.HasPrefix(entry, "/") && strings.HasSuffix(entry, "/") {
+			expr = entry[1 : len(entry)-1]
+		}
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid watch keyword %q: %w", entry, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+func (a *App) isHighlight(text string) bool {
+	for _, pattern := range a.cfg().WatchPatterns {
+		if pattern.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
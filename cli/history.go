@@ -0,0 +1,157 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 5000
+)
+
+// HistorySummary terminates a streamed history or search response.
+type HistorySummary struct {
+	Count           int   `json:"count"`
+	OldestTimestamp int64 `json:"oldest_timestamp"`
+	OldestID        int64 `json:"oldest_id"`
+	HasMore         bool  `json:"has_more"`
+}
+
+func (a *App) streamHistory(client *socketClient, cmd *SocketCommand) {
+	var where []string
+	var args []interface{}
+	if cmd.ChatJID != "" {
+		where = append(where, "chat_jid = ?")
+		args = append(args, a.canonicalChat(cmd.ChatJID))
+	}
+	if chats := client.tenantChats(); chats != nil {
+		cond, chatArgs := inChats(chats)
+		where = append(where, cond)
+		args = append(args, chatArgs...)
+	}
+	a.streamMessages(client, cmd, where, args)
+}
+
+// streamSearch matches the text, and media_type if given; either is
+// enough to search.
+func (a *App) streamSearch(client *socketClient, cmd *SocketCommand) {
+	if strings.TrimSpace(cmd.Query) == "" && cmd.MediaType == "" {
+		client.respondError(cmd, fmt.Errorf("search requires a query"))
+		return
+	}
+	// Matching text would let a redacted client probe content it can't read
+	if strings.TrimSpace(cmd.Query) != "" && client.isRedacted() {
+		client.respondError(cmd, fmt.Errorf("redacted clients can only search by media_type"))
+		return
+	}
+
+	var where []string
+	var args []interface{}
+	if strings.TrimSpace(cmd.Query) != "" {
+		where = append(where, "text LIKE ? ESCAPE '\\'")
+		args = append(args, "%"+escapeLike(cmd.Query)+"%")
+	}
+	if cmd.ChatJID != "" {
+		where = append(where, "chat_jid = ?")
+		args = append(args, a.canonicalChat(cmd.ChatJID))
+	}
+	if chats := client.tenantChats(); chats != nil {
+		cond, chatArgs := inChats(chats)
+		where = append(where, cond)
+		args = append(args, chatArgs...)
+	}
+	a.streamMessages(client, cmd, where, args)
+}
+
+// streamMessages writes matching messages newest first as individual "row"
+// lines followed by a "result" line carrying a HistorySummary. Rows are
+// written as they are read so large results never sit in memory.
+func (a *App) streamMessages(client *socketClient, cmd *SocketCommand, where []string, args []interface{}) {
+	limit := cmd.Limit
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+
+	// before_id breaks ties between messages sharing the boundary second
+	if cmd.Before > 0 && cmd.BeforeID > 0 {
+		where = append(where, "(timestamp < ? OR (timestamp = ? AND id < ?))")
+		args = append(args, cmd.Before, cmd.Before, cmd.BeforeID)
+	} else if cmd.Before > 0 {
+		where = append(where, "timestamp < ?")
+		args = append(args, cmd.Before)
+	}
+	cond, mediaArgs, err := mediaFilter(cmd.MediaType)
+	if err != nil {
+		client.respondError(cmd, err)
+		return
+	}
+	if cond != "" {
+		where = append(where, cond)
+		args = append(args, mediaArgs...)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(recordColumns(&Message{}), ", "), messagesView)
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	// Fetch one extra row to report whether another page exists
+	query += " ORDER BY timestamp DESC, id DESC LIMIT ?"
+	args = append(args, limit+1)
+
+	rows, err := a.msgDB.Query(query, args...)
+	if err != nil {
+		client.respondError(cmd, err)
+		return
+	}
+	defer rows.Close()
+
+	summary := HistorySummary{}
+	for rows.Next() {
+		if summary.Count == limit {
+			summary.HasMore = true
+			break
+		}
+		var msg Message
+		if err := scanRecord(rows, &msg); err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		err := client.send(SocketEvent{Type: "row", Action: cmd.Action, RequestID: cmd.RequestID, Data: &msg})
+		if err != nil {
+			return
+		}
+		summary.Count++
+		summary.OldestTimestamp = msg.Timestamp
+		summary.OldestID = msg.ID
+	}
+	if err := rows.Err(); err != nil {
+		client.respondError(cmd, err)
+		return
+	}
+
+	client.respond(cmd, summary)
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// messageByRowID looks up a stored message by its local row ID, the short
+// handle reply_to_id takes instead of chat, message and sender JIDs.
+func (a *App) messageByRowID(id int64) (*Message, error) {
+	var msg Message
+	err := a.msgDB.QueryRow(
+		fmt.Sprintf("SELECT id, chat_jid, message_id, sender_jid FROM %s WHERE id = ?", messagesView), id,
+	).Scan(&msg.ID, &msg.ChatJID, &msg.MessageID, &msg.SenderJID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no stored message with id %d", id)
+	} else if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
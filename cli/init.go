@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// runInit interactively writes the config file and links the device, as an
+// easier start than editing environment variables by hand.
+func runInit(config Config) {
+	in := bufio.NewReader(os.Stdin)
+	path := configFilePath()
+
+	fmt.Println("wacli setup")
+	fmt.Println()
+
+	if _, err := os.Stat(path); err == nil {
+		if !askYesNo(in, fmt.Sprintf("%s exists. Overwrite?", path), false) {
+			fmt.Println("Keeping the existing config.")
+			os.Exit(0)
+		}
+	}
+
+	values := map[string]string{}
+
+	config.DataDir = ask(in, "Data directory (session and message databases)", config.DataDir)
+	values["WACLI_DATA_DIR"] = config.DataDir
+	config.MediaDir = filepath.Join(config.DataDir, "media")
+	config.SocketPath = ask(in, "Socket path", config.SocketPath)
+	values["WACLI_SOCKET_PATH"] = config.SocketPath
+
+	fmt.Println()
+	config.NotifyBackend = askChoice(in, "Desktop notifications", []string{"none", notifyBackendNotifySend}, "none")
+	if config.NotifyBackend == "none" {
+		config.NotifyBackend = ""
+	}
+	values["NOTIFY_BACKEND"] = config.NotifyBackend
+
+	config.AttentionMode = askChoice(in, "Attention hook", []string{attentionModeDisabled, attentionModeRworkspaces, attentionModeCommand}, attentionModeDisabled)
+	values["ATTENTION_MODE"] = config.AttentionMode
+	if config.AttentionMode == attentionModeCommand {
+		config.AttentionCommand = ask(in, "Attention command (message JSON on stdin)", config.AttentionCommand)
+		values["ATTENTION_COMMAND"] = config.AttentionCommand
+	}
+
+	fmt.Println()
+	config.IncludeMutedMessages = askYesNo(in, "Include messages from muted chats?", config.IncludeMutedMessages)
+	values["INCLUDE_MUTED_MESSAGES"] = fmt.Sprint(config.IncludeMutedMessages)
+	config.IncludeStatusMessages = askYesNo(in, "Include status updates?", config.IncludeStatusMessages)
+	values["INCLUDE_STATUS_MESSAGES"] = fmt.Sprint(config.IncludeStatusMessages)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create config directory: %v\n", err)
+		os.Exit(1)
+	}
+	if err := godotenv.Write(values, path); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write config: %v\n", err)
+		os.Exit(1)
+	}
+	os.Chmod(path, 0600)
+	fmt.Printf("\nWrote %s\n\n", path)
+
+	// Derive the tokens and other files from the chosen data directory
+	config, err := loadConfig(pathFlags{DataDir: config.DataDir, SocketPath: config.SocketPath, MediaDir: config.flags.MediaDir})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		os.Exit(1)
+	}
+	app := newApp(config)
+	defer app.msgDB.Close()
+
+	if app.client.Store.ID != nil {
+		fmt.Println("Device already logged in. You can now run 'wacli daemon'.")
+		return
+	}
+
+	var loginArgs []string
+	if askChoice(in, "Login method", []string{"qr", "phone"}, "qr") == "phone" {
+		loginArgs = []string{"--phone", strings.TrimLeft(ask(in, "Phone number with country code", ""), "+")}
+	}
+	runLogin(app, loginArgs)
+}
+
+func ask(in *bufio.Reader, question string, def string) string {
+	if def != "" {
+		fmt.Printf("%s [%s]: ", question, def)
+	} else {
+		fmt.Printf("%s: ", question)
+	}
+	line, err := in.ReadString('\n')
+	if err != nil && line == "" {
+		fmt.Println()
+		os.Exit(1)
+	}
+	if answer := strings.TrimSpace(line); answer != "" {
+		return answer
+	}
+	return def
+}
+
+func askChoice(in *bufio.Reader, question string, choices []string, def string) string {
+	for {
+		answer := ask(in, fmt.Sprintf("%s (%s)", question, strings.Join(choices, "/")), def)
+		for _, choice := range choices {
+			if answer == choice {
+				return answer
+			}
+		}
+		fmt.Printf("Please answer one of: %s\n", strings.Join(choices, ", "))
+	}
+}
+
+func askYesNo(in *bufio.Reader, question string, def bool) bool {
+	defAnswer := "n"
+	if def {
+		defAnswer = "y"
+	}
+	for {
+		switch strings.ToLower(ask(in, question+" (y/n)", defAnswer)) {
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		}
+	}
+}
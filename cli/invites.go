@@ -0,0 +1,134 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// InviteLink is a group's invite link, new after a revoke.
+type InviteLink struct {
+	ChatJID string `json:"chat_jid"`
+	Link    string `json:"link"`
+}
+
+func (l InviteLink) scopeChatJID() string { return l.ChatJID }
+
+// GroupInvite is a group invite someone sent you in a chat, kept in
+// group_invites until accept_invite uses it.
+type GroupInvite struct {
+	ChatJID    string `json:"chat_jid"`
+	MessageID  string `json:"message_id"`
+	SenderJID  string `json:"sender_jid"`
+	SenderName string `json:"sender_name"`
+	GroupJID   string `json:"group_jid"`
+	GroupName  string `json:"group_name"`
+	Code       string `json:"code"`
+	Expiration int64  `json:"expiration"`
+	Caption    string `json:"caption"`
+}
+
+func (i GroupInvite) scopeChatJID() string { return i.ChatJID }
+
+func (i GroupInvite) redacted() interface{} {
+	i.Code = ""
+	i.Caption = ""
+	return i
+}
+
+// GroupJoin answers join_via_link and accept_invite.
+type GroupJoin struct {
+	ChatJID string `json:"chat_jid"`
+	Name    string `json:"name"`
+}
+
+// inviteLink returns a group's invite link; reset revokes the current one
+// and returns its replacement.
+func (a *App) inviteLink(chatJID string, reset bool) (InviteLink, error) {
+	jid, err := types.ParseJID(chatJID)
+	if err != nil || jid.Server != types.GroupServer {
+		return InviteLink{}, fmt.Errorf("invalid group JID %q", chatJID)
+	}
+	link, err := a.client.GetGroupInviteLink(a.ctx, jid, reset)
+	if err != nil {
+		return InviteLink{}, err
+	}
+	return InviteLink{ChatJID: jid.String(), Link: link}, nil
+}
+
+// joinViaLink joins a group by its https://chat.whatsapp.com/ link or the
+// link's code.
+func (a *App) joinViaLink(link string) (GroupJoin, error) {
+	if link == "" {
+		return GroupJoin{}, fmt.Errorf("link is required")
+	}
+	jid, err := a.client.JoinGroupWithLink(a.ctx, link)
+	if err != nil {
+		return GroupJoin{}, err
+	}
+	return GroupJoin{ChatJID: jid.String(), Name: a.groupName(jid)}, nil
+}
+
+// handleGroupInvite keeps an incoming group invite for accept_invite and
+// broadcasts it as a group_invite event.
+func (a *App) handleGroupInvite(msg *events.Message, invite *waE2E.GroupInviteMessage) {
+	evt := GroupInvite{
+		ChatJID:    a.canonicalChat(msg.Info.Chat.String()),
+		MessageID:  msg.Info.ID,
+		SenderJID:  msg.Info.Sender.ToNonAD().String(),
+		SenderName: a.getSenderName(msg),
+		GroupJID:   invite.GetGroupJID(),
+		GroupName:  invite.GetGroupName(),
+		Code:       invite.GetInviteCode(),
+		Expiration: invite.GetInviteExpiration(),
+		Caption:    invite.GetCaption(),
+	}
+	_, err := a.msgDB.Exec(`
+		INSERT OR REPLACE INTO group_invites
+			(chat_jid, message_id, sender_jid, group_jid, group_name, code, expiration, received_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, evt.ChatJID, evt.MessageID, evt.SenderJID, evt.GroupJID, evt.GroupName, evt.Code, evt.Expiration, time.Now().Unix())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save group invite: %v\n", err)
+	}
+	a.broadcastEvent("group_invite", evt)
+}
+
+// acceptInvite joins the group of an invite received in chatJID.
+func (a *App) acceptInvite(chatJID string, messageID string) (GroupJoin, error) {
+	var groupJID, senderJID, code string
+	var expiration int64
+	err := a.msgDB.QueryRow(
+		"SELECT group_jid, sender_jid, code, expiration FROM group_invites WHERE chat_jid = ? AND message_id = ?",
+		a.canonicalChat(chatJID), messageID,
+	).Scan(&groupJID, &senderJID, &code, &expiration)
+	if err == sql.ErrNoRows {
+		return GroupJoin{}, fmt.Errorf("no group invite %s in %s", messageID, chatJID)
+	} else if err != nil {
+		return GroupJoin{}, err
+	}
+	if expiration > 0 && time.Now().Unix() > expiration {
+		return GroupJoin{}, fmt.Errorf("invite expired at %s", time.Unix(expiration, 0).Format(time.RFC3339))
+	}
+
+	group, err := types.ParseJID(groupJID)
+	if err != nil {
+		return GroupJoin{}, fmt.Errorf("invalid group JID in invite: %w", err)
+	}
+	inviter, err := types.ParseJID(senderJID)
+	if err != nil {
+		return GroupJoin{}, fmt.Errorf("invalid inviter JID: %w", err)
+	}
+	if err := a.client.JoinGroupWithInvite(a.ctx, group, inviter, code, expiration); err != nil {
+		return GroupJoin{}, err
+	}
+	if _, err := a.msgDB.Exec("DELETE FROM group_invites WHERE chat_jid = ? AND message_id = ?", a.canonicalChat(chatJID), messageID); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to remove accepted group invite: %v\n", err)
+	}
+	return GroupJoin{ChatJID: group.String(), Name: a.groupName(group)}, nil
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+const (
+	ircServerName   = "wacli"
+	ircWriteTimeout = 10 * time.Second
+	ircSource       = "irc"
+	ircLineLimit    = 400
+)
+
+// The IRC gateway lets any IRC client be a frontend: group chats are
+// channels, contacts are nicks and direct chats are queries with them.
+// Incoming messages arrive as PRIVMSGs, joining their channel on the way
+// if needed, and PRIVMSGs from the client are sent through sendMessage.
+
+type ircServer struct {
+	app      *App
+	listener net.Listener
+	password string
+
+	mu      sync.Mutex
+	clients map[*ircClient]struct{}
+	// lowercased channel or nick to JID, and JID to its name
+	channels  map[string]string
+	channelOf map[string]string
+	nicks     map[string]string
+	nickOf    map[string]string
+}
+
+type ircClient struct {
+	conn     net.Conn
+	user     bool
+	passOK   bool
+	welcomed bool
+	writeMu  sync.Mutex
+	// guarded by the server's mu once welcomed
+	nick   string
+	joined map[string]bool
+}
+
+// startIRCServer listens on IRC_LISTEN. It needs IRC_PASSWORD even on
+// loopback, since any local process that connects could send.
+func (a *App) startIRCServer() (*ircServer, error) {
+	config := a.cfg()
+	if config.IRCListen == "" {
+		return nil, nil
+	}
+	if _, _, err := net.SplitHostPort(config.IRCListen); err != nil {
+		return nil, fmt.Errorf("invalid IRC_LISTEN: %w", err)
+	}
+	if config.IRCPassword == "" {
+		return nil, fmt.Errorf("IRC_LISTEN needs IRC_PASSWORD")
+	}
+
+	listener, err := net.Listen("tcp", config.IRCListen)
+	if err != nil {
+		return nil, err
+	}
+	s := &ircServer{
+		app:       a,
+		listener:  listener,
+		password:  config.IRCPassword,
+		clients:   make(map[*ircClient]struct{}),
+		channels:  make(map[string]string),
+		channelOf: make(map[string]string),
+		nicks:     make(map[string]string),
+		nickOf:    make(map[string]string),
+	}
+	a.goSafe("irc listener", func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			a.goSafe("irc client", func() { s.serve(conn) })
+		}
+	})
+	return s, nil
+}
+
+// ircName turns a chat or contact name into a valid nick or channel name,
+// falling back to the JID's user part.
+func ircName(name string, fallback string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', strings.ContainsRune("-_[]{}\\|^`", r):
+			b.WriteRune(r)
+		case r == ' ' || r == '.':
+			b.WriteRune('_')
+		}
+	}
+	result := strings.Trim(b.String(), "_")
+	if result == "" {
+		result = fallback
+	}
+	if c := result[0]; (c >= '0' && c <= '9') || c == '-' {
+		result = "_" + result
+	}
+	return result
+}
+
+// register names a JID in one of the registries, keeping names unique.
+func register(byName map[string]string, nameOf map[string]string, jid string, name string) string {
+	if existing, ok := nameOf[jid]; ok {
+		return existing
+	}
+	unique := name
+	for i := 2; byName[strings.ToLower(unique)] != ""; i++ {
+		unique = name + strconv.Itoa(i)
+	}
+	byName[strings.ToLower(unique)] = jid
+	nameOf[jid] = unique
+	return unique
+}
+
+func (s *ircServer) channelFor(chatJID string, chatName string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jid, _ := types.ParseJID(chatJID)
+	return register(s.channels, s.channelOf, chatJID, "#"+ircName(chatName, jid.User))
+}
+
+func (s *ircServer) nickFor(senderJID string, senderName string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jid, _ := types.ParseJID(senderJID)
+	return register(s.nicks, s.nickOf, jid.ToNonAD().String(), ircName(senderName, jid.User))
+}
+
+// resolve finds the JID of a channel or nick, naming the stored chats
+// first if it isn't known yet.
+func (s *ircServer) resolve(target string) string {
+	registry := s.nicks
+	if strings.HasPrefix(target, "#") {
+		registry = s.channels
+	}
+	s.mu.Lock()
+	jid := registry[strings.ToLower(target)]
+	s.mu.Unlock()
+	if jid != "" {
+		return jid
+	}
+
+	chats, err := s.app.listChats()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list chats for IRC: %v\n", err)
+		return ""
+	}
+	for _, chat := range chats {
+		if chat.IsGroup {
+			s.channelFor(chat.ChatJID, chat.ChatName)
+		} else {
+			s.nickFor(chat.ChatJID, chat.ChatName)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return registry[strings.ToLower(target)]
+}
+
+// Emit delivers incoming messages to the connected IRC clients. It runs
+// behind a queuedSink, so a stuck client never holds up the daemon.
+func (s *ircServer) Emit(eventType string, payload interface{}) error {
+	msg, ok := payload.(*Message)
+	if !ok || messageEventType(msg) != eventType {
+		return nil
+	}
+	from := s.nickFor(msg.SenderJID, msg.SenderName)
+	channel := ""
+	if msg.IsGroup || msg.IsNewsletter {
+		channel = s.channelFor(msg.ChatJID, msg.ChatName)
+	}
+
+	type recipient struct {
+		client *ircClient
+		nick   string
+		joined bool
+	}
+	s.mu.Lock()
+	recipients := make([]recipient, 0, len(s.clients))
+	for client := range s.clients {
+		recipients = append(recipients, recipient{client, client.nick, client.joined[strings.ToLower(channel)]})
+	}
+	s.mu.Unlock()
+
+	prefix := fmt.Sprintf(":%s!%s@whatsapp", from, from)
+	for _, r := range recipients {
+		client, target := r.client, channel
+		if channel == "" {
+			target = r.nick
+		} else if !r.joined {
+			s.join(client, channel, msg.ChatJID, msg.ChatName)
+		}
+		for _, line := range splitIRCText(msg.displayText()) {
+			client.send("%s PRIVMSG %s :%s", prefix, target, line)
+		}
+	}
+	return nil
+}
+
+func (s *ircServer) Close() error {
+	s.listener.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for client := range s.clients {
+		client.conn.Close()
+	}
+	return nil
+}
+
+// splitIRCText breaks a message into lines short enough for IRC.
+func splitIRCText(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		runes := []rune(strings.TrimRight(line, "\r"))
+		for len(runes) > ircLineLimit {
+			lines = append(lines, string(runes[:ircLineLimit]))
+			runes = runes[ircLineLimit:]
+		}
+		lines = append(lines, string(runes))
+	}
+	return lines
+}
+
+func (c *ircClient) send(format string, args ...interface{}) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(ircWriteTimeout))
+	if _, err := fmt.Fprintf(c.conn, format+"\r\n", args...); err != nil {
+		c.conn.Close()
+	}
+}
+
+// reply sends a numeric reply to the client.
+func (c *ircClient) reply(code string, params string) {
+	nick := c.nick
+	if nick == "" {
+		nick = "*"
+	}
+	c.send(":%s %s %s %s", ircServerName, code, nick, params)
+}
+
+func (s *ircServer) join(client *ircClient, channel string, chatJID string, chatName string) {
+	s.mu.Lock()
+	client.joined[strings.ToLower(channel)] = true
+	nick := client.nick
+	s.mu.Unlock()
+	client.send(":%s!%s@wacli JOIN %s", nick, nick, channel)
+	client.send(":%s 332 %s %s :%s (%s)", ircServerName, nick, channel, chatName, chatJID)
+	client.send(":%s 353 %s = %s :%s", ircServerName, nick, channel, nick)
+	client.send(":%s 366 %s %s :End of /NAMES list", ircServerName, nick, channel)
+}
+
+// parseIRCLine splits a line into its command and parameters, the last of
+// which may be a trailing parameter with spaces.
+func parseIRCLine(line string) (string, []string) {
+	if strings.HasPrefix(line, ":") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+	var params []string
+	for line != "" {
+		if strings.HasPrefix(line, ":") {
+			params = append(params, line[1:])
+			break
+		}
+		var param string
+		param, line, _ = strings.Cut(line, " ")
+		if param != "" {
+			params = append(params, param)
+		}
+	}
+	if len(params) == 0 {
+		return "", nil
+	}
+	return strings.ToUpper(params[0]), params[1:]
+}
+
+func (s *ircServer) serve(conn net.Conn) {
+	client := &ircClient{conn: conn, joined: make(map[string]bool)}
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, client)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), 64*1024)
+	for scanner.Scan() {
+		command, params := parseIRCLine(strings.TrimRight(scanner.Text(), "\r"))
+		if command == "" {
+			continue
+		}
+		if !s.handle(client, command, params) {
+			return
+		}
+	}
+}
+
+// handle runs one command and reports whether the connection stays open.
+func (s *ircServer) handle(client *ircClient, command string, params []string) bool {
+	param := func(i int) string {
+		if i < len(params) {
+			return params[i]
+		}
+		return ""
+	}
+
+	switch command {
+	case "CAP":
+		if strings.ToUpper(param(0)) == "LS" {
+			client.send(":%s CAP * LS :", ircServerName)
+		}
+		return true
+	case "PASS":
+		client.passOK = subtle.ConstantTimeCompare([]byte(param(0)), []byte(s.password)) == 1
+		return true
+	case "NICK":
+		if param(0) == "" {
+			client.reply("431", ":No nickname given")
+			return true
+		}
+		if client.welcomed {
+			client.send(":%s!%s@wacli NICK %s", client.nick, client.nick, param(0))
+		}
+		s.mu.Lock()
+		client.nick = param(0)
+		s.mu.Unlock()
+	case "USER":
+		client.user = true
+	case "PING":
+		client.send(":%s PONG %s :%s", ircServerName, ircServerName, param(0))
+		return true
+	case "QUIT":
+		return false
+	}
+
+	if !client.welcomed {
+		if client.nick == "" || !client.user {
+			return true
+		}
+		if !client.passOK {
+			client.reply("464", ":Password incorrect")
+			return false
+		}
+		client.welcomed = true
+		s.mu.Lock()
+		s.clients[client] = struct{}{}
+		s.mu.Unlock()
+		client.reply("001", ":Welcome to WhatsApp through wacli")
+		client.reply("002", ":Your host is "+ircServerName+", running wacli "+version)
+		client.reply("003", ":Group chats are channels, contacts are nicks; /list shows the chats")
+		client.reply("004", ircServerName+" "+version+" o o")
+		client.reply("422", ":MOTD File is missing")
+		return true
+	}
+
+	switch command {
+	case "NICK", "USER":
+	case "JOIN":
+		for _, channel := range strings.Split(param(0), ",") {
+			chatJID := s.resolve(channel)
+			if chatJID == "" || !strings.HasPrefix(channel, "#") {
+				client.reply("403", channel+" :No such chat")
+				continue
+			}
+			s.mu.Lock()
+			channel = s.channelOf[chatJID]
+			s.mu.Unlock()
+			jid, _ := types.ParseJID(chatJID)
+			s.join(client, channel, chatJID, s.app.groupName(jid))
+		}
+	case "PART":
+		for _, channel := range strings.Split(param(0), ",") {
+			s.mu.Lock()
+			delete(client.joined, strings.ToLower(channel))
+			s.mu.Unlock()
+			client.send(":%s!%s@wacli PART %s", client.nick, client.nick, channel)
+		}
+	case "PRIVMSG", "NOTICE":
+		target, text := param(0), param(1)
+		// /me arrives as a CTCP ACTION
+		if strings.HasPrefix(text, "\x01ACTION ") {
+			text = "_" + strings.TrimSuffix(strings.TrimPrefix(text, "\x01ACTION "), "\x01") + "_"
+		}
+		chatJID := s.resolve(target)
+		if chatJID == "" {
+			client.reply("401", target+" :No such chat")
+			return true
+		}
+		// The gateway is a bridge, so the kill switch stops it like plugins
+		var id types.MessageID
+		err := s.app.automationAllowed(ircSource)
+		if err == nil {
+			id, err = s.app.sendMessage(chatJID, text, nil, priorityInteractive)
+		}
+		s.app.recordSent(ircSource, ircSource, chatJID, text, id, err)
+		if err != nil && command == "PRIVMSG" {
+			client.send(":%s NOTICE %s :Not sent: %s", ircServerName, client.nick, err)
+		}
+	case "LIST":
+		chats, err := s.app.listChats()
+		if err != nil {
+			client.send(":%s NOTICE %s :%s", ircServerName, client.nick, err)
+			return true
+		}
+		client.reply("321", "Channel :Users Name")
+		for _, chat := range chats {
+			if chat.IsGroup {
+				channel := s.channelFor(chat.ChatJID, chat.ChatName)
+				client.reply("322", fmt.Sprintf("%s %d :%s", channel, chat.MessageCount, chat.ChatName))
+			}
+		}
+		client.reply("323", ":End of /LIST")
+	case "WHOIS":
+		nick := param(len(params) - 1)
+		jid := s.resolve(nick)
+		if jid == "" {
+			client.reply("401", nick+" :No such nick")
+		} else {
+			parsed, _ := types.ParseJID(jid)
+			client.reply("311", fmt.Sprintf("%s %s whatsapp * :%s", nick, parsed.User, jid))
+		}
+		client.reply("318", nick+" :End of /WHOIS list")
+	case "MODE":
+		if strings.HasPrefix(param(0), "#") && len(params) == 1 {
+			client.reply("324", param(0)+" +nt")
+		}
+	case "WHO":
+		client.reply("315", param(0)+" :End of /WHO list")
+	default:
+		client.reply("421", command+" :Unknown command")
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// KeyExport is the output of `wacli export-keys`. It only contains public
+// identity material; private keys never leave the device store.
+type KeyExport struct {
+	ExportedAt int64                 `json:"exported_at"`
+	Identity   IdentityExport        `json:"identity"`
+	Chats      map[string][]MediaKey `json:"chats"`
+}
+
+type IdentityExport struct {
+	JID                 string `json:"jid"`
+	RegistrationID      uint32 `json:"registration_id"`
+	IdentityPublicKey   string `json:"identity_public_key"`
+	AccountSignatureKey string `json:"account_signature_key"`
+}
+
+const keyExportWarning = `WARNING: this writes the media keys of every stored media message.
+Anyone holding the file and the encrypted media (which WhatsApp's CDN serves
+to anyone with the direct path until it expires) can decrypt that media.
+Keep the export on encrypted storage and delete it after the audit.`
+
+func runExportKeys(app *App, args []string) {
+	fs := flag.NewFlagSet("export-keys", flag.ExitOnError)
+	out := fs.String("out", "", "file to write the export to (created with mode 0600)")
+	var chats stringList
+	fs.Var(&chats, "chat", "only export this chat (repeatable)")
+	yes := fs.Bool("yes", false, "skip the confirmation prompt")
+	fs.Parse(args)
+
+	if *out == "" {
+		fmt.Fprintln(os.Stderr, "Usage: wacli export-keys --out FILE [--chat JID]... [--yes]")
+		os.Exit(1)
+	}
+
+	fmt.Fprintln(os.Stderr, keyExportWarning)
+	if !*yes && !askYesNo(bufio.NewReader(os.Stdin), "Continue?", false) {
+		os.Exit(1)
+	}
+
+	export, err := app.exportKeys(chats)
+	exitOnError(err)
+
+	data, err := json.MarshalIndent(export, "", "  ")
+	exitOnError(err)
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	exitOnError(err)
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		exitOnError(err)
+	}
+	exitOnError(f.Close())
+
+	count := 0
+	for _, keys := range export.Chats {
+		count += len(keys)
+	}
+	fmt.Printf("Exported %d media keys from %d chats to %s\n", count, len(export.Chats), *out)
+}
+
+func (a *App) exportKeys(chats []string) (*KeyExport, error) {
+	device := a.client.Store
+	if device.ID == nil {
+		return nil, fmt.Errorf("device not logged in")
+	}
+
+	export := &KeyExport{
+		ExportedAt: time.Now().Unix(),
+		Identity: IdentityExport{
+			JID:               device.ID.String(),
+			RegistrationID:    device.RegistrationID,
+			IdentityPublicKey: hex.EncodeToString(device.IdentityKey.Pub[:]),
+		},
+		Chats: map[string][]MediaKey{},
+	}
+	if device.Account != nil {
+		export.Identity.AccountSignatureKey = hex.EncodeToString(device.Account.GetAccountSignatureKey())
+	}
+
+	query := `SELECT message_id, chat_jid, timestamp, media_type, mimetype, direct_path, media_key,
+		file_sha256, file_enc_sha256, file_length FROM media_keys`
+	var args []interface{}
+	if len(chats) > 0 {
+		query += " WHERE chat_jid IN (?" + strings.Repeat(", ?", len(chats)-1) + ")"
+		for _, chat := range chats {
+			args = append(args, chat)
+		}
+	}
+	query += " ORDER BY chat_jid, timestamp"
+
+	rows, err := a.msgDB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var k MediaKey
+		err := rows.Scan(&k.MessageID, &k.ChatJID, &k.Timestamp, &k.MediaType, &k.Mimetype, &k.DirectPath,
+			&k.MediaKey, &k.FileSHA256, &k.FileEncSHA256, &k.FileLength)
+		if err != nil {
+			return nil, err
+		}
+		export.Chats[k.ChatJID] = append(export.Chats[k.ChatJID], k)
+	}
+	return export, rows.Err()
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Reply latency is the time from a message arriving at the daemon to
+// wacli's answer in that chat being accepted by the server, the number
+// that matters to customer-facing responders running on the socket.
+
+const (
+	defaultLatencyWindow = 7 * 24 * time.Hour
+	latencyRetention     = 30 * 24 * time.Hour
+	sloCheckInterval     = 10 * time.Second
+)
+
+// replyClocks remembers per chat when the oldest message still waiting
+// for an answer arrived.
+type replyClocks struct {
+	mu      sync.Mutex
+	waiting map[string]*replyClock
+}
+
+type replyClock struct {
+	chatName   string
+	receivedAt time.Time
+	alerted    bool
+}
+
+// LatencyStats summarizes reply latencies in milliseconds. Breaches count
+// replies slower than REPLY_SLO.
+type LatencyStats struct {
+	Count    int   `json:"count"`
+	P50Ms    int64 `json:"p50_ms"`
+	P90Ms    int64 `json:"p90_ms"`
+	P99Ms    int64 `json:"p99_ms"`
+	MaxMs    int64 `json:"max_ms"`
+	Breaches int   `json:"breaches"`
+}
+
+type ChatLatency struct {
+	ChatJID string `json:"chat_jid"`
+	LatencyStats
+}
+
+type ReplyLatencyReport struct {
+	Since   int64         `json:"since"`
+	SLOMs   int64         `json:"slo_ms,omitempty"`
+	Overall LatencyStats  `json:"overall"`
+	Chats   []ChatLatency `json:"chats"`
+}
+
+// SLOBreach is broadcast as reply_slo_breached when a message has waited
+// longer than REPLY_SLO, once when the deadline passes unanswered and
+// again with Replied set if a late answer follows.
+type SLOBreach struct {
+	ChatJID   string `json:"chat_jid"`
+	ChatName  string `json:"chat_name"`
+	LatencyMs int64  `json:"latency_ms"`
+	SLOMs     int64  `json:"slo_ms"`
+	Replied   bool   `json:"replied"`
+}
+
+// startReplyClock starts timing an answer to msg, unless an earlier
+// message in the chat is already waiting.
+func (a *App) startReplyClock(msg *Message) {
+	if msg.IsGroup && !msg.IsMentioned && !msg.IsReplyToMe {
+		return
+	}
+	a.replyClocks.mu.Lock()
+	defer a.replyClocks.mu.Unlock()
+	if a.replyClocks.waiting == nil {
+		a.replyClocks.waiting = make(map[string]*replyClock)
+	}
+	if _, ok := a.replyClocks.waiting[msg.ChatJID]; !ok {
+		a.replyClocks.waiting[msg.ChatJID] = &replyClock{chatName: msg.ChatName, receivedAt: time.Now()}
+	}
+}
+
+// stopReplyClock ends the wait in a chat. Sends from wacli are recorded as
+// samples; an answer from another device just clears the wait.
+func (a *App) stopReplyClock(chatJID string, repliedAt time.Time, record bool) {
+	a.replyClocks.mu.Lock()
+	clock, ok := a.replyClocks.waiting[chatJID]
+	delete(a.replyClocks.waiting, chatJID)
+	a.replyClocks.mu.Unlock()
+	if !ok || !record {
+		return
+	}
+
+	latency := repliedAt.Sub(clock.receivedAt)
+	if latency < 0 {
+		latency = 0
+	}
+	_, err := a.msgDB.Exec("INSERT INTO reply_latency (chat_jid, received_at, replied_at, latency_ms) VALUES (?, ?, ?, ?)",
+		chatJID, clock.receivedAt.Unix(), repliedAt.Unix(), latency.Milliseconds())
+	if err == nil {
+		_, err = a.msgDB.Exec("DELETE FROM reply_latency WHERE replied_at < ?", time.Now().Add(-latencyRetention).Unix())
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to record reply latency: %v\n", err)
+	}
+
+	if a.cfg().ReplySLO > 0 && latency > a.cfg().ReplySLO {
+		a.alertSLOBreach(SLOBreach{
+			ChatJID:   chatJID,
+			ChatName:  clock.chatName,
+			LatencyMs: latency.Milliseconds(),
+			SLOMs:     a.cfg().ReplySLO.Milliseconds(),
+			Replied:   true,
+		})
+	}
+}
+
+// watchReplySLO alerts once for every message left unanswered past
+// REPLY_SLO.
+func (a *App) watchReplySLO() {
+	if a.cfg().ReplySLO == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(sloCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		var breaches []SLOBreach
+		a.replyClocks.mu.Lock()
+		for chatJID, clock := range a.replyClocks.waiting {
+			waited := time.Since(clock.receivedAt)
+			if clock.alerted || waited <= a.cfg().ReplySLO {
+				continue
+			}
+			clock.alerted = true
+			breaches = append(breaches, SLOBreach{
+				ChatJID:   chatJID,
+				ChatName:  clock.chatName,
+				LatencyMs: waited.Milliseconds(),
+				SLOMs:     a.cfg().ReplySLO.Milliseconds(),
+			})
+		}
+		a.replyClocks.mu.Unlock()
+
+		for _, breach := range breaches {
+			a.alertSLOBreach(breach)
+		}
+	}
+}
+
+func (a *App) alertSLOBreach(breach SLOBreach) {
+	a.broadcastEvent("reply_slo_breached", breach)
+	if a.currentNotifier() == nil {
+		return
+	}
+	body := fmt.Sprintf("Unanswered for %s", time.Duration(breach.LatencyMs)*time.Millisecond)
+	if breach.Replied {
+		body = fmt.Sprintf("Answered after %s", time.Duration(breach.LatencyMs)*time.Millisecond)
+	}
+	a.sendNotification(Notification{
+		Title:    "Reply SLO missed in " + breach.ChatName,
+		Body:     body,
+		Urgency:  "normal",
+		Category: "im",
+	})
+}
+
+// replyLatency reports latency percentiles since the given time, overall
+// and per chat, slowest chat first. Nil chats covers all chats.
+func (a *App) replyLatency(chatJID string, since int64, chats []string) (*ReplyLatencyReport, error) {
+	if since == 0 {
+		since = time.Now().Add(-defaultLatencyWindow).Unix()
+	}
+	query := "SELECT chat_jid, latency_ms FROM reply_latency WHERE replied_at >= ?"
+	args := []interface{}{since}
+	if chatJID != "" {
+		query += " AND chat_jid = ?"
+		args = append(args, chatJID)
+	}
+	rows, err := a.msgDB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []int64
+	byChat := make(map[string][]int64)
+	for rows.Next() {
+		var jid string
+		var latency int64
+		if err := rows.Scan(&jid, &latency); err != nil {
+			return nil, err
+		}
+		if chats != nil && !containsString(chats, jid) {
+			continue
+		}
+		all = append(all, latency)
+		byChat[jid] = append(byChat[jid], latency)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	slo := a.cfg().ReplySLO.Milliseconds()
+	report := &ReplyLatencyReport{Since: since, SLOMs: slo, Overall: latencyStats(all, slo), Chats: []ChatLatency{}}
+	for jid, samples := range byChat {
+		report.Chats = append(report.Chats, ChatLatency{ChatJID: jid, LatencyStats: latencyStats(samples, slo)})
+	}
+	sort.Slice(report.Chats, func(i, j int) bool {
+		return report.Chats[i].P90Ms > report.Chats[j].P90Ms
+	})
+	return report, nil
+}
+
+func latencyStats(samples []int64, slo int64) LatencyStats {
+	stats := LatencyStats{Count: len(samples)}
+	if len(samples) == 0 {
+		return stats
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	// Nearest rank, so every percentile is an actual sample
+	rank := func(p float64) int64 {
+		return samples[int(math.Ceil(p*float64(len(samples))))-1]
+	}
+	stats.P50Ms, stats.P90Ms, stats.P99Ms = rank(0.5), rank(0.9), rank(0.99)
+	stats.MaxMs = samples[len(samples)-1]
+	for _, latency := range samples {
+		if slo > 0 && latency > slo {
+			stats.Breaches++
+		}
+	}
+	return stats
+}
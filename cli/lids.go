@@ -0,0 +1,178 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// WhatsApp is moving users from phone number JIDs to LIDs, so one contact
+// can send as either. lid_mappings keeps each LID's phone number JID as
+// messages reveal it, and senders are stored under the phone number JID
+// once it is known, so a contact's messages stay under one sender.
+
+// LIDMapping answers resolve_lid. Source is "stored" for mappings wacli
+// learned from messages and "client" for ones only whatsmeow knew.
+type LIDMapping struct {
+	LID    string `json:"lid"`
+	PN     string `json:"pn"`
+	Source string `json:"source"`
+}
+
+// learnAddressing records the LID mapping of a message whose sender is
+// named both ways.
+func (a *App) learnAddressing(msg *events.Message) {
+	sender, alt := msg.Info.Sender, msg.Info.SenderAlt
+	if sender.Server == types.DefaultUserServer {
+		sender, alt = alt, sender
+	}
+	if sender.Server != types.HiddenUserServer || alt.Server != types.DefaultUserServer {
+		return
+	}
+	if err := a.learnLID(sender, alt); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to store LID mapping: %v\n", err)
+	}
+}
+
+// learnLID stores a mapping and, when it is new or changed, moves messages
+// stored under the LID over to the phone number JID.
+func (a *App) learnLID(lid types.JID, pn types.JID) error {
+	lid, pn = lid.ToNonAD(), pn.ToNonAD()
+	res, err := a.msgDB.Exec(`
+		INSERT INTO lid_mappings (lid_jid, pn_jid, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(lid_jid) DO UPDATE SET pn_jid = excluded.pn_jid, updated_at = excluded.updated_at
+		WHERE pn_jid != excluded.pn_jid
+	`, lid.String(), pn.String(), time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	if changed, _ := res.RowsAffected(); changed == 0 {
+		return nil
+	}
+	return a.moveLIDSender(lid, pn)
+}
+
+// moveLIDSender rewrites the senders stored under a LID, with or without
+// a device, to its phone number JID.
+func (a *App) moveLIDSender(lid types.JID, pn types.JID) error {
+	lid, pn = lid.ToNonAD(), pn.ToNonAD()
+	device := lid.User + ":%@" + types.HiddenUserServer
+	for _, column := range []string{"sender_jid", "quoted_sender_jid"} {
+		query := fmt.Sprintf("UPDATE %%s SET %[1]s = ? WHERE %[1]s = ? OR %[1]s LIKE ?", column)
+		if _, err := a.updateMessageTables(query, pn.String(), lid.String(), device); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pnForLID returns the phone number JID of a LID, or an empty JID when it
+// isn't known.
+func (a *App) pnForLID(lid types.JID) types.JID {
+	lid = lid.ToNonAD()
+	var pn string
+	err := a.msgDB.QueryRow("SELECT pn_jid FROM lid_mappings WHERE lid_jid = ?", lid.String()).Scan(&pn)
+	if err == nil {
+		if jid, err := types.ParseJID(pn); err == nil {
+			return jid
+		}
+	}
+	jid, err := a.client.Store.LIDs.GetPNForLID(a.ctx, lid)
+	if err != nil || jid.IsEmpty() {
+		return types.JID{}
+	}
+	return jid
+}
+
+// canonicalSender returns the JID a sender's messages are stored under: a
+// LID's phone number JID when known, the JID itself otherwise.
+func (a *App) canonicalSender(jid types.JID) string {
+	if jid.Server != types.HiddenUserServer {
+		return jid.String()
+	}
+	if pn := a.pnForLID(jid); !pn.IsEmpty() {
+		return pn.String()
+	}
+	return jid.String()
+}
+
+// resolveLID maps a LID to its phone number JID or back.
+func (a *App) resolveLID(raw string) (LIDMapping, error) {
+	jid, err := types.ParseJID(raw)
+	if err != nil {
+		return LIDMapping{}, fmt.Errorf("invalid JID %q", raw)
+	}
+	jid = jid.ToNonAD()
+
+	var mapping LIDMapping
+	switch jid.Server {
+	case types.HiddenUserServer:
+		err = a.msgDB.QueryRow("SELECT lid_jid, pn_jid FROM lid_mappings WHERE lid_jid = ?", jid.String()).Scan(&mapping.LID, &mapping.PN)
+	case types.DefaultUserServer:
+		err = a.msgDB.QueryRow(
+			"SELECT lid_jid, pn_jid FROM lid_mappings WHERE pn_jid = ? ORDER BY updated_at DESC LIMIT 1", jid.String(),
+		).Scan(&mapping.LID, &mapping.PN)
+	default:
+		return LIDMapping{}, fmt.Errorf("%s is neither a LID nor a phone number JID", raw)
+	}
+	if err == nil {
+		mapping.Source = "stored"
+		return mapping, nil
+	} else if err != sql.ErrNoRows {
+		return LIDMapping{}, err
+	}
+
+	var other types.JID
+	if jid.Server == types.HiddenUserServer {
+		other, err = a.client.Store.LIDs.GetPNForLID(a.ctx, jid)
+		mapping.LID, mapping.PN = jid.String(), other.String()
+	} else {
+		other, err = a.client.Store.LIDs.GetLIDForPN(a.ctx, jid)
+		mapping.LID, mapping.PN = other.String(), jid.String()
+	}
+	if err != nil {
+		return LIDMapping{}, err
+	}
+	if other.IsEmpty() {
+		return LIDMapping{}, fmt.Errorf("no LID mapping known for %s", jid)
+	}
+	mapping.Source = "client"
+	return mapping, nil
+}
+
+// backfillLIDs maps senders stored under a LID through the mappings
+// whatsmeow has learned, e.g. from history sync.
+func (a *App) backfillLIDs() {
+	rows, err := a.msgDB.Query(fmt.Sprintf(
+		"SELECT DISTINCT sender_jid FROM %s WHERE sender_jid LIKE ?", messagesView,
+	), "%@"+types.HiddenUserServer)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list LID senders: %v\n", err)
+		return
+	}
+	var senders []types.JID
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			break
+		}
+		if jid, err := types.ParseJID(raw); err == nil {
+			senders = append(senders, jid)
+		}
+	}
+	rows.Close()
+
+	for _, lid := range senders {
+		pn, err := a.client.Store.LIDs.GetPNForLID(a.ctx, lid.ToNonAD())
+		if err != nil || pn.IsEmpty() {
+			continue
+		}
+		if err := a.learnLID(lid, pn); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to store LID mapping: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"golang.org/x/net/html"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	linkPreviewTimeout   = 10 * time.Second
+	maxPreviewPageBytes  = 1 << 20
+	maxPreviewImageBytes = 5 << 20
+	// The inline thumbnail travels inside the message, the larger one is
+	// uploaded like media and shown once the card is opened
+	inlineThumbnailSize   = 140
+	uploadedThumbnailSize = 1024
+)
+
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+type linkPreview struct {
+	URL         string
+	Title       string
+	Description string
+	ImageURL    string
+}
+
+// addLinkPreview fills the rich link card of a text message from the
+// first URL in it. Failures are logged and the message goes out without
+// a card, as the official client does when a page can't be fetched.
+func (a *App) addLinkPreview(msg *waE2E.ExtendedTextMessage) {
+	if !a.cfg().LinkPreviews {
+		return
+	}
+	link := strings.TrimRight(urlPattern.FindString(msg.GetText()), ".,;:!?)]}'")
+	if link == "" {
+		return
+	}
+
+	httpClient := &http.Client{Timeout: linkPreviewTimeout}
+	preview, err := fetchLinkPreview(httpClient, link)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to fetch link preview for %s: %v\n", link, err)
+		return
+	}
+	if preview.Title == "" {
+		return
+	}
+
+	msg.MatchedText = proto.String(link)
+	msg.Title = proto.String(preview.Title)
+	msg.Description = proto.String(preview.Description)
+	msg.PreviewType = waE2E.ExtendedTextMessage_NONE.Enum()
+
+	if preview.ImageURL == "" {
+		return
+	}
+	if err := a.addPreviewThumbnail(httpClient, msg, preview.ImageURL); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to add link preview image for %s: %v\n", link, err)
+	}
+}
+
+func (a *App) addPreviewThumbnail(httpClient *http.Client, msg *waE2E.ExtendedTextMessage, imageURL string) error {
+	data, _, err := fetchLimited(httpClient, imageURL, maxPreviewImageBytes)
+	if err != nil {
+		return err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	inline, _, err := encodeThumbnail(img, inlineThumbnailSize)
+	if err != nil {
+		return err
+	}
+	msg.JPEGThumbnail = inline
+
+	full, bounds, err := encodeThumbnail(img, uploadedThumbnailSize)
+	if err != nil {
+		return err
+	}
+	upload, err := a.client.Upload(a.ctx, full, whatsmeow.MediaLinkThumbnail)
+	if err != nil {
+		return fmt.Errorf("upload failed: %w", err)
+	}
+	msg.ThumbnailDirectPath = proto.String(upload.DirectPath)
+	msg.ThumbnailSHA256 = upload.FileSHA256
+	msg.ThumbnailEncSHA256 = upload.FileEncSHA256
+	msg.MediaKey = upload.MediaKey
+	msg.MediaKeyTimestamp = proto.Int64(time.Now().Unix())
+	msg.ThumbnailWidth = proto.Uint32(uint32(bounds.Dx()))
+	msg.ThumbnailHeight = proto.Uint32(uint32(bounds.Dy()))
+	return nil
+}
+
+// fetchLinkPreview reads the title, description and image of a page from
+// its Open Graph tags, falling back to <title> and the description meta.
+func fetchLinkPreview(httpClient *http.Client, link string) (*linkPreview, error) {
+	data, contentType, err := fetchLimited(httpClient, link, maxPreviewPageBytes)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(contentType, "html") {
+		return nil, fmt.Errorf("not an HTML page: %s", contentType)
+	}
+
+	preview := &linkPreview{URL: link}
+	meta := map[string]string{}
+	var title string
+
+	tokenizer := html.NewTokenizer(bytes.NewReader(data))
+	for {
+		tt := tokenizer.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		token := tokenizer.Token()
+		if token.Data == "body" {
+			break
+		}
+		switch {
+		case tt == html.StartTagToken && token.Data == "title":
+			if tokenizer.Next() == html.TextToken {
+				title = strings.TrimSpace(tokenizer.Token().Data)
+			}
+		case (tt == html.StartTagToken || tt == html.SelfClosingTagToken) && token.Data == "meta":
+			var key, content string
+			for _, attr := range token.Attr {
+				switch attr.Key {
+				case "property", "name":
+					key = strings.ToLower(attr.Val)
+				case "content":
+					content = strings.TrimSpace(attr.Val)
+				}
+			}
+			if key != "" && content != "" && meta[key] == "" {
+				meta[key] = content
+			}
+		}
+	}
+
+	preview.Title = firstNonEmpty(meta["og:title"], meta["twitter:title"], title)
+	preview.Description = firstNonEmpty(meta["og:description"], meta["twitter:description"], meta["description"])
+	if imageURL := firstNonEmpty(meta["og:image"], meta["twitter:image"]); imageURL != "" {
+		// Image URLs may be relative to the page
+		base, err := url.Parse(link)
+		if err == nil {
+			if ref, err := base.Parse(imageURL); err == nil {
+				preview.ImageURL = ref.String()
+			}
+		}
+	}
+	return preview, nil
+}
+
+func fetchLimited(httpClient *http.Client, link string, limit int64) ([]byte, string, error) {
+	resp, err := httpClient.Get(link)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("%s returned %s", link, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit))
+	if err != nil {
+		return nil, "", err
+	}
+	return data, resp.Header.Get("Content-Type"), nil
+}
+
+// encodeThumbnail scales img to fit within size x size and encodes it as
+// JPEG, returning the scaled bounds.
+func encodeThumbnail(img image.Image, size int) ([]byte, image.Rectangle, error) {
+	src := img.Bounds()
+	width, height := src.Dx(), src.Dy()
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, max(1, height*size/width)
+		} else {
+			width, height = max(1, width*size/height), size
+		}
+	}
+
+	// Nearest-neighbour is enough for a preview card
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			dst.Set(x, y, img.At(src.Min.X+x*src.Dx()/width, src.Min.Y+y*src.Dy()/height))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, image.Rectangle{}, err
+	}
+	return buf.Bytes(), dst.Bounds(), nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+func (a *App) sendLocation(chatJID string, latitude float64, longitude float64, name string, address string, priority sendPriority) (types.MessageID, error) {
+	jid, err := types.ParseJID(chatJID)
+	if err != nil {
+		return "", fmt.Errorf("invalid JID: %w", err)
+	}
+	if math.IsNaN(latitude) || latitude < -90 || latitude > 90 {
+		return "", fmt.Errorf("latitude %v is outside -90 to 90", latitude)
+	}
+	if math.IsNaN(longitude) || longitude < -180 || longitude > 180 {
+		return "", fmt.Errorf("longitude %v is outside -180 to 180", longitude)
+	}
+
+	loc := &waE2E.LocationMessage{
+		DegreesLatitude:  proto.Float64(latitude),
+		DegreesLongitude: proto.Float64(longitude),
+	}
+	if name != "" {
+		loc.Name = proto.String(name)
+	}
+	if address != "" {
+		loc.Address = proto.String(address)
+	}
+
+	resp, err := a.sendToChat(jid, &waE2E.Message{LocationMessage: loc}, priority)
+	if err != nil {
+		return "", fmt.Errorf("send location failed: %w", err)
+	}
+
+	fmt.Printf("Sent location to %s\n", chatJID)
+	return resp.ID, nil
+}
+
+// applyLocation copies coordinates from location messages into the
+// structured columns of the stored message.
+func applyLocation(message *Message, msg *waE2E.Message) {
+	if loc := msg.GetLocationMessage(); loc != nil {
+		lat, lng := loc.GetDegreesLatitude(), loc.GetDegreesLongitude()
+		message.Latitude = &lat
+		message.Longitude = &lng
+		message.LocationName = loc.GetName()
+		message.LocationAddress = loc.GetAddress()
+	} else if live := msg.GetLiveLocationMessage(); live != nil {
+		lat, lng := live.GetDegreesLatitude(), live.GetDegreesLongitude()
+		message.Latitude = &lat
+		message.Longitude = &lng
+		message.IsLiveLocation = true
+	}
+}
+
+func formatLocation(label string, latitude float64, longitude float64, details ...string) string {
+	var parts []string
+	for _, detail := range details {
+		if detail != "" {
+			parts = append(parts, detail)
+		}
+	}
+	coords := fmt.Sprintf("(%.6f, %.6f)", latitude, longitude)
+	if len(parts) == 0 {
+		return label + " " + coords
+	}
+	return label + " " + strings.Join(parts, ", ") + " " + coords
+}
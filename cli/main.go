@@ -1,23 +1,27 @@
 package main
 
 import (
-	"bufio"
 	"context"
-	"database/sql"
-	"encoding/json"
+	"flag"
 	"fmt"
 	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
-	"github.com/mdp/qrterminal/v3"
 	_ "github.com/mattn/go-sqlite3"
 	"go.mau.fi/whatsmeow"
 	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/store"
 	"go.mau.fi/whatsmeow/store/sqlstore"
 	"go.mau.fi/whatsmeow/types"
 	"go.mau.fi/whatsmeow/types/events"
@@ -26,55 +30,459 @@ import (
 )
 
 const (
-	runtimeDir          = "/tmp/rlocal/wacli"
-	socketPath          = runtimeDir + "/wacli.sock"
-	rworkspacesSocket   = "/tmp/rlocal/rworkspaces/sock"
-	attentionID         = "wacli"
 	maxMessages         = 200
 	trimToCount         = 150
 )
 
 type Config struct {
+	flags pathFlags
+
+	DataDir               string
+	SocketPath            string
+	MediaDir              string
 	IncludeStatusMessages bool
 	IncludeMutedMessages  bool
+	PartitionByMonth      bool
+	RetentionMonths       int
+	SlowQueryBudget       time.Duration
+	SocketToken           string
+	SocketMode            os.FileMode
+	SocketGroup           string
+	ListenTLS             string
+	TLSCert               string
+	TLSKey                string
+	TLSClientCA           string
+	TLSAdminNames         map[string]bool
+	Remote                string
+	RemoteCA              string
+	RemoteCert            string
+	RemoteKey             string
+	DBKey                 string
+	DBKeyCommand          string
+	DBDSN                 string
+	PresenceMode          string
+	PresenceIdleCmd       string
+	PresenceIdleAfter     time.Duration
+	LowDiskThreshold      uint64
+	CrashReportDir        string
+	AttentionMode         string
+	AttentionSocket       string
+	AttentionCommand      string
+	NotifyBackend         string
+	NotifyDefault         string
+	NotifyRules           map[string]string
+	NotifyForwarded       string
+	StealthReadChats      map[string]bool
+	DigestTime            string
+	DigestChat            string
+	UnrepliedNotifyAfter  time.Duration
+	TokensFile            string
+	WebhooksFile          string
+	RulesFile             string
+	ExportsFile           string
+	BotFile               string
+	FFmpegPath            string
+	LinkPreviews          bool
+	AutoReplyText         string
+	AutoReplyCooldown     time.Duration
+	AutoReplyExclude      map[string]bool
+	AutoReplyGroups       bool
+	SendInterval          time.Duration
+	SendBurst             int
+	SendJitter            time.Duration
+	SendChatLimit         int
+	SendChatWindow        time.Duration
+	OfflineQueueMaxAge    time.Duration
+	ReplySLO              time.Duration
+	PurgeExpiredMessages  bool
+	DownloadViewOnce      bool
+	TranscriptDir         string
+	TranscriptFormat      string
+	WatchPatterns         []*regexp.Regexp
+	TextFilterCmd         string
+	StarSync              bool
+	RetainChats           map[string]bool
+	EventFile             string
+	EventWebhookURL       string
+	MQTTURL               string
+	MQTTTopic             string
+	NATSURL               string
+	NATSSubject           string
+	RedisURL              string
+	RedisChannel          string
+	SMTPURL               string
+	EmailFrom             string
+	EmailTo               []string
+	EmailMaxAttachment    int64
+	TelegramBotToken      string
+	TelegramBridge        map[string]string
+	IRCListen             string
+	IRCPassword           string
+	PluginDir             string
+	ScriptsDir            string
+	SinkEvents            map[string]bool
 }
 
 type App struct {
 	client      *whatsmeow.Client
+	container   *sqlstore.Container
 	ctx         context.Context
-	msgDB       *sql.DB
+	msgDB       *DB
+	store       Store
 	config      Config
-	socketConns map[net.Conn]struct{}
+	reloadMu    sync.RWMutex
+	socketConns map[*socketClient]struct{}
 	connMu      sync.RWMutex
+	partitionMu sync.Mutex
+	hotMonth    string
+	reconnectMu sync.Mutex
+	reloginMu   sync.Mutex
+	presenceMu  sync.Mutex
+	presence    types.Presence
+	storageLow  atomic.Bool
+	socketFile  os.FileInfo
+	handlersWG  sync.WaitGroup
+	handlersMu  sync.RWMutex
+	closing     bool
+	attention   AttentionNotifier
+	notifier    Notifier
+	sinks       []EventSink
+	tokens      []APIToken
+	webhooks    []GroupWebhook
+	exports     []ExportSchedule
+	bot         *BotConfig
+	botLimiter  botLimiter
+	rules       []Rule
+	autoReply   autoReplier
+
+	startedAt   time.Time
+	lastEventAt atomic.Int64
+
+	// Unix time the panic_stop kill switch was engaged, zero when released
+	automationStoppedAt atomic.Int64
+
+	// Listener for remote clients, nil unless WACLI_LISTEN_TLS is set
+	tlsListener net.Listener
+
+	replyClocks replyClocks
+
+	// Paces outgoing messages, nil unless SEND_INTERVAL is set
+	sendQueue *sendQueue
+	offlineMu sync.Mutex
+
+	// Nil unless TRANSCRIPT_DIR is set
+	transcripts *transcriptSink
+
+	// The executables in PLUGIN_DIR
+	plugins []*plugin
+	// The Lua scripts in SCRIPTS_DIR
+	scripts scriptEngine
+
+	newsletters newsletterCache
+	communities communityCache
+	connection  connectionTracker
+	groups      groupCache
+
+	versionMu      sync.Mutex
+	latestWAWeb    string
+	clientOutdated bool
+}
+
+// pathFlags are the global command line flags. They win over the
+// environment and also move the files derived from the data directory.
+type pathFlags struct {
+	DataDir    string
+	SocketPath string
+	MediaDir   string
 }
 
-func loadConfig() Config {
+func loadConfig(flags pathFlags) (Config, error) {
+	// Earlier files win, and real environment variables win over all of them
 	godotenv.Load()
+	if _, err := os.Stat(configFilePath()); err == nil {
+		godotenv.Load(configFilePath())
+	}
+	if path := settingsFilePath(); path != "" {
+		if err := loadSettingsFile(path); err != nil {
+			return Config{}, fmt.Errorf("load %s: %w", path, err)
+		}
+	}
+
+	retentionMonths, _ := strconv.Atoi(os.Getenv("RETENTION_MONTHS"))
+	slowQueryMs, _ := strconv.Atoi(os.Getenv("WACLI_SLOW_QUERY_MS"))
+	// Octal like chmod; invalid or empty keeps the default owner-only access
+	socketMode, _ := strconv.ParseUint(os.Getenv("WACLI_SOCKET_MODE"), 8, 32)
+
+	presenceIdleCmd := os.Getenv("PRESENCE_IDLE_CMD")
+	if presenceIdleCmd == "" {
+		presenceIdleCmd = "xprintidle"
+	}
+	presenceIdleAfter, err := time.ParseDuration(os.Getenv("PRESENCE_IDLE_AFTER"))
+	if err != nil {
+		presenceIdleAfter = 5 * time.Minute
+	}
+
+	lowDiskMB, err := strconv.ParseUint(os.Getenv("LOW_DISK_THRESHOLD_MB"), 10, 64)
+	if err != nil {
+		lowDiskMB = 100
+	}
+
+	// Invalid or empty disables the unreplied notification
+	unrepliedNotifyAfter, _ := time.ParseDuration(os.Getenv("UNREPLIED_NOTIFY_AFTER"))
+
+	// Invalid or empty disables reply SLO alerts
+	replySLO, _ := time.ParseDuration(os.Getenv("REPLY_SLO"))
+
+	// Invalid or empty sends without pacing
+	sendInterval, _ := time.ParseDuration(os.Getenv("SEND_INTERVAL"))
+	sendBurst, _ := strconv.Atoi(os.Getenv("SEND_BURST"))
+
+	var emailTo []string
+	for _, addr := range strings.Split(os.Getenv("EMAIL_TO"), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			emailTo = append(emailTo, addr)
+		}
+	}
+	emailFrom := os.Getenv("EMAIL_FROM")
+	if emailFrom == "" && len(emailTo) > 0 {
+		emailFrom = emailTo[0]
+	}
+	emailMaxMB, err := strconv.ParseInt(os.Getenv("EMAIL_MAX_ATTACHMENT_MB"), 10, 64)
+	if err != nil || emailMaxMB < 0 {
+		emailMaxMB = defaultEmailMaxAttachmentMB
+	}
+	sendJitter, _ := time.ParseDuration(os.Getenv("SEND_JITTER"))
+	sendChatLimit, sendChatWindow := parseRateLimit(os.Getenv("SEND_CHAT_LIMIT"))
+	offlineQueueMaxAge, err := time.ParseDuration(os.Getenv("OFFLINE_QUEUE_MAX_AGE"))
+	if err != nil {
+		offlineQueueMaxAge = time.Hour
+	}
+
+	autoReplyCooldown, err := time.ParseDuration(os.Getenv("AUTOREPLY_COOLDOWN"))
+	if err != nil {
+		autoReplyCooldown = 12 * time.Hour
+	}
+
+	watchPatterns, err := parseWatchPatterns(os.Getenv("WATCH_KEYWORDS"))
+	if err != nil {
+		return Config{}, err
+	}
+	telegramBridge, err := parseTelegramBridge(os.Getenv("TELEGRAM_BRIDGE"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	dataDir := flags.DataDir
+	if dataDir == "" {
+		dataDir = envOr("WACLI_DATA_DIR", defaultDataDir)
+	}
+	socketPath := flags.SocketPath
+	if socketPath == "" {
+		socketPath = envOr("WACLI_SOCKET_PATH", defaultSocketPath)
+	}
+	mediaDir := flags.MediaDir
+	if mediaDir == "" {
+		mediaDir = envOr("WACLI_MEDIA_DIR", func() string { return filepath.Join(dataDir, "media") })
+	}
 
 	return Config{
+		flags:                 flags,
+		DataDir:               dataDir,
+		SocketPath:            socketPath,
+		MediaDir:              mediaDir,
 		IncludeStatusMessages: os.Getenv("INCLUDE_STATUS_MESSAGES") == "true",
 		IncludeMutedMessages:  os.Getenv("INCLUDE_MUTED_MESSAGES") == "true",
-	}
+		PartitionByMonth:      os.Getenv("PARTITION_BY_MONTH") == "true",
+		LinkPreviews:          os.Getenv("LINK_PREVIEWS") == "true",
+		AutoReplyText:         os.Getenv("AUTOREPLY_TEXT"),
+		AutoReplyCooldown:     autoReplyCooldown,
+		AutoReplyExclude:      parseJIDSet(os.Getenv("AUTOREPLY_EXCLUDE")),
+		AutoReplyGroups:       os.Getenv("AUTOREPLY_GROUPS") == "true",
+		SendInterval:          sendInterval,
+		SendBurst:             sendBurst,
+		SendJitter:            sendJitter,
+		SendChatLimit:         sendChatLimit,
+		SendChatWindow:        sendChatWindow,
+		OfflineQueueMaxAge:    offlineQueueMaxAge,
+		ReplySLO:              replySLO,
+		PurgeExpiredMessages:  os.Getenv("PURGE_EXPIRED_MESSAGES") == "true",
+		DownloadViewOnce:      os.Getenv("DOWNLOAD_VIEW_ONCE") == "true",
+		TranscriptDir:         os.Getenv("TRANSCRIPT_DIR"),
+		TranscriptFormat:      os.Getenv("TRANSCRIPT_FORMAT"),
+		WatchPatterns:         watchPatterns,
+		TextFilterCmd:         os.Getenv("WACLI_TEXT_FILTER_CMD"),
+		StarSync:              os.Getenv("STAR_SYNC") == "true",
+		RetainChats:           parseJIDSet(os.Getenv("RETAIN_CHATS")),
+		EventFile:             os.Getenv("EVENT_FILE"),
+		EventWebhookURL:       os.Getenv("EVENT_WEBHOOK_URL"),
+		MQTTURL:               os.Getenv("MQTT_URL"),
+		MQTTTopic:             envOr("MQTT_TOPIC", func() string { return defaultMQTTTopic }),
+		NATSURL:               os.Getenv("NATS_URL"),
+		NATSSubject:           envOr("NATS_SUBJECT", func() string { return defaultNATSSubject }),
+		RedisURL:              os.Getenv("REDIS_URL"),
+		RedisChannel:          envOr("REDIS_CHANNEL", func() string { return defaultRedisChannel }),
+		SMTPURL:               os.Getenv("SMTP_URL"),
+		EmailFrom:             emailFrom,
+		EmailTo:               emailTo,
+		EmailMaxAttachment:    emailMaxMB * 1024 * 1024,
+		TelegramBotToken:      os.Getenv("TELEGRAM_BOT_TOKEN"),
+		TelegramBridge:        telegramBridge,
+		IRCListen:             os.Getenv("IRC_LISTEN"),
+		IRCPassword:           os.Getenv("IRC_PASSWORD"),
+		PluginDir:             os.Getenv("PLUGIN_DIR"),
+		ScriptsDir:            os.Getenv("SCRIPTS_DIR"),
+		SinkEvents:            parseJIDSet(os.Getenv("EVENT_SINK_EVENTS")),
+		RetentionMonths:       retentionMonths,
+		SlowQueryBudget:       time.Duration(slowQueryMs) * time.Millisecond,
+		SocketToken:           os.Getenv("WACLI_SOCKET_TOKEN"),
+		SocketMode:            os.FileMode(socketMode) & os.ModePerm,
+		SocketGroup:           os.Getenv("WACLI_SOCKET_GROUP"),
+		ListenTLS:             os.Getenv("WACLI_LISTEN_TLS"),
+		TLSCert:               os.Getenv("WACLI_TLS_CERT"),
+		TLSKey:                os.Getenv("WACLI_TLS_KEY"),
+		TLSClientCA:           os.Getenv("WACLI_TLS_CLIENT_CA"),
+		TLSAdminNames:         parseJIDSet(os.Getenv("WACLI_TLS_ADMIN_CNS")),
+		Remote:                os.Getenv("WACLI_REMOTE"),
+		RemoteCA:              os.Getenv("WACLI_REMOTE_CA"),
+		RemoteCert:            os.Getenv("WACLI_REMOTE_CERT"),
+		RemoteKey:             os.Getenv("WACLI_REMOTE_KEY"),
+		DBKey:                 os.Getenv("WACLI_DB_KEY"),
+		DBKeyCommand:          os.Getenv("WACLI_DB_KEY_COMMAND"),
+		DBDSN:                 os.Getenv("WACLI_DB_DSN"),
+		PresenceMode:          os.Getenv("PRESENCE_MODE"),
+		PresenceIdleCmd:       presenceIdleCmd,
+		PresenceIdleAfter:     presenceIdleAfter,
+		LowDiskThreshold:      lowDiskMB * 1024 * 1024,
+		CrashReportDir:        os.Getenv("CRASH_REPORT_DIR"),
+		AttentionMode:         envOr("ATTENTION_MODE", func() string { return attentionModeRworkspaces }),
+		AttentionSocket:       envOr("ATTENTION_SOCKET", func() string { return defaultRworkspacesSocket }),
+		AttentionCommand:      os.Getenv("ATTENTION_COMMAND"),
+		NotifyBackend:         os.Getenv("NOTIFY_BACKEND"),
+		NotifyDefault:         envOr("NOTIFY_DEFAULT", func() string { return notifyLevelAll }),
+		NotifyRules:           parseNotifyRules(os.Getenv("NOTIFY_RULES")),
+		NotifyForwarded:       envOr("NOTIFY_FORWARDED", func() string { return notifyLevelAll }),
+		StealthReadChats:      parseJIDSet(os.Getenv("STEALTH_READ_CHATS")),
+		DigestTime:            os.Getenv("DIGEST_TIME"),
+		DigestChat:            os.Getenv("DIGEST_CHAT"),
+		UnrepliedNotifyAfter:  unrepliedNotifyAfter,
+		TokensFile:            envOr("WACLI_TOKENS_FILE", func() string { return filepath.Join(dataDir, "tokens.json") }),
+		FFmpegPath:            envOr("FFMPEG_PATH", func() string { return "ffmpeg" }),
+		WebhooksFile:          envOr("WACLI_WEBHOOKS_FILE", func() string { return filepath.Join(dataDir, "webhooks.json") }),
+		RulesFile:             envOr("WACLI_RULES_FILE", func() string { return filepath.Join(dataDir, "rules.yaml") }),
+		ExportsFile:           envOr("WACLI_EXPORTS_FILE", func() string { return filepath.Join(dataDir, "exports.json") }),
+		BotFile:               envOr("WACLI_BOT_FILE", func() string { return filepath.Join(dataDir, "bot.json") }),
+	}, nil
 }
 
 func main() {
+	dataDir := flag.String("data-dir", "", "directory for the device and message databases (env WACLI_DATA_DIR)")
+	socket := flag.String("socket", "", "path of the unix socket (env WACLI_SOCKET_PATH)")
+	mediaDir := flag.String("media-dir", "", "directory for downloaded media (env WACLI_MEDIA_DIR)")
+	flag.Parse()
+
 	command := "daemon"
-	if len(os.Args) > 1 {
-		command = os.Args[1]
+	if flag.NArg() > 0 {
+		command = flag.Arg(0)
+	}
+
+	config, err := loadConfig(pathFlags{DataDir: *dataDir, SocketPath: *socket, MediaDir: *mediaDir})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch command {
+	case "self-update":
+		globalArgs := os.Args[1 : len(os.Args)-flag.NArg()]
+		runSelfUpdate(globalArgs, flag.Args()[1:])
+		return
+	case "init":
+		runInit(config)
+		return
+	case "tui":
+		runTUI(config)
+		return
+	case "token":
+		runToken(config, flag.Args()[1:])
+		return
+	case "send":
+		runSend(config, flag.Args()[1:])
+		return
+	case "history":
+		runHistory(config, flag.Args()[1:])
+		return
+	case "chats":
+		runChats(config, flag.Args()[1:])
+		return
+	case "pick":
+		runPick(config, flag.Args()[1:])
+		return
+	case "status":
+		runStatus(config, flag.Args()[1:])
+		return
+	case "unreplied":
+		runUnreplied(config, flag.Args()[1:])
+		return
+	case "export":
+		runExport(config, flag.Args()[1:])
+		return
+	case "tail":
+		runTail(config, flag.Args()[1:])
+		return
+	case "open":
+		runOpen(config, flag.Args()[1:])
+		return
+	case "repl":
+		runREPL(config, flag.Args()[1:])
+		return
+	case "completion":
+		runCompletion(flag.Args()[1:])
+		return
+	case "__complete-chats":
+		runCompleteChats(config, flag.Args()[1:])
+		return
+	}
+
+	app := newApp(config)
+	defer app.store.Close()
+
+	if command == "daemon" {
+		runDaemon(app)
+	} else if command == "login" {
+		runLogin(app, flag.Args()[1:])
+	} else if command == "export-keys" {
+		runExportKeys(app, flag.Args()[1:])
+	} else {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", command)
+		fmt.Fprintf(os.Stderr, "Usage: wacli [--data-dir DIR] [--socket PATH] [--media-dir DIR] <command>\n\nCommands: daemon, login, init, tui, send, pick, open, history, chats, unreplied, export, tail, status, repl, token, export-keys, completion, self-update\n")
+		os.Exit(1)
 	}
+}
 
-	config := loadConfig()
+// newApp opens the databases and creates the WhatsApp client, exiting on
+// failure since no command can do anything useful without them.
+func newApp(config Config) *App {
 	ctx := context.Background()
 
-	msgDB, err := initMessageDB()
+	if err := ensureDirs(config); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create data directories: %v\n", err)
+		os.Exit(1)
+	}
+
+	msgDB, err := initMessageDB(config)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to init message database: %v\n", err)
 		os.Exit(1)
 	}
-	defer msgDB.Close()
+
+	store, err := openStore(config, msgDB)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open message archive: %v\n", err)
+		os.Exit(1)
+	}
 
 	dbLog := waLog.Stdout("Database", "ERROR", true)
-	container, err := sqlstore.New(ctx, "sqlite3", "file:wacli.db?_foreign_keys=on", dbLog)
+	container, err := sqlstore.New(ctx, "sqlite3", "file:"+config.deviceDBPath()+"?_foreign_keys=on", dbLog)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to create database: %v\n", err)
 		os.Exit(1)
@@ -86,29 +494,85 @@ func main() {
 		os.Exit(1)
 	}
 
-	clientLog := waLog.Stdout("Client", "ERROR", true)
-	client := whatsmeow.NewClient(deviceStore, clientLog)
-	client.EnableAutoReconnect = true
-
 	app := &App{
-		client:      client,
+		container:   container,
 		ctx:         ctx,
 		msgDB:       msgDB,
+		store:       store,
 		config:      config,
-		socketConns: make(map[net.Conn]struct{}),
+		socketConns: make(map[*socketClient]struct{}),
+		startedAt:   time.Now(),
+		attention:   newAttentionNotifier(config),
+		notifier:    newNotifier(config),
+	}
+	app.client = app.newClient(deviceStore)
+	if config.TranscriptDir != "" {
+		app.transcripts = newTranscriptSink(config.TranscriptDir, config.TranscriptFormat)
+	}
+	if config.SendInterval > 0 {
+		app.sendQueue = newSendQueue(config.SendInterval, config.SendBurst, config.SendJitter)
 	}
 
-	client.AddEventHandler(app.handleEvent)
+	app.tokens, err = loadTokens(config.TokensFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load API tokens: %v\n", err)
+		os.Exit(1)
+	}
+	if config.SocketToken != "" {
+		app.tokens = append(app.tokens, APIToken{Name: sharedTokenName, Token: config.SocketToken})
+	}
 
-	if command == "daemon" {
-		runDaemon(app)
-	} else if command == "login" {
-		runLogin(app)
-	} else {
-		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", command)
-		fmt.Fprintf(os.Stderr, "Usage: wacli [daemon|login]\n")
+	app.webhooks, err = loadWebhooks(config.WebhooksFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load webhooks: %v\n", err)
 		os.Exit(1)
 	}
+
+	app.rules, err = loadRules(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load rules: %v\n", err)
+		os.Exit(1)
+	}
+
+	app.exports, err = loadExportSchedules(config.ExportsFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load export schedules: %v\n", err)
+		os.Exit(1)
+	}
+
+	app.bot, err = loadBotConfig(config.BotFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load bot commands: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := app.loadAutomationState(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load automation state: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := app.loadAutoReply(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load auto-reply: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := app.rotatePartitions(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to rotate message partitions: %v\n", err)
+		os.Exit(1)
+	}
+
+	return app
+}
+
+func (a *App) newClient(device *store.Device) *whatsmeow.Client {
+	clientLog := waLog.Stdout("Client", "ERROR", true)
+	client := whatsmeow.NewClient(device, clientLog)
+	client.EnableAutoReconnect = true
+	client.AutoReconnectHook = func(err error) bool {
+		return a.autoReconnectFailed(client, err)
+	}
+	client.AddEventHandler(a.handleEvent)
+	return client
 }
 
 func runDaemon(app *App) {
@@ -122,32 +586,95 @@ func runDaemon(app *App) {
 		fmt.Fprintf(os.Stderr, "Failed to start socket server: %v\n", err)
 		os.Exit(1)
 	}
-	defer listener.Close()
-	defer os.Remove(socketPath)
-
+	app.tlsListener, err = app.startTLSServer()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start TLS listener: %v\n", err)
+		os.Exit(1)
+	}
+	ircServer, err := app.startIRCServer()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start IRC gateway: %v\n", err)
+		os.Exit(1)
+	}
+	// Only the daemon gets the outside sinks, so login doesn't connect to
+	// brokers or poll Telegram
+	app.sinks = app.newEventSinks(app.cfg())
+	if ircServer != nil {
+		app.sinks = append(app.sinks, app.newQueuedSink("irc", ircServer, nil))
+	}
+	if err := app.startPlugins(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start plugins: %v\n", err)
+		os.Exit(1)
+	}
+	if app.cfg().ScriptsDir != "" {
+		app.goSafe("scripts watcher", app.watchScripts)
+	}
+	app.setConnectionState(ConnectionState{State: connStateConnecting})
 	if err := app.client.Connect(); err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to connect: %v\n", err)
 		os.Exit(1)
 	}
 
+	app.goSafe("resume watcher", app.watchResume)
+	app.goSafe("network watcher", app.watchNetwork)
+	app.goSafe("idle watcher", app.watchDesktopIdle)
+	app.goSafe("storage watcher", app.watchStorage)
+	app.goSafe("client version check", app.checkClientVersion)
+	app.goSafe("digest", app.watchDigest)
+	app.goSafe("reminders", app.watchReminders)
+	app.goSafe("unreplied watcher", app.watchUnreplied)
+	app.goSafe("scheduled exports", app.watchExports)
+	app.goSafe("reply SLO watcher", app.watchReplySLO)
+	app.goSafe("message expiry", app.watchExpiredMessages)
+	app.goSafe("prune", app.watchPrune)
+	app.goSafe("config watcher", app.watchReload)
+	app.goSafe("systemd watchdog", app.watchSystemdWatchdog)
+
 	fmt.Println("Connected. Watching for messages...")
-	fmt.Printf("Socket server listening on %s\n", socketPath)
+	fmt.Printf("Socket server listening on %s\n", app.cfg().SocketPath)
+	if app.tlsListener != nil {
+		fmt.Printf("TLS listener on %s\n", app.tlsListener.Addr())
+	}
+	if ircServer != nil {
+		fmt.Printf("IRC gateway on %s\n", ircServer.listener.Addr())
+	}
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 	<-sigChan
 
-	app.client.Disconnect()
+	app.shutdown(listener)
 	fmt.Println("\nDisconnected.")
 }
 
-func runLogin(app *App) {
+func runLogin(app *App, args []string) {
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	phone := fs.String("phone", "", "link with a pairing code for this phone number instead of a QR code")
+	fs.Parse(args)
+
 	if app.client.Store.ID != nil {
 		fmt.Println("Device already logged in.")
 		os.Exit(0)
 	}
 
-	if err := app.loginWithQR(); err != nil {
+	// Serve the socket while pairing so a remote TUI or web UI can show the
+	// QR code from its qr events
+	listener, err := app.startSocketServer()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Not serving the socket during login: %v\n", err)
+	}
+
+	if *phone != "" {
+		err = app.loginWithPhone(*phone)
+	} else {
+		err = app.loginWithQR()
+	}
+	if listener != nil {
+		listener.Close()
+		app.closeSocketConns()
+		app.removeSocketFile()
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Login failed: %v\n", err)
 		os.Exit(1)
 	}
@@ -155,12 +682,18 @@ func runLogin(app *App) {
 	fmt.Println("Login complete. You can now run 'wacli daemon' or start the systemd service.")
 }
 
-func initMessageDB() (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", "file:messages.db?_foreign_keys=on")
+func initMessageDB(config Config) (*DB, error) {
+	db, err := openMessageDB(config)
 	if err != nil {
 		return nil, err
 	}
 
+	// WAL lets readers (the TUI, exports, stats) run alongside the writer
+	// goroutine; the mode is stored in the file, so this sticks
+	if _, err := db.Exec("PRAGMA journal_mode=WAL"); err != nil {
+		return nil, err
+	}
+
 	_, err = db.Exec(`
 		CREATE TABLE IF NOT EXISTS messages (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -176,6 +709,8 @@ func initMessageDB() (*sql.DB, error) {
 			text TEXT NOT NULL
 		);
 		CREATE INDEX IF NOT EXISTS idx_messages_timestamp ON messages(timestamp);
+		CREATE INDEX IF NOT EXISTS idx_messages_chat_timestamp ON messages(chat_jid, timestamp);
+		CREATE INDEX IF NOT EXISTS idx_messages_sender_timestamp ON messages(sender_jid, timestamp);
 
 		CREATE TABLE IF NOT EXISTS calls (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -188,146 +723,275 @@ func initMessageDB() (*sql.DB, error) {
 			group_name TEXT NOT NULL
 		);
 		CREATE INDEX IF NOT EXISTS idx_calls_timestamp ON calls(timestamp);
-	`)
-	if err != nil {
-		return nil, err
-	}
 
-	return db, nil
-}
+		CREATE TABLE IF NOT EXISTS polls (
+			poll_id TEXT PRIMARY KEY,
+			timestamp INTEGER NOT NULL,
+			chat_jid TEXT NOT NULL,
+			sender_jid TEXT NOT NULL,
+			question TEXT NOT NULL,
+			options TEXT NOT NULL,
+			multi_select INTEGER NOT NULL
+		);
 
-func (a *App) startSocketServer() (net.Listener, error) {
-	if err := os.MkdirAll(runtimeDir, 0755); err != nil {
-		return nil, err
-	}
-	os.Remove(socketPath)
-	listener, err := net.Listen("unix", socketPath)
-	if err != nil {
-		return nil, err
-	}
+		CREATE TABLE IF NOT EXISTS poll_votes (
+			poll_id TEXT NOT NULL,
+			voter_jid TEXT NOT NULL,
+			timestamp INTEGER NOT NULL,
+			options TEXT NOT NULL,
+			PRIMARY KEY (poll_id, voter_jid)
+		);
 
-	go func() {
-		for {
-			conn, err := listener.Accept()
-			if err != nil {
-				return
-			}
-			go a.handleSocketConn(conn)
-		}
-	}()
+		CREATE TABLE IF NOT EXISTS chat_activity (
+			chat_jid TEXT PRIMARY KEY,
+			last_outgoing INTEGER NOT NULL
+		);
 
-	return listener, nil
-}
+		CREATE TABLE IF NOT EXISTS outgoing_messages (
+			message_id TEXT PRIMARY KEY,
+			timestamp INTEGER NOT NULL,
+			chat_jid TEXT NOT NULL,
+			text TEXT NOT NULL,
+			status TEXT NOT NULL,
+			status_at INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_outgoing_messages_chat_timestamp ON outgoing_messages(chat_jid, timestamp);
 
-type SocketCommand struct {
-	Action    string `json:"action"`
-	ChatJID   string `json:"chat_jid"`
-	MessageID string `json:"message_id"`
-	SenderJID string `json:"sender_jid"`
-	Text      string `json:"text"`
-}
+		CREATE TABLE IF NOT EXISTS reminders (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			chat_jid TEXT NOT NULL,
+			message_id TEXT NOT NULL,
+			created_at INTEGER NOT NULL,
+			due_at INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_reminders_due_at ON reminders(due_at);
 
-func (a *App) handleSocketConn(conn net.Conn) {
-	a.connMu.Lock()
-	a.socketConns[conn] = struct{}{}
-	a.connMu.Unlock()
-
-	defer func() {
-		a.connMu.Lock()
-		delete(a.socketConns, conn)
-		a.connMu.Unlock()
-		conn.Close()
-	}()
-
-	scanner := bufio.NewScanner(conn)
-	for scanner.Scan() {
-		line := scanner.Bytes()
-		var cmd SocketCommand
-		if err := json.Unmarshal(line, &cmd); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to parse socket command: %v\n", err)
-			continue
-		}
+		CREATE TABLE IF NOT EXISTS media_keys (
+			message_id TEXT PRIMARY KEY,
+			chat_jid TEXT NOT NULL,
+			timestamp INTEGER NOT NULL,
+			media_type TEXT NOT NULL,
+			mimetype TEXT NOT NULL,
+			direct_path TEXT NOT NULL,
+			media_key TEXT NOT NULL,
+			file_sha256 TEXT NOT NULL,
+			file_enc_sha256 TEXT NOT NULL,
+			file_length INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_media_keys_chat_timestamp ON media_keys(chat_jid, timestamp);
 
-		switch cmd.Action {
-		case "send":
-			if err := a.sendMessage(cmd.ChatJID, cmd.Text); err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to send message: %v\n", err)
-			}
-		case "reply":
-			if err := a.replyToMessage(cmd.ChatJID, cmd.MessageID, cmd.SenderJID, cmd.Text); err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to reply to message: %v\n", err)
-			}
-		default:
-			fmt.Fprintf(os.Stderr, "Unknown socket command: %s\n", cmd.Action)
-		}
-	}
-}
+		CREATE TABLE IF NOT EXISTS contact_info (
+			jid TEXT PRIMARY KEY,
+			notes TEXT NOT NULL,
+			dates TEXT NOT NULL,
+			updated_at INTEGER NOT NULL
+		);
 
-type SocketEvent struct {
-	Type string      `json:"type"`
-	Data interface{} `json:"data"`
-}
+		CREATE TABLE IF NOT EXISTS export_runs (
+			name TEXT PRIMARY KEY,
+			period_end INTEGER NOT NULL
+		);
 
-func (a *App) broadcastMessage(msg *Message) {
-	event := SocketEvent{Type: "message", Data: msg}
-	data, err := json.Marshal(event)
-	if err != nil {
-		return
-	}
-	data = append(data, '\n')
+		CREATE TABLE IF NOT EXISTS daemon_state (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL
+		);
 
-	a.connMu.RLock()
-	defer a.connMu.RUnlock()
+		CREATE TABLE IF NOT EXISTS autoreplies (
+			chat_jid TEXT PRIMARY KEY,
+			replied_at INTEGER NOT NULL
+		);
 
-	for conn := range a.socketConns {
-		conn.Write(data)
-	}
+		CREATE TABLE IF NOT EXISTS contact_presence (
+			jid TEXT PRIMARY KEY,
+			available INTEGER NOT NULL,
+			last_seen INTEGER NOT NULL,
+			updated_at INTEGER NOT NULL
+		);
 
-	if err := sendAttentionWindow(); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to send attention: %v\n", err)
-		os.Exit(1)
-	}
-}
+		CREATE TABLE IF NOT EXISTS reply_latency (
+			chat_jid TEXT NOT NULL,
+			received_at INTEGER NOT NULL,
+			replied_at INTEGER NOT NULL,
+			latency_ms INTEGER NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS offline_queue (
+			message_id TEXT PRIMARY KEY,
+			chat_jid TEXT NOT NULL,
+			message BLOB NOT NULL,
+			priority INTEGER NOT NULL,
+			queued_at INTEGER NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS status_views (
+			message_id TEXT NOT NULL,
+			viewer_jid TEXT NOT NULL,
+			viewed_at INTEGER NOT NULL,
+			PRIMARY KEY (message_id, viewer_jid)
+		);
+
+		CREATE TABLE IF NOT EXISTS group_cache (
+			jid TEXT PRIMARY KEY,
+			info TEXT NOT NULL,
+			fetched_at INTEGER NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS sent_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp INTEGER NOT NULL,
+			action TEXT NOT NULL,
+			client TEXT NOT NULL,
+			chat_jid TEXT NOT NULL,
+			text TEXT NOT NULL,
+			message_id TEXT NOT NULL,
+			status TEXT NOT NULL,
+			error TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_sent_log_chat_timestamp ON sent_log(chat_jid, timestamp);
+
+		CREATE TABLE IF NOT EXISTS drafts (
+			chat_jid TEXT PRIMARY KEY,
+			text TEXT NOT NULL,
+			updated_at INTEGER NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS group_invites (
+			chat_jid TEXT NOT NULL,
+			message_id TEXT NOT NULL,
+			sender_jid TEXT NOT NULL,
+			group_jid TEXT NOT NULL,
+			group_name TEXT NOT NULL,
+			code TEXT NOT NULL,
+			expiration INTEGER NOT NULL,
+			received_at INTEGER NOT NULL,
+			PRIMARY KEY (chat_jid, message_id)
+		);
+
+		CREATE TABLE IF NOT EXISTS retained_chats (
+			chat_jid TEXT PRIMARY KEY,
+			created_at INTEGER NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS telegram_forwards (
+			tg_chat_id TEXT NOT NULL,
+			tg_message_id INTEGER NOT NULL,
+			chat_jid TEXT NOT NULL,
+			message_id TEXT NOT NULL,
+			sender_jid TEXT NOT NULL,
+			created_at INTEGER NOT NULL,
+			PRIMARY KEY (tg_chat_id, tg_message_id)
+		);
+
+		CREATE TABLE IF NOT EXISTS lid_mappings (
+			lid_jid TEXT PRIMARY KEY,
+			pn_jid TEXT NOT NULL,
+			updated_at INTEGER NOT NULL
+		);
 
-func (a *App) broadcastCall(call *Call) {
-	event := SocketEvent{Type: "call", Data: call}
-	data, err := json.Marshal(event)
+		CREATE TABLE IF NOT EXISTS chat_aliases (
+			alias_jid TEXT PRIMARY KEY,
+			chat_jid TEXT NOT NULL,
+			source TEXT NOT NULL,
+			created_at INTEGER NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS script_kv (
+			script TEXT NOT NULL,
+			key TEXT NOT NULL,
+			value TEXT NOT NULL,
+			updated_at INTEGER NOT NULL,
+			PRIMARY KEY (script, key)
+		);
+	`)
 	if err != nil {
-		return
+		return nil, err
 	}
-	data = append(data, '\n')
-
-	a.connMu.RLock()
-	defer a.connMu.RUnlock()
 
-	for conn := range a.socketConns {
-		conn.Write(data)
+	if err := migrateColumns(db, messageMigrations); err != nil {
+		return nil, err
 	}
+
+	msgDB := &DB{DB: db, slowQueryBudget: config.SlowQueryBudget, writes: make(chan writeRequest, writeBatchSize)}
+	go msgDB.runWriter()
+	return msgDB, nil
 }
 
-func (a *App) sendMessage(chatJID string, text string) error {
+// messageMigrations lists columns added after the initial schema, applied
+// in order on startup.
+var messageMigrations = []columnMigration{
+	{"messages", "latitude", "REAL"},
+	{"messages", "longitude", "REAL"},
+	{"messages", "location_name", "TEXT NOT NULL DEFAULT ''"},
+	{"messages", "location_address", "TEXT NOT NULL DEFAULT ''"},
+	{"messages", "is_live_location", "INTEGER NOT NULL DEFAULT 0"},
+	{"messages", "quoted_message_id", "TEXT NOT NULL DEFAULT ''"},
+	{"messages", "quoted_sender_jid", "TEXT NOT NULL DEFAULT ''"},
+	{"messages", "quoted_text", "TEXT NOT NULL DEFAULT ''"},
+	{"messages", "is_mentioned", "INTEGER NOT NULL DEFAULT 0"},
+	{"messages", "is_pinned", "INTEGER NOT NULL DEFAULT 0"},
+	{"messages", "pinned_at", "INTEGER NOT NULL DEFAULT 0"},
+	{"messages", "media_path", "TEXT NOT NULL DEFAULT ''"},
+	{"messages", "contacts", "TEXT NOT NULL DEFAULT ''"},
+	{"messages", "is_forwarded", "INTEGER NOT NULL DEFAULT 0"},
+	{"messages", "forwarding_score", "INTEGER NOT NULL DEFAULT 0"},
+	{"messages", "is_newsletter", "INTEGER NOT NULL DEFAULT 0"},
+	{"messages", "community_jid", "TEXT NOT NULL DEFAULT ''"},
+	{"messages", "community_name", "TEXT NOT NULL DEFAULT ''"},
+	{"messages", "expires_at", "INTEGER NOT NULL DEFAULT 0"},
+	{"messages", "is_view_once", "INTEGER NOT NULL DEFAULT 0"},
+	{"messages", "is_highlight", "INTEGER NOT NULL DEFAULT 0"},
+	{"messages", "extra", "TEXT NOT NULL DEFAULT ''"},
+	{"messages", "is_starred", "INTEGER NOT NULL DEFAULT 0"},
+	{"messages", "media_type", "TEXT NOT NULL DEFAULT ''"},
+	{"messages", "mimetype", "TEXT NOT NULL DEFAULT ''"},
+	{"messages", "file_size", "INTEGER NOT NULL DEFAULT 0"},
+	{"messages", "file_name", "TEXT NOT NULL DEFAULT ''"},
+	{"outgoing_messages", "automated", "INTEGER NOT NULL DEFAULT 0"},
+	{"outgoing_messages", "failure_code", "TEXT NOT NULL DEFAULT ''"},
+	{"outgoing_messages", "failure_reason", "TEXT NOT NULL DEFAULT ''"},
+}
+
+func (a *App) sendMessage(chatJID string, text string, mentions []string, priority sendPriority) (types.MessageID, error) {
 	jid, err := types.ParseJID(chatJID)
 	if err != nil {
-		return fmt.Errorf("invalid JID: %w", err)
+		return "", fmt.Errorf("invalid JID: %w", err)
 	}
 
-	msg := &waE2E.Message{
-		Conversation: proto.String(text),
+	ext := &waE2E.ExtendedTextMessage{Text: proto.String(text)}
+	if len(mentions) > 0 {
+		for _, mention := range mentions {
+			if _, err := types.ParseJID(mention); err != nil {
+				return "", fmt.Errorf("invalid mention JID %q: %w", mention, err)
+			}
+		}
+		ext.ContextInfo = &waE2E.ContextInfo{
+			MentionedJID: mentions,
+		}
+	}
+	// Thumbnails would need the newsletter upload
+	if !isNewsletter(jid) {
+		a.addLinkPreview(ext)
 	}
 
-	_, err = a.client.SendMessage(a.ctx, jid, msg)
+	msg := &waE2E.Message{ExtendedTextMessage: ext}
+	if ext.ContextInfo == nil && ext.Title == nil {
+		msg = &waE2E.Message{Conversation: proto.String(text)}
+	}
+
+	resp, err := a.sendToChat(jid, msg, priority)
 	if err != nil {
-		return fmt.Errorf("send failed: %w", err)
+		return "", fmt.Errorf("send failed: %w", err)
 	}
 
 	fmt.Printf("Sent message to %s\n", chatJID)
-	return nil
+	return resp.ID, nil
 }
 
-func (a *App) replyToMessage(chatJID string, messageID string, senderJID string, text string) error {
+func (a *App) replyToMessage(chatJID string, messageID string, senderJID string, text string, priority sendPriority) (types.MessageID, error) {
 	jid, err := types.ParseJID(chatJID)
 	if err != nil {
-		return fmt.Errorf("invalid chat JID: %w", err)
+		return "", fmt.Errorf("invalid chat JID: %w", err)
 	}
 
 	msg := &waE2E.Message{
@@ -339,50 +1003,121 @@ func (a *App) replyToMessage(chatJID string, messageID string, senderJID string,
 			},
 		},
 	}
+	a.addLinkPreview(msg.ExtendedTextMessage)
 
-	_, err = a.client.SendMessage(a.ctx, jid, msg)
+	resp, err := a.sendToChat(jid, msg, priority)
 	if err != nil {
-		return fmt.Errorf("reply failed: %w", err)
+		return "", fmt.Errorf("reply failed: %w", err)
 	}
 
 	fmt.Printf("Replied to message %s in %s\n", messageID, chatJID)
-	return nil
+	return resp.ID, nil
 }
 
-func (a *App) loginWithQR() error {
+func (a *App) loginWithPhone(phone string) error {
+	err := a.pairWithPhone(phone)
+	a.broadcastPairResult(err)
+	return err
+}
+
+func (a *App) pairWithPhone(phone string) error {
 	qrChan, _ := a.client.GetQRChannel(a.ctx)
 	if err := a.client.Connect(); err != nil {
 		return err
 	}
 
+	paired := false
 	for evt := range qrChan {
 		if evt.Event == "code" {
-			fmt.Println("Scan this QR code to login:")
-			qrterminal.GenerateHalfBlock(evt.Code, qrterminal.L, os.Stdout)
+			// Pairing needs an established login websocket, signalled by the first QR code
+			if paired {
+				continue
+			}
+			code, err := a.client.PairPhone(a.ctx, phone, true, whatsmeow.PairClientChrome, "Chrome (Linux)")
+			if err != nil {
+				return err
+			}
+			paired = true
+			fmt.Printf("Enter this code on your phone (Linked devices > Link with phone number): %s\n", code)
 		} else if evt.Event == "success" {
 			fmt.Println("Login successful")
 		} else {
-			panic(fmt.Sprintf("Login failed: %s", evt.Event))
+			return fmt.Errorf("login failed: %s", evt.Event)
 		}
 	}
 	return nil
 }
 
+func (a *App) loginWithQR() error {
+	if err := a.pairWithQR(); err != nil {
+		return err
+	}
+	fmt.Println("Login successful")
+	return nil
+}
+
 func (a *App) handleEvent(evt interface{}) {
+	if !a.beginHandler() {
+		return
+	}
+	defer a.handlersWG.Done()
+	defer a.recoverPanic(fmt.Sprintf("event %T", evt))
+	a.lastEventAt.Store(time.Now().Unix())
+
 	switch v := evt.(type) {
 	case *events.Message:
 		a.handleMessage(v)
+	case *events.Receipt:
+		a.handleReceipt(v)
+	case *events.Presence:
+		a.handlePresence(v)
 	case *events.CallOffer:
 		a.handleCallOffer(v)
 	case *events.CallOfferNotice:
 		a.handleCallOfferNotice(v)
 	case *events.Connected:
 		fmt.Println("Connected to WhatsApp")
+		a.setConnectionState(ConnectionState{State: connStateConnected})
+		sdNotifyConnected()
+		a.goSafe("presence", a.applyPresence)
+		a.goSafe("offline queue", a.flushOfflineQueue)
+		a.goSafe("communities", a.refreshCommunities)
+		a.goSafe("lid backfill", a.backfillLIDs)
 	case *events.Disconnected:
 		fmt.Println("Disconnected from WhatsApp")
+		a.handleDisconnected()
+		sdNotify("STATUS=Disconnected from WhatsApp, reconnecting")
+	case *events.KeepAliveTimeout:
+		a.expectDisconnect(fmt.Sprintf("keepalive timed out %d times", v.ErrorCount))
+	case *events.KeepAliveRestored:
+		a.expectDisconnect("")
+	case *events.StreamError:
+		a.expectDisconnect("stream error " + v.Code)
+	case *events.StreamReplaced:
+		a.setConnectionState(ConnectionState{State: connStateDisconnected, Reason: "replaced by another connection"})
+	case *events.TemporaryBan:
+		a.setConnectionState(ConnectionState{State: connStateDisconnected, Reason: v.String()})
+	case *events.ConnectFailure:
+		a.setConnectionState(ConnectionState{State: connStateDisconnected, Reason: fmt.Sprintf("connect failure %d: %s", v.Reason, v.Message)})
+	case *events.NewsletterJoin:
+		a.forgetNewsletter(v.ID)
+	case *events.NewsletterLeave:
+		a.forgetNewsletter(v.ID)
+	case *events.JoinedGroup:
+		a.cacheGroup(&v.GroupInfo)
+		a.goSafe("communities", a.refreshCommunities)
+	case *events.Star:
+		a.handleStar(v)
+	case *events.GroupInfo:
+		a.forgetGroup(v.JID)
+		if v.Link != nil || v.Unlink != nil {
+			a.goSafe("communities", a.refreshCommunities)
+		}
+	case *events.ClientOutdated:
+		a.setConnectionState(ConnectionState{State: connStateDisconnected, Reason: "client outdated"})
+		a.handleClientOutdated()
 	case *events.LoggedOut:
-		fmt.Println("Logged out from WhatsApp")
-		os.Exit(0)
+		a.handleLoggedOut(v)
 	}
 }
 
@@ -405,26 +1140,3 @@ func buildInsertParams(record interface{}) (columns []string, placeholders []str
 	}
 	return
 }
-
-func sendAttentionWindow() error {
-	conn, err := net.Dial("unix", rworkspacesSocket)
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
-
-	payload := map[string]interface{}{
-		"id":      attentionID,
-		"command": []string{"toggle-window", "show", "wacli-tui"},
-	}
-	data, _ := json.Marshal(payload)
-	_, err = conn.Write([]byte(fmt.Sprintf("add_attention_by_cmd %s", data)))
-	if err != nil {
-		return err
-	}
-
-	// Read server response before closing to avoid ConnectionResetError on server
-	buf := make([]byte, 256)
-	conn.Read(buf)
-	return nil
-}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+)
+
+// MediaKey holds what is needed to fetch and verify a message's media
+// independently of WhatsApp: the key decrypting the CDN blob and the
+// hashes of the encrypted and decrypted file.
+type MediaKey struct {
+	MessageID     string `json:"message_id"`
+	ChatJID       string `json:"chat_jid"`
+	Timestamp     int64  `json:"timestamp"`
+	MediaType     string `json:"media_type"`
+	Mimetype      string `json:"mimetype"`
+	DirectPath    string `json:"direct_path"`
+	MediaKey      string `json:"media_key"`
+	FileSHA256    string `json:"file_sha256"`
+	FileEncSHA256 string `json:"file_enc_sha256"`
+	FileLength    uint64 `json:"file_length"`
+}
+
+type mediaMessage interface {
+	whatsmeow.DownloadableMessage
+	GetMimetype() string
+	GetFileLength() uint64
+}
+
+// getMediaMessage returns the downloadable part of a message and its kind.
+func getMediaMessage(msg *waE2E.Message) (mediaMessage, string) {
+	switch {
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage(), "image"
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage(), "video"
+	case msg.GetAudioMessage() != nil:
+		return msg.GetAudioMessage(), "audio"
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage(), "document"
+	case msg.GetStickerMessage() != nil:
+		return msg.GetStickerMessage(), "sticker"
+	}
+	return nil, ""
+}
+
+// mediaLabels are how text-only outputs show the kind of media.
+var mediaLabels = map[string]string{
+	"image":    "[Image]",
+	"video":    "[Video]",
+	"audio":    "[Audio]",
+	"document": "[Document]",
+	"sticker":  "[Sticker]",
+}
+
+// applyMedia records the kind, mimetype, size and file name of a message's
+// media in their own columns; the text of a media message is its caption.
+func applyMedia(message *Message, msg *waE2E.Message) {
+	media, mediaType := getMediaMessage(msg)
+	if media == nil {
+		return
+	}
+	message.MediaType = mediaType
+	message.Mimetype = media.GetMimetype()
+	message.FileSize = int64(media.GetFileLength())
+	message.FileName = msg.GetDocumentMessage().GetFileName()
+}
+
+// mediaCaption returns the caption of an image, video or document.
+func mediaCaption(msg *waE2E.Message) string {
+	switch {
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetCaption()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetCaption()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage().GetCaption()
+	}
+	return ""
+}
+
+// labelMedia puts the kind of media and the file name before a caption,
+// for outputs that only show text such as notifications, IRC and tail.
+func labelMedia(mediaType, fileName, text string) string {
+	label, ok := mediaLabels[mediaType]
+	if !ok {
+		return text
+	}
+	if fileName != "" {
+		label += " " + fileName
+	}
+	if text == "" {
+		return label
+	}
+	return label + " " + text
+}
+
+func (m *Message) displayText() string {
+	return labelMedia(m.MediaType, m.FileName, m.Text)
+}
+
+// mediaFilter turns the media_type of a history or search into a
+// condition: one of the media kinds, "any" for messages with media or
+// "none" for those without.
+func mediaFilter(mediaType string) (string, []interface{}, error) {
+	switch mediaType {
+	case "":
+		return "", nil, nil
+	case "any":
+		return "media_type != ''", nil, nil
+	case "none":
+		return "media_type = ''", nil, nil
+	case "image", "video", "audio", "document", "sticker":
+		return "media_type = ?", []interface{}{mediaType}, nil
+	}
+	return "", nil, fmt.Errorf("unknown media_type %q", mediaType)
+}
+
+// saveMediaKey records the media key of a stored message, if it has media.
+func (a *App) saveMediaKey(message *Message, msg *waE2E.Message) {
+	media, mediaType := getMediaMessage(msg)
+	if media == nil || len(media.GetMediaKey()) == 0 {
+		return
+	}
+
+	_, err := a.msgDB.Exec(`
+		INSERT OR REPLACE INTO media_keys
+			(message_id, chat_jid, timestamp, media_type, mimetype, direct_path, media_key, file_sha256, file_enc_sha256, file_length)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, message.MessageID, message.ChatJID, message.Timestamp, mediaType, media.GetMimetype(), media.GetDirectPath(),
+		hex.EncodeToString(media.GetMediaKey()), hex.EncodeToString(media.GetFileSHA256()),
+		hex.EncodeToString(media.GetFileEncSHA256()), media.GetFileLength())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save media key: %v\n", err)
+	}
+}
+
+// downloadMedia saves a message's media under the media directory, named
+// by its content hash so repeated stickers are stored once, and returns
+// the path. Nothing is written while disk space is low.
+func (a *App) downloadMedia(media mediaMessage, subdir string, ext string) (string, error) {
+	if !a.mediaDownloadsAllowed() {
+		return "", nil
+	}
+
+	dir := filepath.Join(a.cfg().MediaDir, subdir)
+	path := filepath.Join(dir, hex.EncodeToString(media.GetFileSHA256())+ext)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	data, err := a.client.Download(a.ctx, media)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// saveSticker downloads an incoming sticker and records where it was saved.
+func (a *App) saveSticker(message *Message, msg *waE2E.Message) {
+	sticker := msg.GetStickerMessage()
+	if sticker == nil {
+		return
+	}
+	path, err := a.downloadMedia(sticker, "stickers", ".webp")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to download sticker: %v\n", err)
+		return
+	}
+	message.MediaPath = path
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// getContactName returns the best known display name for a user JID. LID
+// JIDs are looked up through their phone number JID when the LID itself
+// has no contact entry.
+func (a *App) getContactName(jid types.JID) string {
+	contact, err := a.client.Store.Contacts.GetContact(a.ctx, jid)
+	if (err != nil || !contact.Found) && jid.Server == types.HiddenUserServer {
+		if pn := a.pnForLID(jid); !pn.IsEmpty() {
+			contact, err = a.client.Store.Contacts.GetContact(a.ctx, pn)
+			if err == nil && !contact.Found {
+				return pn.User
+			}
+		}
+	}
+	if err == nil && contact.Found {
+		if contact.PushName != "" {
+			return contact.PushName
+		}
+		if contact.FullName != "" {
+			return contact.FullName
+		}
+	}
+	return jid.User
+}
+
+// resolveMentions replaces raw "@<number>" mentions in text with the
+// mentioned contact's display name.
+func (a *App) resolveMentions(text string, msg *waE2E.Message) string {
+	ctx := getContextInfo(msg)
+	if ctx == nil {
+		return text
+	}
+
+	for _, mentioned := range ctx.GetMentionedJID() {
+		jid, err := types.ParseJID(mentioned)
+		if err != nil || jid.User == "" {
+			continue
+		}
+		token := "@" + jid.User
+		if !strings.Contains(text, token) {
+			continue
+		}
+		if name := a.getContactName(jid); name != jid.User {
+			text = replaceMention(text, token, "@"+name)
+		}
+	}
+	return text
+}
+
+// replaceMention replaces each token in text that ends at a word boundary,
+// so "@4917" leaves "@491712345" alone.
+func replaceMention(text, token, replacement string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(text, token)
+		if i < 0 {
+			b.WriteString(text)
+			return b.String()
+		}
+		end := i + len(token)
+		b.WriteString(text[:i])
+		if end < len(text) && isWordByte(text[end]) {
+			b.WriteString(token)
+		} else {
+			b.WriteString(replacement)
+		}
+		text = text[end:]
+	}
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
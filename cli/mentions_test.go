@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestReplaceMention(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"alone", "@4917", "@Anna"},
+		{"in sentence", "hi @4917, see you", "hi @Anna, see you"},
+		{"repeated", "@4917 and @4917", "@Anna and @Anna"},
+		{"longer number", "@491712345", "@491712345"},
+		{"mixed", "@491712345 @4917", "@491712345 @Anna"},
+		{"followed by letter", "@4917abc", "@4917abc"},
+		{"followed by underscore", "@4917_", "@4917_"},
+		{"followed by non-ascii", "@4917ä", "@Annaä"},
+		{"absent", "hello", "hello"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := replaceMention(tt.text, "@4917", "@Anna"); got != tt.want {
+				t.Errorf("replaceMention(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
@@ -24,94 +24,201 @@ type Message struct {
 	IsMuted     bool   `json:"is_muted"`
 	IsReplyToMe bool   `json:"is_reply_to_me"`
 	Text        string `json:"text"`
+
+	Latitude        *float64 `json:"latitude"`
+	Longitude       *float64 `json:"longitude"`
+	LocationName    string   `json:"location_name"`
+	LocationAddress string   `json:"location_address"`
+	IsLiveLocation  bool     `json:"is_live_location"`
+
+	QuotedMessageID string `json:"quoted_message_id"`
+	QuotedSenderJID string `json:"quoted_sender_jid"`
+	QuotedText      string `json:"quoted_text"`
+
+	IsMentioned bool `json:"is_mentioned"`
+	// Matched one of WATCH_KEYWORDS
+	IsHighlight bool `json:"is_highlight"`
+
+	IsPinned bool  `json:"is_pinned"`
+	PinnedAt int64 `json:"pinned_at"`
+
+	MediaPath string `json:"media_path"`
+
+	Contacts SharedContacts `json:"contacts"`
+
+	IsForwarded     bool `json:"is_forwarded"`
+	ForwardingScore int  `json:"forwarding_score"`
+
+	IsNewsletter bool `json:"is_newsletter"`
+	IsViewOnce   bool `json:"is_view_once"`
+
+	CommunityJID  string `json:"community_jid"`
+	CommunityName string `json:"community_name"`
+
+	// Unix time the message disappears from WhatsApp, zero if it doesn't
+	ExpiresAt int64 `json:"expires_at"`
+
+	// Fields added by WACLI_TEXT_FILTER_CMD
+	Extra MessageExtra `json:"extra"`
+
+	// Starred messages are never trimmed
+	IsStarred bool `json:"is_starred"`
+
+	// image, video, audio, document or sticker; empty without media. Text
+	// then only holds the caption, see displayText.
+	MediaType string `json:"media_type"`
+	Mimetype  string `json:"mimetype"`
+	FileSize  int64  `json:"file_size"`
+	FileName  string `json:"file_name"`
 }
 
+const (
+	quotedSnippetLength = 200
+	// WhatsApp labels messages "Forwarded many times" from this score on
+	frequentlyForwardedScore = 5
+)
+
 func (a *App) handleMessage(msg *events.Message) {
-	if msg.Info.IsFromMe {
+	if msg.Message.GetPollUpdateMessage() != nil {
+		a.handlePollVote(msg)
+		return
+	}
+	if poll := getPollCreation(msg.Message); poll != nil {
+		a.handlePollCreation(msg, poll)
+	}
+	if pin := msg.Message.GetPinInChatMessage(); pin != nil {
+		a.handlePin(msg, pin)
 		return
 	}
 
-	chatJID := msg.Info.Chat
+	a.linkAddressingAlt(msg)
+	a.learnAddressing(msg)
 
-	if chatJID.Server == "broadcast" && !a.config.IncludeStatusMessages {
+	if msg.Info.IsFromMe {
+		out := &OutgoingMessage{
+			MessageID: msg.Info.ID,
+			Timestamp: msg.Info.Timestamp.Unix(),
+			ChatJID:   msg.Info.Chat.String(),
+			Text:      extractText(msg.Message),
+		}
+		a.recordOutgoing(out)
+		a.stopReplyClock(out.ChatJID, msg.Info.Timestamp, false)
 		return
 	}
 
+	if invite := msg.Message.GetGroupInviteMessage(); invite != nil {
+		a.handleGroupInvite(msg, invite)
+	}
+
+	chatJID := msg.Info.Chat
+
 	isMuted := a.isMuted(chatJID)
 	isArchived := a.isArchived(chatJID)
 	isMentioned := a.isMentioned(msg)
 	isReplyToMe := a.isReplyToMe(msg)
 
-	if isMuted && !isMentioned && !isReplyToMe && !a.config.IncludeMutedMessages {
-		return
+	text := extractText(msg.Message)
+	if media, _ := getMediaMessage(msg.Message); media != nil {
+		// media_type says what it is, the text is just the caption
+		text = mediaCaption(msg.Message)
+	} else if text == "" {
+		text = "[Media/Other]"
 	}
+	text = a.resolveMentions(text, msg.Message)
+	isHighlight := a.isHighlight(text)
 
-	if isArchived && !isMentioned && !isReplyToMe {
+	if isArchived && !isMentioned && !isReplyToMe && !isHighlight {
 		return
 	}
 
-	text := extractText(msg.Message)
-	if text == "" {
-		text = "[Media/Other]"
+	decision := a.evaluateRules(ruleInput{
+		ChatJID:   chatJID,
+		Muted:     isMuted,
+		Addressed: isMentioned || isReplyToMe,
+		Text:      text,
+		Time:      time.Now(),
+	})
+	// Highlights are never dropped, whatever the rules say about the chat
+	if isHighlight {
+		decision.Store = true
+		decision.Attention = true
+	}
+	if !decision.any() {
+		return
 	}
 
 	senderName := a.getSenderName(msg)
 	chatName := a.getChatName(msg)
 
 	message := &Message{
-		MessageID:   msg.Info.ID,
-		Timestamp:   msg.Info.Timestamp.Unix(),
-		ChatJID:     chatJID.String(),
-		ChatName:    chatName,
-		SenderJID:   msg.Info.Sender.String(),
-		SenderName:  senderName,
-		IsGroup:     msg.Info.IsGroup,
-		IsMuted:     isMuted,
-		IsReplyToMe: isReplyToMe,
-		Text:        text,
+		MessageID:    msg.Info.ID,
+		Timestamp:    msg.Info.Timestamp.Unix(),
+		ChatJID:      a.canonicalChat(chatJID.String()),
+		ChatName:     chatName,
+		SenderJID:    a.canonicalSender(msg.Info.Sender),
+		SenderName:   senderName,
+		IsGroup:      msg.Info.IsGroup,
+		IsMuted:      isMuted,
+		IsReplyToMe:  isReplyToMe,
+		Text:         text,
+		IsMentioned:  isMentioned,
+		IsHighlight:  isHighlight,
+		IsNewsletter: isNewsletter(chatJID),
+	}
+	applyLocation(message, msg.Message)
+	applyQuote(message, msg.Message)
+	applyContacts(message, msg.Message)
+	applyForwarded(message, msg.Message)
+	applyExpiration(message, msg.Message)
+	applyMedia(message, msg.Message)
+	if msg.Info.IsGroup {
+		a.applyCommunity(message, chatJID)
+	}
+	a.saveSticker(message, msg.Message)
+	a.saveViewOnce(message, msg)
+	a.filterMessage(message)
+	if !a.runScripts(message) {
+		decision.Broadcast, decision.Attention, decision.Email = false, false, false
 	}
 
-	if err := a.saveMessage(message); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to save message: %v\n", err)
-		os.Exit(1)
+	if decision.Store {
+		if err := a.saveMessage(message); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to save message: %v\n", err)
+			os.Exit(1)
+		}
+		a.saveMediaKey(message, msg.Message)
+		a.transcribe(*message)
 	}
 
-	a.broadcastMessage(message)
+	a.broadcastMessage(message, decision)
+	if decision.Email {
+		a.goHandler("email", func() { a.emailMessage(message, msg.Message) })
+	}
+	// Channels can't be answered
+	if !message.IsNewsletter {
+		a.startReplyClock(message)
+		a.goHandler("autoreply", func() { a.maybeAutoReply(message) })
+		a.goHandler("bot", func() { a.handleBotCommand(message) })
+	}
 }
 
-func (a *App) saveMessage(msg *Message) error {
-	columns, placeholders, values := buildInsertParams(msg)
-	query := fmt.Sprintf(
-		"INSERT INTO messages (%s) VALUES (%s)",
-		strings.Join(columns, ", "),
-		strings.Join(placeholders, ", "),
-	)
-
-	result, err := a.msgDB.Exec(query, values...)
-	if err != nil {
-		return err
+// messageEventType is the event a message is broadcast and replayed as.
+func messageEventType(msg *Message) string {
+	switch {
+	case msg.IsNewsletter:
+		return "newsletter_message"
+	case msg.IsViewOnce:
+		return "view_once"
 	}
+	return "message"
+}
 
-	msg.ID, _ = result.LastInsertId()
-
-	var count int
-	err = a.msgDB.QueryRow("SELECT COUNT(*) FROM messages").Scan(&count)
-	if err != nil {
+func (a *App) saveMessage(msg *Message) error {
+	if err := a.checkPartitionRollover(); err != nil {
 		return err
 	}
 
-	if count > maxMessages {
-		_, err = a.msgDB.Exec(`
-			DELETE FROM messages WHERE id NOT IN (
-				SELECT id FROM messages ORDER BY timestamp DESC LIMIT ?
-			)
-		`, trimToCount)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return a.store.SaveMessage(msg)
 }
 
 func (a *App) isMuted(chatJID types.JID) bool {
@@ -186,6 +293,38 @@ func (a *App) isReplyToMe(msg *events.Message) bool {
 	return false
 }
 
+// applyQuote records which message this one replies to, with a short
+// snippet of the quoted text so clients can render the thread.
+func applyQuote(message *Message, msg *waE2E.Message) {
+	ctx := getContextInfo(msg)
+	if ctx == nil || ctx.GetStanzaID() == "" {
+		return
+	}
+	message.QuotedMessageID = ctx.GetStanzaID()
+	message.QuotedSenderJID = ctx.GetParticipant()
+	message.QuotedText = truncateRunes(extractText(ctx.GetQuotedMessage()), quotedSnippetLength)
+}
+
+// applyForwarded records whether a message was forwarded and how many
+// times it has been forwarded along the chain.
+func applyForwarded(message *Message, msg *waE2E.Message) {
+	ctx := getContextInfo(msg)
+	message.IsForwarded = ctx.GetIsForwarded()
+	message.ForwardingScore = int(ctx.GetForwardingScore())
+}
+
+func (m *Message) isFrequentlyForwarded() bool {
+	return m.ForwardingScore >= frequentlyForwardedScore
+}
+
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "…"
+}
+
 func getContextInfo(msg *waE2E.Message) *waE2E.ContextInfo {
 	if msg == nil {
 		return nil
@@ -208,6 +347,15 @@ func getContextInfo(msg *waE2E.Message) *waE2E.ContextInfo {
 	if sticker := msg.GetStickerMessage(); sticker != nil {
 		return sticker.GetContextInfo()
 	}
+	if contact := msg.GetContactMessage(); contact != nil {
+		return contact.GetContextInfo()
+	}
+	if array := msg.GetContactsArrayMessage(); array != nil {
+		return array.GetContextInfo()
+	}
+	if loc := msg.GetLocationMessage(); loc != nil {
+		return loc.GetContextInfo()
+	}
 	return nil
 }
 
@@ -248,14 +396,36 @@ func extractText(msg *waE2E.Message) string {
 	if contact := msg.GetContactMessage(); contact != nil {
 		return "[Contact] " + contact.GetDisplayName()
 	}
+	if array := msg.GetContactsArrayMessage(); array != nil {
+		var names []string
+		for _, contact := range array.GetContacts() {
+			names = append(names, contact.GetDisplayName())
+		}
+		return "[Contacts] " + strings.Join(names, ", ")
+	}
 	if loc := msg.GetLocationMessage(); loc != nil {
-		return "[Location]"
+		return formatLocation("[Location]", loc.GetDegreesLatitude(), loc.GetDegreesLongitude(), loc.GetName(), loc.GetAddress())
+	}
+	if live := msg.GetLiveLocationMessage(); live != nil {
+		return formatLocation("[Live Location]", live.GetDegreesLatitude(), live.GetDegreesLongitude(), live.GetCaption())
+	}
+	if poll := getPollCreation(msg); poll != nil {
+		return "[Poll] " + poll.GetName()
+	}
+	if invite := msg.GetGroupInviteMessage(); invite != nil {
+		if caption := invite.GetCaption(); caption != "" {
+			return "[Group invite] " + invite.GetGroupName() + " " + caption
+		}
+		return "[Group invite] " + invite.GetGroupName()
 	}
 	return ""
 }
 
 func (a *App) getSenderName(msg *events.Message) string {
 	senderJID := msg.Info.Sender
+	if isNewsletter(senderJID) {
+		return a.newsletterName(senderJID)
+	}
 	if msg.Info.IsGroup {
 		contact, err := a.client.Store.Contacts.GetContact(a.ctx, senderJID)
 		if err == nil && contact.Found {
@@ -275,10 +445,12 @@ func (a *App) getSenderName(msg *events.Message) string {
 
 func (a *App) getChatName(msg *events.Message) string {
 	chatJID := msg.Info.Chat
+	if isNewsletter(chatJID) {
+		return a.newsletterName(chatJID)
+	}
 	if msg.Info.IsGroup {
-		groupInfo, err := a.client.GetGroupInfo(a.ctx, chatJID)
-		if err == nil {
-			return groupInfo.Name
+		if name := a.groupName(chatJID); name != "" {
+			return name
 		}
 	}
 	contact, err := a.client.Store.Contacts.GetContact(a.ctx, chatJID)
@@ -0,0 +1,84 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	routeChangeDebounce = 3 * time.Second
+
+	// Multicast groups from linux/rtnetlink.h, not exported by syscall
+	rtmgrpIPv4Route = 0x40
+	rtmgrpIPv6Route = 0x400
+)
+
+// watchNetwork subscribes to rtnetlink route notifications and reconnects
+// once the default route has settled after a change (e.g. Wi-Fi to
+// ethernet, or a VPN coming up).
+func (a *App) watchNetwork() {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_ROUTE)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Network watch disabled: %v\n", err)
+		return
+	}
+	defer syscall.Close(fd)
+
+	addr := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: rtmgrpIPv4Route | rtmgrpIPv6Route,
+	}
+	if err := syscall.Bind(fd, addr); err != nil {
+		fmt.Fprintf(os.Stderr, "Network watch disabled: %v\n", err)
+		return
+	}
+
+	var debounce *time.Timer
+	buf := make([]byte, os.Getpagesize())
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if err == syscall.EINTR || err == syscall.ENOBUFS {
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "Network watch stopped: %v\n", err)
+			return
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		if !containsDefaultRouteChange(msgs) {
+			continue
+		}
+
+		if debounce != nil {
+			debounce.Stop()
+		}
+		debounce = time.AfterFunc(routeChangeDebounce, func() {
+			a.forceReconnect("default route changed")
+		})
+	}
+}
+
+func containsDefaultRouteChange(msgs []syscall.NetlinkMessage) bool {
+	for _, msg := range msgs {
+		if msg.Header.Type != syscall.RTM_NEWROUTE && msg.Header.Type != syscall.RTM_DELROUTE {
+			continue
+		}
+		if len(msg.Data) < syscall.SizeofRtMsg {
+			continue
+		}
+		rt := (*syscall.RtMsg)(unsafe.Pointer(&msg.Data[0]))
+		if rt.Table == syscall.RT_TABLE_MAIN && rt.Dst_len == 0 {
+			return true
+		}
+	}
+	return false
+}
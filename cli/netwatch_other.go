@@ -0,0 +1,7 @@
+//go:build !linux
+
+package main
+
+// watchNetwork is only implemented on Linux; elsewhere reconnects rely on
+// whatsmeow's own keepalive timeouts.
+func (a *App) watchNetwork() {}
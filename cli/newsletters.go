@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Newsletters are WhatsApp Channels: one-to-many chats under @newsletter
+// that only their owners and admins post to. Posts from followed channels
+// are stored like any other message but broadcast as newsletter_message,
+// so chat clients don't mix them into conversations.
+
+const channelLinkPrefix = "https://whatsapp.com/channel/"
+
+// errNewsletterReadOnly refuses posting to a channel you merely follow.
+var errNewsletterReadOnly = errors.New("only owners and admins can post to a channel")
+
+// NewsletterInfo is the result of follow_newsletter and unfollow_newsletter.
+type NewsletterInfo struct {
+	ChatJID     string `json:"chat_jid"`
+	Name        string `json:"name"`
+	Role        string `json:"role,omitempty"`
+	Subscribers int    `json:"subscribers"`
+	Following   bool   `json:"following"`
+}
+
+// newsletterCache keeps channel metadata so names and roles aren't
+// fetched for every post. Entries are dropped on follow and unfollow.
+type newsletterCache struct {
+	mu    sync.Mutex
+	byJID map[types.JID]*types.NewsletterMetadata
+}
+
+func isNewsletter(jid types.JID) bool {
+	return jid.Server == types.NewsletterServer
+}
+
+func (a *App) newsletterMeta(jid types.JID) (*types.NewsletterMetadata, error) {
+	a.newsletters.mu.Lock()
+	meta, ok := a.newsletters.byJID[jid]
+	a.newsletters.mu.Unlock()
+	if ok {
+		return meta, nil
+	}
+
+	meta, err := a.client.GetNewsletterInfo(a.ctx, jid)
+	if err != nil {
+		return nil, err
+	}
+	a.newsletters.mu.Lock()
+	if a.newsletters.byJID == nil {
+		a.newsletters.byJID = make(map[types.JID]*types.NewsletterMetadata)
+	}
+	a.newsletters.byJID[jid] = meta
+	a.newsletters.mu.Unlock()
+	return meta, nil
+}
+
+func (a *App) forgetNewsletter(jid types.JID) {
+	a.newsletters.mu.Lock()
+	delete(a.newsletters.byJID, jid)
+	a.newsletters.mu.Unlock()
+}
+
+func (a *App) newsletterName(jid types.JID) string {
+	meta, err := a.newsletterMeta(jid)
+	if err != nil || meta.ThreadMeta.Name.Text == "" {
+		return jid.User
+	}
+	return meta.ThreadMeta.Name.Text
+}
+
+// resolveNewsletter accepts a channel JID, an invite link or its bare
+// invite code.
+func (a *App) resolveNewsletter(ref string) (types.JID, error) {
+	ref = strings.TrimSpace(ref)
+	if strings.Contains(ref, "@") {
+		jid, err := types.ParseJID(ref)
+		if err != nil {
+			return types.JID{}, fmt.Errorf("invalid JID: %w", err)
+		}
+		if !isNewsletter(jid) {
+			return types.JID{}, fmt.Errorf("%s is not a channel", ref)
+		}
+		return jid, nil
+	}
+	code := strings.TrimSuffix(strings.TrimPrefix(ref, channelLinkPrefix), "/")
+	if code == "" {
+		return types.JID{}, fmt.Errorf("missing channel JID or invite link")
+	}
+	meta, err := a.client.GetNewsletterInfoWithInvite(a.ctx, code)
+	if err != nil {
+		return types.JID{}, fmt.Errorf("resolve invite %q: %w", code, err)
+	}
+	return meta.ID, nil
+}
+
+// followNewsletter follows or unfollows a channel and returns its fresh
+// metadata.
+func (a *App) followNewsletter(ref string, follow bool) (*NewsletterInfo, error) {
+	jid, err := a.resolveNewsletter(ref)
+	if err != nil {
+		return nil, err
+	}
+	if follow {
+		err = a.client.FollowNewsletter(a.ctx, jid)
+	} else {
+		err = a.client.UnfollowNewsletter(a.ctx, jid)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	a.forgetNewsletter(jid)
+	meta, err := a.newsletterMeta(jid)
+	if err != nil {
+		return nil, err
+	}
+	info := &NewsletterInfo{
+		ChatJID:     jid.String(),
+		Name:        meta.ThreadMeta.Name.Text,
+		Subscribers: meta.ThreadMeta.SubscriberCount,
+		Following:   follow,
+	}
+	if meta.ViewerMeta != nil {
+		info.Role = string(meta.ViewerMeta.Role)
+	}
+	return info, nil
+}
+
+// checkNewsletterSend vets a send to a channel before it's queued. Media
+// would need the separate newsletter upload, so only text, polls and
+// locations can be posted. The role is only checked while connected; a
+// queued post to a channel you don't run fails when the server rejects it.
+func (a *App) checkNewsletterSend(jid types.JID, msg *waE2E.Message) error {
+	if !isNewsletter(jid) {
+		return nil
+	}
+	if msg.Conversation == nil && msg.ExtendedTextMessage == nil && msg.LocationMessage == nil && getPollCreation(msg) == nil {
+		return fmt.Errorf("only text, polls and locations can be posted to a channel")
+	}
+	if !a.client.IsConnected() {
+		return nil
+	}
+	meta, err := a.newsletterMeta(jid)
+	if err != nil {
+		return err
+	}
+	if meta.ViewerMeta == nil || (meta.ViewerMeta.Role != types.NewsletterRoleOwner && meta.ViewerMeta.Role != types.NewsletterRoleAdmin) {
+		return fmt.Errorf("%w: %s", errNewsletterReadOnly, jid)
+	}
+	return nil
+}
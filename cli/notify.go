@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	notifyBackendNotifySend = "notify-send"
+
+	notifyLevelAll      = "all"
+	notifyLevelMentions = "mentions"
+	notifyLevelOff      = "off"
+	notifyLevelLow      = "low"
+
+	notificationBodyLength = 200
+)
+
+type Notification struct {
+	Title    string
+	Body     string
+	Urgency  string
+	Category string
+}
+
+// Notifier delivers desktop notifications.
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+func newNotifier(config Config) Notifier {
+	switch config.NotifyBackend {
+	case "":
+		return nil
+	case notifyBackendNotifySend:
+		return &notifySendNotifier{}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown NOTIFY_BACKEND %q, notifications disabled\n", config.NotifyBackend)
+		return nil
+	}
+}
+
+// notifySendNotifier uses libnotify's notify-send, which talks to the
+// org.freedesktop.Notifications D-Bus service.
+type notifySendNotifier struct{}
+
+func (n *notifySendNotifier) Notify(notification Notification) error {
+	args := []string{"--app-name=wacli", "--urgency=" + notification.Urgency}
+	if notification.Category != "" {
+		args = append(args, "--category="+notification.Category)
+	}
+	args = append(args, notification.Title, notification.Body)
+	return exec.Command("notify-send", args...).Run()
+}
+
+// parseNotifyRules parses NOTIFY_RULES, a comma separated list of
+// <jid>=<all|mentions|off> entries.
+func parseNotifyRules(value string) map[string]string {
+	rules := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		jid, level, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			continue
+		}
+		rules[strings.TrimSpace(jid)] = strings.TrimSpace(level)
+	}
+	return rules
+}
+
+func (a *App) notificationLevel(jid string) string {
+	if level, ok := a.cfg().NotifyRules[jid]; ok {
+		return level
+	}
+	return a.cfg().NotifyDefault
+}
+
+func (a *App) notifyMessage(msg *Message) {
+	if a.currentNotifier() == nil {
+		return
+	}
+
+	// Highlights notify like mentions, muted or not
+	addressed := msg.IsMentioned || msg.IsReplyToMe || msg.IsHighlight
+	switch a.notificationLevel(msg.ChatJID) {
+	case notifyLevelOff:
+		return
+	case notifyLevelMentions:
+		if !addressed {
+			return
+		}
+	}
+	if msg.IsMuted && !addressed {
+		return
+	}
+
+	title := msg.SenderName
+	if msg.IsGroup {
+		title = msg.SenderName + " @ " + msg.ChatName
+	}
+	urgency := "normal"
+	if addressed {
+		urgency = "critical"
+	} else if msg.isFrequentlyForwarded() {
+		// Chain mail rarely deserves attention
+		switch a.cfg().NotifyForwarded {
+		case notifyLevelOff:
+			return
+		case notifyLevelLow:
+			urgency = "low"
+		}
+	}
+
+	a.sendNotification(Notification{
+		Title:    title,
+		Body:     truncateRunes(msg.displayText(), notificationBodyLength),
+		Urgency:  urgency,
+		Category: "im.received",
+	})
+}
+
+func (a *App) notifyCall(call *Call) {
+	if a.currentNotifier() == nil {
+		return
+	}
+
+	jid := call.CallerJID
+	if call.IsGroup {
+		jid = call.GroupJID
+	}
+	if a.notificationLevel(jid) == notifyLevelOff {
+		return
+	}
+
+	body := call.CallerName
+	if call.IsGroup && call.GroupName != "" {
+		body = call.CallerName + " @ " + call.GroupName
+	}
+	a.sendNotification(Notification{
+		Title:    "Incoming call",
+		Body:     body,
+		Urgency:  "critical",
+		Category: "call.incoming",
+	})
+}
+
+func (a *App) sendNotification(n Notification) {
+	a.goSafe("notification", func() {
+		notifier := a.currentNotifier()
+		if notifier == nil {
+			return
+		}
+		if err := notifier.Notify(n); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to send notification: %v\n", err)
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// errOfflineExpired fails queued sends that waited longer than
+// OFFLINE_QUEUE_MAX_AGE for the connection to come back.
+var errOfflineExpired = errors.New("not sent before the offline queue's max age")
+
+// queuedOfflineError is returned for a send that was kept in the offline
+// queue instead of being sent. It carries the ID the message will be sent
+// under.
+type queuedOfflineError struct {
+	id types.MessageID
+}
+
+func (e *queuedOfflineError) Error() string {
+	return fmt.Sprintf("not connected, %s queued until reconnect", e.id)
+}
+
+func isQueuedOffline(err error) bool {
+	var queued *queuedOfflineError
+	return errors.As(err, &queued)
+}
+
+// queueOffline stores a send made while disconnected. Messages are kept as
+// marshaled protobufs, so every kind of send action can be queued.
+func (a *App) queueOffline(id types.MessageID, jid types.JID, msg *waE2E.Message, priority sendPriority) error {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = a.msgDB.Exec(`
+		INSERT INTO offline_queue (message_id, chat_jid, message, priority, queued_at) VALUES (?, ?, ?, ?, ?)
+	`, id, jid.String(), data, int(priority), time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	return &queuedOfflineError{id: id}
+}
+
+type offlineSend struct {
+	id       types.MessageID
+	chatJID  string
+	data     []byte
+	priority sendPriority
+	queuedAt int64
+}
+
+// flushOfflineQueue sends what was queued while disconnected, oldest first,
+// and fails messages past their max age. It stops early if the connection
+// drops again; the rest waits for the next reconnect.
+func (a *App) flushOfflineQueue() {
+	if !a.offlineMu.TryLock() {
+		return
+	}
+	defer a.offlineMu.Unlock()
+
+	sends, err := a.offlineSends()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read offline queue: %v\n", err)
+		return
+	}
+	for _, send := range sends {
+		if !a.client.IsConnected() {
+			return
+		}
+		if _, err := a.msgDB.Exec("DELETE FROM offline_queue WHERE message_id = ?", send.id); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to update offline queue: %v\n", err)
+			return
+		}
+
+		jid, err := types.ParseJID(send.chatJID)
+		var msg waE2E.Message
+		if err == nil {
+			err = proto.Unmarshal(send.data, &msg)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Dropping unreadable queued message %s: %v\n", send.id, err)
+			continue
+		}
+
+		if time.Since(time.Unix(send.queuedAt, 0)) > a.cfg().OfflineQueueMaxAge {
+			a.recordSendFailure(&OutgoingMessage{
+				MessageID: send.id,
+				ChatJID:   send.chatJID,
+				Text:      extractText(&msg),
+			}, errOfflineExpired)
+			continue
+		}
+		if _, err := a.sendPaced(send.id, jid, &msg, false, send.priority); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to send queued message %s: %v\n", send.id, err)
+		}
+	}
+}
+
+func (a *App) offlineSends() ([]offlineSend, error) {
+	rows, err := a.msgDB.Query("SELECT message_id, chat_jid, message, priority, queued_at FROM offline_queue ORDER BY queued_at, rowid")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sends []offlineSend
+	for rows.Next() {
+		var send offlineSend
+		if err := rows.Scan(&send.id, &send.chatJID, &send.data, &send.priority, &send.queuedAt); err != nil {
+			return nil, err
+		}
+		sends = append(sends, send)
+	}
+	return sends, rows.Err()
+}
+
+func (a *App) offlineQueueLength() int {
+	var n int
+	a.msgDB.QueryRow("SELECT COUNT(*) FROM offline_queue").Scan(&n)
+	return n
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"unicode"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// PhoneLookup answers resolve: whether a phone number is registered on
+// WhatsApp and the JID to send to.
+type PhoneLookup struct {
+	Phone        string `json:"phone"`
+	JID          string `json:"jid,omitempty"`
+	Registered   bool   `json:"registered"`
+	BusinessName string `json:"business_name,omitempty"`
+}
+
+type OpenChat struct {
+	ChatJID string `json:"chat_jid"`
+	Text    string `json:"text"`
+}
+
+// parseChatURI extracts the phone number and prefilled text from wa.me,
+// api.whatsapp.com, whatsapp:// and tel: links.
+func parseChatURI(raw string) (phone string, text string, err error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", "", err
+	}
+
+	switch {
+	case u.Scheme == "tel":
+		phone = u.Opaque
+		if phone == "" {
+			phone = u.Host + u.Path
+		}
+	case u.Scheme == "whatsapp" || u.Host == "api.whatsapp.com":
+		phone = u.Query().Get("phone")
+		text = u.Query().Get("text")
+	case u.Host == "wa.me" || u.Host == "www.wa.me":
+		phone = strings.Trim(u.Path, "/")
+		text = u.Query().Get("text")
+	default:
+		return "", "", fmt.Errorf("unsupported link %q", raw)
+	}
+
+	phone = strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, phone)
+	if phone == "" {
+		return "", "", fmt.Errorf("no phone number in %q", raw)
+	}
+	return phone, text, nil
+}
+
+// resolvePhone looks up the account registered for a phone number.
+func (a *App) resolvePhone(phone string) (types.JID, error) {
+	resp, err := a.client.IsOnWhatsApp(a.ctx, []string{"+" + strings.TrimLeft(phone, "+")})
+	if err != nil {
+		return types.JID{}, err
+	}
+	if len(resp) == 0 || !resp[0].IsIn {
+		return types.JID{}, fmt.Errorf("%s is not on WhatsApp", phone)
+	}
+	return resp[0].JID, nil
+}
+
+// lookupPhone checks a phone number with country code, in any notation,
+// against WhatsApp. Unregistered numbers are not an error.
+func (a *App) lookupPhone(raw string) (PhoneLookup, error) {
+	phone := strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, raw)
+	if phone == "" {
+		return PhoneLookup{}, fmt.Errorf("no phone number in %q", raw)
+	}
+	resp, err := a.client.IsOnWhatsApp(a.ctx, []string{"+" + phone})
+	if err != nil {
+		return PhoneLookup{}, err
+	}
+	lookup := PhoneLookup{Phone: phone}
+	if len(resp) == 0 || !resp[0].IsIn {
+		return lookup, nil
+	}
+	lookup.JID = resp[0].JID.String()
+	lookup.Registered = true
+	if resp[0].VerifiedName != nil {
+		lookup.BusinessName = resp[0].VerifiedName.Details.GetVerifiedName()
+	}
+	return lookup, nil
+}
+
+// openChat asks clients to show a chat, with text to prefill the composer.
+func (a *App) openChat(cmd *SocketCommand) (OpenChat, error) {
+	open := OpenChat{ChatJID: cmd.ChatJID, Text: cmd.Text}
+	if cmd.Phone != "" {
+		jid, err := a.resolvePhone(cmd.Phone)
+		if err != nil {
+			return OpenChat{}, err
+		}
+		open.ChatJID = jid.String()
+	}
+	if open.ChatJID == "" {
+		return OpenChat{}, fmt.Errorf("chat_jid or phone is required")
+	}
+
+	a.broadcastEvent("open_chat", open)
+	return open, nil
+}
+
+func runOpen(config Config, args []string) {
+	fs := flag.NewFlagSet("open", flag.ExitOnError)
+	send := fs.Bool("send", false, "send the prefilled text instead of opening it in the TUI")
+	positional := parseInterspersed(fs, args)
+	if len(positional) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: wacli open <wa.me or tel: link> [--send]")
+		os.Exit(1)
+	}
+
+	phone, text, err := parseChatURI(positional[0])
+	exitOnError(err)
+
+	d, err := dialDaemon(config)
+	exitOnError(err)
+	defer d.Close()
+
+	prefill := text
+	if *send {
+		prefill = ""
+	}
+	data, err := d.request(SocketCommand{Action: "open_chat", Phone: phone, Text: prefill}, nil)
+	exitOnError(err)
+
+	var open OpenChat
+	exitOnError(json.Unmarshal(data, &open))
+	if *send && text != "" {
+		_, err := d.request(SocketCommand{Action: "send", ChatJID: open.ChatJID, Text: text}, nil)
+		exitOnError(err)
+	}
+	fmt.Println(open.ChatJID)
+}
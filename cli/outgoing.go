@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+)
+
+const (
+	outgoingStatusSent   = "sent"
+	outgoingStatusFailed = "failed"
+)
+
+// OutgoingMessage is a message you sent, from wacli or another device,
+// kept apart from the incoming archive to track its delivery status.
+// Sends that failed are kept too, with a machine-readable FailureCode and
+// the error as FailureReason.
+type OutgoingMessage struct {
+	MessageID     string `json:"message_id"`
+	Timestamp     int64  `json:"timestamp"`
+	ChatJID       string `json:"chat_jid"`
+	Text          string `json:"text"`
+	Status        string `json:"status"`
+	StatusAt      int64  `json:"status_at"`
+	Automated     bool   `json:"automated"`
+	FailureCode   string `json:"failure_code"`
+	FailureReason string `json:"failure_reason"`
+}
+
+// sendToChat sends a message built by one of the send actions and records
+// it as outgoing.
+func (a *App) sendToChat(jid types.JID, msg *waE2E.Message, priority sendPriority) (whatsmeow.SendResponse, error) {
+	return a.sendQueued(a.client.GenerateMessageID(), jid, msg, false, priority)
+}
+
+// sendAutomated sends on the daemon's own initiative, unless the kill
+// switch is engaged. The message is recorded as outgoing but doesn't count
+// as you writing in the chat, so reminders and unreplied tracking keep
+// waiting for a real answer.
+func (a *App) sendAutomated(source string, jid types.JID, msg *waE2E.Message) (whatsmeow.SendResponse, error) {
+	var resp whatsmeow.SendResponse
+	err := a.automationAllowed(source)
+	if err == nil {
+		resp, err = a.sendQueued(a.client.GenerateMessageID(), jid, msg, true, priorityBulk)
+	}
+	a.recordSent(source, "daemon", jid.String(), extractText(msg), resp.ID, err)
+	return resp, err
+}
+
+// sendQueued sends under an ID chosen up front, so a failed or queued send
+// can be tracked by it as well. Your own sends made while disconnected go
+// to the offline queue when it's enabled; automated ones just fail.
+func (a *App) sendQueued(id types.MessageID, jid types.JID, msg *waE2E.Message, automated bool, priority sendPriority) (whatsmeow.SendResponse, error) {
+	if err := a.checkNewsletterSend(jid, msg); err != nil {
+		a.recordSendFailure(&OutgoingMessage{MessageID: id, ChatJID: jid.String(), Text: extractText(msg), Automated: automated}, err)
+		return whatsmeow.SendResponse{}, err
+	}
+	if !automated && a.cfg().OfflineQueueMaxAge > 0 && !a.client.IsConnected() {
+		return whatsmeow.SendResponse{ID: id}, a.queueOffline(id, jid, msg, priority)
+	}
+	return a.sendPaced(id, jid, msg, automated, priority)
+}
+
+// sendPaced waits for the send's turn in the outgoing queue when sends
+// are paced. Bulk sends are held to SEND_CHAT_LIMIT.
+func (a *App) sendPaced(id types.MessageID, jid types.JID, msg *waE2E.Message, automated bool, priority sendPriority) (whatsmeow.SendResponse, error) {
+	if priority == priorityBulk {
+		if err := a.checkChatCap(jid); err != nil {
+			a.recordSendFailure(&OutgoingMessage{MessageID: id, ChatJID: jid.String(), Text: extractText(msg), Automated: automated}, err)
+			return whatsmeow.SendResponse{}, err
+		}
+	}
+	if a.sendQueue == nil {
+		return a.sendAndRecord(id, jid, msg, automated)
+	}
+	var resp whatsmeow.SendResponse
+	var err error
+	a.sendQueue.do(priority, func() {
+		resp, err = a.sendAndRecord(id, jid, msg, automated)
+	})
+	return resp, err
+}
+
+func (a *App) sendAndRecord(id types.MessageID, jid types.JID, msg *waE2E.Message, automated bool) (whatsmeow.SendResponse, error) {
+	resp, err := a.client.SendMessage(a.ctx, jid, msg, whatsmeow.SendRequestExtra{ID: id})
+	out := &OutgoingMessage{
+		MessageID: id,
+		Timestamp: resp.Timestamp.Unix(),
+		ChatJID:   jid.String(),
+		Text:      extractText(msg),
+		Automated: automated,
+	}
+	if err != nil {
+		a.recordSendFailure(out, err)
+		return resp, err
+	}
+	a.recordOutgoing(out)
+	a.stopReplyClock(out.ChatJID, resp.Timestamp, true)
+	return resp, nil
+}
+
+// recordOutgoing stores a sent message and, unless it was automated,
+// remembers when you last wrote in its chat.
+func (a *App) recordOutgoing(msg *OutgoingMessage) {
+	msg.Status = outgoingStatusSent
+	msg.StatusAt = msg.Timestamp
+
+	a.insertOutgoing(msg)
+	a.transcribeOutgoing(msg)
+	if msg.Automated {
+		return
+	}
+
+	_, err := a.msgDB.Exec(`
+		INSERT INTO chat_activity (chat_jid, last_outgoing) VALUES (?, ?)
+		ON CONFLICT(chat_jid) DO UPDATE SET last_outgoing = MAX(last_outgoing, excluded.last_outgoing)
+	`, msg.ChatJID, msg.Timestamp)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to record outgoing message: %v\n", err)
+	}
+}
+
+func (a *App) insertOutgoing(msg *OutgoingMessage) {
+	msg.ChatJID = a.canonicalChat(msg.ChatJID)
+	columns, placeholders, values := buildInsertParams(msg)
+	query := fmt.Sprintf(
+		"INSERT OR IGNORE INTO outgoing_messages (%s) VALUES (%s)",
+		strings.Join(columns, ", "),
+		strings.Join(placeholders, ", "),
+	)
+	if _, err := a.msgDB.Exec(query, values...); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save outgoing message: %v\n", err)
+	}
+}
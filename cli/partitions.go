@@ -0,0 +1,339 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Messages older than the current month are moved out of the hot messages
+// table into per-month tables named messages_YYYY_MM. The messages_all view
+// unions the hot table with every partition so readers don't need to know
+// where a row lives.
+const (
+	partitionPrefix = "messages_"
+	partitionGlob   = "messages_[0-9][0-9][0-9][0-9]_[0-9][0-9]"
+	messagesView    = "messages_all"
+)
+
+func monthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+func partitionName(t time.Time) string {
+	return fmt.Sprintf("%s%04d_%02d", partitionPrefix, t.Year(), int(t.Month()))
+}
+
+func partitionMonth(name string) (time.Time, error) {
+	return time.ParseInLocation("2006_01", strings.TrimPrefix(name, partitionPrefix), time.Local)
+}
+
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func listPartitions(db queryer) ([]string, error) {
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name GLOB ?", partitionGlob)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, rows.Err()
+}
+
+type tableColumn struct {
+	Name    string
+	Type    string
+	NotNull bool
+	Default sql.NullString
+}
+
+// definition is the column's type and constraints as ALTER TABLE ADD
+// COLUMN takes them, so a column added to a partition gets the same
+// default for the rows already in it.
+func (c tableColumn) definition() string {
+	def := c.Type
+	if c.NotNull && c.Default.Valid {
+		def += " NOT NULL"
+	}
+	if c.Default.Valid {
+		def += " DEFAULT " + c.Default.String
+	}
+	return def
+}
+
+func tableColumns(q queryer, table string) ([]tableColumn, error) {
+	rows, err := q.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []tableColumn
+	for rows.Next() {
+		var (
+			cid       int
+			col       tableColumn
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &col.Name, &col.Type, &notNull, &dfltValue, &pk); err != nil {
+			return nil, err
+		}
+		col.NotNull = notNull != 0
+		col.Default = dfltValue
+		columns = append(columns, col)
+	}
+	return columns, rows.Err()
+}
+
+var createMessagesTable = regexp.MustCompile(`^CREATE TABLE\s+"?messages"?`)
+
+// createPartition creates a partition with the hot table's schema, so its
+// columns keep their NOT NULL constraints and defaults.
+func createPartition(tx *sql.Tx, partition string) error {
+	var schema string
+	if err := tx.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'messages'").Scan(&schema); err != nil {
+		return err
+	}
+	if !createMessagesTable.MatchString(schema) {
+		return fmt.Errorf("unexpected messages schema: %s", schema)
+	}
+	_, err := tx.Exec(createMessagesTable.ReplaceAllLiteralString(schema, "CREATE TABLE IF NOT EXISTS "+partition))
+	return err
+}
+
+// syncPartitionColumns adds any columns the hot table gained since the
+// partition was created, so the view can select the same column list from
+// both, and returns the partition's columns.
+func syncPartitionColumns(tx *sql.Tx, partition string, hot []tableColumn) ([]tableColumn, error) {
+	existing, err := tableColumns(tx, partition)
+	if err != nil {
+		return nil, err
+	}
+	have := make(map[string]bool, len(existing))
+	for _, col := range existing {
+		have[col.Name] = true
+	}
+	for _, col := range hot {
+		if have[col.Name] {
+			continue
+		}
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", partition, col.Name, col.definition())); err != nil {
+			return nil, err
+		}
+		existing = append(existing, col)
+	}
+	return existing, nil
+}
+
+// partitionSelectList selects the hot table's columns from a partition.
+// Partitions created before they copied the hot table's schema, and columns
+// added to them without a default, can hold NULLs where the hot table
+// can't; those read as the hot table's default instead.
+func partitionSelectList(hot, partition []tableColumn) string {
+	nullable := make(map[string]bool, len(partition))
+	for _, col := range partition {
+		nullable[col.Name] = !col.NotNull
+	}
+	names := make([]string, len(hot))
+	for i, col := range hot {
+		names[i] = col.Name
+		if col.NotNull && col.Default.Valid && nullable[col.Name] {
+			names[i] = fmt.Sprintf("COALESCE(%s, %s) AS %s", col.Name, col.Default.String, col.Name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// updateMessageTables runs an UPDATE against the hot table and every
+// partition, since the view itself is read-only. The query has a %s
+// placeholder for the table name.
+func (a *App) updateMessageTables(query string, args ...interface{}) (int64, error) {
+	partitions, err := listPartitions(a.msgDB.DB)
+	if err != nil {
+		return 0, err
+	}
+
+	var affected int64
+	for _, table := range append([]string{"messages"}, partitions...) {
+		res, err := a.msgDB.Exec(fmt.Sprintf(query, table), args...)
+		if err != nil {
+			return affected, err
+		}
+		n, _ := res.RowsAffected()
+		affected += n
+	}
+	return affected, nil
+}
+
+// rotatePartitions moves messages from previous months into their partition
+// tables, drops partitions past the retention window and rebuilds the view.
+func (a *App) rotatePartitions() error {
+	a.partitionMu.Lock()
+	defer a.partitionMu.Unlock()
+
+	now := time.Now()
+	a.hotMonth = partitionName(now)
+
+	if !a.cfg().PartitionByMonth {
+		return a.rebuildMessagesView()
+	}
+
+	tx, err := a.msgDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	hot, err := tableColumns(tx, "messages")
+	if err != nil {
+		return err
+	}
+	names := make([]string, len(hot))
+	for i, col := range hot {
+		names[i] = col.Name
+	}
+	columnList := strings.Join(names, ", ")
+
+	cutoff := monthStart(now)
+	var oldest sql.NullInt64
+	if err := tx.QueryRow("SELECT MIN(timestamp) FROM messages WHERE timestamp < ?", cutoff.Unix()).Scan(&oldest); err != nil {
+		return err
+	}
+
+	if oldest.Valid {
+		for month := monthStart(time.Unix(oldest.Int64, 0)); month.Before(cutoff); month = month.AddDate(0, 1, 0) {
+			next := month.AddDate(0, 1, 0)
+			partition := partitionName(month)
+
+			var found bool
+			err := tx.QueryRow(
+				"SELECT EXISTS (SELECT 1 FROM messages WHERE timestamp >= ? AND timestamp < ?)",
+				month.Unix(), next.Unix(),
+			).Scan(&found)
+			if err != nil {
+				return err
+			}
+			if !found {
+				continue
+			}
+
+			if err := createPartition(tx, partition); err != nil {
+				return err
+			}
+			_, err = tx.Exec(fmt.Sprintf(`
+				CREATE INDEX IF NOT EXISTS idx_%[1]s_timestamp ON %[1]s(timestamp);
+				CREATE INDEX IF NOT EXISTS idx_%[1]s_chat_timestamp ON %[1]s(chat_jid, timestamp);
+				CREATE INDEX IF NOT EXISTS idx_%[1]s_sender_timestamp ON %[1]s(sender_jid, timestamp);
+			`, partition))
+			if err != nil {
+				return err
+			}
+			if _, err := syncPartitionColumns(tx, partition, hot); err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(fmt.Sprintf(
+				"INSERT INTO %s (%s) SELECT %s FROM messages WHERE timestamp >= ? AND timestamp < ?",
+				partition, columnList, columnList,
+			), month.Unix(), next.Unix())
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec("DELETE FROM messages WHERE timestamp >= ? AND timestamp < ?", month.Unix(), next.Unix())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if a.cfg().RetentionMonths > 0 {
+		if err := a.dropExpiredPartitions(now); err != nil {
+			return err
+		}
+	}
+
+	return a.rebuildMessagesView()
+}
+
+func (a *App) rebuildMessagesView() error {
+	tx, err := a.msgDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := createMessagesView(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// createMessagesView replaces the view over the hot table and the
+// partitions that exist in tx.
+func createMessagesView(tx *sql.Tx) error {
+	hot, err := tableColumns(tx, "messages")
+	if err != nil {
+		return err
+	}
+	names := make([]string, len(hot))
+	for i, col := range hot {
+		names[i] = col.Name
+	}
+	columnList := strings.Join(names, ", ")
+
+	partitions, err := listPartitions(tx)
+	if err != nil {
+		return err
+	}
+
+	selects := []string{fmt.Sprintf("SELECT %s FROM messages", columnList)}
+	for _, partition := range partitions {
+		columns, err := syncPartitionColumns(tx, partition, hot)
+		if err != nil {
+			return err
+		}
+		selects = append(selects, fmt.Sprintf("SELECT %s FROM %s", partitionSelectList(hot, columns), partition))
+	}
+
+	_, err = tx.Exec(fmt.Sprintf(
+		"DROP VIEW IF EXISTS %[1]s; CREATE VIEW %[1]s AS %[2]s",
+		messagesView, strings.Join(selects, " UNION ALL "),
+	))
+	return err
+}
+
+// checkPartitionRollover rotates partitions the first time a message is
+// saved after the month changes.
+func (a *App) checkPartitionRollover() error {
+	if !a.cfg().PartitionByMonth {
+		return nil
+	}
+	a.partitionMu.Lock()
+	current := a.hotMonth
+	a.partitionMu.Unlock()
+
+	if current == partitionName(time.Now()) {
+		return nil
+	}
+	return a.rotatePartitions()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const (
+	legacyRuntimeDir = "/tmp/rlocal/wacli"
+	deviceDBName     = "wacli.db"
+	messageDBName    = "messages.db"
+)
+
+// defaultDataDir keeps using the working directory when it already holds
+// a device database from before data directories were configurable, and
+// otherwise follows the XDG base directory spec.
+func defaultDataDir() string {
+	if _, err := os.Stat(deviceDBName); err == nil {
+		return "."
+	}
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "wacli")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".local", "share", "wacli")
+	}
+	return "."
+}
+
+func defaultSocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "wacli", "wacli.sock")
+	}
+	return filepath.Join(legacyRuntimeDir, "wacli.sock")
+}
+
+func envOr(key string, fallback func() string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback()
+}
+
+// ensureDirs creates the data and media directories private to the user
+// running the daemon, as they hold the session keys and message history.
+func ensureDirs(config Config) error {
+	for _, dir := range []string{config.DataDir, config.MediaDir} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c Config) deviceDBPath() string {
+	return filepath.Join(c.DataDir, deviceDBName)
+}
+
+func (c Config) messageDBPath() string {
+	return filepath.Join(c.DataDir, messageDBName)
+}
+
+// configFilePath is the config file written by `wacli init`, read in
+// addition to a .env in the working directory.
+func configFilePath() string {
+	if path := os.Getenv("WACLI_CONFIG"); path != "" {
+		return path
+	}
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "wacli", "config.env")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".config", "wacli", "config.env")
+	}
+	return "config.env"
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// defaultPicker shows the name and last message of the TSV chat list and
+// prints the picked line, JID first.
+const defaultPicker = "fzf --delimiter='\t' --with-nth=2.. --prompt='chat> '"
+
+// writeChatsTSV prints one "JID<tab>name<tab>last message" line per chat,
+// the format `wacli chats --format tsv` and pickers such as fzf and dmenu
+// work with. Tabs and newlines inside fields become spaces.
+func writeChatsTSV(w io.Writer, chats []ChatSummary) {
+	field := strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+	for _, chat := range chats {
+		last := chat.LastText
+		if chat.LastSender != "" {
+			last = chat.LastSender + ": " + last
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", chat.ChatJID, field.Replace(chat.ChatName), field.Replace(truncateRunes(last, 80)))
+	}
+}
+
+// runPick sends a message to a chat picked from a list: the line a picker
+// printed is read from stdin, or without piped input the chat list is fed
+// to --picker. The message is then asked for on the terminal.
+func runPick(config Config, args []string) {
+	fs := flag.NewFlagSet("pick", flag.ExitOnError)
+	picker := fs.String("picker", envOr("WACLI_PICKER", func() string { return defaultPicker }), "command that reads TSV chat lines and prints the chosen one")
+	fs.Parse(args)
+
+	d, err := dialDaemon(config)
+	exitOnError(err)
+	defer d.Close()
+
+	var picked string
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		data, err := d.request(SocketCommand{Action: "list_chats"}, nil)
+		exitOnError(err)
+		var chats []ChatSummary
+		exitOnError(json.Unmarshal(data, &chats))
+
+		var list bytes.Buffer
+		writeChatsTSV(&list, chats)
+		cmd := exec.Command("sh", "-c", *picker)
+		cmd.Stdin = &list
+		cmd.Stderr = os.Stderr
+		output, err := cmd.Output()
+		if err != nil {
+			// Pickers exit non-zero when nothing was chosen
+			os.Exit(1)
+		}
+		picked = string(output)
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			exitOnError(fmt.Errorf("no chat picked"))
+		}
+		picked = line
+	}
+
+	fields := strings.Split(strings.TrimRight(picked, "\r\n"), "\t")
+	jid := strings.TrimSpace(fields[0])
+	if jid == "" {
+		exitOnError(fmt.Errorf("no chat picked"))
+	}
+	jid = normalizeJID(jid)
+	name := jid
+	if len(fields) > 1 && fields[1] != "" {
+		name = fields[1]
+	}
+
+	// stdin may be the picker's output, so ask on the terminal itself
+	tty, err := os.Open("/dev/tty")
+	exitOnError(err)
+	defer tty.Close()
+	fmt.Fprintf(os.Stderr, "Message to %s: ", name)
+	text, _ := bufio.NewReader(tty).ReadString('\n')
+	text = strings.TrimSpace(text)
+	if text == "" {
+		fmt.Fprintln(os.Stderr, "Nothing sent.")
+		return
+	}
+
+	data, err := d.request(SocketCommand{Action: "send", ChatJID: jid, Text: text}, nil)
+	exitOnError(err)
+	var result SendResult
+	json.Unmarshal(data, &result)
+	fmt.Println(result.MessageID)
+	if result.Status == "queued" {
+		fmt.Fprintln(os.Stderr, "WhatsApp is disconnected, the message is queued until the daemon reconnects")
+	}
+}
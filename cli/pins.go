@@ -0,0 +1,64 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+type PinUpdate struct {
+	ChatJID   string `json:"chat_jid"`
+	MessageID string `json:"message_id"`
+	PinnedBy  string `json:"pinned_by"`
+	Pinned    bool   `json:"pinned"`
+	Timestamp int64  `json:"timestamp"`
+	Text      string `json:"text"`
+}
+
+// handlePin flags the stored message as pinned or unpinned and broadcasts
+// the change, including the pinned text when the message is in the archive.
+func (a *App) handlePin(msg *events.Message, pin *waE2E.PinInChatMessage) {
+	update := PinUpdate{
+		ChatJID:   msg.Info.Chat.String(),
+		MessageID: pin.GetKey().GetID(),
+		PinnedBy:  msg.Info.Sender.ToNonAD().String(),
+		Pinned:    pin.GetType() == waE2E.PinInChatMessage_PIN_FOR_ALL,
+		Timestamp: msg.Info.Timestamp.Unix(),
+	}
+
+	pinnedAt := int64(0)
+	if update.Pinned {
+		pinnedAt = update.Timestamp
+	}
+	_, err := a.updateMessageTables(
+		"UPDATE %s SET is_pinned = ?, pinned_at = ? WHERE chat_jid = ? AND message_id = ?",
+		update.Pinned, pinnedAt, update.ChatJID, update.MessageID,
+	)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to flag pinned message: %v\n", err)
+	}
+
+	err = a.msgDB.QueryRow(fmt.Sprintf(
+		"SELECT text FROM %s WHERE chat_jid = ? AND message_id = ? LIMIT 1", messagesView,
+	), update.ChatJID, update.MessageID).Scan(&update.Text)
+	if err != nil && err != sql.ErrNoRows {
+		fmt.Fprintf(os.Stderr, "Failed to load pinned message: %v\n", err)
+	}
+
+	a.broadcastEvent("pin", update)
+	if msg.Info.IsGroup {
+		a.emitGroupEvent("pin", msg.Info.Chat, update)
+	}
+}
+
+// streamPinned lists the pinned messages of a chat in the history format.
+func (a *App) streamPinned(client *socketClient, cmd *SocketCommand) {
+	if cmd.ChatJID == "" {
+		client.respondError(cmd, fmt.Errorf("chat_jid is required"))
+		return
+	}
+	a.streamMessages(client, cmd, []string{"chat_jid = ?", "is_pinned = 1"}, []interface{}{cmd.ChatJID})
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	pluginRestartDelay = 10 * time.Second
+	pluginStopTimeout  = 5 * time.Second
+	pluginQueueSize    = 256
+)
+
+// Plugins are the executables in PLUGIN_DIR. Each runs as a socket client
+// over its stdin and stdout: it reads the hello and every event as JSON
+// lines and writes commands in the socket schema, answered on stdin. A
+// plugin can only send, reply and react, gets none of the daemon's
+// environment, and is restarted when it exits.
+
+// pluginActions are the only actions a plugin may use.
+var pluginActions = []string{"send", "reply", "react"}
+
+// pluginEnv are the variables plugins and bot commands inherit; secrets
+// like the DB key and bot tokens stay with the daemon.
+var pluginEnv = []string{"PATH", "HOME", "LANG", "LC_ALL", "TZ", "TMPDIR"}
+
+var errPluginBacklog = errors.New("plugin is not reading its input, event dropped")
+
+type plugin struct {
+	name string
+	path string
+	stop chan struct{}
+	done chan struct{}
+
+	mu  sync.Mutex
+	cmd *exec.Cmd
+}
+
+// startPlugins starts every executable in PLUGIN_DIR. Hidden files and
+// files without an execute bit are skipped, so a plugin can be disabled
+// with chmod -x.
+func (a *App) startPlugins() error {
+	dir := a.cfg().PluginDir
+	if dir == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		info, err := os.Stat(path)
+		if strings.HasPrefix(entry.Name(), ".") || err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+			continue
+		}
+		p := &plugin{name: entry.Name(), path: path, stop: make(chan struct{}), done: make(chan struct{})}
+		a.plugins = append(a.plugins, p)
+		a.goSafe("plugin "+p.name, func() { a.supervisePlugin(p) })
+	}
+	return nil
+}
+
+// supervisePlugin runs a plugin until the daemon stops, restarting it
+// after a delay whenever it exits.
+func (a *App) supervisePlugin(p *plugin) {
+	defer close(p.done)
+	for {
+		if err := a.runPlugin(p); err != nil {
+			fmt.Fprintf(os.Stderr, "Plugin %s: %v\n", p.name, err)
+		}
+		select {
+		case <-p.stop:
+			return
+		case <-time.After(pluginRestartDelay):
+		}
+		fmt.Fprintf(os.Stderr, "Restarting plugin %s\n", p.name)
+	}
+}
+
+func (a *App) runPlugin(p *plugin) error {
+	cmd := exec.Command(p.path)
+	cmd.Dir = filepath.Dir(p.path)
+	cmd.Env = []string{"WACLI_PLUGIN=" + p.name}
+	for _, key := range pluginEnv {
+		if value, ok := os.LookupEnv(key); ok {
+			cmd.Env = append(cmd.Env, key+"="+value)
+		}
+	}
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	select {
+	case <-p.stop:
+		p.mu.Unlock()
+		return nil
+	default:
+	}
+	if err := cmd.Start(); err != nil {
+		p.mu.Unlock()
+		return err
+	}
+	p.cmd = cmd
+	p.mu.Unlock()
+
+	conn := newPluginConn(p.name, stdin)
+	client := &socketClient{conn: conn}
+	client.token.Store(&APIToken{Name: "plugin:" + p.name, Actions: pluginActions})
+	a.connMu.Lock()
+	a.socketConns[client] = struct{}{}
+	a.connMu.Unlock()
+	client.send(SocketEvent{Type: "hello", Data: a.versionInfo()})
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var command SocketCommand
+		if err := json.Unmarshal(scanner.Bytes(), &command); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to parse command from plugin %s: %v\n", p.name, err)
+			continue
+		}
+		// authorize lets local clients do anything while no tokens are
+		// configured, so check the plugin's own token here
+		if err := client.token.Load().allows(command.Action, command.ChatJID); err != nil {
+			client.respondError(&command, err)
+			continue
+		}
+		// Plugins are bots, so the kill switch stops them too
+		if err := a.automationAllowed("plugin " + p.name); err != nil {
+			client.respondError(&command, err)
+			continue
+		}
+		a.handleSocketCommand(client, &command)
+	}
+
+	a.connMu.Lock()
+	delete(a.socketConns, client)
+	a.connMu.Unlock()
+	conn.Close()
+	if err := cmd.Wait(); err != nil {
+		return err
+	}
+	return errors.New("exited")
+}
+
+// stopPlugins waits for the plugins to exit, which they should do when
+// closeSocketConns closes their stdin, and kills those that don't.
+func (a *App) stopPlugins() {
+	deadline := time.After(pluginStopTimeout)
+	for _, p := range a.plugins {
+		p.mu.Lock()
+		close(p.stop)
+		p.mu.Unlock()
+	}
+	for _, p := range a.plugins {
+		select {
+		case <-p.done:
+			continue
+		case <-deadline:
+		}
+		p.mu.Lock()
+		if p.cmd != nil && p.cmd.Process != nil {
+			fmt.Fprintf(os.Stderr, "Killing plugin %s\n", p.name)
+			p.cmd.Process.Kill()
+		}
+		p.mu.Unlock()
+	}
+}
+
+// pluginConn lets a plugin's stdin stand in for a socket connection.
+// Writes are queued and dropped when the plugin stops reading, so a stuck
+// plugin never holds up broadcasts.
+type pluginConn struct {
+	name  string
+	stdin io.WriteCloser
+	queue chan []byte
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newPluginConn(name string, stdin io.WriteCloser) *pluginConn {
+	c := &pluginConn{name: name, stdin: stdin, queue: make(chan []byte, pluginQueueSize)}
+	go c.run()
+	return c
+}
+
+func (c *pluginConn) run() {
+	for data := range c.queue {
+		// A plugin that exited fails every write; keep draining
+		c.stdin.Write(data)
+	}
+	c.stdin.Close()
+}
+
+func (c *pluginConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	select {
+	case c.queue <- append([]byte(nil), b...):
+		return len(b), nil
+	default:
+		return 0, errPluginBacklog
+	}
+}
+
+func (c *pluginConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.queue)
+	}
+	return nil
+}
+
+func (c *pluginConn) Read([]byte) (int, error)         { return 0, io.EOF }
+func (c *pluginConn) LocalAddr() net.Addr              { return pluginAddr(c.name) }
+func (c *pluginConn) RemoteAddr() net.Addr             { return pluginAddr(c.name) }
+func (c *pluginConn) SetDeadline(time.Time) error      { return nil }
+func (c *pluginConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *pluginConn) SetWriteDeadline(time.Time) error { return nil }
+
+type pluginAddr string
+
+func (a pluginAddr) Network() string { return "plugin" }
+func (a pluginAddr) String() string  { return string(a) }
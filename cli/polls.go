@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+type PollOptionResult struct {
+	Name   string   `json:"name"`
+	Votes  int      `json:"votes"`
+	Voters []string `json:"voters"`
+}
+
+type PollResult struct {
+	PollID      string             `json:"poll_id"`
+	ChatJID     string             `json:"chat_jid"`
+	Question    string             `json:"question"`
+	MultiSelect bool               `json:"multi_select"`
+	Options     []PollOptionResult `json:"options"`
+}
+
+func getPollCreation(msg *waE2E.Message) *waE2E.PollCreationMessage {
+	if msg == nil {
+		return nil
+	}
+	if poll := msg.GetPollCreationMessage(); poll != nil {
+		return poll
+	}
+	if poll := msg.GetPollCreationMessageV2(); poll != nil {
+		return poll
+	}
+	if poll := msg.GetPollCreationMessageV3(); poll != nil {
+		return poll
+	}
+	return msg.GetPollCreationMessageV5()
+}
+
+func (a *App) sendPoll(chatJID string, question string, options []string, multiSelect bool, priority sendPriority) (types.MessageID, error) {
+	jid, err := types.ParseJID(chatJID)
+	if err != nil {
+		return "", fmt.Errorf("invalid JID: %w", err)
+	}
+	if len(options) < 2 {
+		return "", fmt.Errorf("poll needs at least two options")
+	}
+
+	selectable := 1
+	if multiSelect {
+		selectable = 0
+	}
+	msg := a.client.BuildPollCreation(question, options, selectable)
+
+	resp, err := a.sendToChat(jid, msg, priority)
+	if err != nil {
+		return "", fmt.Errorf("send poll failed: %w", err)
+	}
+
+	if err := a.savePoll(resp.ID, jid, *a.client.Store.ID, question, options, multiSelect, resp.Timestamp.Unix()); err != nil {
+		return "", fmt.Errorf("save poll failed: %w", err)
+	}
+
+	fmt.Printf("Sent poll to %s\n", chatJID)
+	return resp.ID, nil
+}
+
+func (a *App) handlePollCreation(msg *events.Message, poll *waE2E.PollCreationMessage) {
+	options := make([]string, len(poll.GetOptions()))
+	for i, opt := range poll.GetOptions() {
+		options[i] = opt.GetOptionName()
+	}
+	multiSelect := poll.GetSelectableOptionsCount() != 1
+
+	err := a.savePoll(msg.Info.ID, msg.Info.Chat, msg.Info.Sender, poll.GetName(), options, multiSelect, msg.Info.Timestamp.Unix())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save poll: %v\n", err)
+	}
+}
+
+func (a *App) savePoll(pollID string, chat types.JID, sender types.JID, question string, options []string, multiSelect bool, timestamp int64) error {
+	optionsJSON, err := json.Marshal(options)
+	if err != nil {
+		return err
+	}
+	_, err = a.msgDB.Exec(`
+		INSERT OR REPLACE INTO polls (poll_id, timestamp, chat_jid, sender_jid, question, options, multi_select)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, pollID, timestamp, chat.String(), sender.ToNonAD().String(), question, string(optionsJSON), multiSelect)
+	return err
+}
+
+func (a *App) handlePollVote(msg *events.Message) {
+	vote, err := a.client.DecryptPollVote(a.ctx, msg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to decrypt poll vote: %v\n", err)
+		return
+	}
+
+	pollID := msg.Message.GetPollUpdateMessage().GetPollCreationMessageKey().GetID()
+	options, err := a.loadPollOptions(pollID)
+	if err == sql.ErrNoRows {
+		fmt.Fprintf(os.Stderr, "Poll vote for unknown poll %s\n", pollID)
+		return
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load poll: %v\n", err)
+		return
+	}
+
+	hashes := whatsmeow.HashPollOptions(options)
+	selected := []string{}
+	for _, selectedHash := range vote.GetSelectedOptions() {
+		for i, hash := range hashes {
+			if bytes.Equal(hash, selectedHash) {
+				selected = append(selected, options[i])
+				break
+			}
+		}
+	}
+	selectedJSON, _ := json.Marshal(selected)
+
+	// A new vote replaces the voter's previous selection; an empty selection retracts it
+	_, err = a.msgDB.Exec(`
+		INSERT OR REPLACE INTO poll_votes (poll_id, voter_jid, timestamp, options)
+		VALUES (?, ?, ?, ?)
+	`, pollID, msg.Info.Sender.ToNonAD().String(), msg.Info.Timestamp.Unix(), string(selectedJSON))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save poll vote: %v\n", err)
+		return
+	}
+
+	result, err := a.getPollResult(pollID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to aggregate poll: %v\n", err)
+		return
+	}
+	a.broadcastEvent("poll_update", result)
+	if msg.Info.IsGroup {
+		a.emitGroupEvent("poll_update", msg.Info.Chat, result)
+	}
+}
+
+func (a *App) loadPollOptions(pollID string) ([]string, error) {
+	var optionsJSON string
+	err := a.msgDB.QueryRow("SELECT options FROM polls WHERE poll_id = ?", pollID).Scan(&optionsJSON)
+	if err != nil {
+		return nil, err
+	}
+	var options []string
+	err = json.Unmarshal([]byte(optionsJSON), &options)
+	return options, err
+}
+
+func (a *App) getPollResult(pollID string) (*PollResult, error) {
+	result := &PollResult{PollID: pollID}
+	var optionsJSON string
+	err := a.msgDB.QueryRow(
+		"SELECT chat_jid, question, options, multi_select FROM polls WHERE poll_id = ?", pollID,
+	).Scan(&result.ChatJID, &result.Question, &optionsJSON, &result.MultiSelect)
+	if err != nil {
+		return nil, err
+	}
+
+	var options []string
+	if err := json.Unmarshal([]byte(optionsJSON), &options); err != nil {
+		return nil, err
+	}
+	index := make(map[string]int, len(options))
+	for i, name := range options {
+		result.Options = append(result.Options, PollOptionResult{Name: name, Voters: []string{}})
+		index[name] = i
+	}
+
+	rows, err := a.msgDB.Query("SELECT voter_jid, options FROM poll_votes WHERE poll_id = ?", pollID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var voter, selectedJSON string
+		if err := rows.Scan(&voter, &selectedJSON); err != nil {
+			return nil, err
+		}
+		var selected []string
+		if err := json.Unmarshal([]byte(selectedJSON), &selected); err != nil {
+			continue
+		}
+		for _, name := range selected {
+			if i, ok := index[name]; ok {
+				result.Options[i].Votes++
+				result.Options[i].Voters = append(result.Options[i].Voters, voter)
+			}
+		}
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+const (
+	presenceModeAvailable   = "available"
+	presenceModeUnavailable = "unavailable"
+	presenceModeIdle        = "idle"
+
+	presencePollInterval = 30 * time.Second
+)
+
+// applyPresence sends the presence configured by PRESENCE_MODE. It runs on
+// every connect since the server forgets presence when the socket drops.
+func (a *App) applyPresence() {
+	switch a.cfg().PresenceMode {
+	case presenceModeAvailable:
+		a.setPresence(types.PresenceAvailable)
+	case presenceModeUnavailable:
+		a.setPresence(types.PresenceUnavailable)
+	case presenceModeIdle:
+		a.setPresence(a.desktopPresence())
+	}
+}
+
+func (a *App) setPresence(presence types.Presence) {
+	a.presenceMu.Lock()
+	defer a.presenceMu.Unlock()
+
+	if err := a.client.SendPresence(a.ctx, presence); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to send presence: %v\n", err)
+		return
+	}
+	a.presence = presence
+}
+
+// watchDesktopIdle mirrors the desktop idle state in idle mode, only
+// sending presence when it changes. It checks the mode on every tick, as a
+// reload can switch idle mode on or off.
+func (a *App) watchDesktopIdle() {
+	ticker := time.NewTicker(presencePollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if a.cfg().PresenceMode != presenceModeIdle || !a.client.IsConnected() {
+			continue
+		}
+		presence := a.desktopPresence()
+
+		a.presenceMu.Lock()
+		changed := presence != a.presence
+		a.presenceMu.Unlock()
+
+		if changed {
+			a.setPresence(presence)
+		}
+	}
+}
+
+// desktopPresence runs PRESENCE_IDLE_CMD, which must print the idle time
+// in milliseconds (as xprintidle does). Unknown idle state counts as away.
+func (a *App) desktopPresence() types.Presence {
+	fields := strings.Fields(a.cfg().PresenceIdleCmd)
+	if len(fields) == 0 {
+		return types.PresenceUnavailable
+	}
+	out, err := exec.Command(fields[0], fields[1:]...).Output()
+	if err != nil {
+		return types.PresenceUnavailable
+	}
+	idleMs, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return types.PresenceUnavailable
+	}
+	if time.Duration(idleMs)*time.Millisecond >= a.cfg().PresenceIdleAfter {
+		return types.PresenceUnavailable
+	}
+	return types.PresenceAvailable
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mau.fi/whatsmeow/appstate"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Profile is your own account as others see it.
+type Profile struct {
+	JID      string `json:"jid"`
+	PushName string `json:"push_name"`
+	About    string `json:"about"`
+}
+
+func (a *App) getProfile() (*Profile, error) {
+	if a.client.Store.ID == nil {
+		return nil, fmt.Errorf("not logged in")
+	}
+	jid := a.client.Store.ID.ToNonAD()
+	profile := &Profile{JID: jid.String(), PushName: a.client.Store.PushName}
+
+	info, err := a.client.GetUserInfo(a.ctx, []types.JID{jid})
+	if err != nil {
+		return nil, fmt.Errorf("get about failed: %w", err)
+	}
+	profile.About = info[jid].Status
+	return profile, nil
+}
+
+// setName changes your push name through app state, so your phone shows
+// the new name as well.
+func (a *App) setName(name string) (*Profile, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("set_name requires name")
+	}
+	if err := a.client.SendAppState(a.ctx, appstate.BuildSettingPushName(name)); err != nil {
+		return nil, fmt.Errorf("set name failed: %w", err)
+	}
+	// The patch only comes back on the next app state sync
+	a.client.Store.PushName = name
+	if err := a.client.Store.Save(a.ctx); err != nil {
+		return nil, err
+	}
+	return a.getProfile()
+}
+
+func (a *App) setAbout(about string) (*Profile, error) {
+	if err := a.client.SetStatusMessage(a.ctx, about); err != nil {
+		return nil, fmt.Errorf("set about failed: %w", err)
+	}
+	return a.getProfile()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultNATSSubject  = "wacli"
+	defaultRedisChannel = "wacli"
+	redisPublishTimeout = 10 * time.Second
+)
+
+// natsSink publishes each event's data as JSON to <subject>.<event>. Chat
+// JIDs contain dots, so unlike MQTT topics subjects don't name the chat.
+type natsSink struct {
+	conn    *nats.Conn
+	subject string
+}
+
+// newNATSSink connects to NATS_URL. Like the MQTT sink it keeps retrying an
+// unreachable server in the background, buffering what is published.
+func newNATSSink(url string, subject string) (*natsSink, error) {
+	if subject == "" {
+		subject = defaultNATSSubject
+	}
+	conn, err := nats.Connect(url,
+		nats.Name("wacli"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &natsSink{conn: conn, subject: subject}, nil
+}
+
+func (s *natsSink) Emit(eventType string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return s.conn.Publish(s.subject+"."+eventType, data)
+}
+
+func (s *natsSink) Close() error {
+	return s.conn.Drain()
+}
+
+// redisSink publishes each event's data as JSON to the Pub/Sub channel
+// <channel>:<event>.
+type redisSink struct {
+	client  *redis.Client
+	channel string
+}
+
+func newRedisSink(url string, channel string) (*redisSink, error) {
+	if channel == "" {
+		channel = defaultRedisChannel
+	}
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	return &redisSink{client: redis.NewClient(opts), channel: channel}, nil
+}
+
+func (s *redisSink) Emit(eventType string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisPublishTimeout)
+	defer cancel()
+	return s.client.Publish(ctx, s.channel+":"+eventType, data).Err()
+}
+
+func (s *redisSink) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// sendReaction reacts to a message; an empty reaction removes yours.
+func (a *App) sendReaction(chatJID string, messageID string, senderJID string, reaction string, priority sendPriority) (types.MessageID, error) {
+	chat, err := types.ParseJID(chatJID)
+	if err != nil {
+		return "", fmt.Errorf("invalid chat JID: %w", err)
+	}
+	sender, err := types.ParseJID(senderJID)
+	if err != nil {
+		return "", fmt.Errorf("invalid sender JID: %w", err)
+	}
+
+	resp, err := a.sendToChat(chat, a.client.BuildReaction(chat, sender, messageID, reaction), priority)
+	if err != nil {
+		return "", fmt.Errorf("react failed: %w", err)
+	}
+
+	fmt.Printf("Reacted to message %s in %s\n", messageID, chatJID)
+	return resp.ID, nil
+}
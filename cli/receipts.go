@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+type ReadResult struct {
+	Sent    bool `json:"sent"`
+	Stealth bool `json:"stealth"`
+}
+
+// parseJIDSet parses a comma separated list of JIDs.
+func parseJIDSet(value string) map[string]bool {
+	set := make(map[string]bool)
+	for _, jid := range strings.Split(value, ",") {
+		if jid = strings.TrimSpace(jid); jid != "" {
+			set[jid] = true
+		}
+	}
+	return set
+}
+
+// markRead sends read receipts for messages a client has shown, unless the
+// chat is in STEALTH_READ_CHATS. Sender is required for group messages.
+func (a *App) markRead(chatJID string, senderJID string, messageIDs []string) (ReadResult, error) {
+	if a.cfg().StealthReadChats[chatJID] {
+		return ReadResult{Stealth: true}, nil
+	}
+	if len(messageIDs) == 0 {
+		return ReadResult{}, fmt.Errorf("no message IDs given")
+	}
+
+	chat, err := types.ParseJID(chatJID)
+	if err != nil {
+		return ReadResult{}, fmt.Errorf("invalid chat JID: %w", err)
+	}
+	var sender types.JID
+	if senderJID != "" {
+		sender, err = types.ParseJID(senderJID)
+		if err != nil {
+			return ReadResult{}, fmt.Errorf("invalid sender JID: %w", err)
+		}
+	}
+
+	ids := make([]types.MessageID, len(messageIDs))
+	for i, id := range messageIDs {
+		ids[i] = types.MessageID(id)
+	}
+	if err := a.client.MarkRead(a.ctx, ids, time.Now(), chat, sender); err != nil {
+		return ReadResult{}, fmt.Errorf("mark read failed: %w", err)
+	}
+	return ReadResult{Sent: true}, nil
+}
+
+// receiptStatuses maps receipt types to outgoing message statuses, in the
+// order a message moves through them.
+var receiptStatuses = map[types.ReceiptType]string{
+	types.ReceiptTypeDelivered: "delivered",
+	types.ReceiptTypeRead:      "read",
+	types.ReceiptTypePlayed:    "played",
+}
+
+type ReceiptUpdate struct {
+	ChatJID    string   `json:"chat_jid"`
+	SenderJID  string   `json:"sender_jid"`
+	MessageIDs []string `json:"message_ids"`
+	Status     string   `json:"status"`
+	Timestamp  int64    `json:"timestamp"`
+}
+
+// handleReceipt advances the status of your outgoing messages. Statuses
+// never go backwards, so a late delivery receipt does not undo a read.
+func (a *App) handleReceipt(evt *events.Receipt) {
+	if evt.IsFromMe {
+		return
+	}
+	if evt.Chat == types.StatusBroadcastJID && (evt.Type == types.ReceiptTypeRead || evt.Type == types.ReceiptTypePlayed) {
+		a.recordStatusViews(evt)
+	}
+	status, ok := receiptStatuses[evt.Type]
+	if !ok {
+		return
+	}
+
+	update := ReceiptUpdate{
+		ChatJID:   evt.Chat.String(),
+		SenderJID: evt.Sender.ToNonAD().String(),
+		Status:    status,
+		Timestamp: evt.Timestamp.Unix(),
+	}
+	for _, id := range evt.MessageIDs {
+		res, err := a.msgDB.Exec(`
+			UPDATE outgoing_messages SET status = ?, status_at = ?
+			WHERE message_id = ? AND `+statusRank("status")+` < `+statusRank("?"),
+			status, update.Timestamp, id, status)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to update message status: %v\n", err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			update.MessageIDs = append(update.MessageIDs, id)
+		}
+	}
+
+	if len(update.MessageIDs) > 0 {
+		a.broadcastEvent("receipt", update)
+	}
+}
+
+func statusRank(expr string) string {
+	return "(CASE " + expr + " WHEN 'sent' THEN 1 WHEN 'delivered' THEN 2 WHEN 'read' THEN 3 WHEN 'played' THEN 4 ELSE 0 END)"
+}
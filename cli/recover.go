@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+)
+
+type InternalError struct {
+	Timestamp int64  `json:"timestamp"`
+	Context   string `json:"context"`
+	Error     string `json:"error"`
+}
+
+// recoverPanic must be deferred directly. It keeps a panic in one handler
+// from taking down the daemon: the stack is logged, clients receive an
+// internal_error event and a crash report is written if configured.
+func (a *App) recoverPanic(context string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	stack := debug.Stack()
+	fmt.Fprintf(os.Stderr, "Recovered panic in %s: %v\n%s", context, r, stack)
+
+	report := InternalError{
+		Timestamp: time.Now().Unix(),
+		Context:   context,
+		Error:     fmt.Sprint(r),
+	}
+	a.broadcastEvent("internal_error", report)
+
+	if a.cfg().CrashReportDir != "" {
+		if err := writeCrashReport(a.cfg().CrashReportDir, report, stack); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write crash report: %v\n", err)
+		}
+	}
+}
+
+// goSafe runs fn in its own goroutine under recoverPanic, so a panic in
+// background work is reported instead of taking down the daemon.
+func (a *App) goSafe(context string, fn func()) {
+	go func() {
+		defer a.recoverPanic(context)
+		fn()
+	}()
+}
+
+func writeCrashReport(dir string, report InternalError, stack []byte) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("crash-%s.txt", time.Unix(report.Timestamp, 0).Format("20060102-150405"))
+	content := fmt.Sprintf("time: %s\ncontext: %s\npanic: %s\n\n%s",
+		time.Unix(report.Timestamp, 0).Format(time.RFC3339), report.Context, report.Error, stack)
+	return os.WriteFile(filepath.Join(dir, name), []byte(content), 0600)
+}
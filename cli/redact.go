@@ -0,0 +1,98 @@
+package main
+
+import "reflect"
+
+// redactable payloads can strip their conversation content, leaving the
+// metadata monitoring clients need (who, where, when, how many).
+type redactable interface {
+	redacted() interface{}
+}
+
+func (c *socketClient) isRedacted() bool {
+	token := c.token.Load()
+	return c.redacted.Load() || (token != nil && token.Redacted)
+}
+
+// redactData returns the content-free version of a payload, including each
+// element of a slice payload.
+func redactData(data interface{}) interface{} {
+	if r, ok := data.(redactable); ok {
+		return r.redacted()
+	}
+
+	v := reflect.ValueOf(data)
+	if v.Kind() != reflect.Slice {
+		return data
+	}
+	out := make([]interface{}, v.Len())
+	for i := range out {
+		out[i] = redactData(v.Index(i).Interface())
+	}
+	return out
+}
+
+func (m Message) redacted() interface{} {
+	m.Text = ""
+	m.QuotedText = ""
+	m.Latitude = nil
+	m.Longitude = nil
+	m.LocationName = ""
+	m.LocationAddress = ""
+	m.Contacts = nil
+	m.Extra = nil
+	m.FileName = ""
+	m.MediaPath = ""
+	return m
+}
+
+func (c ChatSummary) redacted() interface{} {
+	c.LastText = ""
+	return c
+}
+
+func (c UnrepliedChat) redacted() interface{} {
+	c.LastText = ""
+	return c
+}
+
+func (r Reminder) redacted() interface{} {
+	r.Text = ""
+	return r
+}
+
+func (p PinUpdate) redacted() interface{} {
+	p.Text = ""
+	return p
+}
+
+func (p PollResult) redacted() interface{} {
+	p.Question = ""
+	options := make([]PollOptionResult, len(p.Options))
+	for i, opt := range p.Options {
+		opt.Name = ""
+		options[i] = opt
+	}
+	p.Options = options
+	return p
+}
+
+func (o OpenChat) redacted() interface{} {
+	o.Text = ""
+	return o
+}
+
+func (s SenderInfo) redacted() interface{} {
+	s.Notes = ""
+	s.Dates = nil
+	return s
+}
+
+func (p StatusPost) redacted() interface{} {
+	p.Text = ""
+	return p
+}
+
+// QR codes are login credentials, not just content
+func (q QRCode) redacted() interface{} {
+	return QRCode{}
+}
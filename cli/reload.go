@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const reloadCheckInterval = 5 * time.Second
+
+// settingsFileNames are looked up next to config.env, the first one found
+// wins.
+var settingsFileNames = []string{"wacli.yaml", "wacli.yml", "wacli.toml"}
+
+// restartSettings are opened once at startup (listeners, databases, paths
+// and the send queue), so a reload keeps their old values.
+var restartSettings = []string{
+	"DataDir", "SocketPath", "MediaDir", "SocketMode", "SocketGroup",
+	"ListenTLS", "TLSCert", "TLSKey", "TLSClientCA",
+	"DBKey", "DBKeyCommand", "DBDSN", "PartitionByMonth", "SlowQueryBudget",
+	"SendInterval", "SendBurst", "SendJitter", "TranscriptDir", "TranscriptFormat",
+	"EventFile", "EventWebhookURL", "MQTTURL", "MQTTTopic", "NATSURL", "NATSSubject",
+	"RedisURL", "RedisChannel", "SinkEvents", "TelegramBotToken", "TelegramBridge",
+	"IRCListen", "IRCPassword", "PluginDir", "ScriptsDir",
+}
+
+// startEnv is the environment the process was started with, before any
+// config file was loaded. Reloads go back to it first, so a setting
+// removed from a file doesn't linger.
+var startEnv = os.Environ()
+
+// settingsFilePath is WACLI_SETTINGS, or the first settings file next to
+// config.env. Empty when there is none.
+func settingsFilePath() string {
+	if path := os.Getenv("WACLI_SETTINGS"); path != "" {
+		return path
+	}
+	dir := filepath.Dir(configFilePath())
+	for _, name := range settingsFileNames {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// loadSettingsFile reads a YAML or TOML file whose keys are the names of
+// the environment variables, in any case, and sets those not already set
+// to a value. Lists are joined with commas and maps become key=value
+// lists, the form the variables take.
+func loadSettingsFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var settings map[string]interface{}
+	if strings.HasSuffix(path, ".toml") {
+		err = toml.Unmarshal(data, &settings)
+	} else {
+		err = yaml.Unmarshal(data, &settings)
+	}
+	if err != nil {
+		return err
+	}
+
+	for key, value := range settings {
+		name := strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+		// Empty counts as unset, as with envOr, so a copied .env.example
+		// doesn't shadow the file
+		if os.Getenv(name) != "" {
+			continue
+		}
+		os.Setenv(name, settingValue(value))
+	}
+	return nil
+}
+
+func settingValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = settingValue(item)
+		}
+		return strings.Join(items, ",")
+	case map[string]interface{}:
+		items := make([]string, 0, len(v))
+		for key, item := range v {
+			items = append(items, key+"="+settingValue(item))
+		}
+		sort.Strings(items)
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// restoreEnv undoes what the config files set, leaving variables the
+// process was started with alone.
+func restoreEnv() {
+	started := make(map[string]string, len(startEnv))
+	for _, kv := range startEnv {
+		key, value, _ := strings.Cut(kv, "=")
+		started[key] = value
+	}
+	for _, kv := range os.Environ() {
+		key, value, _ := strings.Cut(kv, "=")
+		if original, ok := started[key]; !ok {
+			os.Unsetenv(key)
+		} else if value != original {
+			os.Setenv(key, original)
+		}
+	}
+}
+
+// cfg returns the current configuration, which a reload may replace at
+// any time.
+func (a *App) cfg() Config {
+	a.reloadMu.RLock()
+	defer a.reloadMu.RUnlock()
+	return a.config
+}
+
+func (a *App) currentRules() []Rule {
+	a.reloadMu.RLock()
+	defer a.reloadMu.RUnlock()
+	return a.rules
+}
+
+func (a *App) currentWebhooks() []GroupWebhook {
+	a.reloadMu.RLock()
+	defer a.reloadMu.RUnlock()
+	return a.webhooks
+}
+
+func (a *App) currentTokens() []APIToken {
+	a.reloadMu.RLock()
+	defer a.reloadMu.RUnlock()
+	return a.tokens
+}
+
+func (a *App) currentExports() []ExportSchedule {
+	a.reloadMu.RLock()
+	defer a.reloadMu.RUnlock()
+	return a.exports
+}
+
+func (a *App) currentBot() *BotConfig {
+	a.reloadMu.RLock()
+	defer a.reloadMu.RUnlock()
+	return a.bot
+}
+
+func (a *App) currentNotifier() Notifier {
+	a.reloadMu.RLock()
+	defer a.reloadMu.RUnlock()
+	return a.notifier
+}
+
+func (a *App) currentAttention() AttentionNotifier {
+	a.reloadMu.RLock()
+	defer a.reloadMu.RUnlock()
+	return a.attention
+}
+
+// reloadConfig rereads the environment and settings files along with the
+// rules, webhooks, tokens, export and bot files, and swaps them in without
+// touching the WhatsApp session or socket clients. Nothing changes if any
+// of them fails to load.
+func (a *App) reloadConfig() error {
+	restoreEnv()
+	config, err := loadConfig(a.cfg().flags)
+	if err != nil {
+		return err
+	}
+
+	old := a.cfg()
+	oldValue := reflect.ValueOf(old)
+	newValue := reflect.ValueOf(&config).Elem()
+	var pending []string
+	for _, name := range restartSettings {
+		if !reflect.DeepEqual(oldValue.FieldByName(name).Interface(), newValue.FieldByName(name).Interface()) {
+			pending = append(pending, name)
+		}
+		newValue.FieldByName(name).Set(oldValue.FieldByName(name))
+	}
+
+	rules, err := loadRules(config)
+	if err != nil {
+		return fmt.Errorf("load rules: %w", err)
+	}
+	webhooks, err := loadWebhooks(config.WebhooksFile)
+	if err != nil {
+		return fmt.Errorf("load webhooks: %w", err)
+	}
+	tokens, err := loadTokens(config.TokensFile)
+	if err != nil {
+		return fmt.Errorf("load API tokens: %w", err)
+	}
+	if config.SocketToken != "" {
+		tokens = append(tokens, APIToken{Name: sharedTokenName, Token: config.SocketToken})
+	}
+	exports, err := loadExportSchedules(config.ExportsFile)
+	if err != nil {
+		return fmt.Errorf("load export schedules: %w", err)
+	}
+	bot, err := loadBotConfig(config.BotFile)
+	if err != nil {
+		return fmt.Errorf("load bot commands: %w", err)
+	}
+
+	a.reloadMu.Lock()
+	a.config = config
+	a.rules = rules
+	a.webhooks = webhooks
+	a.tokens = tokens
+	a.exports = exports
+	a.bot = bot
+	a.notifier = newNotifier(config)
+	a.attention = newAttentionNotifier(config)
+	a.reloadMu.Unlock()
+
+	fmt.Println("Config reloaded.")
+	if config.PresenceMode != old.PresenceMode && a.client.IsConnected() {
+		a.goSafe("presence", a.applyPresence)
+	}
+	if len(pending) > 0 {
+		fmt.Fprintf(os.Stderr, "Restart to apply: %s\n", strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// watchReload reloads the config on SIGHUP and whenever one of the files
+// it is read from changes.
+func (a *App) watchReload() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(reloadCheckInterval)
+	defer ticker.Stop()
+
+	stamps := a.configFileStamps()
+	for {
+		select {
+		case <-hup:
+		case <-ticker.C:
+			current := a.configFileStamps()
+			if reflect.DeepEqual(current, stamps) {
+				continue
+			}
+		}
+		if err := a.reloadConfig(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to reload config: %v\n", err)
+		}
+		stamps = a.configFileStamps()
+	}
+}
+
+// configFileStamps records the modification time of every config file,
+// zero for those that don't exist.
+func (a *App) configFileStamps() map[string]time.Time {
+	config := a.cfg()
+	paths := []string{".env", configFilePath(), settingsFilePath(), config.RulesFile, config.WebhooksFile, config.TokensFile, config.ExportsFile, config.BotFile}
+	stamps := make(map[string]time.Time, len(paths))
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil {
+			stamps[path] = info.ModTime()
+		} else {
+			stamps[path] = time.Time{}
+		}
+	}
+	return stamps
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mdp/qrterminal/v3"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+const reloginRetryDelay = 30 * time.Second
+
+type LoggedOutInfo struct {
+	Reason string `json:"reason"`
+}
+
+type QRCode struct {
+	Code string `json:"code"`
+}
+
+type PairResult struct {
+	JID   string `json:"jid,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// handleLoggedOut keeps the daemon and its socket clients alive when the
+// device is unlinked and starts pairing a fresh device in the background.
+// whatsmeow deletes the old session itself.
+func (a *App) handleLoggedOut(evt *events.LoggedOut) {
+	fmt.Printf("Logged out from WhatsApp (%s), waiting for a new login\n", evt.Reason)
+	a.broadcastEvent("logged_out", LoggedOutInfo{Reason: evt.Reason.String()})
+	a.setConnectionState(ConnectionState{State: connStateLoggedOut, Reason: evt.Reason.String()})
+	sdNotify("STATUS=Logged out, waiting for a new login")
+	a.goSafe("relogin", a.relogin)
+}
+
+// relogin replaces the client with one on a new device and shows QR codes
+// until it is linked, retrying after timeouts and errors.
+func (a *App) relogin() {
+	if !a.reloginMu.TryLock() {
+		return
+	}
+	defer a.reloginMu.Unlock()
+
+	a.client.Disconnect()
+	for {
+		a.client = a.newClient(a.container.NewDevice())
+		err := a.pairWithQR()
+		if err == nil {
+			fmt.Println("Login successful")
+			a.broadcastEvent("logged_in", nil)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Re-login failed: %v\n", err)
+		a.client.Disconnect()
+		time.Sleep(reloginRetryDelay)
+	}
+}
+
+// pairWithQR prints each QR code and broadcasts it as a qr event so clients
+// without access to the daemon's output can render it, followed by
+// pair_success or pair_error.
+func (a *App) pairWithQR() error {
+	err := a.waitForQRScan()
+	a.broadcastPairResult(err)
+	return err
+}
+
+func (a *App) waitForQRScan() error {
+	qrChan, err := a.client.GetQRChannel(a.ctx)
+	if err != nil {
+		return err
+	}
+	if err := a.client.Connect(); err != nil {
+		return err
+	}
+
+	for evt := range qrChan {
+		switch evt.Event {
+		case "code":
+			fmt.Println("Scan this QR code to login:")
+			qrterminal.GenerateHalfBlock(evt.Code, qrterminal.L, os.Stdout)
+			a.broadcastEvent("qr", QRCode{Code: evt.Code})
+		case "success":
+			return nil
+		default:
+			if evt.Error != nil {
+				return evt.Error
+			}
+			return fmt.Errorf("login failed: %s", evt.Event)
+		}
+	}
+	return fmt.Errorf("QR channel closed")
+}
+
+func (a *App) broadcastPairResult(err error) {
+	if err != nil {
+		a.broadcastEvent("pair_error", PairResult{Error: err.Error()})
+		return
+	}
+	var result PairResult
+	if a.client.Store.ID != nil {
+		result.JID = a.client.Store.ID.ToNonAD().String()
+	}
+	a.broadcastEvent("pair_success", result)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+)
+
+const reminderCheckInterval = 30 * time.Second
+
+type Reminder struct {
+	ID         int64  `json:"id"`
+	ChatJID    string `json:"chat_jid"`
+	MessageID  string `json:"message_id"`
+	CreatedAt  int64  `json:"created_at"`
+	DueAt      int64  `json:"due_at"`
+	ChatName   string `json:"chat_name"`
+	SenderName string `json:"sender_name"`
+	Text       string `json:"text"`
+}
+
+// addReminder flags a message for follow-up. The reminder fires at the
+// deadline unless you write in the chat before then.
+func (a *App) addReminder(chatJID string, messageID string, remindIn string) (Reminder, error) {
+	delay, err := time.ParseDuration(remindIn)
+	if err != nil || delay <= 0 {
+		return Reminder{}, fmt.Errorf("invalid remind_in %q", remindIn)
+	}
+	if chatJID == "" {
+		return Reminder{}, fmt.Errorf("chat_jid is required")
+	}
+
+	now := time.Now()
+	reminder := Reminder{
+		ChatJID:   chatJID,
+		MessageID: messageID,
+		CreatedAt: now.Unix(),
+		DueAt:     now.Add(delay).Unix(),
+	}
+	res, err := a.msgDB.Exec(`
+		INSERT INTO reminders (chat_jid, message_id, created_at, due_at) VALUES (?, ?, ?, ?)
+	`, reminder.ChatJID, reminder.MessageID, reminder.CreatedAt, reminder.DueAt)
+	if err != nil {
+		return Reminder{}, err
+	}
+	reminder.ID, _ = res.LastInsertId()
+	return a.fillReminder(reminder), nil
+}
+
+func (a *App) listReminders() ([]Reminder, error) {
+	rows, err := a.msgDB.Query(`SELECT id, chat_jid, message_id, created_at, due_at FROM reminders ORDER BY due_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reminders := []Reminder{}
+	for rows.Next() {
+		var r Reminder
+		if err := rows.Scan(&r.ID, &r.ChatJID, &r.MessageID, &r.CreatedAt, &r.DueAt); err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range reminders {
+		reminders[i] = a.fillReminder(reminders[i])
+	}
+	return reminders, nil
+}
+
+// cancelReminder deletes a reminder. A tenant's chats limit it to their
+// reminders; others are reported missing like unknown ids.
+func (a *App) cancelReminder(id int64, chats []string) error {
+	query := `DELETE FROM reminders WHERE id = ?`
+	args := []interface{}{id}
+	if chats != nil {
+		condition, chatArgs := inChats(chats)
+		query += " AND " + condition
+		args = append(args, chatArgs...)
+	}
+	res, err := a.msgDB.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("no reminder %d", id)
+	}
+	return nil
+}
+
+// fillReminder adds the flagged message from the archive, when it is still
+// there, so clients can show what the reminder is about.
+func (a *App) fillReminder(r Reminder) Reminder {
+	query := fmt.Sprintf(`
+		SELECT chat_name, sender_name, text FROM %s
+		WHERE chat_jid = ? AND (message_id = ? OR ? = '')
+		ORDER BY timestamp DESC LIMIT 1
+	`, messagesView)
+	err := a.msgDB.QueryRow(query, r.ChatJID, r.MessageID, r.MessageID).Scan(&r.ChatName, &r.SenderName, &r.Text)
+	if err != nil && err != sql.ErrNoRows {
+		fmt.Fprintf(os.Stderr, "Failed to load reminder message: %v\n", err)
+	}
+	return r
+}
+
+func (a *App) watchReminders() {
+	ticker := time.NewTicker(reminderCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := a.fireDueReminders(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to check reminders: %v\n", err)
+		}
+	}
+}
+
+// fireDueReminders broadcasts reminder_due for reminders past their
+// deadline whose chat has not been written in since they were set, and
+// drops the rest silently.
+func (a *App) fireDueReminders() error {
+	rows, err := a.msgDB.Query(`
+		SELECT r.id, r.chat_jid, r.message_id, r.created_at, r.due_at,
+			COALESCE(c.last_outgoing, 0) > r.created_at AS answered
+		FROM reminders r
+		LEFT JOIN chat_activity c ON c.chat_jid = r.chat_jid
+		WHERE r.due_at <= ?
+	`, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+
+	var due []Reminder
+	var ids []int64
+	for rows.Next() {
+		var r Reminder
+		var answered bool
+		if err := rows.Scan(&r.ID, &r.ChatJID, &r.MessageID, &r.CreatedAt, &r.DueAt, &answered); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, r.ID)
+		if !answered {
+			due = append(due, r)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if _, err := a.msgDB.Exec(`DELETE FROM reminders WHERE id = ?`, id); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range due {
+		r = a.fillReminder(r)
+		a.broadcastEvent("reminder_due", r)
+		if a.currentNotifier() != nil {
+			title := "Reply to " + r.ChatJID
+			if r.ChatName != "" {
+				title = "Reply to " + r.ChatName
+			}
+			a.sendNotification(Notification{
+				Title:    title,
+				Body:     truncateRunes(r.Text, notificationBodyLength),
+				Urgency:  "normal",
+				Category: "im",
+			})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+const tlsHandshakeTimeout = 10 * time.Second
+
+// startTLSServer serves the socket protocol on WACLI_LISTEN_TLS for
+// clients on other machines. Clients prove themselves with a certificate
+// signed by WACLI_TLS_CLIENT_CA or by sending a token, so one of the two
+// must be configured: an open TCP port must never grant full access the
+// way the local socket does without tokens.
+func (a *App) startTLSServer() (net.Listener, error) {
+	if a.cfg().ListenTLS == "" {
+		return nil, nil
+	}
+	if a.cfg().TLSCert == "" || a.cfg().TLSKey == "" {
+		return nil, fmt.Errorf("WACLI_LISTEN_TLS needs WACLI_TLS_CERT and WACLI_TLS_KEY")
+	}
+	if a.cfg().TLSClientCA == "" && len(a.currentTokens()) == 0 {
+		return nil, fmt.Errorf("WACLI_LISTEN_TLS needs WACLI_TLS_CLIENT_CA or API tokens")
+	}
+
+	cert, err := tls.LoadX509KeyPair(a.cfg().TLSCert, a.cfg().TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("load TLS certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if a.cfg().TLSClientCA != "" {
+		pool, err := loadCertPool(a.cfg().TLSClientCA)
+		if err != nil {
+			return nil, err
+		}
+		// Clients without a certificate may still authenticate with a token
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	listener, err := tls.Listen("tcp", a.cfg().ListenTLS, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	a.goSafe("tls listener", func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			a.goSafe("remote client", func() { a.handleRemoteConn(conn.(*tls.Conn)) })
+		}
+	})
+	return listener, nil
+}
+
+// handleRemoteConn completes the TLS handshake and serves the client. A
+// verified client certificate authenticates it as the token named like the
+// certificate's common name, or with full access if the name is listed in
+// WACLI_TLS_ADMIN_CNS. Any other certificate is refused, so revoking a
+// restricted token never leaves its certificates with more access.
+func (a *App) handleRemoteConn(conn *tls.Conn) {
+	conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := conn.Handshake(); err != nil {
+		fmt.Fprintf(os.Stderr, "TLS handshake with %s failed: %v\n", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	client := &socketClient{conn: conn, remote: true}
+	if chains := conn.ConnectionState().VerifiedChains; len(chains) > 0 {
+		name := chains[0][0].Subject.CommonName
+		token := a.findTokenByName(name)
+		if token == nil && a.cfg().TLSAdminNames[name] {
+			token = &APIToken{Name: name}
+		}
+		if token == nil {
+			fmt.Fprintf(os.Stderr, "Refused client certificate %q from %s: no token or admin name matches it\n", name, conn.RemoteAddr())
+			conn.Close()
+			return
+		}
+		client.token.Store(token)
+	}
+	a.handleSocketConn(client)
+}
+
+func (a *App) findTokenByName(name string) *APIToken {
+	tokens := a.currentTokens()
+	for _, token := range tokens {
+		if token.Name == name {
+			return &token
+		}
+	}
+	return nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// dialRemote connects a thin client to a daemon's TLS listener at
+// WACLI_REMOTE, trusting WACLI_REMOTE_CA or the system roots and
+// presenting WACLI_REMOTE_CERT if set.
+func dialRemote(config Config) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(config.Remote)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WACLI_REMOTE %q: %w", config.Remote, err)
+	}
+	tlsConfig := &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	if config.RemoteCA != "" {
+		if tlsConfig.RootCAs, err = loadCertPool(config.RemoteCA); err != nil {
+			return nil, err
+		}
+	}
+	if config.RemoteCert != "" {
+		cert, err := tls.LoadX509KeyPair(config.RemoteCert, config.RemoteKey)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: tlsHandshakeTimeout}, "tcp", config.Remote, tlsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("daemon not reachable at %s: %w", config.Remote, err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/term"
+)
+
+const replHelp = `Commands:
+  chats                      list chats and their aliases
+  send <chat> <text>         send a message
+  history <chat> [n]         show the last n messages (default 20)
+  react <chat> <emoji>       react to the latest message in the chat
+  status                     show daemon status
+  help                       show this help
+  quit                       leave the repl
+<chat> is an alias from "chats", a JID or a phone number. Tab completes
+commands and aliases.`
+
+var replCommands = []string{"chats", "send", "history", "react", "status", "help", "quit"}
+
+type repl struct {
+	conn    *daemonConn
+	out     io.Writer
+	aliases map[string]string
+}
+
+func runREPL(config Config, args []string) {
+	fs := flag.NewFlagSet("repl", flag.ExitOnError)
+	fs.Parse(args)
+
+	d, err := dialDaemon(config)
+	exitOnError(err)
+	defer d.Close()
+
+	r := &repl{conn: d, out: os.Stdout, aliases: map[string]string{}}
+
+	var readLine func() (string, error)
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		state, err := term.MakeRaw(int(os.Stdin.Fd()))
+		exitOnError(err)
+		defer term.Restore(int(os.Stdin.Fd()), state)
+
+		t := term.NewTerminal(struct {
+			io.Reader
+			io.Writer
+		}{os.Stdin, os.Stdout}, "wacli> ")
+		t.AutoCompleteCallback = r.complete(t)
+		r.out = t
+		readLine = t.ReadLine
+	} else {
+		in := bufio.NewReader(os.Stdin)
+		readLine = func() (string, error) {
+			line, err := in.ReadString('\n')
+			if err != nil && line != "" {
+				err = nil
+			}
+			return strings.TrimRight(line, "\r\n"), err
+		}
+	}
+
+	d.listen(r.printEvent)
+	if _, err := r.loadAliases(); err != nil {
+		fmt.Fprintf(r.out, "Error: %v\n", err)
+	}
+	fmt.Fprintln(r.out, `Connected. Type "help" for commands.`)
+
+	for {
+		line, err := readLine()
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "quit" || fields[0] == "exit" {
+			return
+		}
+		if err := r.run(fields); err != nil {
+			fmt.Fprintf(r.out, "Error: %v\n", err)
+		}
+	}
+}
+
+func (r *repl) run(fields []string) error {
+	switch fields[0] {
+	case "help":
+		fmt.Fprintln(r.out, replHelp)
+	case "chats":
+		chats, err := r.loadAliases()
+		if err != nil {
+			return err
+		}
+		byJID := make(map[string]string, len(r.aliases))
+		for alias, jid := range r.aliases {
+			byJID[jid] = alias
+		}
+		for _, chat := range chats {
+			fmt.Fprintf(r.out, "%-24s %-30s %s\n", byJID[chat.ChatJID], truncateRunes(chat.ChatName, 30), chat.ChatJID)
+		}
+	case "send":
+		if len(fields) < 3 {
+			return fmt.Errorf("usage: send <chat> <text>")
+		}
+		data, err := r.conn.request(SocketCommand{Action: "send", ChatJID: r.resolve(fields[1]), Text: strings.Join(fields[2:], " ")}, nil)
+		if err != nil {
+			return err
+		}
+		var result SendResult
+		json.Unmarshal(data, &result)
+		fmt.Fprintf(r.out, "Sent %s\n", result.MessageID)
+	case "history":
+		if len(fields) < 2 {
+			return fmt.Errorf("usage: history <chat> [n]")
+		}
+		limit := 20
+		if len(fields) > 2 {
+			n, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return fmt.Errorf("invalid count %q", fields[2])
+			}
+			limit = n
+		}
+		messages, err := r.history(r.resolve(fields[1]), limit)
+		if err != nil {
+			return err
+		}
+		for i := len(messages) - 1; i >= 0; i-- {
+			msg := messages[i]
+			fmt.Fprintf(r.out, "%s  %s: %s\n", formatTimestamp(msg.Timestamp), msg.SenderName, msg.displayText())
+		}
+	case "react":
+		if len(fields) != 3 {
+			return fmt.Errorf("usage: react <chat> <emoji>")
+		}
+		chatJID := r.resolve(fields[1])
+		messages, err := r.history(chatJID, 1)
+		if err != nil {
+			return err
+		}
+		if len(messages) == 0 {
+			return fmt.Errorf("no stored messages in %s", chatJID)
+		}
+		_, err = r.conn.request(SocketCommand{
+			Action:    "react",
+			ChatJID:   chatJID,
+			MessageID: messages[0].MessageID,
+			SenderJID: messages[0].SenderJID,
+			Text:      fields[2],
+		}, nil)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(r.out, "Reacted to %s: %s\n", messages[0].SenderName, truncateRunes(messages[0].displayText(), 60))
+	case "status":
+		data, err := r.conn.request(SocketCommand{Action: "status"}, nil)
+		if err != nil {
+			return err
+		}
+		var status map[string]interface{}
+		if err := json.Unmarshal(data, &status); err != nil {
+			return err
+		}
+		out, _ := json.MarshalIndent(status, "", "  ")
+		fmt.Fprintln(r.out, string(out))
+	default:
+		return fmt.Errorf("unknown command %q, try help", fields[0])
+	}
+	return nil
+}
+
+func (r *repl) history(chatJID string, limit int) ([]Message, error) {
+	var messages []Message
+	_, err := r.conn.request(SocketCommand{Action: "history", ChatJID: chatJID, Limit: limit}, func(row json.RawMessage) error {
+		var msg Message
+		if err := json.Unmarshal(row, &msg); err != nil {
+			return err
+		}
+		messages = append(messages, msg)
+		return nil
+	})
+	return messages, err
+}
+
+// loadAliases refreshes the aliases from the daemon's chat list.
+func (r *repl) loadAliases() ([]ChatSummary, error) {
+	data, err := r.conn.request(SocketCommand{Action: "list_chats"}, nil)
+	if err != nil {
+		return nil, err
+	}
+	var chats []ChatSummary
+	if err := json.Unmarshal(data, &chats); err != nil {
+		return nil, err
+	}
+	r.aliases = chatAliases(chats)
+	return chats, nil
+}
+
+// chatAliases derives short, typeable names from the chat names, mapped
+// to their JIDs.
+func chatAliases(chats []ChatSummary) map[string]string {
+	aliases := make(map[string]string, len(chats))
+	for _, chat := range chats {
+		base := chatAlias(chat.ChatName)
+		if base == "" {
+			base = strings.SplitN(chat.ChatJID, "@", 2)[0]
+		}
+		alias := base
+		for n := 2; aliases[alias] != ""; n++ {
+			alias = fmt.Sprintf("%s-%d", base, n)
+		}
+		aliases[alias] = chat.ChatJID
+	}
+	return aliases
+}
+
+func chatAlias(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, c := range strings.ToLower(name) {
+		switch {
+		case unicode.IsLetter(c) || unicode.IsDigit(c):
+			b.WriteRune(c)
+			dash = false
+		case !dash && b.Len() > 0:
+			b.WriteRune('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+func (r *repl) resolve(chat string) string {
+	if jid, ok := r.aliases[chat]; ok {
+		return jid
+	}
+	return normalizeJID(chat)
+}
+
+// complete returns the tab completion callback: commands for the first
+// word, chat aliases for the second.
+func (r *repl) complete(t *term.Terminal) func(string, int, rune) (string, int, bool) {
+	return func(line string, pos int, key rune) (string, int, bool) {
+		if key != '\t' {
+			return "", 0, false
+		}
+		before := line[:pos]
+		start := strings.LastIndex(before, " ") + 1
+		word := before[start:]
+
+		var candidates []string
+		switch strings.Count(strings.TrimLeft(before, " "), " ") {
+		case 0:
+			candidates = replCommands
+		case 1:
+			for alias := range r.aliases {
+				candidates = append(candidates, alias)
+			}
+		}
+
+		var matches []string
+		for _, c := range candidates {
+			if strings.HasPrefix(c, word) {
+				matches = append(matches, c)
+			}
+		}
+		if len(matches) == 0 {
+			return "", 0, false
+		}
+		sort.Strings(matches)
+
+		completion := matches[0] + " "
+		if len(matches) > 1 {
+			completion = commonPrefix(matches)
+			fmt.Fprintln(t, strings.Join(matches, "  "))
+		}
+		newLine := line[:start] + completion + line[pos:]
+		return newLine, start + len(completion), true
+	}
+}
+
+func commonPrefix(words []string) string {
+	prefix := []rune(words[0])
+	for _, word := range words[1:] {
+		runes := []rune(word)
+		n := 0
+		for n < len(prefix) && n < len(runes) && prefix[n] == runes[n] {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	return string(prefix)
+}
+
+func (r *repl) printEvent(evt clientEvent) {
+	switch evt.Type {
+	case "message", "newsletter_message", "view_once":
+		var msg Message
+		if json.Unmarshal(evt.Data, &msg) != nil {
+			return
+		}
+		chat := msg.SenderName
+		if msg.IsGroup {
+			chat = msg.SenderName + " @ " + msg.ChatName
+		}
+		fmt.Fprintf(r.out, "%s  %s: %s\n", formatTimestamp(msg.Timestamp), chat, msg.displayText())
+	case "call":
+		var call Call
+		if json.Unmarshal(evt.Data, &call) != nil {
+			return
+		}
+		fmt.Fprintf(r.out, "%s  Incoming call from %s\n", formatTimestamp(call.Timestamp), call.CallerName)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Retained chats are archived locally forever: their messages are never
+// trimmed, expired with their partition or cleaned up for low disk space.
+// They come from RETAIN_CHATS and from the retain_chat action, which keeps
+// them in retained_chats.
+
+type RetainedChat struct {
+	ChatJID  string `json:"chat_jid"`
+	Retained bool   `json:"retained"`
+	// "config" for RETAIN_CHATS, "action" for retain_chat
+	Source string `json:"source,omitempty"`
+}
+
+func (r RetainedChat) scopeChatJID() string { return r.ChatJID }
+
+// prunableMessages is the condition matching messages that trimming and
+// retention may delete, with its arguments.
+func (a *App) prunableMessages() (string, []interface{}) {
+	where := "is_starred = 0 AND chat_jid NOT IN (SELECT chat_jid FROM retained_chats)"
+	configured := a.cfg().RetainChats
+	if len(configured) == 0 {
+		return where, nil
+	}
+	chats := make([]string, 0, len(configured))
+	for jid := range configured {
+		chats = append(chats, a.canonicalChat(jid))
+	}
+	sort.Strings(chats)
+	cond, args := inChats(chats)
+	return where + " AND NOT " + cond, args
+}
+
+// retainChat adds a chat to the retained chats, or with undo removes it.
+// Chats in RETAIN_CHATS can only be released by editing the config.
+func (a *App) retainChat(chatJID string, undo bool) (RetainedChat, error) {
+	if jid, err := types.ParseJID(chatJID); err != nil || jid.IsEmpty() {
+		return RetainedChat{}, fmt.Errorf("invalid JID %q", chatJID)
+	}
+	result := RetainedChat{ChatJID: a.canonicalChat(chatJID), Retained: !undo, Source: "action"}
+	if a.cfg().RetainChats[chatJID] || a.cfg().RetainChats[result.ChatJID] {
+		if undo {
+			return RetainedChat{}, fmt.Errorf("%s is retained by RETAIN_CHATS", chatJID)
+		}
+		result.Source = "config"
+		return result, nil
+	}
+
+	var err error
+	if undo {
+		result.Source = ""
+		_, err = a.msgDB.Exec("DELETE FROM retained_chats WHERE chat_jid = ?", result.ChatJID)
+	} else {
+		_, err = a.msgDB.Exec(
+			"INSERT OR IGNORE INTO retained_chats (chat_jid, created_at) VALUES (?, ?)",
+			result.ChatJID, time.Now().Unix(),
+		)
+	}
+	return result, err
+}
+
+// retainedChats lists the retained chats from both sources.
+func (a *App) retainedChats() ([]RetainedChat, error) {
+	chats := []RetainedChat{}
+	seen := make(map[string]bool)
+	for jid := range a.cfg().RetainChats {
+		jid = a.canonicalChat(jid)
+		if !seen[jid] {
+			seen[jid] = true
+			chats = append(chats, RetainedChat{ChatJID: jid, Retained: true, Source: "config"})
+		}
+	}
+
+	rows, err := a.msgDB.Query("SELECT chat_jid FROM retained_chats")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var jid string
+		if err := rows.Scan(&jid); err != nil {
+			return nil, err
+		}
+		if !seen[jid] {
+			seen[jid] = true
+			chats = append(chats, RetainedChat{ChatJID: jid, Retained: true, Source: "action"})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(chats, func(i, j int) bool { return chats[i].ChatJID < chats[j].ChatJID })
+	return chats, nil
+}
+
+func (a *App) dropExpiredPartitions(now time.Time) error {
+	partitions, err := listPartitions(a.msgDB.DB)
+	if err != nil {
+		return err
+	}
+
+	keepFrom := monthStart(now).AddDate(0, -a.cfg().RetentionMonths, 0)
+	for _, partition := range partitions {
+		month, err := partitionMonth(partition)
+		if err != nil || !month.Before(keepFrom) {
+			continue
+		}
+		if _, err := a.expirePartition(partition); err != nil {
+			return err
+		}
+		fmt.Printf("Expired message partition %s\n", partition)
+	}
+	return nil
+}
+
+// expirePartition deletes the messages of a partition past retention
+// except the starred ones and those of retained chats, and drops the
+// partition once none are left. The drop and the rebuilt view commit
+// together, so readers never see the view over a missing table. It
+// reports whether anything was removed.
+func (a *App) expirePartition(partition string) (bool, error) {
+	tx, err := a.msgDB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	where, args := a.prunableMessages()
+	res, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s", partition, where), args...)
+	if err != nil {
+		return false, err
+	}
+	deleted, _ := res.RowsAffected()
+
+	var left bool
+	if err := tx.QueryRow(fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s)", partition)).Scan(&left); err != nil {
+		return false, err
+	}
+	if !left {
+		if _, err := tx.Exec(fmt.Sprintf("DROP TABLE %s", partition)); err != nil {
+			return false, err
+		}
+		if err := createMessagesView(tx); err != nil {
+			return false, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return deleted > 0 || !left, nil
+}
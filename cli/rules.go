@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	chatTypeGroup      = "group"
+	chatTypeDirect     = "direct"
+	chatTypeStatus     = "status"
+	chatTypeNewsletter = "newsletter"
+)
+
+// Rule matches incoming messages and decides what happens to them. All
+// conditions that are set must hold; Chats holds JIDs or glob patterns
+// such as "*@g.us" and Keywords match case-insensitively anywhere in the
+// text. QuietHours is a local time range like "22:00-07:00".
+type Rule struct {
+	Name       string   `yaml:"name"`
+	Chats      []string `yaml:"chats"`
+	ChatType   string   `yaml:"chat_type"`
+	Keywords   []string `yaml:"keywords"`
+	Muted      *bool    `yaml:"muted"`
+	Addressed  *bool    `yaml:"addressed"`
+	QuietHours string   `yaml:"quiet_hours"`
+
+	Store     *bool `yaml:"store"`
+	Broadcast *bool `yaml:"broadcast"`
+	Attention *bool `yaml:"attention"`
+	Email     *bool `yaml:"email"`
+
+	quietStart, quietEnd int
+}
+
+// RuleDecision is what the rules decided for one message. Attention covers
+// both the attention backend and desktop notifications; Email forwards the
+// message through SMTP_URL.
+type RuleDecision struct {
+	Store     bool
+	Broadcast bool
+	Attention bool
+	Email     bool
+}
+
+func (d RuleDecision) any() bool {
+	return d.Store || d.Broadcast || d.Attention || d.Email
+}
+
+// ruleInput is what rules are matched against.
+type ruleInput struct {
+	ChatJID   types.JID
+	Muted     bool
+	Addressed bool
+	Text      string
+	Time      time.Time
+}
+
+// loadRules reads the rules file. Without one, the defaults drop status
+// updates and unaddressed messages from muted chats, unless the older
+// INCLUDE_STATUS_MESSAGES and INCLUDE_MUTED_MESSAGES toggles say otherwise.
+func loadRules(config Config) ([]Rule, error) {
+	data, err := os.ReadFile(config.RulesFile)
+	if os.IsNotExist(err) {
+		return defaultRules(config), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Rules []Rule `yaml:"rules"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", config.RulesFile, err)
+	}
+	for i := range file.Rules {
+		if err := file.Rules[i].validate(); err != nil {
+			name := file.Rules[i].Name
+			if name == "" {
+				name = fmt.Sprintf("#%d", i+1)
+			}
+			return nil, fmt.Errorf("rule %s: %w", name, err)
+		}
+	}
+	return file.Rules, nil
+}
+
+func defaultRules(config Config) []Rule {
+	var rules []Rule
+	if !config.IncludeStatusMessages {
+		rules = append(rules, Rule{Name: "status", ChatType: chatTypeStatus, Store: boolPtr(false), Broadcast: boolPtr(false), Attention: boolPtr(false)})
+	}
+	if !config.IncludeMutedMessages {
+		rules = append(rules, Rule{Name: "muted", Muted: boolPtr(true), Addressed: boolPtr(false), Store: boolPtr(false), Broadcast: boolPtr(false), Attention: boolPtr(false)})
+	}
+	return rules
+}
+
+func (r *Rule) validate() error {
+	for _, pattern := range r.Chats {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid chat pattern %q", pattern)
+		}
+	}
+	switch r.ChatType {
+	case "", chatTypeGroup, chatTypeDirect, chatTypeStatus, chatTypeNewsletter:
+	default:
+		return fmt.Errorf("invalid chat_type %q", r.ChatType)
+	}
+	if r.QuietHours != "" {
+		start, end, ok := strings.Cut(r.QuietHours, "-")
+		var err1, err2 error
+		r.quietStart, err1 = parseClock(strings.TrimSpace(start))
+		r.quietEnd, err2 = parseClock(strings.TrimSpace(end))
+		if !ok || err1 != nil || err2 != nil {
+			return fmt.Errorf("invalid quiet_hours %q, want HH:MM-HH:MM", r.QuietHours)
+		}
+	}
+	return nil
+}
+
+// parseClock returns the minutes since midnight of an HH:MM time.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (r *Rule) matches(in ruleInput) bool {
+	if len(r.Chats) > 0 {
+		jid := in.ChatJID.String()
+		matched := false
+		for _, pattern := range r.Chats {
+			if ok, _ := path.Match(pattern, jid); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if r.ChatType != "" && r.ChatType != chatType(in.ChatJID) {
+		return false
+	}
+	if len(r.Keywords) > 0 {
+		text := strings.ToLower(in.Text)
+		matched := false
+		for _, keyword := range r.Keywords {
+			if strings.Contains(text, strings.ToLower(keyword)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if r.Muted != nil && *r.Muted != in.Muted {
+		return false
+	}
+	if r.Addressed != nil && *r.Addressed != in.Addressed {
+		return false
+	}
+	if r.QuietHours != "" {
+		now := in.Time.Hour()*60 + in.Time.Minute()
+		// A range such as 22:00-07:00 wraps past midnight
+		if r.quietStart <= r.quietEnd {
+			if now < r.quietStart || now >= r.quietEnd {
+				return false
+			}
+		} else if now < r.quietStart && now >= r.quietEnd {
+			return false
+		}
+	}
+	return true
+}
+
+func chatType(jid types.JID) string {
+	switch jid.Server {
+	case types.GroupServer:
+		return chatTypeGroup
+	case types.BroadcastServer:
+		return chatTypeStatus
+	case types.NewsletterServer:
+		return chatTypeNewsletter
+	}
+	return chatTypeDirect
+}
+
+// evaluateRules runs the rules in order. For each of store, broadcast,
+// attention and email the first matching rule that sets it decides;
+// anything no rule decides is allowed, except email, which is opt-in.
+func (a *App) evaluateRules(in ruleInput) RuleDecision {
+	decision := RuleDecision{Store: true, Broadcast: true, Attention: true}
+	var store, broadcast, attention, email bool
+	rules := a.currentRules()
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.matches(in) {
+			continue
+		}
+		if rule.Store != nil && !store {
+			decision.Store, store = *rule.Store, true
+		}
+		if rule.Broadcast != nil && !broadcast {
+			decision.Broadcast, broadcast = *rule.Broadcast, true
+		}
+		if rule.Attention != nil && !attention {
+			decision.Attention, attention = *rule.Attention, true
+		}
+		if rule.Email != nil && !email {
+			decision.Email, email = *rule.Email, true
+		}
+	}
+	return decision
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
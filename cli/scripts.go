@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	scriptTimeout   = 2 * time.Second
+	scriptSendLimit = 5
+)
+
+// Scripts are the Lua files in SCRIPTS_DIR, reloaded when they change.
+// A script may define on_message(msg), called with every incoming message
+// the rules let through, as a table with the socket's message fields.
+// Returning false keeps the message from being broadcast, notified or
+// mailed; it is still stored. The wacli table is all a script can reach:
+// send, reply, get and set on its own key/value store, log and now.
+
+type scriptEngine struct {
+	mu      sync.Mutex
+	scripts map[string]*script
+	stamps  map[string]time.Time
+}
+
+type script struct {
+	name string
+
+	mu    sync.Mutex
+	state *lua.LState
+	// sends requested by the running hook, made once it returns
+	pending []scriptSend
+}
+
+type scriptSend struct {
+	chatJID string
+	text    string
+	// set for replies
+	messageID string
+	senderJID string
+}
+
+// scriptLibs are the standard libraries scripts get; io, os and package
+// would reach outside the sandbox.
+var scriptLibs = map[string]lua.LGFunction{
+	lua.BaseLibName:   lua.OpenBase,
+	lua.TabLibName:    lua.OpenTable,
+	lua.StringLibName: lua.OpenString,
+	lua.MathLibName:   lua.OpenMath,
+}
+
+// scriptUnsafeGlobals are the base library functions that load code from
+// files or strings.
+var scriptUnsafeGlobals = []string{"dofile", "loadfile", "load", "loadstring", "require", "module"}
+
+// watchScripts loads the scripts and reloads those that change, appear or
+// disappear.
+func (a *App) watchScripts() {
+	ticker := time.NewTicker(reloadCheckInterval)
+	defer ticker.Stop()
+	for {
+		a.loadScripts()
+		<-ticker.C
+	}
+}
+
+// loadScripts brings the loaded scripts in line with SCRIPTS_DIR. A script
+// that fails to load is reported and its previous version kept running.
+func (a *App) loadScripts() {
+	dir := a.cfg().ScriptsDir
+	paths, err := filepath.Glob(filepath.Join(dir, "*.lua"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list scripts: %v\n", err)
+		return
+	}
+	stamps := make(map[string]time.Time, len(paths))
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			stamps[filepath.Base(path)] = info.ModTime()
+		}
+	}
+
+	e := &a.scripts
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.scripts == nil {
+		e.scripts = make(map[string]*script)
+	}
+	for name, old := range e.scripts {
+		if _, ok := stamps[name]; !ok {
+			old.close()
+			delete(e.scripts, name)
+			delete(e.stamps, name)
+			fmt.Printf("Unloaded script %s\n", name)
+		}
+	}
+	for name, stamp := range stamps {
+		if prev, ok := e.stamps[name]; ok && prev.Equal(stamp) {
+			continue
+		}
+		if e.stamps == nil {
+			e.stamps = make(map[string]time.Time)
+		}
+		// Remember failures too, so a broken script is reported once
+		e.stamps[name] = stamp
+		s, err := a.loadScript(name, filepath.Join(dir, name))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load script %s: %v\n", name, err)
+			continue
+		}
+		if old, ok := e.scripts[name]; ok {
+			old.close()
+		}
+		e.scripts[name] = s
+		fmt.Printf("Loaded script %s\n", name)
+	}
+}
+
+func (a *App) loadScript(name string, path string) (*script, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for lib, open := range scriptLibs {
+		L.Push(L.NewFunction(open))
+		L.Push(lua.LString(lib))
+		L.Call(1, 0)
+	}
+	for _, global := range scriptUnsafeGlobals {
+		L.SetGlobal(global, lua.LNil)
+	}
+
+	s := &script{name: name, state: L}
+	L.SetGlobal("print", L.NewFunction(s.luaLog))
+	L.SetGlobal("wacli", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"send":  s.luaSend,
+		"reply": s.luaReply,
+		"get":   func(L *lua.LState) int { return a.luaGet(s, L) },
+		"set":   func(L *lua.LState) int { return a.luaSet(s, L) },
+		"log":   s.luaLog,
+		"now":   func(L *lua.LState) int { L.Push(lua.LNumber(time.Now().Unix())); return 1 },
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), scriptTimeout)
+	defer cancel()
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+	fn, err := L.Load(bytes.NewReader(source), name)
+	if err == nil {
+		L.Push(fn)
+		err = L.PCall(0, 0, nil)
+	}
+	if err != nil {
+		L.Close()
+		return nil, err
+	}
+	// Loading may not send anything
+	s.pending = nil
+	return s, nil
+}
+
+func (s *script) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.Close()
+}
+
+// runScripts passes a message to every script's on_message, in name order,
+// and reports whether all of them let it through. Sends the hooks asked
+// for are made in the background.
+func (a *App) runScripts(msg *Message) bool {
+	a.scripts.mu.Lock()
+	scripts := make([]*script, 0, len(a.scripts.scripts))
+	for _, s := range a.scripts.scripts {
+		scripts = append(scripts, s)
+	}
+	a.scripts.mu.Unlock()
+	if len(scripts) == 0 {
+		return true
+	}
+	sort.Slice(scripts, func(i, j int) bool { return scripts[i].name < scripts[j].name })
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return true
+	}
+	var fields interface{}
+	json.Unmarshal(data, &fields)
+
+	keep := true
+	for _, s := range scripts {
+		ok, sends, err := s.onMessage(fields)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Script %s failed on message %s: %v\n", s.name, msg.MessageID, err)
+		}
+		keep = keep && ok
+		if len(sends) > 0 {
+			a.goSafe("script "+s.name, func() { a.sendScriptMessages(s.name, sends) })
+		}
+	}
+	return keep
+}
+
+func (s *script) onMessage(fields interface{}) (bool, []scriptSend, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	L := s.state
+	hook, ok := L.GetGlobal("on_message").(*lua.LFunction)
+	if !ok {
+		return true, nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), scriptTimeout)
+	defer cancel()
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+	s.pending = nil
+	err := L.CallByParam(lua.P{Fn: hook, NRet: 1, Protect: true}, toLua(L, fields))
+	if err != nil {
+		return true, nil, err
+	}
+	result := L.Get(-1)
+	L.Pop(1)
+	return result != lua.LFalse, s.pending, nil
+}
+
+// runScriptCommand calls on_command(name, args, msg) of a script for the
+// bot, and returns what it returned as the reply.
+func (a *App) runScriptCommand(scriptName string, name string, args []string, msg *Message) (string, error) {
+	a.scripts.mu.Lock()
+	s := a.scripts.scripts[scriptName]
+	a.scripts.mu.Unlock()
+	if s == nil {
+		return "", fmt.Errorf("script %s is not loaded", scriptName)
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	var fields interface{}
+	json.Unmarshal(data, &fields)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	L := s.state
+	hook, ok := L.GetGlobal("on_command").(*lua.LFunction)
+	if !ok {
+		return "", fmt.Errorf("script %s has no on_command", scriptName)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), scriptTimeout)
+	defer cancel()
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+
+	luaArgs := L.NewTable()
+	for _, arg := range args {
+		luaArgs.Append(lua.LString(arg))
+	}
+	s.pending = nil
+	if err := L.CallByParam(lua.P{Fn: hook, NRet: 1, Protect: true}, lua.LString(name), luaArgs, toLua(L, fields)); err != nil {
+		return "", err
+	}
+	result := L.Get(-1)
+	L.Pop(1)
+	if sends := s.pending; len(sends) > 0 {
+		a.goSafe("script "+s.name, func() { a.sendScriptMessages(s.name, sends) })
+	}
+	if result == lua.LNil {
+		return "", nil
+	}
+	return L.ToStringMeta(result).String(), nil
+}
+
+func (a *App) sendScriptMessages(name string, sends []scriptSend) {
+	for _, send := range sends {
+		jid, err := types.ParseJID(send.chatJID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Script %s: invalid chat JID %q\n", name, send.chatJID)
+			continue
+		}
+		msg := &waE2E.Message{Conversation: proto.String(send.text)}
+		if send.messageID != "" {
+			msg = &waE2E.Message{ExtendedTextMessage: &waE2E.ExtendedTextMessage{
+				Text: proto.String(send.text),
+				ContextInfo: &waE2E.ContextInfo{
+					StanzaID:    proto.String(send.messageID),
+					Participant: proto.String(send.senderJID),
+				},
+			}}
+		}
+		if _, err := a.sendAutomated("script:"+name, jid, msg); err != nil {
+			fmt.Fprintf(os.Stderr, "Script %s failed to send to %s: %v\n", name, send.chatJID, err)
+		}
+	}
+}
+
+// toLua converts decoded JSON to Lua values.
+func toLua(L *lua.LState, value interface{}) lua.LValue {
+	switch v := value.(type) {
+	case string:
+		return lua.LString(v)
+	case float64:
+		return lua.LNumber(v)
+	case bool:
+		return lua.LBool(v)
+	case []interface{}:
+		table := L.NewTable()
+		for _, item := range v {
+			table.Append(toLua(L, item))
+		}
+		return table
+	case map[string]interface{}:
+		table := L.NewTable()
+		for key, item := range v {
+			table.RawSetString(key, toLua(L, item))
+		}
+		return table
+	}
+	return lua.LNil
+}
+
+func (s *script) queue(L *lua.LState, send scriptSend) int {
+	if len(s.pending) >= scriptSendLimit {
+		L.RaiseError("at most %d sends per message", scriptSendLimit)
+	}
+	s.pending = append(s.pending, send)
+	return 0
+}
+
+// wacli.send(chat_jid, text)
+func (s *script) luaSend(L *lua.LState) int {
+	return s.queue(L, scriptSend{chatJID: L.CheckString(1), text: L.CheckString(2)})
+}
+
+// wacli.reply(msg, text) quotes msg in its chat.
+func (s *script) luaReply(L *lua.LState) int {
+	msg := L.CheckTable(1)
+	chatJID, ok1 := msg.RawGetString("chat_jid").(lua.LString)
+	messageID, ok2 := msg.RawGetString("message_id").(lua.LString)
+	senderJID, ok3 := msg.RawGetString("sender_jid").(lua.LString)
+	if !ok1 || !ok2 || !ok3 {
+		L.ArgError(1, "not a message")
+	}
+	return s.queue(L, scriptSend{
+		chatJID:   string(chatJID),
+		messageID: string(messageID),
+		senderJID: string(senderJID),
+		text:      L.CheckString(2),
+	})
+}
+
+// wacli.log(...) and print(...) write a line to the daemon's output.
+func (s *script) luaLog(L *lua.LState) int {
+	parts := make([]string, L.GetTop())
+	for i := range parts {
+		parts[i] = L.ToStringMeta(L.Get(i + 1)).String()
+	}
+	fmt.Printf("[%s] %s\n", s.name, strings.Join(parts, " "))
+	return 0
+}
+
+// wacli.get(key) returns the script's stored value, nil if unset.
+func (a *App) luaGet(s *script, L *lua.LState) int {
+	var value string
+	err := a.msgDB.QueryRow("SELECT value FROM script_kv WHERE script = ? AND key = ?", s.name, L.CheckString(1)).Scan(&value)
+	if err == sql.ErrNoRows {
+		L.Push(lua.LNil)
+		return 1
+	} else if err != nil {
+		L.RaiseError("get: %v", err)
+	}
+	L.Push(lua.LString(value))
+	return 1
+}
+
+// wacli.set(key, value) stores a string for the script across restarts
+// and reloads; a nil value deletes the key.
+func (a *App) luaSet(s *script, L *lua.LState) int {
+	key := L.CheckString(1)
+	var err error
+	if L.Get(2) == lua.LNil {
+		_, err = a.msgDB.Exec("DELETE FROM script_kv WHERE script = ? AND key = ?", s.name, key)
+	} else {
+		_, err = a.msgDB.Exec(
+			"INSERT OR REPLACE INTO script_kv (script, key, value, updated_at) VALUES (?, ?, ?, ?)",
+			s.name, key, L.ToStringMeta(L.Get(2)).String(), time.Now().Unix(),
+		)
+	}
+	if err != nil {
+		L.RaiseError("set: %v", err)
+	}
+	return 0
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Under systemd with Type=notify, the daemon reports readiness and its
+// connection state on $NOTIFY_SOCKET, and with WatchdogSec= it pings the
+// watchdog for as long as WhatsApp is reachable, so a connection that
+// wedges without whatsmeow noticing gets the service restarted.
+
+// sdReady is set once READY=1 went out; later connects only update STATUS.
+var sdReady atomic.Bool
+
+// sdNotify sends a state to the service manager. Without NOTIFY_SOCKET
+// (not started by systemd, or Type=simple) it does nothing.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	// Abstract socket names come with an @ for the leading NUL
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.Write([]byte(state))
+}
+
+func sdNotifyConnected() {
+	if sdReady.CompareAndSwap(false, true) {
+		sdNotify("READY=1\nSTATUS=Connected to WhatsApp")
+		return
+	}
+	sdNotify("STATUS=Connected to WhatsApp")
+}
+
+// watchdogInterval is WATCHDOG_USEC when the watchdog is meant for this
+// process, zero otherwise.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// watchSystemdWatchdog pings the watchdog twice per interval while the
+// daemon is healthy: events are arriving through handleEvent, or, on a
+// quiet connection, the server answers a keepalive. While disconnected
+// whatsmeow is reconnecting on its own and a restart wouldn't help, so the
+// pings continue.
+func (a *App) watchSystemdWatchdog() {
+	interval := watchdogInterval()
+	if interval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if a.healthy(interval / 2) {
+			sdNotify("WATCHDOG=1")
+		}
+	}
+}
+
+func (a *App) healthy(window time.Duration) bool {
+	if time.Since(time.Unix(a.lastEventAt.Load(), 0)) < window {
+		return true
+	}
+	if !a.client.IsConnected() {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(a.ctx, window/2)
+	defer cancel()
+	ok, _ := a.client.DangerousInternals().SendKeepAlive(ctx)
+	return ok
+}
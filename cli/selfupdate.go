@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	updateRepo          = "reed1/wacli"
+	updateChecksumsFile = "checksums.txt"
+	updateSignatureFile = "checksums.txt.sig"
+)
+
+// version is set at build time with -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+type githubRelease struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+func (r *githubRelease) assetURL(name string) string {
+	for _, asset := range r.Assets {
+		if asset.Name == name {
+			return asset.URL
+		}
+	}
+	return ""
+}
+
+// runSelfUpdate replaces the running binary with the latest GitHub release
+// for this platform after checking it against the release's checksums file
+// (and its ed25519 signature when WACLI_UPDATE_PUBKEY is set). With
+// --restart the new binary is exec'd as the daemon afterwards, so
+// `wacli self-update --restart` works as a service's start command.
+func runSelfUpdate(globalArgs []string, args []string) {
+	fs := flag.NewFlagSet("self-update", flag.ExitOnError)
+	checkOnly := fs.Bool("check", false, "only report whether an update is available")
+	restart := fs.Bool("restart", false, "exec the daemon after updating")
+	fs.Parse(args)
+
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to locate executable: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := selfUpdate(exe, *checkOnly); err != nil {
+		fmt.Fprintf(os.Stderr, "Self-update failed: %v\n", err)
+		if !*restart {
+			os.Exit(1)
+		}
+	}
+
+	if *restart && !*checkOnly {
+		argv := append([]string{exe}, globalArgs...)
+		argv = append(argv, "daemon")
+		if err := execSelf(exe, argv); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to restart daemon: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+func selfUpdate(exe string, checkOnly bool) error {
+	httpClient := &http.Client{Timeout: 5 * time.Minute}
+
+	release, err := fetchLatestRelease(httpClient)
+	if err != nil {
+		return err
+	}
+	if release.TagName == version {
+		fmt.Printf("Already up to date (%s)\n", version)
+		return nil
+	}
+
+	assetName := fmt.Sprintf("wacli_%s_%s", runtime.GOOS, runtime.GOARCH)
+	binaryURL := release.assetURL(assetName)
+	checksumsURL := release.assetURL(updateChecksumsFile)
+	if binaryURL == "" || checksumsURL == "" {
+		return fmt.Errorf("release %s has no %s or %s asset", release.TagName, assetName, updateChecksumsFile)
+	}
+
+	if checkOnly {
+		fmt.Printf("Update available: %s -> %s\n", version, release.TagName)
+		return nil
+	}
+
+	checksums, err := download(httpClient, checksumsURL)
+	if err != nil {
+		return err
+	}
+	if pubKey := os.Getenv("WACLI_UPDATE_PUBKEY"); pubKey != "" {
+		sigURL := release.assetURL(updateSignatureFile)
+		if sigURL == "" {
+			return fmt.Errorf("release %s is not signed", release.TagName)
+		}
+		sig, err := download(httpClient, sigURL)
+		if err != nil {
+			return err
+		}
+		if err := verifySignature(pubKey, checksums, sig); err != nil {
+			return err
+		}
+	}
+
+	expected, err := findChecksum(checksums, assetName)
+	if err != nil {
+		return err
+	}
+
+	binary, err := download(httpClient, binaryURL)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(binary)
+	if hex.EncodeToString(sum[:]) != expected {
+		return fmt.Errorf("checksum mismatch for %s", assetName)
+	}
+
+	// Write next to the binary so the rename is atomic on the same filesystem
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".wacli-update-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		return err
+	}
+
+	fmt.Printf("Updated %s -> %s\n", version, release.TagName)
+	return nil
+}
+
+func fetchLatestRelease(httpClient *http.Client) (*githubRelease, error) {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/releases/latest", updateRepo)
+	body, err := download(httpClient, url)
+	if err != nil {
+		return nil, err
+	}
+	var release githubRelease
+	if err := json.Unmarshal(body, &release); err != nil {
+		return nil, fmt.Errorf("invalid release response: %w", err)
+	}
+	return &release, nil
+}
+
+func download(httpClient *http.Client, url string) ([]byte, error) {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// findChecksum reads a sha256sum-style file ("<hex>  <name>" per line).
+func findChecksum(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("no checksum for %s", name)
+}
+
+func verifySignature(pubKeyB64 string, message []byte, sigB64 []byte) error {
+	pubKey, err := base64.StdEncoding.DecodeString(pubKeyB64)
+	if err != nil || len(pubKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid WACLI_UPDATE_PUBKEY")
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sigB64)))
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	if !ed25519.Verify(pubKey, message, sig) {
+		return fmt.Errorf("signature verification failed")
+	}
+	return nil
+}
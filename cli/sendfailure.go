@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+)
+
+// SendFailure is broadcast as send_failed when WhatsApp refuses a message
+// or it can't be sent at all. ServerCode is the error code of the server's
+// ack, when there was one.
+type SendFailure struct {
+	MessageID  string `json:"message_id"`
+	ChatJID    string `json:"chat_jid"`
+	Code       string `json:"code"`
+	ServerCode int    `json:"server_code,omitempty"`
+	Reason     string `json:"reason"`
+	Automated  bool   `json:"automated"`
+}
+
+// serverErrorCodes names the error codes of server acks. WhatsApp doesn't
+// document them; these are the ones with a known meaning.
+var serverErrorCodes = map[int]string{
+	400: "bad_request",
+	401: "not_authorized",
+	403: "forbidden",
+	404: "recipient_not_found",
+	406: "not_acceptable",
+	413: "too_large",
+	429: "rate_limited",
+	463: "contact_restricted",
+	500: "server_error",
+}
+
+// sendFailureCode classifies a send error. Unknown server codes become
+// server_error and anything else unknown.
+func sendFailureCode(err error) (string, int) {
+	var disconnected *whatsmeow.DisconnectedError
+	switch {
+	case errors.Is(err, whatsmeow.ErrServerReturnedError):
+		// The code is only available in the message: "server returned error 463"
+		fields := strings.Fields(err.Error())
+		code, _ := strconv.Atoi(fields[len(fields)-1])
+		if name, ok := serverErrorCodes[code]; ok {
+			return name, code
+		}
+		return "server_error", code
+	case errors.Is(err, whatsmeow.ErrMessageTimedOut):
+		return "timeout", 0
+	case errors.Is(err, whatsmeow.ErrNotConnected), errors.As(err, &disconnected):
+		return "not_connected", 0
+	case errors.Is(err, whatsmeow.ErrNotLoggedIn):
+		return "not_logged_in", 0
+	case errors.Is(err, whatsmeow.ErrNoSession):
+		return "no_session", 0
+	case errors.Is(err, whatsmeow.ErrUnknownServer), errors.Is(err, whatsmeow.ErrBroadcastListUnsupported),
+		errors.Is(err, whatsmeow.ErrRecipientADJID):
+		return "invalid_recipient", 0
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled", 0
+	case errors.Is(err, errOfflineExpired):
+		return "expired", 0
+	case errors.Is(err, errChatCapped):
+		return "chat_capped", 0
+	case errors.Is(err, errNewsletterReadOnly):
+		return "forbidden", 0
+	}
+	return "unknown", 0
+}
+
+// recordSendFailure keeps a failed send with its reason and tells socket
+// clients; logging is left to the caller. It doesn't count as writing in
+// the chat.
+func (a *App) recordSendFailure(msg *OutgoingMessage, err error) {
+	code, serverCode := sendFailureCode(err)
+	now := time.Now().Unix()
+	if msg.Timestamp <= 0 {
+		msg.Timestamp = now
+	}
+	msg.Status = outgoingStatusFailed
+	msg.StatusAt = now
+	msg.FailureCode = code
+	msg.FailureReason = err.Error()
+	a.insertOutgoing(msg)
+
+	a.broadcastEvent("send_failed", SendFailure{
+		MessageID:  msg.MessageID,
+		ChatJID:    msg.ChatJID,
+		Code:       code,
+		ServerCode: serverCode,
+		Reason:     msg.FailureReason,
+		Automated:  msg.Automated,
+	})
+}
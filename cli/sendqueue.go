@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// sendPriority picks the lane of the outgoing queue a send waits in.
+type sendPriority int
+
+const (
+	// priorityInteractive is for sends a person is waiting on
+	priorityInteractive sendPriority = iota
+	// priorityBulk is for automated and mass traffic
+	priorityBulk
+
+	priorityLanes = 2
+)
+
+func parseSendPriority(s string) (sendPriority, error) {
+	switch s {
+	case "", "interactive":
+		return priorityInteractive, nil
+	case "bulk":
+		return priorityBulk, nil
+	}
+	return 0, fmt.Errorf("invalid priority %q, want interactive or bulk", s)
+}
+
+func (p sendPriority) String() string {
+	if p == priorityBulk {
+		return "bulk"
+	}
+	return "interactive"
+}
+
+// sendQueue paces outgoing messages with a token bucket: it holds up to
+// burst tokens, gains one every interval, and each send takes one after a
+// random jitter, so sends never go out at machine-regular intervals.
+// Whenever the next slot comes up, interactive sends go before anything in
+// the bulk lane, so a bot blasting messages never makes you wait behind it.
+type sendQueue struct {
+	interval time.Duration
+	burst    int
+	jitter   time.Duration
+	mu       sync.Mutex
+	lanes    [priorityLanes][]chan struct{}
+	ready    chan struct{}
+
+	// Only touched by run
+	tokens   float64
+	refilled time.Time
+}
+
+func newSendQueue(interval time.Duration, burst int, jitter time.Duration) *sendQueue {
+	q := &sendQueue{
+		interval: interval,
+		burst:    max(burst, 1),
+		jitter:   jitter,
+		ready:    make(chan struct{}, 1),
+		tokens:   float64(max(burst, 1)),
+		refilled: time.Now(),
+	}
+	go q.run()
+	return q
+}
+
+// do runs send once its turn comes up and returns after it finished.
+func (q *sendQueue) do(priority sendPriority, send func()) {
+	turn := make(chan struct{})
+	q.mu.Lock()
+	q.lanes[priority] = append(q.lanes[priority], turn)
+	q.mu.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+
+	<-turn
+	defer func() { turn <- struct{}{} }()
+	send()
+}
+
+func (q *sendQueue) run() {
+	for {
+		if q.empty() {
+			<-q.ready
+			continue
+		}
+		q.takeToken()
+		// Picked only now, so interactive sends arriving meanwhile go first
+		turn := q.next()
+		turn <- struct{}{}
+		<-turn
+	}
+}
+
+// takeToken waits for a token and the jitter.
+func (q *sendQueue) takeToken() {
+	now := time.Now()
+	q.tokens = min(float64(q.burst), q.tokens+float64(now.Sub(q.refilled))/float64(q.interval))
+	q.refilled = now
+	if q.tokens < 1 {
+		time.Sleep(time.Duration((1 - q.tokens) * float64(q.interval)))
+		q.tokens, q.refilled = 1, time.Now()
+	}
+	q.tokens--
+	if q.jitter > 0 {
+		time.Sleep(rand.N(q.jitter))
+	}
+}
+
+func (q *sendQueue) empty() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i := range q.lanes {
+		if len(q.lanes[i]) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (q *sendQueue) next() chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i := range q.lanes {
+		if len(q.lanes[i]) > 0 {
+			turn := q.lanes[i][0]
+			q.lanes[i] = q.lanes[i][1:]
+			return turn
+		}
+	}
+	return nil
+}
+
+// pending returns the number of sends waiting in each lane.
+func (q *sendQueue) pending() map[string]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pending := make(map[string]int, priorityLanes)
+	for i := range q.lanes {
+		pending[sendPriority(i).String()] = len(q.lanes[i])
+	}
+	return pending
+}
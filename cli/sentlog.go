@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// sent_log audits every send wacli was asked to make, by a socket client
+// or by its own automations, with what was sent and how it went. Unlike
+// outgoing_messages it also keeps sends that never got a message ID, such
+// as those refused for a bad JID, and it isn't trimmed.
+
+// SentLogEntry is one send. Client is the name of the token the socket
+// client authenticated with, "local" or "remote" without one, or "daemon"
+// for automations, whose Action is their source such as "autoreply".
+type SentLogEntry struct {
+	ID        int64  `json:"id"`
+	Timestamp int64  `json:"timestamp"`
+	Action    string `json:"action"`
+	Client    string `json:"client"`
+	ChatJID   string `json:"chat_jid"`
+	Text      string `json:"text"`
+	MessageID string `json:"message_id"`
+	Status    string `json:"status"`
+	Error     string `json:"error"`
+}
+
+func (e SentLogEntry) scopeChatJID() string { return e.ChatJID }
+
+func (e SentLogEntry) redacted() interface{} {
+	e.Text = ""
+	return e
+}
+
+// clientName identifies a socket client in the sent log.
+func (c *socketClient) clientName() string {
+	token := c.token.Load()
+	switch {
+	case token != nil:
+		return token.Name
+	case c.remote:
+		return "remote"
+	}
+	return "local"
+}
+
+// logSent records the outcome of a send made for a socket command.
+func (a *App) logSent(client *socketClient, cmd *SocketCommand, chatJID, text string, id types.MessageID, err error) {
+	a.recordSent(cmd.Action, client.clientName(), chatJID, text, id, err)
+}
+
+func (a *App) recordSent(action, client, chatJID, text string, id types.MessageID, err error) {
+	entry := &SentLogEntry{
+		Timestamp: time.Now().Unix(),
+		Action:    action,
+		Client:    client,
+		ChatJID:   a.canonicalChat(chatJID),
+		Text:      text,
+		MessageID: id,
+		Status:    outgoingStatusSent,
+	}
+	var queued *queuedOfflineError
+	switch {
+	case errors.As(err, &queued):
+		entry.MessageID, entry.Status = queued.id, "queued"
+	case err != nil:
+		entry.Status, entry.Error = outgoingStatusFailed, err.Error()
+	}
+
+	columns, placeholders, values := buildInsertParams(entry)
+	query := fmt.Sprintf(
+		"INSERT INTO sent_log (%s) VALUES (%s)",
+		strings.Join(columns, ", "),
+		strings.Join(placeholders, ", "),
+	)
+	if _, err := a.msgDB.insert(query, values...); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write sent log: %v\n", err)
+	}
+}
+
+// sentHistory returns sent log entries newest first, optionally for one
+// chat and a time range. chats limits them to a tenant's chats.
+func (a *App) sentHistory(chatJID string, since, before int64, limit int, chats []string) ([]SentLogEntry, error) {
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+
+	var where []string
+	var args []interface{}
+	if chatJID != "" {
+		where = append(where, "chat_jid = ?")
+		args = append(args, a.canonicalChat(chatJID))
+	}
+	if chats != nil {
+		cond, chatArgs := inChats(chats)
+		where = append(where, cond)
+		args = append(args, chatArgs...)
+	}
+	if since > 0 {
+		where = append(where, "timestamp >= ?")
+		args = append(args, since)
+	}
+	if before > 0 {
+		where = append(where, "timestamp < ?")
+		args = append(args, before)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM sent_log", strings.Join(recordColumns(&SentLogEntry{}), ", "))
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY timestamp DESC, id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := a.msgDB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []SentLogEntry{}
+	for rows.Next() {
+		var entry SentLogEntry
+		if err := scanRecord(rows, &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
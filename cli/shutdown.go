@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+const handlerDrainTimeout = 10 * time.Second
+
+// shutdown stops the daemon in an order that loses nothing in flight: no
+// new socket clients or WhatsApp events, let running event handlers finish
+// their DB writes and broadcasts, tell clients and plugins we're going away,
+// flush the event sinks, and only then drop WhatsApp.
+func (a *App) shutdown(listener net.Listener) {
+	sdNotify("STOPPING=1")
+	listener.Close()
+	if a.tlsListener != nil {
+		a.tlsListener.Close()
+	}
+
+	a.handlersMu.Lock()
+	a.closing = true
+	a.handlersMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		a.handlersWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(handlerDrainTimeout):
+		fmt.Fprintf(os.Stderr, "Timed out waiting for event handlers to finish\n")
+	}
+
+	a.broadcastEvent("shutdown", nil)
+	a.closeSocketConns()
+	a.stopPlugins()
+	a.closeSinks()
+
+	a.client.Disconnect()
+	a.removeSocketFile()
+}
+
+// beginHandler registers an event handler with the shutdown drain. It
+// returns false once shutdown has begun, so no Add races the Wait.
+func (a *App) beginHandler() bool {
+	a.handlersMu.RLock()
+	defer a.handlersMu.RUnlock()
+	if a.closing {
+		return false
+	}
+	a.handlersWG.Add(1)
+	return true
+}
+
+// goHandler is goSafe for work an event handler hands off, which shutdown
+// waits for like the handler itself. Call it only from inside a handler.
+func (a *App) goHandler(context string, fn func()) {
+	a.handlersWG.Add(1)
+	a.goSafe(context, func() {
+		defer a.handlersWG.Done()
+		fn()
+	})
+}
+
+func (a *App) closeSocketConns() {
+	a.connMu.Lock()
+	defer a.connMu.Unlock()
+
+	for client := range a.socketConns {
+		client.conn.Close()
+	}
+}
+
+// removeSocketFile deletes the socket unless another daemon has already
+// replaced it with its own.
+func (a *App) removeSocketFile() {
+	info, err := os.Stat(a.cfg().SocketPath)
+	if err != nil || a.socketFile == nil || !os.SameFile(info, a.socketFile) {
+		return
+	}
+	os.Remove(a.cfg().SocketPath)
+}
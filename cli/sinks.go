@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	sinkQueueSize      = 256
+	sinkCloseTimeout   = 5 * time.Second
+	mqttPublishTimeout = 10 * time.Second
+	defaultMQTTTopic   = "wacli"
+)
+
+// EventSink receives every broadcast event. The socket sink hands them to
+// connected clients; the others forward them to outside systems, so home
+// automation and the like get messages without a bridge process.
+type EventSink interface {
+	Emit(eventType string, payload interface{}) error
+	Close() error
+}
+
+// newEventSinks builds the socket sink and the outside sinks the config
+// enables: EVENT_FILE, EVENT_WEBHOOK_URL, MQTT_URL, NATS_URL, REDIS_URL
+// and the Telegram bridge. Outside sinks get their events through a queue, so a slow
+// broker never holds up handlers.
+func (a *App) newEventSinks(config Config) []EventSink {
+	sinks := []EventSink{&socketSink{app: a}}
+	if config.EventFile != "" {
+		sinks = append(sinks, a.newQueuedSink("file", &fileSink{path: config.EventFile}, config.SinkEvents))
+	}
+	if config.EventWebhookURL != "" {
+		sinks = append(sinks, a.newQueuedSink("webhook", &webhookSink{url: config.EventWebhookURL}, config.SinkEvents))
+	}
+	if config.MQTTURL != "" {
+		sinks = append(sinks, a.newQueuedSink("mqtt", newMQTTSink(config.MQTTURL, config.MQTTTopic), config.SinkEvents))
+	}
+	if config.NATSURL != "" {
+		sink, err := newNATSSink(config.NATSURL, config.NATSSubject)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "NATS sink disabled: %v\n", err)
+		} else {
+			sinks = append(sinks, a.newQueuedSink("nats", sink, config.SinkEvents))
+		}
+	}
+	if config.RedisURL != "" {
+		sink, err := newRedisSink(config.RedisURL, config.RedisChannel)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Redis sink disabled: %v\n", err)
+		} else {
+			sinks = append(sinks, a.newQueuedSink("redis", sink, config.SinkEvents))
+		}
+	}
+	if config.TelegramBotToken != "" && len(config.TelegramBridge) > 0 {
+		bridge := newTelegramBridge(a, config.TelegramBotToken, config.TelegramBridge)
+		a.goSafe("telegram bridge", bridge.pollReplies)
+		sinks = append(sinks, a.newQueuedSink("telegram", bridge, nil))
+	}
+	return sinks
+}
+
+func (a *App) broadcastEvent(eventType string, payload interface{}) {
+	for _, sink := range a.sinks {
+		if err := sink.Emit(eventType, payload); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to emit %s: %v\n", eventType, err)
+		}
+	}
+}
+
+// closeSinks delivers what the outside sinks still have queued and closes
+// them.
+func (a *App) closeSinks() {
+	for _, sink := range a.sinks {
+		if err := sink.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to close event sink: %v\n", err)
+		}
+	}
+}
+
+// socketSink writes events to the socket clients that may see them,
+// redacted for redacted clients.
+type socketSink struct {
+	app *App
+}
+
+func (s *socketSink) Emit(eventType string, payload interface{}) error {
+	event := SocketEvent{Type: eventType, Data: payload}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	var redacted []byte
+	s.app.connMu.RLock()
+	defer s.app.connMu.RUnlock()
+
+	for client := range s.app.socketConns {
+		if !s.app.receivesEvents(client) || !client.receivesEvent(eventType, payload) || !client.subscribed(eventType, payload) {
+			continue
+		}
+		if !client.isRedacted() {
+			client.deliver(data)
+			continue
+		}
+		if redacted == nil {
+			redacted, err = json.Marshal(SocketEvent{Type: eventType, Data: redactData(payload)})
+			if err != nil {
+				continue
+			}
+			redacted = append(redacted, '\n')
+		}
+		client.deliver(redacted)
+	}
+	return nil
+}
+
+func (s *socketSink) Close() error { return nil }
+
+// queuedSink delivers events to a sink from its own goroutine. Events
+// outside EVENT_SINK_EVENTS, and pairing events unless listed there, are
+// skipped, and when the queue is full new events are dropped rather than
+// blocking the daemon.
+type queuedSink struct {
+	app     *App
+	name    string
+	sink    EventSink
+	events  map[string]bool
+	queue   chan queuedEvent
+	done    chan struct{}
+	mu      sync.Mutex
+	dropped int
+	closed  bool
+}
+
+// pairingEvents carry login QR codes and pairing results. Whoever reads a
+// QR code can link their own device, so outside sinks only get these when
+// EVENT_SINK_EVENTS names them.
+var pairingEvents = map[string]bool{"qr": true, "pair_success": true, "pair_error": true}
+
+type queuedEvent struct {
+	eventType string
+	payload   interface{}
+}
+
+func (a *App) newQueuedSink(name string, sink EventSink, events map[string]bool) *queuedSink {
+	q := &queuedSink{
+		app:    a,
+		name:   name,
+		sink:   sink,
+		events: events,
+		queue:  make(chan queuedEvent, sinkQueueSize),
+		done:   make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+func (q *queuedSink) Emit(eventType string, payload interface{}) error {
+	if len(q.events) > 0 && !q.events[eventType] {
+		return nil
+	}
+	if len(q.events) == 0 && pairingEvents[eventType] {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil
+	}
+	select {
+	case q.queue <- queuedEvent{eventType: eventType, payload: payload}:
+		return nil
+	default:
+		q.dropped++
+		return fmt.Errorf("%s sink queue full, %d events dropped", q.name, q.dropped)
+	}
+}
+
+func (q *queuedSink) run() {
+	defer close(q.done)
+	for event := range q.queue {
+		q.emit(event)
+	}
+}
+
+// emit delivers one event. A panic in the sink is recovered here so the
+// queue keeps draining.
+func (q *queuedSink) emit(event queuedEvent) {
+	defer q.app.recoverPanic(q.name + " sink")
+	if err := q.sink.Emit(event.eventType, event.payload); err != nil {
+		fmt.Fprintf(os.Stderr, "%s sink failed on %s: %v\n", q.name, event.eventType, err)
+	}
+}
+
+func (q *queuedSink) Close() error {
+	q.mu.Lock()
+	q.closed = true
+	close(q.queue)
+	q.mu.Unlock()
+
+	select {
+	case <-q.done:
+	case <-time.After(sinkCloseTimeout):
+		fmt.Fprintf(os.Stderr, "Timed out flushing %s sink\n", q.name)
+	}
+	return q.sink.Close()
+}
+
+// fileSink appends events as JSON lines, in the socket's event format.
+type fileSink struct {
+	path string
+}
+
+func (s *fileSink) Emit(eventType string, payload interface{}) error {
+	data, err := json.Marshal(SocketEvent{Type: eventType, Data: payload})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+func (s *fileSink) Close() error { return nil }
+
+// webhookSink posts each event to a URL, in the socket's event format.
+type webhookSink struct {
+	url string
+}
+
+func (s *webhookSink) Emit(eventType string, payload interface{}) error {
+	data, err := json.Marshal(SocketEvent{Type: eventType, Data: payload})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "wacli/"+strings.TrimPrefix(version, "v"))
+
+	client := &http.Client{Timeout: webhookTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *webhookSink) Close() error { return nil }
+
+// mqttSink publishes each event's data as JSON to <topic>/<chat jid>/<event>
+// for events about a chat and to <topic>/<event> for the rest, so
+// subscribers can pick chats with topic filters.
+type mqttSink struct {
+	client mqtt.Client
+	topic  string
+}
+
+func newMQTTSink(brokerURL string, topic string) *mqttSink {
+	if topic == "" {
+		topic = defaultMQTTTopic
+	}
+	hostname, _ := os.Hostname()
+	opts := mqtt.NewClientOptions().
+		AddBroker(brokerURL).
+		SetClientID(fmt.Sprintf("wacli-%s-%d", hostname, os.Getpid())).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOnConnectHandler(func(mqtt.Client) { fmt.Println("Connected to MQTT broker") }).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			fmt.Fprintf(os.Stderr, "MQTT connection lost: %v\n", err)
+		})
+	client := mqtt.NewClient(opts)
+	// With SetConnectRetry an unreachable broker is retried in the
+	// background, and events publish once it is reached
+	client.Connect()
+	return &mqttSink{client: client, topic: strings.TrimSuffix(topic, "/")}
+}
+
+func (s *mqttSink) Emit(eventType string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	topic := s.topic + "/" + eventType
+	if scoped, ok := payload.(chatScoped); ok && scoped.scopeChatJID() != "" {
+		topic = s.topic + "/" + scoped.scopeChatJID() + "/" + eventType
+	}
+	token := s.client.Publish(topic, 1, false, data)
+	if !token.WaitTimeout(mqttPublishTimeout) {
+		return fmt.Errorf("publish to %s timed out", topic)
+	}
+	return token.Error()
+}
+
+func (s *mqttSink) Close() error {
+	s.client.Disconnect(uint(sinkCloseTimeout / time.Millisecond))
+	return nil
+}
@@ -0,0 +1,732 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+func (a *App) startSocketServer() (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(a.cfg().SocketPath), 0700); err != nil {
+		return nil, err
+	}
+	// Only clear the socket file if nobody answers on it, so a restart that
+	// overlaps the old daemon's shutdown doesn't steal its socket
+	if conn, err := net.Dial("unix", a.cfg().SocketPath); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("another daemon is listening on %s", a.cfg().SocketPath)
+	}
+	os.Remove(a.cfg().SocketPath)
+	listener, err := net.Listen("unix", a.cfg().SocketPath)
+	if err != nil {
+		return nil, err
+	}
+	// The file is removed in removeSocketFile, and only if it is still ours
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := applySocketPermissions(a.cfg().SocketPath, a.cfg().SocketMode, a.cfg().SocketGroup); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	if a.socketFile, err = os.Stat(a.cfg().SocketPath); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	a.goSafe("socket listener", func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			a.goSafe("socket client", func() { a.handleSocketConn(&socketClient{conn: conn}) })
+		}
+	})
+
+	return listener, nil
+}
+
+// applySocketPermissions gives the socket WACLI_SOCKET_MODE and
+// WACLI_SOCKET_GROUP. Its directory gets search permission for the classes
+// the mode lets in, since connecting needs both.
+func applySocketPermissions(path string, mode os.FileMode, group string) error {
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			if g, err = user.LookupGroupId(group); err != nil {
+				return fmt.Errorf("unknown socket group %q", group)
+			}
+		}
+		gid, _ := strconv.Atoi(g.Gid)
+		for _, p := range []string{path, filepath.Dir(path)} {
+			if err := os.Chown(p, -1, gid); err != nil {
+				return fmt.Errorf("set socket group: %w", err)
+			}
+		}
+	}
+	if mode == 0 {
+		return nil
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		return fmt.Errorf("set socket mode: %w", err)
+	}
+	dirMode := os.FileMode(0700)
+	if mode&0060 != 0 {
+		dirMode |= 0010
+	}
+	if mode&0006 != 0 {
+		dirMode |= 0001
+	}
+	return os.Chmod(filepath.Dir(path), dirMode)
+}
+
+type SocketCommand struct {
+	Action      string            `json:"action"`
+	RequestID   string            `json:"request_id"`
+	ChatJID     string            `json:"chat_jid"`
+	MessageID   string            `json:"message_id"`
+	MessageIDs  []string          `json:"message_ids"`
+	SenderJID   string            `json:"sender_jid"`
+	Text        string            `json:"text"`
+	Mentions    []string          `json:"mentions"`
+	Question    string            `json:"question"`
+	Options     []string          `json:"options"`
+	MultiSelect bool              `json:"multi_select"`
+	Latitude    *float64          `json:"latitude"`
+	Longitude   *float64          `json:"longitude"`
+	Name        string            `json:"name"`
+	Address     string            `json:"address"`
+	Query       string            `json:"query"`
+	MediaType   string            `json:"media_type"`
+	Link        string            `json:"link"`
+	Limit       int               `json:"limit"`
+	Before      int64             `json:"before"`
+	BeforeID    int64             `json:"before_id"`
+	Since       int64             `json:"since"`
+	Until       int64             `json:"until"`
+	Token       string            `json:"token"`
+	RemindIn    string            `json:"remind_in"`
+	ID          int64             `json:"id"`
+	OlderThan   string            `json:"older_than"`
+	Notes       *string           `json:"notes"`
+	Dates       map[string]string `json:"dates"`
+	Phone       string            `json:"phone"`
+	Duration    string            `json:"duration"`
+	Undo        bool              `json:"undo"`
+	Path        string            `json:"path"`
+	VCard       string            `json:"vcard"`
+	Format      string            `json:"format"`
+	IntoJID     string            `json:"into_jid"`
+	Priority    string            `json:"priority"`
+	Types       []string          `json:"types"`
+	Chats       []string          `json:"chats"`
+	GroupsOnly  bool              `json:"groups_only"`
+	Preview     bool              `json:"preview"`
+	Template    bool              `json:"template"`
+	Vars        map[string]string `json:"vars"`
+}
+
+// socketClient is a connected socket peer. Writes are serialized so
+// broadcasts and streamed query results never interleave mid-line.
+type socketClient struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+	// token is set when the client authenticates and read by broadcasts
+	token    atomic.Pointer[APIToken]
+	redacted atomic.Bool
+	// remote clients came in over TLS and always need to authenticate
+	remote bool
+
+	subscription atomic.Pointer[Subscription]
+
+	// held collects broadcasts while a backlog replay is being written
+	holdMu  sync.Mutex
+	holding bool
+	held    [][]byte
+}
+
+func (c *socketClient) write(data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.conn.Write(data)
+	return err
+}
+
+// deliver writes a broadcast, or queues it while the client is held.
+func (c *socketClient) deliver(data []byte) {
+	c.holdMu.Lock()
+	defer c.holdMu.Unlock()
+	if c.holding {
+		c.held = append(c.held, data)
+		return
+	}
+	c.write(data)
+}
+
+func (c *socketClient) hold() {
+	c.holdMu.Lock()
+	defer c.holdMu.Unlock()
+	c.holding = true
+}
+
+// release writes the broadcasts queued since hold and resumes live delivery.
+func (c *socketClient) release() {
+	c.holdMu.Lock()
+	defer c.holdMu.Unlock()
+	for _, data := range c.held {
+		c.write(data)
+	}
+	c.holding = false
+	c.held = nil
+}
+
+func (c *socketClient) send(event SocketEvent) error {
+	if c.isRedacted() {
+		event.Data = redactData(event.Data)
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return c.write(append(data, '\n'))
+}
+
+func (c *socketClient) respond(cmd *SocketCommand, payload interface{}) error {
+	return c.send(SocketEvent{Type: "result", Action: cmd.Action, RequestID: cmd.RequestID, Data: payload})
+}
+
+type SendResult struct {
+	MessageID string `json:"message_id"`
+	Status    string `json:"status"`
+}
+
+// respondSent answers a send-type command with the new message ID, and
+// whether it was sent or queued until reconnect.
+func (c *socketClient) respondSent(cmd *SocketCommand, id types.MessageID, err error) error {
+	var queued *queuedOfflineError
+	if errors.As(err, &queued) {
+		return c.respond(cmd, SendResult{MessageID: queued.id, Status: "queued"})
+	}
+	if err != nil {
+		return c.respondError(cmd, err)
+	}
+	return c.respond(cmd, SendResult{MessageID: id, Status: outgoingStatusSent})
+}
+
+func (c *socketClient) respondError(cmd *SocketCommand, err error) error {
+	return c.send(SocketEvent{Type: "error", Action: cmd.Action, RequestID: cmd.RequestID, Error: err.Error()})
+}
+
+func (a *App) handleSocketConn(client *socketClient) {
+	a.connMu.Lock()
+	a.socketConns[client] = struct{}{}
+	a.connMu.Unlock()
+
+	defer func() {
+		a.connMu.Lock()
+		delete(a.socketConns, client)
+		a.connMu.Unlock()
+		client.conn.Close()
+	}()
+
+	client.send(SocketEvent{Type: "hello", Data: a.versionInfo()})
+
+	scanner := bufio.NewScanner(client.conn)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		var cmd SocketCommand
+		if err := json.Unmarshal(line, &cmd); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to parse socket command: %v\n", err)
+			continue
+		}
+
+		a.handleSocketCommand(client, &cmd)
+	}
+}
+
+func (a *App) handleSocketCommand(client *socketClient, cmd *SocketCommand) {
+	defer a.recoverPanic("socket command " + cmd.Action)
+
+	if cmd.Action == "auth" {
+		a.authenticate(client, cmd)
+		return
+	}
+	if err := a.authorize(client, cmd); err != nil {
+		client.respondError(cmd, err)
+		return
+	}
+	priority, err := parseSendPriority(cmd.Priority)
+	if err != nil {
+		client.respondError(cmd, err)
+		return
+	}
+
+	switch cmd.Action {
+	case "send":
+		text, err := a.renderSendText(cmd, cmd.ChatJID)
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		id, err := a.sendMessage(cmd.ChatJID, text, cmd.Mentions, priority)
+		if err != nil && !isQueuedOffline(err) {
+			fmt.Fprintf(os.Stderr, "Failed to send message: %v\n", err)
+		}
+		a.logSent(client, cmd, cmd.ChatJID, text, id, err)
+		client.respondSent(cmd, id, err)
+	case "reply":
+		text, err := a.renderSendText(cmd, cmd.ChatJID)
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		id, err := a.replyToMessage(cmd.ChatJID, cmd.MessageID, cmd.SenderJID, text, priority)
+		if err != nil && !isQueuedOffline(err) {
+			fmt.Fprintf(os.Stderr, "Failed to reply to message: %v\n", err)
+		}
+		a.logSent(client, cmd, cmd.ChatJID, text, id, err)
+		client.respondSent(cmd, id, err)
+	case "reply_to_id":
+		target, err := a.messageByRowID(cmd.ID)
+		if err == nil {
+			if tenant := client.tenantChats(); tenant != nil && !containsString(tenant, target.ChatJID) {
+				err = fmt.Errorf("token %q may not access %s", client.token.Load().Name, target.ChatJID)
+			}
+		}
+		var text string
+		if err == nil {
+			text, err = a.renderSendText(cmd, target.ChatJID)
+		}
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		id, err := a.replyToMessage(target.ChatJID, target.MessageID, target.SenderJID, text, priority)
+		if err != nil && !isQueuedOffline(err) {
+			fmt.Fprintf(os.Stderr, "Failed to reply to message: %v\n", err)
+		}
+		a.logSent(client, cmd, target.ChatJID, text, id, err)
+		client.respondSent(cmd, id, err)
+	case "react":
+		id, err := a.sendReaction(cmd.ChatJID, cmd.MessageID, cmd.SenderJID, cmd.Text, priority)
+		if err != nil && !isQueuedOffline(err) {
+			fmt.Fprintf(os.Stderr, "Failed to react to message: %v\n", err)
+		}
+		a.logSent(client, cmd, cmd.ChatJID, cmd.Text, id, err)
+		client.respondSent(cmd, id, err)
+	case "post_status":
+		id, err := a.postStatus(cmd.Text, cmd.Path, priority)
+		if err != nil && !isQueuedOffline(err) {
+			fmt.Fprintf(os.Stderr, "Failed to post status: %v\n", err)
+		}
+		a.logSent(client, cmd, types.StatusBroadcastJID.String(), cmd.Text, id, err)
+		client.respondSent(cmd, id, err)
+	case "status_views":
+		posts, err := a.statusViews(cmd.MessageID, cmd.Since)
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, posts)
+	case "send_bulk":
+		a.streamBulkSend(client, cmd)
+	case "send_poll":
+		id, err := a.sendPoll(cmd.ChatJID, cmd.Question, cmd.Options, cmd.MultiSelect, priority)
+		if err != nil && !isQueuedOffline(err) {
+			fmt.Fprintf(os.Stderr, "Failed to send poll: %v\n", err)
+		}
+		a.logSent(client, cmd, cmd.ChatJID, "[Poll] "+cmd.Question, id, err)
+		client.respondSent(cmd, id, err)
+	case "send_location":
+		// Missing coordinates would otherwise send a pin at 0,0
+		if cmd.Latitude == nil || cmd.Longitude == nil {
+			client.respondError(cmd, errors.New("latitude and longitude are required"))
+			return
+		}
+		id, err := a.sendLocation(cmd.ChatJID, *cmd.Latitude, *cmd.Longitude, cmd.Name, cmd.Address, priority)
+		if err != nil && !isQueuedOffline(err) {
+			fmt.Fprintf(os.Stderr, "Failed to send location: %v\n", err)
+		}
+		a.logSent(client, cmd, cmd.ChatJID, formatLocation("[Location]", *cmd.Latitude, *cmd.Longitude, cmd.Name, cmd.Address), id, err)
+		client.respondSent(cmd, id, err)
+	case "send_voice":
+		id, err := a.sendVoice(cmd.ChatJID, cmd.Path, priority)
+		if err != nil && !isQueuedOffline(err) {
+			fmt.Fprintf(os.Stderr, "Failed to send voice note: %v\n", err)
+		}
+		a.logSent(client, cmd, cmd.ChatJID, "[Voice Message]", id, err)
+		client.respondSent(cmd, id, err)
+	case "send_sticker":
+		id, err := a.sendSticker(cmd.ChatJID, cmd.Path, priority)
+		if err != nil && !isQueuedOffline(err) {
+			fmt.Fprintf(os.Stderr, "Failed to send sticker: %v\n", err)
+		}
+		a.logSent(client, cmd, cmd.ChatJID, "[Sticker]", id, err)
+		client.respondSent(cmd, id, err)
+	case "send_contact":
+		id, err := a.sendContact(cmd.ChatJID, cmd.Name, cmd.Phone, cmd.VCard, priority)
+		if err != nil && !isQueuedOffline(err) {
+			fmt.Fprintf(os.Stderr, "Failed to send contact: %v\n", err)
+		}
+		a.logSent(client, cmd, cmd.ChatJID, "[Contact] "+cmd.Name, id, err)
+		client.respondSent(cmd, id, err)
+	case "mark_read":
+		ids := cmd.MessageIDs
+		if cmd.MessageID != "" {
+			ids = append(ids, cmd.MessageID)
+		}
+		result, err := a.markRead(cmd.ChatJID, cmd.SenderJID, ids)
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, result)
+	case "remind":
+		reminder, err := a.addReminder(cmd.ChatJID, cmd.MessageID, cmd.RemindIn)
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, reminder)
+	case "list_reminders":
+		reminders, err := a.listReminders()
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, filterChats(reminders, client.tenantChats()))
+	case "cancel_reminder":
+		if err := a.cancelReminder(cmd.ID, client.tenantChats()); err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, nil)
+	case "status":
+		client.respond(cmd, a.status())
+	case "autoreply":
+		state, err := a.setAutoReply(cmd.Text, cmd.Duration, cmd.Undo)
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, state)
+	case "panic_stop", "resume_automation":
+		state, err := a.setAutomationStopped(cmd.Action == "panic_stop")
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, state)
+	case "list_chats":
+		chats, err := a.listChats()
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, filterChats(chats, client.tenantChats()))
+	case "sender_info":
+		info, err := a.senderInfo(cmd.SenderJID)
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, info)
+	case "set_contact_info":
+		if err := a.setContactInfo(cmd.SenderJID, cmd.Notes, cmd.Dates); err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		info, err := a.senderInfo(cmd.SenderJID)
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, info)
+	case "subscribe":
+		client.respond(cmd, a.subscribe(client, cmd))
+	case "redact":
+		if token := client.token.Load(); cmd.Undo && token != nil && token.Redacted {
+			client.respondError(cmd, fmt.Errorf("token %q is always redacted", token.Name))
+			return
+		}
+		client.redacted.Store(!cmd.Undo)
+		client.respond(cmd, map[string]bool{"redacted": client.isRedacted()})
+	case "mute_chat", "archive_chat", "pin_chat":
+		settings, err := a.updateChatSettings(cmd.Action, cmd.ChatJID, cmd.Duration, cmd.Undo)
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, settings)
+	case "set_disappearing":
+		timer, err := a.setDisappearing(cmd.ChatJID, cmd.Duration)
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, timer)
+	case "resolve":
+		lookup, err := a.lookupPhone(cmd.Phone)
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, lookup)
+	case "resolve_lid":
+		mapping, err := a.resolveLID(cmd.SenderJID)
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, mapping)
+	case "open_chat":
+		open, err := a.openChat(cmd)
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, open)
+	case "unreplied":
+		olderThan, err := time.ParseDuration(cmd.OlderThan)
+		if err != nil {
+			client.respondError(cmd, fmt.Errorf("invalid older_than %q", cmd.OlderThan))
+			return
+		}
+		chats, err := a.unrepliedChats(olderThan)
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, filterChats(chats, client.tenantChats()))
+	case "heatmap":
+		heatmap, err := a.heatmap(cmd.ChatJID, cmd.Since, cmd.Until)
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		heatmap.Chats = filterChats(heatmap.Chats, client.tenantChats())
+		client.respond(cmd, heatmap)
+	case "availability":
+		availability, err := a.availability(cmd.SenderJID)
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, availability)
+	case "get_invite_link", "revoke_invite_link":
+		link, err := a.inviteLink(cmd.ChatJID, cmd.Action == "revoke_invite_link")
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, link)
+	case "join_via_link":
+		joined, err := a.joinViaLink(cmd.Link)
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, joined)
+	case "accept_invite":
+		joined, err := a.acceptInvite(cmd.ChatJID, cmd.MessageID)
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, joined)
+	case "follow_newsletter", "unfollow_newsletter":
+		info, err := a.followNewsletter(cmd.ChatJID, cmd.Action == "follow_newsletter")
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, info)
+	case "get_profile":
+		profile, err := a.getProfile()
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, profile)
+	case "set_name", "set_about":
+		var profile *Profile
+		var err error
+		if cmd.Action == "set_name" {
+			profile, err = a.setName(cmd.Name)
+		} else {
+			profile, err = a.setAbout(cmd.Text)
+		}
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, profile)
+	case "get_avatar":
+		if cmd.ChatJID == "" {
+			client.respondError(cmd, fmt.Errorf("get_avatar requires chat_jid"))
+			return
+		}
+		avatar, err := a.getAvatar(normalizeJID(cmd.ChatJID), cmd.Preview)
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, avatar)
+	case "set_avatar":
+		if cmd.Path == "" {
+			client.respondError(cmd, fmt.Errorf("set_avatar requires path"))
+			return
+		}
+		avatar, err := a.setAvatar(cmd.Path)
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, avatar)
+	case "list_communities":
+		communities, err := a.loadCommunities()
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, filterChats(communities, client.tenantChats()))
+	case "community_groups":
+		if cmd.ChatJID == "" {
+			client.respondError(cmd, fmt.Errorf("community_groups requires chat_jid"))
+			return
+		}
+		groups, err := a.communityGroups(normalizeJID(cmd.ChatJID))
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, groups)
+	case "merge_chats":
+		if cmd.ChatJID == "" || cmd.IntoJID == "" {
+			client.respondError(cmd, fmt.Errorf("merge_chats requires chat_jid and into_jid"))
+			return
+		}
+		merge, err := a.mergeChats(normalizeJID(cmd.ChatJID), normalizeJID(cmd.IntoJID), mergeSourceManual)
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, merge)
+	case "backlog":
+		a.streamBacklog(client, cmd)
+	case "reply_latency":
+		report, err := a.replyLatency(a.canonicalChat(cmd.ChatJID), cmd.Since, client.tenantChats())
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, report)
+	case "save_draft":
+		draft, err := a.saveDraft(cmd.ChatJID, cmd.Text)
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, draft)
+	case "get_draft":
+		draft, err := a.getDraft(cmd.ChatJID)
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, draft)
+	case "list_drafts":
+		drafts, err := a.listDrafts()
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, filterChats(drafts, client.tenantChats()))
+	case "sent_history":
+		entries, err := a.sentHistory(cmd.ChatJID, cmd.Since, cmd.Before, cmd.Limit, client.tenantChats())
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, entries)
+	case "history":
+		a.streamHistory(client, cmd)
+	case "search":
+		a.streamSearch(client, cmd)
+	case "list_pinned":
+		a.streamPinned(client, cmd)
+	case "star":
+		update, err := a.starMessage(cmd.ChatJID, cmd.MessageID, !cmd.Undo)
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, update)
+	case "list_starred":
+		a.streamStarred(client, cmd)
+	case "retain_chat":
+		retained, err := a.retainChat(cmd.ChatJID, cmd.Undo)
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, retained)
+	case "list_retained":
+		chats, err := a.retainedChats()
+		if err != nil {
+			client.respondError(cmd, err)
+			return
+		}
+		client.respond(cmd, filterChats(chats, client.tenantChats()))
+	case "export":
+		a.streamExport(client, cmd)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown socket command: %s\n", cmd.Action)
+		// Clients wait for an answer to every request
+		client.respondError(cmd, fmt.Errorf("unknown action %q", cmd.Action))
+	}
+}
+
+// SocketEvent is a line written to socket clients. Broadcast events only
+// carry Type and Data; responses to commands echo the action and request ID.
+type SocketEvent struct {
+	Type      string      `json:"type"`
+	Action    string      `json:"action,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+func (a *App) broadcastMessage(msg *Message, decision RuleDecision) {
+	if decision.Broadcast {
+		a.broadcastEvent(messageEventType(msg), msg)
+	}
+	if msg.IsHighlight {
+		a.broadcastEvent("highlight", msg)
+	}
+	if !decision.Attention {
+		return
+	}
+
+	if attention := a.currentAttention(); attention != nil {
+		if err := attention.Notify(msg); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to send attention: %v\n", err)
+		}
+	}
+	a.notifyMessage(msg)
+}
+
+func (a *App) broadcastCall(call *Call) {
+	a.broadcastEvent("call", call)
+	a.notifyCall(call)
+}
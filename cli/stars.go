@@ -0,0 +1,105 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+
+	"go.mau.fi/whatsmeow/appstate"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Starred messages are exempt from trimming and partition retention, so
+// they stay in the archive for as long as they are starred. With
+// STAR_SYNC the star is also set on WhatsApp, and stars set on your other
+// devices are picked up either way.
+
+type StarUpdate struct {
+	ChatJID   string `json:"chat_jid"`
+	MessageID string `json:"message_id"`
+	Starred   bool   `json:"starred"`
+}
+
+func (s StarUpdate) scopeChatJID() string { return s.ChatJID }
+
+// starMessage stars or unstars a stored message.
+func (a *App) starMessage(chatJID string, messageID string, starred bool) (StarUpdate, error) {
+	update := StarUpdate{ChatJID: a.canonicalChat(chatJID), MessageID: messageID, Starred: starred}
+	if messageID == "" {
+		return update, fmt.Errorf("message_id is required")
+	}
+
+	var senderJID string
+	err := a.msgDB.QueryRow(fmt.Sprintf(
+		"SELECT sender_jid FROM %s WHERE chat_jid = ? AND message_id = ? LIMIT 1", messagesView,
+	), update.ChatJID, messageID).Scan(&senderJID)
+	if err == sql.ErrNoRows {
+		return update, fmt.Errorf("no stored message %s in %s", messageID, update.ChatJID)
+	} else if err != nil {
+		return update, err
+	}
+
+	if a.cfg().StarSync {
+		chat, err := types.ParseJID(chatJID)
+		if err != nil {
+			return update, fmt.Errorf("invalid chat JID: %w", err)
+		}
+		sender, err := types.ParseJID(senderJID)
+		if err != nil {
+			return update, fmt.Errorf("invalid sender JID: %w", err)
+		}
+		// Only incoming messages are stored, so none of them are from you
+		patch := appstate.BuildStar(chat, sender.ToNonAD(), messageID, false, starred)
+		if err := a.client.SendAppState(a.ctx, patch); err != nil {
+			return update, fmt.Errorf("star failed: %w", err)
+		}
+	}
+
+	if err := a.flagStarred(update); err != nil {
+		return update, err
+	}
+	a.broadcastEvent("star", update)
+	return update, nil
+}
+
+// handleStar applies a star set or cleared on another device.
+func (a *App) handleStar(evt *events.Star) {
+	update := StarUpdate{
+		ChatJID:   a.canonicalChat(evt.ChatJID.String()),
+		MessageID: evt.MessageID,
+		Starred:   evt.Action.GetStarred(),
+	}
+	if err := a.flagStarred(update); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to flag starred message: %v\n", err)
+		return
+	}
+	if !evt.FromFullSync {
+		a.broadcastEvent("star", update)
+	}
+}
+
+func (a *App) flagStarred(update StarUpdate) error {
+	_, err := a.updateMessageTables(
+		"UPDATE %s SET is_starred = ? WHERE chat_jid = ? AND message_id = ?",
+		update.Starred, update.ChatJID, update.MessageID,
+	)
+	return err
+}
+
+// streamStarred lists starred messages in the history format, of one chat
+// or all of them.
+func (a *App) streamStarred(client *socketClient, cmd *SocketCommand) {
+	where := []string{"is_starred = 1"}
+	var args []interface{}
+	if cmd.ChatJID != "" {
+		where = append(where, "chat_jid = ?")
+		args = append(args, a.canonicalChat(cmd.ChatJID))
+	}
+	if chats := client.tenantChats(); chats != nil {
+		cond, chatArgs := inChats(chats)
+		where = append(where, cond)
+		args = append(args, chatArgs...)
+	}
+	a.streamMessages(client, cmd, where, args)
+}
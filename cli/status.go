@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+type Status struct {
+	Connected     bool            `json:"connected"`
+	Connection    ConnectionState `json:"connection"`
+	LoggedIn      bool            `json:"logged_in"`
+	JID           string          `json:"jid"`
+	PushName      string          `json:"push_name"`
+	UptimeSeconds int64           `json:"uptime_seconds"`
+	MessageCount  int64           `json:"message_count"`
+	DBError       string          `json:"db_error,omitempty"`
+	SocketClients int             `json:"socket_clients"`
+	LastEventAt   int64           `json:"last_event_at"`
+	Automation    AutomationState `json:"automation"`
+	AutoReply     AutoReplyState  `json:"autoreply"`
+	SendQueue     map[string]int  `json:"send_queue,omitempty"`
+	OfflineQueue  int             `json:"offline_queue"`
+	Versions      VersionInfo     `json:"versions"`
+}
+
+func (a *App) status() Status {
+	status := Status{
+		Connected:     a.client.IsConnected(),
+		Connection:    a.connectionState(),
+		LoggedIn:      a.client.IsLoggedIn(),
+		PushName:      a.client.Store.PushName,
+		UptimeSeconds: int64(time.Since(a.startedAt).Seconds()),
+		LastEventAt:   a.lastEventAt.Load(),
+		Automation:    a.automationState(),
+		AutoReply:     a.autoReplyState(),
+		OfflineQueue:  a.offlineQueueLength(),
+		Versions:      a.versionInfo(),
+	}
+	if a.sendQueue != nil {
+		status.SendQueue = a.sendQueue.pending()
+	}
+	if a.client.Store.ID != nil {
+		status.JID = a.client.Store.ID.String()
+	}
+
+	err := a.msgDB.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", messagesView)).Scan(&status.MessageCount)
+	if err != nil {
+		status.DBError = err.Error()
+	}
+
+	a.connMu.RLock()
+	status.SocketClients = len(a.socketConns)
+	a.connMu.RUnlock()
+
+	return status
+}
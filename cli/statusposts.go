@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// Text statuses need a background to render; WhatsApp's default teal
+	statusBackgroundARGB = 0xFF128C7E
+	statusTextARGB       = 0xFFFFFFFF
+	// Statuses expire after a day, so that's what status_views covers by default
+	statusLifetime = 24 * time.Hour
+)
+
+// StatusViewer is someone who viewed one of your status posts.
+type StatusViewer struct {
+	ViewerJID  string `json:"viewer_jid"`
+	ViewerName string `json:"viewer_name"`
+	ViewedAt   int64  `json:"viewed_at"`
+}
+
+// StatusPost is a status you posted, from wacli or another device, with
+// the viewers whose read receipts arrived while the daemon was running.
+type StatusPost struct {
+	MessageID string         `json:"message_id"`
+	Timestamp int64          `json:"timestamp"`
+	Text      string         `json:"text"`
+	Viewers   []StatusViewer `json:"viewers"`
+}
+
+// StatusView is broadcast as status_viewed.
+type StatusView struct {
+	MessageIDs []string `json:"message_ids"`
+	StatusViewer
+}
+
+// postStatus publishes a status update to your contacts, following your
+// status privacy settings. With a path it posts the image and uses text as
+// its caption.
+func (a *App) postStatus(text string, path string, priority sendPriority) (types.MessageID, error) {
+	var msg *waE2E.Message
+	if path == "" {
+		if strings.TrimSpace(text) == "" {
+			return "", fmt.Errorf("post_status requires text or path")
+		}
+		msg = &waE2E.Message{ExtendedTextMessage: &waE2E.ExtendedTextMessage{
+			Text:           proto.String(text),
+			BackgroundArgb: proto.Uint32(statusBackgroundARGB),
+			TextArgb:       proto.Uint32(statusTextARGB),
+			Font:           waE2E.ExtendedTextMessage_SYSTEM.Enum(),
+		}}
+	} else {
+		photo, err := a.statusImage(path)
+		if err != nil {
+			return "", err
+		}
+		if text != "" {
+			photo.Caption = proto.String(text)
+		}
+		msg = &waE2E.Message{ImageMessage: photo}
+	}
+
+	resp, err := a.sendToChat(types.StatusBroadcastJID, msg, priority)
+	if err != nil {
+		return "", fmt.Errorf("post status failed: %w", err)
+	}
+
+	fmt.Println("Posted status")
+	return resp.ID, nil
+}
+
+// statusImage uploads a local image, converted to JPEG unless it already is
+// one, with the inline thumbnail WhatsApp shows while it loads.
+func (a *App) statusImage(path string) (*waE2E.ImageMessage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	if http.DetectContentType(data) != "image/jpeg" {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+			return nil, err
+		}
+		data = buf.Bytes()
+	}
+	thumbnail, _, err := encodeThumbnail(img, inlineThumbnailSize)
+	if err != nil {
+		return nil, err
+	}
+
+	upload, err := a.client.Upload(a.ctx, data, whatsmeow.MediaImage)
+	if err != nil {
+		return nil, fmt.Errorf("upload failed: %w", err)
+	}
+	bounds := img.Bounds()
+	return &waE2E.ImageMessage{
+		URL:           proto.String(upload.URL),
+		DirectPath:    proto.String(upload.DirectPath),
+		MediaKey:      upload.MediaKey,
+		FileEncSHA256: upload.FileEncSHA256,
+		FileSHA256:    upload.FileSHA256,
+		FileLength:    proto.Uint64(upload.FileLength),
+		Mimetype:      proto.String("image/jpeg"),
+		Width:         proto.Uint32(uint32(bounds.Dx())),
+		Height:        proto.Uint32(uint32(bounds.Dy())),
+		JPEGThumbnail: thumbnail,
+	}, nil
+}
+
+// recordStatusViews keeps who viewed your status from their read receipts.
+func (a *App) recordStatusViews(evt *events.Receipt) {
+	viewer := evt.Sender.ToNonAD()
+	view := StatusView{StatusViewer: StatusViewer{
+		ViewerJID:  viewer.String(),
+		ViewerName: a.getContactName(viewer),
+		ViewedAt:   evt.Timestamp.Unix(),
+	}}
+	for _, id := range evt.MessageIDs {
+		res, err := a.msgDB.Exec(
+			"INSERT OR IGNORE INTO status_views (message_id, viewer_jid, viewed_at) VALUES (?, ?, ?)",
+			id, view.ViewerJID, view.ViewedAt,
+		)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to record status view: %v\n", err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			view.MessageIDs = append(view.MessageIDs, id)
+		}
+	}
+	if len(view.MessageIDs) > 0 {
+		a.broadcastEvent("status_viewed", view)
+	}
+}
+
+// statusViews lists your status posts since the given time, newest first,
+// with their viewers in the order they looked. An empty messageID covers
+// all posts.
+func (a *App) statusViews(messageID string, since int64) ([]StatusPost, error) {
+	if since == 0 {
+		since = time.Now().Add(-statusLifetime).Unix()
+	}
+	query := "SELECT message_id, timestamp, text FROM outgoing_messages WHERE chat_jid = ? AND timestamp >= ? AND status != ?"
+	args := []interface{}{types.StatusBroadcastJID.String(), since, outgoingStatusFailed}
+	if messageID != "" {
+		query += " AND message_id = ?"
+		args = append(args, messageID)
+	}
+	rows, err := a.msgDB.Query(query+" ORDER BY timestamp DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	posts := []StatusPost{}
+	for rows.Next() {
+		post := StatusPost{Viewers: []StatusViewer{}}
+		if err := rows.Scan(&post.MessageID, &post.Timestamp, &post.Text); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range posts {
+		rows, err := a.msgDB.Query("SELECT viewer_jid, viewed_at FROM status_views WHERE message_id = ? ORDER BY viewed_at", posts[i].MessageID)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var viewer StatusViewer
+			if err := rows.Scan(&viewer.ViewerJID, &viewer.ViewedAt); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if jid, err := types.ParseJID(viewer.ViewerJID); err == nil {
+				viewer.ViewerName = a.getContactName(jid)
+			}
+			posts[i].Viewers = append(posts[i].Viewers, viewer)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return posts, nil
+}
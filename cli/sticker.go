@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	stickerSize     = 512
+	stickerMimetype = "image/webp"
+)
+
+// sendSticker converts a local image with ffmpeg to a 512x512 WebP, scaled
+// to fit and padded with transparency, and sends it as a sticker.
+func (a *App) sendSticker(chatJID string, path string, priority sendPriority) (types.MessageID, error) {
+	jid, err := types.ParseJID(chatJID)
+	if err != nil {
+		return "", fmt.Errorf("invalid JID: %w", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+
+	filter := fmt.Sprintf(
+		"scale=%[1]d:%[1]d:force_original_aspect_ratio=decrease,format=rgba,pad=%[1]d:%[1]d:(ow-iw)/2:(oh-ih)/2:color=black@0",
+		stickerSize,
+	)
+	webp, err := a.ffmpeg("-i", path, "-vf", filter, "-frames:v", "1", "-c:v", "libwebp", "-q:v", "80", "-f", "webp", "pipe:1")
+	if err != nil {
+		return "", fmt.Errorf("convert failed: %w", err)
+	}
+
+	upload, err := a.client.Upload(a.ctx, webp, whatsmeow.MediaImage)
+	if err != nil {
+		return "", fmt.Errorf("upload failed: %w", err)
+	}
+
+	msg := &waE2E.Message{StickerMessage: &waE2E.StickerMessage{
+		URL:           proto.String(upload.URL),
+		DirectPath:    proto.String(upload.DirectPath),
+		MediaKey:      upload.MediaKey,
+		FileEncSHA256: upload.FileEncSHA256,
+		FileSHA256:    upload.FileSHA256,
+		FileLength:    proto.Uint64(upload.FileLength),
+		Mimetype:      proto.String(stickerMimetype),
+		Width:         proto.Uint32(stickerSize),
+		Height:        proto.Uint32(stickerSize),
+	}}
+
+	resp, err := a.sendToChat(jid, msg, priority)
+	if err != nil {
+		return "", fmt.Errorf("send sticker failed: %w", err)
+	}
+
+	fmt.Printf("Sent sticker to %s\n", chatJID)
+	return resp.ID, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const storageCheckInterval = time.Minute
+
+type StorageStatus struct {
+	Path           string `json:"path"`
+	FreeBytes      uint64 `json:"free_bytes"`
+	ThresholdBytes uint64 `json:"threshold_bytes"`
+}
+
+// watchStorage checks free space on the data volume. Below the threshold
+// media downloads pause and the archive is trimmed harder, so inserts keep
+// succeeding instead of failing once the disk is full.
+func (a *App) watchStorage() {
+	if a.cfg().LowDiskThreshold == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(storageCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		a.checkStorage()
+		<-ticker.C
+	}
+}
+
+func (a *App) checkStorage() {
+	free, err := diskFree(a.cfg().DataDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to check free disk space: %v\n", err)
+		return
+	}
+
+	status := StorageStatus{
+		Path:           a.cfg().DataDir,
+		FreeBytes:      free,
+		ThresholdBytes: a.cfg().LowDiskThreshold,
+	}
+	low := free < a.cfg().LowDiskThreshold
+	wasLow := a.storageLow.Swap(low)
+
+	if low {
+		if !wasLow {
+			fmt.Fprintf(os.Stderr, "Low disk space: %d MB free, pausing media downloads\n", free/1024/1024)
+			a.broadcastEvent("storage_low", status)
+		}
+		if err := a.trimForLowDisk(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to trim for low disk space: %v\n", err)
+		}
+	} else if wasLow {
+		fmt.Println("Disk space recovered, resuming media downloads")
+		a.broadcastEvent("storage_ok", status)
+	}
+}
+
+// mediaDownloadsAllowed reports whether media may be written to disk.
+func (a *App) mediaDownloadsAllowed() bool {
+	return !a.storageLow.Load()
+}
+
+// trimForLowDisk frees space in the message database: with partitioning
+// the oldest month with unstarred messages is expired on each check while
+// space stays low, otherwise messages and calls are cut down to the trim
+// target right away.
+func (a *App) trimForLowDisk() error {
+	if a.cfg().PartitionByMonth {
+		partitions, err := listPartitions(a.msgDB.DB)
+		if err != nil {
+			return err
+		}
+		for _, partition := range partitions {
+			removed, err := a.expirePartition(partition)
+			if err != nil {
+				return err
+			}
+			if removed {
+				fmt.Printf("Expired message partition %s to free disk space\n", partition)
+				return nil
+			}
+		}
+		return nil
+	}
+
+	return a.pruneTables(true)
+}
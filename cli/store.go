@@ -0,0 +1,246 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+)
+
+// Store persists messages and calls. messages.db is always the local
+// store: the TUI reads it and edits, reactions, receipts and the rest of
+// the daemon work on it. With WACLI_DB_DSN every message and call is also
+// written to a Postgres or MySQL archive, which keeps everything and can
+// be queried by several tools at once.
+type Store interface {
+	SaveMessage(msg *Message) error
+	SaveCall(call *Call) error
+	Close() error
+}
+
+// sqliteStore writes to messages.db through its writer goroutine.
+type sqliteStore struct {
+	db *DB
+}
+
+func (s *sqliteStore) SaveMessage(msg *Message) error {
+	query, values := insertQuery("messages", msg, sqlitePlaceholder)
+	id, err := s.db.insert(query, values...)
+	if err != nil {
+		return err
+	}
+	msg.ID = id
+	return nil
+}
+
+func (s *sqliteStore) SaveCall(call *Call) error {
+	query, values := insertQuery("calls", call, sqlitePlaceholder)
+	id, err := s.db.insert(query, values...)
+	if err != nil {
+		return err
+	}
+	call.ID = id
+	return nil
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
+
+// archiveStore saves to the local store first, so IDs and everything
+// reading messages.db stay as they are, then copies the row to the
+// archive. A failed copy is reported but doesn't fail the save; the row
+// is missing from the archive only.
+type archiveStore struct {
+	Store
+	db      *sql.DB
+	dialect string
+}
+
+func (s *archiveStore) SaveMessage(msg *Message) error {
+	if err := s.Store.SaveMessage(msg); err != nil {
+		return err
+	}
+	s.archive("messages", msg)
+	return nil
+}
+
+func (s *archiveStore) SaveCall(call *Call) error {
+	if err := s.Store.SaveCall(call); err != nil {
+		return err
+	}
+	s.archive("calls", call)
+	return nil
+}
+
+func (s *archiveStore) archive(table string, record interface{}) {
+	query, values := insertQuery(table, record, s.placeholder)
+	if _, err := s.db.Exec(query, values...); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to archive %s row: %v\n", table, err)
+	}
+}
+
+func (s *archiveStore) Close() error {
+	s.db.Close()
+	return s.Store.Close()
+}
+
+func (s *archiveStore) placeholder(n int) string {
+	if s.dialect == "postgres" {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
+
+func sqlitePlaceholder(int) string {
+	return "?"
+}
+
+// insertQuery builds an INSERT of a record's columns, numbering
+// placeholders from 1 for drivers that need it.
+func insertQuery(table string, record interface{}, placeholder func(int) string) (string, []interface{}) {
+	columns, placeholders, values := buildInsertParams(record)
+	for i := range placeholders {
+		placeholders[i] = placeholder(i + 1)
+	}
+	return fmt.Sprintf(
+		"INSERT INTO %s (%s) VALUES (%s)",
+		table,
+		strings.Join(columns, ", "),
+		strings.Join(placeholders, ", "),
+	), values
+}
+
+// openStore returns the store for messages.db, wrapped in the archive
+// named by WACLI_DB_DSN if there is one.
+func openStore(config Config, msgDB *DB) (Store, error) {
+	local := &sqliteStore{db: msgDB}
+	if config.DBDSN == "" {
+		return local, nil
+	}
+
+	var dialect, dsn string
+	switch {
+	case strings.HasPrefix(config.DBDSN, "postgres://"), strings.HasPrefix(config.DBDSN, "postgresql://"):
+		dialect, dsn = "postgres", config.DBDSN
+	case strings.HasPrefix(config.DBDSN, "mysql://"):
+		dialect, dsn = "mysql", strings.TrimPrefix(config.DBDSN, "mysql://")
+	default:
+		return nil, fmt.Errorf("WACLI_DB_DSN must start with postgres:// or mysql://")
+	}
+
+	db, err := sql.Open(dialect, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connect to %s: %w", dialect, err)
+	}
+	s := &archiveStore{Store: local, db: db, dialect: dialect}
+	for table, record := range map[string]interface{}{"messages": Message{}, "calls": Call{}} {
+		if err := s.migrate(table, record); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("migrate %s: %w", table, err)
+		}
+	}
+	return s, nil
+}
+
+// migrate creates an archive table with a column per record field and
+// adds the columns of fields added since, the way messageMigrations does
+// for messages.db.
+func (s *archiveStore) migrate(table string, record interface{}) error {
+	t := reflect.TypeOf(record)
+	var columns, types []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("json")
+		if name == "" || name == "id" {
+			continue
+		}
+		columns = append(columns, name)
+		types = append(types, archiveColumnType(field.Type))
+	}
+
+	defs := []string{"id BIGSERIAL PRIMARY KEY"}
+	if s.dialect == "mysql" {
+		defs = []string{"id BIGINT AUTO_INCREMENT PRIMARY KEY"}
+	}
+	for i, column := range columns {
+		defs = append(defs, column+" "+types[i])
+	}
+	if s.dialect == "mysql" {
+		defs = append(defs, "INDEX idx_timestamp (timestamp)")
+	}
+	if _, err := s.db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", table, strings.Join(defs, ", "))); err != nil {
+		return err
+	}
+	if s.dialect == "postgres" {
+		if _, err := s.db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%[1]s_timestamp ON %[1]s (timestamp)", table)); err != nil {
+			return err
+		}
+	}
+
+	schema := "current_schema()"
+	if s.dialect == "mysql" {
+		schema = "DATABASE()"
+	}
+	rows, err := s.db.Query(fmt.Sprintf(
+		"SELECT column_name FROM information_schema.columns WHERE table_schema = %s AND table_name = %s",
+		schema, s.placeholder(1),
+	), table)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[strings.ToLower(name)] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i, column := range columns {
+		if existing[column] {
+			continue
+		}
+		if _, err := s.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, types[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archiveColumnType maps a record field to a column type both Postgres and
+// MySQL understand. Columns are nullable so they can be added to tables
+// that already hold rows.
+func archiveColumnType(t reflect.Type) string {
+	if t.Implements(reflect.TypeOf((*driver.Valuer)(nil)).Elem()) {
+		return "TEXT"
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "BOOLEAN"
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return "BIGINT"
+	case reflect.Float32, reflect.Float64:
+		return "DOUBLE PRECISION"
+	default:
+		return "TEXT"
+	}
+}
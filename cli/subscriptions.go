@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Subscription narrows the broadcasts a socket client receives, so a bot
+// watching one group isn't sent the whole inbox. Empty fields don't
+// filter. It only narrows: tenants still see nothing beyond their chats.
+type Subscription struct {
+	Types      []string `json:"types"`
+	Chats      []string `json:"chats"`
+	GroupsOnly bool     `json:"groups_only"`
+}
+
+func (a *App) subscribe(client *socketClient, cmd *SocketCommand) Subscription {
+	sub := Subscription{Types: cmd.Types, GroupsOnly: cmd.GroupsOnly}
+	for _, chat := range cmd.Chats {
+		sub.Chats = append(sub.Chats, normalizeJID(chat))
+	}
+	client.subscription.Store(&sub)
+	return sub
+}
+
+// subscribed reports whether a broadcast matches the client's
+// subscription. shutdown always goes out so clients know to reconnect.
+func (c *socketClient) subscribed(eventType string, payload interface{}) bool {
+	sub := c.subscription.Load()
+	if sub == nil || eventType == "shutdown" {
+		return true
+	}
+	if len(sub.Types) > 0 && !containsString(sub.Types, eventType) {
+		return false
+	}
+	if len(sub.Chats) == 0 && !sub.GroupsOnly {
+		return true
+	}
+
+	// Daemon-wide events have no chat to match
+	scoped, ok := payload.(chatScoped)
+	if !ok {
+		return false
+	}
+	chat := scoped.scopeChatJID()
+	if len(sub.Chats) > 0 && !containsString(sub.Chats, chat) {
+		return false
+	}
+	return !sub.GroupsOnly || strings.HasSuffix(chat, "@"+types.GroupServer)
+}
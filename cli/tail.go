@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const (
+	tailFormatJSON   = "json"
+	tailFormatPretty = "pretty"
+	defaultTailLines = 10
+)
+
+// ANSI colors used by the pretty tail format.
+const (
+	colorReset  = "\x1b[0m"
+	colorDim    = "\x1b[2m"
+	colorBold   = "\x1b[1m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorBlue   = "\x1b[34m"
+	colorCyan   = "\x1b[36m"
+)
+
+// alertEvents are shown in red by the pretty tail format.
+var alertEvents = map[string]bool{
+	"internal_error":     true,
+	"logged_out":         true,
+	"storage_low":        true,
+	"client_outdated":    true,
+	"automation_stopped": true,
+	"send_failed":        true,
+	"reply_slo_breached": true,
+}
+
+// tailEvent is a broadcast as printed by the JSON tail format.
+type tailEvent struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// eventChat is what the tail filter reads to find an event's chat.
+type eventChat struct {
+	ChatJID   string `json:"chat_jid"`
+	GroupJID  string `json:"group_jid"`
+	CallerJID string `json:"caller_jid"`
+}
+
+func (c eventChat) jid() string {
+	if c.ChatJID != "" {
+		return c.ChatJID
+	}
+	if c.GroupJID != "" {
+		return c.GroupJID
+	}
+	return c.CallerJID
+}
+
+type tailer struct {
+	out    io.Writer
+	format string
+	color  bool
+	chats  []string
+	types  []string
+}
+
+func runTail(config Config, args []string) {
+	fs := flag.NewFlagSet("tail", flag.ExitOnError)
+	var chats, types stringList
+	fs.Var(&chats, "chat", "only show events of this chat (repeatable)")
+	fs.Var(&types, "type", "only show events of this type, e.g. message (repeatable)")
+	format := fs.String("format", "", "json or pretty (default: pretty on a terminal, else json)")
+	noColor := fs.Bool("no-color", false, "don't color pretty output")
+	lines := fs.Int("n", defaultTailLines, "number of stored messages to print first")
+	follow := fs.Bool("follow", false, "keep printing events as they arrive")
+	fs.BoolVar(follow, "f", false, "shorthand for --follow")
+	fs.Parse(args)
+
+	isTerminal := term.IsTerminal(int(os.Stdout.Fd()))
+	t := &tailer{out: os.Stdout, format: *format, types: types}
+	if t.format == "" {
+		t.format = tailFormatJSON
+		if isTerminal {
+			t.format = tailFormatPretty
+		}
+	}
+	if t.format != tailFormatJSON && t.format != tailFormatPretty {
+		fmt.Fprintln(os.Stderr, "Usage: wacli tail [--chat JID]... [--type TYPE]... [-n N] [--follow] [--format json|pretty] [--no-color]")
+		os.Exit(1)
+	}
+	t.color = isTerminal && !*noColor && os.Getenv("NO_COLOR") == ""
+	for _, chat := range chats {
+		t.chats = append(t.chats, normalizeJID(chat))
+	}
+
+	d, err := dialDaemon(config)
+	exitOnError(err)
+	defer d.Close()
+
+	// Subscribe before reading the stored messages, holding live events
+	// back until they are printed, so nothing falls in between
+	var events chan clientEvent
+	if *follow {
+		// Let the daemon drop what we'd filter out anyway
+		_, err = d.request(SocketCommand{Action: "subscribe", Types: t.types, Chats: t.chats}, nil)
+		exitOnError(err)
+		events = make(chan clientEvent, 256)
+		d.listen(func(evt clientEvent) { events <- evt })
+	}
+
+	printed := make(map[string]bool)
+	messages, err := t.recent(d, *lines)
+	exitOnError(err)
+	for _, row := range messages {
+		var msg Message
+		if json.Unmarshal(row, &msg) != nil {
+			continue
+		}
+		evt := clientEvent{Type: messageEventType(&msg), Data: row}
+		if t.matches(evt) {
+			t.print(evt, time.Unix(msg.Timestamp, 0))
+			printed[msg.MessageID] = true
+		}
+	}
+	if !*follow {
+		return
+	}
+
+	go func() {
+		// listen closes responses when the daemon goes away
+		for range d.responses {
+		}
+		close(events)
+	}()
+	for evt := range events {
+		if evt.Type == "hello" {
+			continue
+		}
+		var msg Message
+		if json.Unmarshal(evt.Data, &msg) == nil && msg.MessageID != "" && printed[msg.MessageID] {
+			continue
+		}
+		if t.matches(evt) {
+			t.print(evt, time.Now())
+		}
+	}
+	exitOnError(fmt.Errorf("daemon closed the connection"))
+}
+
+// recent returns the last n stored messages of the chats followed, or of
+// all chats, oldest first.
+func (t *tailer) recent(d *daemonConn, n int) ([]json.RawMessage, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	chats := t.chats
+	if len(chats) == 0 {
+		chats = []string{""}
+	}
+	type row struct {
+		timestamp int64
+		data      json.RawMessage
+	}
+	var rows []row
+	for _, chat := range chats {
+		_, err := d.request(SocketCommand{Action: "history", ChatJID: chat, Limit: n}, func(data json.RawMessage) error {
+			var msg Message
+			if err := json.Unmarshal(data, &msg); err != nil {
+				return err
+			}
+			rows = append(rows, row{msg.Timestamp, data})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].timestamp < rows[j].timestamp })
+	if len(rows) > n {
+		rows = rows[len(rows)-n:]
+	}
+	messages := make([]json.RawMessage, len(rows))
+	for i, r := range rows {
+		messages[i] = r.data
+	}
+	return messages, nil
+}
+
+func (t *tailer) matches(evt clientEvent) bool {
+	if len(t.types) > 0 && !containsString(t.types, evt.Type) {
+		return false
+	}
+	if len(t.chats) > 0 {
+		var chat eventChat
+		json.Unmarshal(evt.Data, &chat)
+		return containsString(t.chats, chat.jid())
+	}
+	return true
+}
+
+// print writes an event; at is when it happened, shown by the pretty
+// format with the date for other days.
+func (t *tailer) print(evt clientEvent, at time.Time) {
+	if t.format == tailFormatJSON {
+		data, err := json.Marshal(tailEvent{Type: evt.Type, Data: evt.Data})
+		if err == nil {
+			fmt.Fprintln(t.out, string(data))
+		}
+		return
+	}
+
+	var line string
+	switch evt.Type {
+	case "message", "newsletter_message", "view_once":
+		var msg Message
+		if json.Unmarshal(evt.Data, &msg) != nil {
+			return
+		}
+		sender := t.paint(colorBold, msg.SenderName)
+		if msg.IsGroup {
+			sender += t.paint(colorDim, " @ ") + t.paint(colorCyan, msg.ChatName)
+		}
+		text := msg.displayText()
+		if msg.IsMentioned || msg.IsReplyToMe || msg.IsHighlight {
+			text = t.paint(colorYellow, text)
+		}
+		line = fmt.Sprintf("%s: %s", sender, strings.ReplaceAll(text, "\n", "\n    "))
+	case "call":
+		var call Call
+		if json.Unmarshal(evt.Data, &call) != nil {
+			return
+		}
+		line = t.paint(colorGreen, "Call from "+call.CallerName)
+		if call.IsGroup {
+			line += t.paint(colorDim, " @ ") + t.paint(colorCyan, call.GroupName)
+		}
+	case "receipt":
+		var receipt ReceiptUpdate
+		if json.Unmarshal(evt.Data, &receipt) != nil {
+			return
+		}
+		line = t.paint(colorDim, fmt.Sprintf("%s %d message(s) in %s", receipt.Status, len(receipt.MessageIDs), receipt.ChatJID))
+	default:
+		color := colorBlue
+		if alertEvents[evt.Type] {
+			color = colorRed
+		}
+		line = t.paint(color, evt.Type)
+		if len(evt.Data) > 0 && string(evt.Data) != "null" {
+			line += " " + string(evt.Data)
+		}
+	}
+	clock := at.Format("15:04:05")
+	if at.Format("2006-01-02") != time.Now().Format("2006-01-02") {
+		clock = at.Format("Jan 02 15:04")
+	}
+	fmt.Fprintf(t.out, "%s  %s\n", t.paint(colorDim, clock), line)
+}
+
+func (t *tailer) paint(color string, s string) string {
+	if !t.color || s == "" {
+		return s
+	}
+	return color + s + colorReset
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+const (
+	telegramAPI          = "https://api.telegram.org/bot"
+	telegramPollTimeout  = 50 * time.Second
+	telegramRetryDelay   = 10 * time.Second
+	telegramOffsetKey    = "telegram_update_offset"
+	telegramSource       = "telegram"
+	telegramMessageLimit = 4096
+)
+
+// The Telegram bridge forwards the messages of the chats in
+// TELEGRAM_BRIDGE to a Telegram bot chat and sends what you answer there
+// back to WhatsApp: replies to a forwarded message quote it, anything else
+// goes to the WhatsApp chat if only one is bridged to that Telegram chat.
+
+type telegramBridge struct {
+	app   *App
+	token string
+	// WhatsApp chat JID to Telegram chat ID
+	chats map[string]string
+}
+
+// parseTelegramBridge parses TELEGRAM_BRIDGE, comma separated
+// <whatsapp jid>=<telegram chat id> pairs.
+func parseTelegramBridge(value string) (map[string]string, error) {
+	chats := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		jid, chatID, ok := strings.Cut(pair, "=")
+		jid, chatID = strings.TrimSpace(jid), strings.TrimSpace(chatID)
+		if _, err := strconv.ParseInt(chatID, 10, 64); !ok || jid == "" || err != nil {
+			return nil, fmt.Errorf("invalid TELEGRAM_BRIDGE entry %q, want <jid>=<telegram chat id>", pair)
+		}
+		chats[jid] = chatID
+	}
+	return chats, nil
+}
+
+func newTelegramBridge(a *App, token string, chats map[string]string) *telegramBridge {
+	return &telegramBridge{app: a, token: token, chats: chats}
+}
+
+// Emit forwards incoming messages of bridged chats. It runs behind a
+// queuedSink, so Telegram being slow never holds up the daemon.
+func (b *telegramBridge) Emit(eventType string, payload interface{}) error {
+	msg, ok := payload.(*Message)
+	if !ok || messageEventType(msg) != eventType {
+		return nil
+	}
+	tgChat := b.telegramChat(msg.ChatJID)
+	if tgChat == "" {
+		return nil
+	}
+
+	text := msg.SenderName + ": " + msg.displayText()
+	if msg.IsGroup {
+		text = msg.SenderName + " in " + msg.ChatName + ": " + msg.displayText()
+	}
+	if runes := []rune(text); len(runes) > telegramMessageLimit {
+		text = string(runes[:telegramMessageLimit-3]) + "..."
+	}
+	var sent struct {
+		MessageID int64 `json:"message_id"`
+	}
+	if err := b.call("sendMessage", map[string]interface{}{"chat_id": tgChat, "text": text}, &sent); err != nil {
+		return err
+	}
+	_, err := b.app.msgDB.Exec(`
+		INSERT OR REPLACE INTO telegram_forwards (tg_chat_id, tg_message_id, chat_jid, message_id, sender_jid, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, tgChat, sent.MessageID, msg.ChatJID, msg.MessageID, msg.SenderJID, time.Now().Unix())
+	return err
+}
+
+func (b *telegramBridge) Close() error { return nil }
+
+// telegramChat returns the Telegram chat a WhatsApp chat is bridged to,
+// also when TELEGRAM_BRIDGE names one of its aliases.
+func (b *telegramBridge) telegramChat(chatJID string) string {
+	if tgChat, ok := b.chats[chatJID]; ok {
+		return tgChat
+	}
+	for jid, tgChat := range b.chats {
+		if b.app.canonicalChat(jid) == chatJID {
+			return tgChat
+		}
+	}
+	return ""
+}
+
+type telegramUpdate struct {
+	UpdateID int64            `json:"update_id"`
+	Message  *telegramMessage `json:"message"`
+}
+
+type telegramMessage struct {
+	MessageID int64 `json:"message_id"`
+	Chat      struct {
+		ID int64 `json:"id"`
+	} `json:"chat"`
+	From *struct {
+		IsBot bool `json:"is_bot"`
+	} `json:"from"`
+	Text    string           `json:"text"`
+	ReplyTo *telegramMessage `json:"reply_to_message"`
+}
+
+// pollReplies long-polls the bot's updates and relays answers from the
+// bridged Telegram chats. The offset is kept in daemon_state, so nothing
+// is sent twice across restarts.
+func (b *telegramBridge) pollReplies() {
+	var offset int64
+	var stored string
+	if err := b.app.msgDB.QueryRow("SELECT value FROM daemon_state WHERE key = ?", telegramOffsetKey).Scan(&stored); err == nil {
+		offset, _ = strconv.ParseInt(stored, 10, 64)
+	}
+
+	for {
+		var updates []telegramUpdate
+		err := b.call("getUpdates", map[string]interface{}{
+			"offset":          offset,
+			"timeout":         int(telegramPollTimeout / time.Second),
+			"allowed_updates": []string{"message"},
+		}, &updates)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Telegram getUpdates failed: %v\n", err)
+			time.Sleep(telegramRetryDelay)
+			continue
+		}
+		for _, update := range updates {
+			offset = update.UpdateID + 1
+			if update.Message != nil {
+				b.relay(update.Message)
+			}
+		}
+		if len(updates) > 0 {
+			_, err := b.app.msgDB.Exec(
+				"INSERT OR REPLACE INTO daemon_state (key, value) VALUES (?, ?)",
+				telegramOffsetKey, strconv.FormatInt(offset, 10),
+			)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to save Telegram offset: %v\n", err)
+			}
+		}
+	}
+}
+
+// relay sends a Telegram message back to WhatsApp. Messages from chats
+// that aren't bridged are ignored, so nobody else can send through the bot.
+func (b *telegramBridge) relay(tg *telegramMessage) {
+	if tg.Text == "" || (tg.From != nil && tg.From.IsBot) {
+		return
+	}
+	tgChat := strconv.FormatInt(tg.Chat.ID, 10)
+	var bridged []string
+	for jid, chatID := range b.chats {
+		if chatID == tgChat {
+			bridged = append(bridged, jid)
+		}
+	}
+	if len(bridged) == 0 {
+		return
+	}
+
+	var id types.MessageID
+	var chatJID string
+	var err error
+	if tg.ReplyTo != nil {
+		var messageID, senderJID string
+		err = b.app.msgDB.QueryRow(
+			"SELECT chat_jid, message_id, sender_jid FROM telegram_forwards WHERE tg_chat_id = ? AND tg_message_id = ?",
+			tgChat, tg.ReplyTo.MessageID,
+		).Scan(&chatJID, &messageID, &senderJID)
+		if err == sql.ErrNoRows {
+			b.notice(tgChat, "That message wasn't forwarded from WhatsApp; reply to a forwarded one.")
+			return
+		} else if err == nil {
+			// The bridge is a bot, so the kill switch stops it like plugins
+			if err = b.app.automationAllowed(telegramSource); err == nil {
+				id, err = b.app.replyToMessage(chatJID, messageID, senderJID, tg.Text, priorityInteractive)
+			}
+		}
+	} else if len(bridged) == 1 {
+		chatJID = bridged[0]
+		if err = b.app.automationAllowed(telegramSource); err == nil {
+			id, err = b.app.sendMessage(chatJID, tg.Text, nil, priorityInteractive)
+		}
+	} else {
+		b.notice(tgChat, "Several WhatsApp chats are bridged here; reply to a forwarded message to answer it.")
+		return
+	}
+	if chatJID != "" {
+		b.app.recordSent(telegramSource, telegramSource, chatJID, tg.Text, id, err)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to relay Telegram message: %v\n", err)
+		b.notice(tgChat, "Not sent: "+err.Error())
+	}
+}
+
+func (b *telegramBridge) notice(tgChat string, text string) {
+	if err := b.call("sendMessage", map[string]interface{}{"chat_id": tgChat, "text": text}, nil); err != nil {
+		fmt.Fprintf(os.Stderr, "Telegram sendMessage failed: %v\n", err)
+	}
+}
+
+// call invokes a Bot API method and decodes its result into result.
+func (b *telegramBridge) call(method string, params map[string]interface{}, result interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: telegramPollTimeout + webhookTimeout}
+	resp, err := client.Post(telegramAPI+b.token+"/"+method, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// The error names the URL, which holds the bot token
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	var reply struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return fmt.Errorf("%s: %s", method, resp.Status)
+	}
+	if !reply.OK {
+		return fmt.Errorf("%s: %s", method, reply.Description)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(reply.Result, result)
+}
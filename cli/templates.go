@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Send templates are text/templates expanded per recipient. Besides the
+// webhook functions they can use {{name}} and {{first_name}} of the
+// recipient, {{date}}, {{clock}} and {{weekday}} (each taking an optional
+// Go time layout), and the command's vars, both as {{key}} and {{.key}}.
+
+var templateVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// sendTemplateFuncs binds the placeholders to a recipient and a time. The
+// auto-reply template is parsed once with them bound to nothing and gets
+// them rebound for every reply.
+func sendTemplateFuncs(name string, now time.Time) template.FuncMap {
+	format := func(layout string) func(...string) string {
+		return func(custom ...string) string {
+			if len(custom) > 0 {
+				return now.Format(custom[0])
+			}
+			return now.Format(layout)
+		}
+	}
+	return template.FuncMap{
+		"name": func() string { return name },
+		"first_name": func() string {
+			first, _, _ := strings.Cut(name, " ")
+			return first
+		},
+		"date":    format("2006-01-02"),
+		"clock":   format("15:04"),
+		"weekday": format("Monday"),
+	}
+}
+
+// recipientName is what {{name}} becomes: the group subject, channel name
+// or contact name.
+func (a *App) recipientName(jid types.JID) string {
+	switch {
+	case isNewsletter(jid):
+		return a.newsletterName(jid)
+	case jid.Server == types.GroupServer:
+		if name := a.groupName(jid); name != "" {
+			return name
+		}
+		return jid.User
+	default:
+		return a.getContactName(jid)
+	}
+}
+
+// renderSendText expands a command's text for one chat when it sets
+// template or vars, and returns it unchanged otherwise.
+func (a *App) renderSendText(cmd *SocketCommand, chatJID string) (string, error) {
+	if !cmd.Template && len(cmd.Vars) == 0 {
+		return cmd.Text, nil
+	}
+	jid, err := types.ParseJID(chatJID)
+	if err != nil {
+		return "", fmt.Errorf("invalid JID: %w", err)
+	}
+
+	funcs := sendTemplateFuncs(a.recipientName(jid), time.Now())
+	for key, value := range cmd.Vars {
+		if templateVarName.MatchString(key) {
+			funcs[key] = func() string { return value }
+		}
+	}
+	tmpl, err := template.New("send").Funcs(webhookFuncs).Funcs(funcs).Option("missingkey=error").Parse(cmd.Text)
+	if err != nil {
+		return "", fmt.Errorf("parse template: %w", err)
+	}
+	var text strings.Builder
+	if err := tmpl.Execute(&text, cmd.Vars); err != nil {
+		return "", fmt.Errorf("render template: %w", err)
+	}
+	return text.String(), nil
+}
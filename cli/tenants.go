@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A token restricted to chats is a tenant: one end user of a deployment
+// that serves several through one WhatsApp account, such as a support
+// desk. Tenants only see their own chats, in command results as well as
+// in broadcast events.
+
+// chatScopedActions query across chats. Tenants may run them without
+// chat_jid and get results covering only their chats.
+var chatScopedActions = map[string]bool{
+	"list_chats":       true,
+	"list_reminders":   true,
+	"unreplied":        true,
+	"heatmap":          true,
+	"history":          true,
+	"sent_history":     true,
+	"list_drafts":      true,
+	"list_starred":     true,
+	"list_retained":    true,
+	"search":           true,
+	"export":           true,
+	"backlog":          true,
+	"subscribe":        true,
+	"reply_latency":    true,
+	"send_bulk":        true,
+	"reply_to_id":      true,
+	"list_communities": true,
+}
+
+// senderActions read or change what the daemon knows about a contact.
+// Tenants may only use them on contacts from their own chats.
+var senderActions = map[string]bool{
+	"sender_info":      true,
+	"set_contact_info": true,
+	"resolve_lid":      true,
+	"availability":     true,
+}
+
+// tenantEvents are daemon-wide broadcasts tenants receive. Others, such as
+// login QR codes or storage warnings, only reach unrestricted clients.
+var tenantEvents = map[string]bool{
+	"shutdown": true,
+}
+
+// chatScoped is implemented by results and event payloads that belong to a
+// single chat.
+type chatScoped interface {
+	scopeChatJID() string
+}
+
+func (m Message) scopeChatJID() string       { return m.ChatJID }
+func (p PinUpdate) scopeChatJID() string     { return p.ChatJID }
+func (p PollResult) scopeChatJID() string    { return p.ChatJID }
+func (r ReceiptUpdate) scopeChatJID() string { return r.ChatJID }
+func (r Reminder) scopeChatJID() string      { return r.ChatJID }
+func (c UnrepliedChat) scopeChatJID() string { return c.ChatJID }
+func (c ChatSummary) scopeChatJID() string   { return c.ChatJID }
+func (c HeatmapChat) scopeChatJID() string   { return c.ChatJID }
+func (o OpenChat) scopeChatJID() string      { return o.ChatJID }
+func (f SendFailure) scopeChatJID() string   { return f.ChatJID }
+func (m ChatMerge) scopeChatJID() string     { return m.ToJID }
+func (c ChatLatency) scopeChatJID() string   { return c.ChatJID }
+func (b SLOBreach) scopeChatJID() string     { return b.ChatJID }
+func (c Community) scopeChatJID() string     { return c.JID }
+
+func (c Call) scopeChatJID() string {
+	if c.IsGroup {
+		return c.GroupJID
+	}
+	return c.CallerJID
+}
+
+// tenantChats returns the chats the client is restricted to, or nil for
+// unrestricted clients.
+func (c *socketClient) tenantChats() []string {
+	token := c.token.Load()
+	if token == nil || len(token.Chats) == 0 {
+		return nil
+	}
+	return token.Chats
+}
+
+// receivesEvent reports whether a broadcast may reach a tenant.
+func (c *socketClient) receivesEvent(eventType string, payload interface{}) bool {
+	chats := c.tenantChats()
+	if len(chats) == 0 {
+		return true
+	}
+	if scoped, ok := payload.(chatScoped); ok {
+		return containsString(chats, scoped.scopeChatJID())
+	}
+	return tenantEvents[eventType]
+}
+
+// filterChats keeps the items belonging to chats; nil chats keeps all.
+func filterChats[T chatScoped](items []T, chats []string) []T {
+	if chats == nil {
+		return items
+	}
+	filtered := make([]T, 0, len(items))
+	for _, item := range items {
+		if containsString(chats, item.scopeChatJID()) {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered
+}
+
+// inChats returns a "chat_jid IN (...)" condition for a query and its
+// arguments.
+func inChats(chats []string) (string, []interface{}) {
+	args := make([]interface{}, len(chats))
+	for i, jid := range chats {
+		args[i] = jid
+	}
+	return "chat_jid IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(chats)), ", ") + ")", args
+}
+
+// checkTenantSender refuses sender actions on contacts a tenant has no
+// chat with: the contact's own chat, or a message from them in one of the
+// tenant's groups.
+func (a *App) checkTenantSender(chats []string, senderJID string) error {
+	if containsString(chats, senderJID) {
+		return nil
+	}
+	condition, args := inChats(chats)
+	var found bool
+	err := a.msgDB.QueryRow(
+		fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s WHERE sender_jid = ? AND %s)", messagesView, condition),
+		append([]interface{}{senderJID}, args...)...,
+	).Scan(&found)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no access to %s", senderJID)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// WACLI_TEXT_FILTER_CMD runs for every incoming message before it is
+// stored and broadcast, with the message JSON on stdin. It may print the
+// message back changed, e.g. with the text scrubbed of phone numbers, and
+// add fields of its own, e.g. a translation. Fields that aren't message
+// fields end up in extra.
+
+const textFilterTimeout = 10 * time.Second
+
+// MessageExtra holds the fields a text filter added, stored as a JSON
+// object in a single TEXT column.
+type MessageExtra map[string]json.RawMessage
+
+func (e MessageExtra) Value() (driver.Value, error) {
+	if len(e) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(e)
+	return string(data), err
+}
+
+func (e *MessageExtra) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	}
+	*e = nil
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, e)
+}
+
+// filterMessage passes msg through the text filter. A filter that fails,
+// times out or prints nothing leaves the message as it was.
+func (a *App) filterMessage(msg *Message) {
+	command := a.cfg().TextFilterCmd
+	if command == "" {
+		return
+	}
+	filtered, err := runTextFilter(command, msg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Text filter failed: %v\n", err)
+		return
+	}
+	if filtered != nil {
+		*msg = *filtered
+	}
+}
+
+func runTextFilter(command string, msg *Message) (*Message, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), textFilterTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	output = bytes.TrimSpace(output)
+	if len(output) == 0 {
+		return nil, nil
+	}
+
+	filtered := *msg
+	if err := json.Unmarshal(output, &filtered); err != nil {
+		return nil, fmt.Errorf("parse output: %w", err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(output, &fields); err != nil {
+		return nil, fmt.Errorf("parse output: %w", err)
+	}
+	known := make(map[string]bool)
+	for _, column := range recordColumns(msg) {
+		known[column] = true
+	}
+	for key, value := range fields {
+		if known[key] {
+			continue
+		}
+		if filtered.Extra == nil {
+			filtered.Extra = make(MessageExtra)
+		}
+		filtered.Extra[key] = value
+	}
+
+	// The message must stay the one WhatsApp delivered
+	filtered.ID = msg.ID
+	filtered.MessageID = msg.MessageID
+	filtered.Timestamp = msg.Timestamp
+	filtered.ChatJID = msg.ChatJID
+	filtered.SenderJID = msg.SenderJID
+	return &filtered, nil
+}
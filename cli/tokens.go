@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// APIToken grants a socket client access to a subset of chats and actions.
+// Empty Chats or Actions mean no restriction on that axis. Redacted tokens
+// only ever receive metadata, and read-only tokens can subscribe to events
+// and query but never send or change anything.
+type APIToken struct {
+	Name     string   `json:"name"`
+	Token    string   `json:"token"`
+	Chats    []string `json:"chats,omitempty"`
+	Actions  []string `json:"actions,omitempty"`
+	Redacted bool     `json:"redacted,omitempty"`
+	ReadOnly bool     `json:"read_only,omitempty"`
+}
+
+// sharedTokenName names the unrestricted token set by WACLI_SOCKET_TOKEN.
+const sharedTokenName = "shared"
+
+// readOnlyActions are the actions read-only tokens may use. Anything not
+// listed, including actions added later, needs a full token.
+var readOnlyActions = map[string]bool{
+	"status":           true,
+	"list_chats":       true,
+	"list_reminders":   true,
+	"sender_info":      true,
+	"redact":           true,
+	"unreplied":        true,
+	"heatmap":          true,
+	"availability":     true,
+	"history":          true,
+	"sent_history":     true,
+	"get_draft":        true,
+	"list_drafts":      true,
+	"search":           true,
+	"list_pinned":      true,
+	"list_starred":     true,
+	"list_retained":    true,
+	"export":           true,
+	"backlog":          true,
+	"subscribe":        true,
+	"reply_latency":    true,
+	"list_communities": true,
+	"community_groups": true,
+	"status_views":     true,
+	"get_avatar":       true,
+	"get_profile":      true,
+	"resolve":          true,
+	"resolve_lid":      true,
+}
+
+var errNotAuthenticated = errors.New("not authenticated, send {\"action\":\"auth\",\"token\":...} first")
+
+func (t *APIToken) allows(action string, chatJID string) error {
+	if len(t.Actions) > 0 && !containsString(t.Actions, action) {
+		return fmt.Errorf("token %q may not use action %s", t.Name, action)
+	}
+	if t.ReadOnly && !readOnlyActions[action] {
+		return fmt.Errorf("token %q is read-only and may not use action %s", t.Name, action)
+	}
+	if privilegedActions[action] {
+		if len(t.Chats) > 0 {
+			return fmt.Errorf("token %q is restricted to specific chats and may not use action %s", t.Name, action)
+		}
+		return nil
+	}
+	if len(t.Chats) > 0 {
+		if chatJID == "" && chatScopedActions[action] {
+			return nil
+		}
+		if chatJID == "" {
+			return fmt.Errorf("token %q is restricted to specific chats, chat_jid is required", t.Name)
+		}
+		if !containsString(t.Chats, chatJID) {
+			return fmt.Errorf("token %q may not access %s", t.Name, chatJID)
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func loadTokens(path string) ([]APIToken, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var tokens []APIToken
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return tokens, nil
+}
+
+func saveTokens(path string, tokens []APIToken) error {
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
+
+func (a *App) findToken(secret string) *APIToken {
+	tokens := a.currentTokens()
+	for _, token := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token.Token), []byte(secret)) == 1 {
+			// A copy, since a reload replaces the slice
+			return &token
+		}
+	}
+	return nil
+}
+
+func (a *App) authenticate(client *socketClient, cmd *SocketCommand) {
+	token := a.findToken(cmd.Token)
+	if token == nil {
+		client.respondError(cmd, errors.New("invalid token"))
+		return
+	}
+	client.token.Store(token)
+	client.respond(cmd, map[string]interface{}{"name": token.Name, "chats": token.Chats, "actions": token.Actions, "redacted": token.Redacted, "read_only": token.ReadOnly})
+}
+
+// authorize checks a command against the client's token. Without any
+// configured tokens the socket stays open to every local client.
+func (a *App) authorize(client *socketClient, cmd *SocketCommand) error {
+	if len(a.currentTokens()) == 0 && !client.remote {
+		return nil
+	}
+	token := client.token.Load()
+	if token == nil {
+		return errNotAuthenticated
+	}
+	if err := token.allows(cmd.Action, cmd.ChatJID); err != nil {
+		return err
+	}
+	if chats := client.tenantChats(); chats != nil && senderActions[cmd.Action] {
+		return a.checkTenantSender(chats, cmd.SenderJID)
+	}
+	return nil
+}
+
+// receivesEvents reports whether broadcasts should reach the client.
+func (a *App) receivesEvents(client *socketClient) bool {
+	return (len(a.currentTokens()) == 0 && !client.remote) || client.token.Load() != nil
+}
+
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
+// runToken manages the tokens file: add, list and revoke.
+func runToken(config Config, args []string) {
+	usage := "Usage: wacli token add NAME [--chat JID]... [--action ACTION]... [--redacted] [--read-only] | list | revoke NAME"
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(1)
+	}
+
+	tokens, err := loadTokens(config.TokensFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load tokens: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "add":
+		fs := flag.NewFlagSet("token add", flag.ExitOnError)
+		var chats, actions stringList
+		fs.Var(&chats, "chat", "restrict to this chat JID (repeatable)")
+		fs.Var(&actions, "action", "restrict to this socket action (repeatable)")
+		redacted := fs.Bool("redacted", false, "only send metadata, never message content")
+		readOnly := fs.Bool("read-only", false, "only subscribe to events and query, never send")
+		if len(args) < 2 || strings.HasPrefix(args[1], "-") {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(1)
+		}
+		name := args[1]
+		fs.Parse(args[2:])
+
+		for _, t := range tokens {
+			if t.Name == name {
+				fmt.Fprintf(os.Stderr, "Token %q already exists\n", name)
+				os.Exit(1)
+			}
+		}
+		secret := make([]byte, 24)
+		if _, err := rand.Read(secret); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to generate token: %v\n", err)
+			os.Exit(1)
+		}
+		token := APIToken{Name: name, Token: hex.EncodeToString(secret), Chats: chats, Actions: actions, Redacted: *redacted, ReadOnly: *readOnly}
+		tokens = append(tokens, token)
+		if err := saveTokens(config.TokensFile, tokens); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to save tokens: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(token.Token)
+	case "list":
+		for _, t := range tokens {
+			fmt.Printf("%s\tchats=%s\tactions=%s\tredacted=%t\tread_only=%t\n", t.Name, orAll(t.Chats), orAll(t.Actions), t.Redacted, t.ReadOnly)
+		}
+	case "revoke":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(1)
+		}
+		kept := tokens[:0]
+		for _, t := range tokens {
+			if t.Name != args[1] {
+				kept = append(kept, t)
+			}
+		}
+		if len(kept) == len(tokens) {
+			fmt.Fprintf(os.Stderr, "No token named %q\n", args[1])
+			os.Exit(1)
+		}
+		if err := saveTokens(config.TokensFile, kept); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to save tokens: %v\n", err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(1)
+	}
+}
+
+func orAll(list []string) string {
+	if len(list) == 0 {
+		return "*"
+	}
+	return strings.Join(list, ",")
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// transcriptFormatJSONL writes one export record per line; the other
+// format is the readable log of exportFormatText.
+const transcriptFormatJSONL = "jsonl"
+
+// transcriptSink appends every stored message, incoming and outgoing, to
+// <TRANSCRIPT_DIR>/<chat jid>/<YYYY-MM>.<format>, so history outlives the
+// trimmed database and can be searched with grep.
+type transcriptSink struct {
+	dir    string
+	format string
+	mu     sync.Mutex
+}
+
+func newTranscriptSink(dir string, format string) *transcriptSink {
+	if format != transcriptFormatJSONL {
+		format = exportFormatText
+	}
+	return &transcriptSink{dir: dir, format: format}
+}
+
+func (s *transcriptSink) append(rec *ExportRecord) error {
+	var line []byte
+	if s.format == transcriptFormatJSONL {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		line = append(data, '\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir := filepath.Join(s.dir, rec.ChatJID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	month := time.Unix(rec.Timestamp, 0).Format("2006-01")
+	f, err := os.OpenFile(filepath.Join(dir, month+"."+s.format), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if line != nil {
+		_, err = f.Write(line)
+	} else {
+		err = (&textExport{w: f}).encode(rec)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// transcribe appends a stored message to its chat's transcript.
+func (a *App) transcribe(msg Message) {
+	if a.transcripts == nil {
+		return
+	}
+	rec, err := a.exportRecord(msg, false)
+	if err == nil {
+		err = a.transcripts.append(rec)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write transcript: %v\n", err)
+	}
+}
+
+// transcribeOutgoing writes one of your messages to the transcript, with
+// your own JID and push name as the sender.
+func (a *App) transcribeOutgoing(out *OutgoingMessage) {
+	if a.transcripts == nil {
+		return
+	}
+	msg := Message{
+		MessageID:  out.MessageID,
+		Timestamp:  out.Timestamp,
+		ChatJID:    out.ChatJID,
+		SenderName: a.client.Store.PushName,
+		Text:       out.Text,
+	}
+	if a.client.Store.ID != nil {
+		msg.SenderJID = a.client.Store.ID.ToNonAD().String()
+	}
+	if msg.SenderName == "" {
+		msg.SenderName = "me"
+	}
+	a.transcribe(msg)
+}
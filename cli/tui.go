@@ -0,0 +1,615 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+const (
+	tuiHistoryLimit  = 200
+	tuiChatListWidth = 32
+	// tuiWindowTitle is the window the rworkspaces attention hook flags
+	tuiWindowTitle = "wacli-tui"
+)
+
+type tuiFocus int
+
+const (
+	tuiFocusChats tuiFocus = iota
+	tuiFocusMessages
+	tuiFocusInput
+)
+
+var (
+	tuiSelectedStyle = lipgloss.NewStyle().Reverse(true)
+	tuiActiveStyle   = lipgloss.NewStyle().Bold(true)
+	tuiDimStyle      = lipgloss.NewStyle().Faint(true)
+	tuiUnreadStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("2")).Bold(true)
+	tuiMentionStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("3"))
+	tuiErrorStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("1"))
+	tuiPaneStyle     = lipgloss.NewStyle().Border(lipgloss.RoundedBorder())
+)
+
+// tuiLine is a message in the open chat, received or sent.
+type tuiLine struct {
+	Timestamp   int64
+	MessageID   string
+	SenderJID   string
+	SenderName  string
+	Text        string
+	Outgoing    bool
+	IsMentioned bool
+}
+
+func tuiLineFromMessage(msg Message) tuiLine {
+	return tuiLine{
+		Timestamp:   msg.Timestamp,
+		MessageID:   msg.MessageID,
+		SenderJID:   msg.SenderJID,
+		SenderName:  msg.SenderName,
+		Text:        msg.displayText(),
+		IsMentioned: msg.IsMentioned || msg.IsReplyToMe,
+	}
+}
+
+// tuiClient serializes requests, which the TUI makes from several commands
+// at once while the daemon connection reads broadcasts in the background.
+type tuiClient struct {
+	mu sync.Mutex
+	d  *daemonConn
+}
+
+func (c *tuiClient) request(cmd SocketCommand, onRow func(json.RawMessage) error) (json.RawMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.d.request(cmd, onRow)
+}
+
+type (
+	tuiChatsMsg   []ChatSummary
+	tuiEventMsg   clientEvent
+	tuiHistoryMsg struct {
+		chatJID string
+		lines   []tuiLine
+	}
+	tuiSentMsg struct {
+		chatJID string
+		line    tuiLine
+	}
+	tuiDraftsMsg []Draft
+	tuiDraftMsg  Draft
+	tuiErrMsg    struct{ err error }
+)
+
+type tuiModel struct {
+	client *tuiClient
+
+	chats  []ChatSummary
+	cursor int
+	unread map[string]int
+	drafts map[string]bool
+
+	open     string
+	lines    []tuiLine
+	selected int
+	replyTo  *tuiLine
+
+	focus  tuiFocus
+	input  textinput.Model
+	view   viewport.Model
+	width  int
+	height int
+	status string
+}
+
+// runTUI is an interactive chat client talking to the daemon over the
+// socket: a chat list, the open chat's messages and a compose line, kept
+// current by broadcast events.
+func runTUI(config Config) {
+	d, err := dialDaemon(config)
+	exitOnError(err)
+	defer d.Close()
+
+	input := textinput.New()
+	input.Placeholder = "Type a message..."
+	input.Prompt = "> "
+	m := &tuiModel{client: &tuiClient{d: d}, unread: make(map[string]int), drafts: make(map[string]bool), input: input}
+
+	p := tea.NewProgram(m, tea.WithAltScreen())
+	d.listen(func(evt clientEvent) { p.Send(tuiEventMsg(evt)) })
+	if _, err := p.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "TUI failed: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func (m *tuiModel) Init() tea.Cmd {
+	return tea.Batch(tea.SetWindowTitle(tuiWindowTitle), m.loadChats(), m.loadDrafts())
+}
+
+func (m *tuiModel) loadChats() tea.Cmd {
+	return func() tea.Msg {
+		data, err := m.client.request(SocketCommand{Action: "list_chats"}, nil)
+		if err != nil {
+			return tuiErrMsg{err}
+		}
+		var chats []ChatSummary
+		if err := json.Unmarshal(data, &chats); err != nil {
+			return tuiErrMsg{err}
+		}
+		return tuiChatsMsg(chats)
+	}
+}
+
+// loadHistory fetches the chat's stored messages and what wacli sent to
+// it, which the archive doesn't keep, and merges them by time.
+func (m *tuiModel) loadHistory(chatJID string) tea.Cmd {
+	return func() tea.Msg {
+		var lines []tuiLine
+		_, err := m.client.request(SocketCommand{Action: "history", ChatJID: chatJID, Limit: tuiHistoryLimit}, func(row json.RawMessage) error {
+			var msg Message
+			if err := json.Unmarshal(row, &msg); err != nil {
+				return err
+			}
+			lines = append(lines, tuiLineFromMessage(msg))
+			return nil
+		})
+		if err != nil {
+			return tuiErrMsg{err}
+		}
+
+		data, err := m.client.request(SocketCommand{Action: "sent_history", ChatJID: chatJID, Limit: tuiHistoryLimit}, nil)
+		if err != nil {
+			return tuiErrMsg{err}
+		}
+		var sent []SentLogEntry
+		if err := json.Unmarshal(data, &sent); err != nil {
+			return tuiErrMsg{err}
+		}
+		for _, entry := range sent {
+			if entry.Error != "" {
+				continue
+			}
+			lines = append(lines, tuiLine{Timestamp: entry.Timestamp, MessageID: entry.MessageID, SenderName: "You", Text: entry.Text, Outgoing: true})
+		}
+
+		sort.SliceStable(lines, func(i, j int) bool { return lines[i].Timestamp < lines[j].Timestamp })
+		return tuiHistoryMsg{chatJID: chatJID, lines: lines}
+	}
+}
+
+// loadDrafts learns which chats have a draft, to mark them in the list.
+func (m *tuiModel) loadDrafts() tea.Cmd {
+	return func() tea.Msg {
+		data, err := m.client.request(SocketCommand{Action: "list_drafts"}, nil)
+		if err != nil {
+			return tuiErrMsg{err}
+		}
+		var drafts []Draft
+		if err := json.Unmarshal(data, &drafts); err != nil {
+			return tuiErrMsg{err}
+		}
+		return tuiDraftsMsg(drafts)
+	}
+}
+
+func (m *tuiModel) loadDraft(chatJID string) tea.Cmd {
+	return func() tea.Msg {
+		data, err := m.client.request(SocketCommand{Action: "get_draft", ChatJID: chatJID}, nil)
+		if err != nil {
+			return tuiErrMsg{err}
+		}
+		var draft Draft
+		if err := json.Unmarshal(data, &draft); err != nil {
+			return tuiErrMsg{err}
+		}
+		// The daemon may answer with the chat's canonical JID
+		draft.ChatJID = chatJID
+		return tuiDraftMsg(draft)
+	}
+}
+
+// saveDraft keeps the compose line of a chat in the daemon; blank text
+// deletes the draft.
+func (m *tuiModel) saveDraft(chatJID, text string) tea.Cmd {
+	if chatJID == "" || (strings.TrimSpace(text) == "" && !m.drafts[chatJID]) {
+		return nil
+	}
+	m.drafts[chatJID] = strings.TrimSpace(text) != ""
+	return func() tea.Msg {
+		if _, err := m.client.request(SocketCommand{Action: "save_draft", ChatJID: chatJID, Text: text}, nil); err != nil {
+			return tuiErrMsg{err}
+		}
+		return nil
+	}
+}
+
+func (m *tuiModel) send(chatJID, text string, replyTo *tuiLine) tea.Cmd {
+	return func() tea.Msg {
+		cmd := SocketCommand{Action: "send", ChatJID: chatJID, Text: text}
+		if replyTo != nil {
+			cmd.Action = "reply"
+			cmd.MessageID = replyTo.MessageID
+			cmd.SenderJID = replyTo.SenderJID
+		}
+		data, err := m.client.request(cmd, nil)
+		if err != nil {
+			return tuiErrMsg{err}
+		}
+		var result SendResult
+		json.Unmarshal(data, &result)
+		line := tuiLine{Timestamp: time.Now().Unix(), MessageID: result.MessageID, SenderName: "You", Text: text, Outgoing: true}
+		if result.Status == "queued" {
+			line.Text += " (queued)"
+		}
+		return tuiSentMsg{chatJID: chatJID, line: line}
+	}
+}
+
+func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.layout()
+	case tea.KeyMsg:
+		return m, m.handleKey(msg)
+	case tuiChatsMsg:
+		m.chats = msg
+		m.cursor = min(m.cursor, max(len(m.chats)-1, 0))
+	case tuiHistoryMsg:
+		if msg.chatJID == m.open {
+			m.lines = msg.lines
+			m.selected = len(m.lines) - 1
+			m.refreshView(true)
+		}
+	case tuiSentMsg:
+		m.status = ""
+		m.addLine(msg.chatJID, msg.line, "You")
+	case tuiDraftsMsg:
+		for _, draft := range msg {
+			m.drafts[draft.ChatJID] = true
+		}
+	case tuiDraftMsg:
+		m.drafts[msg.ChatJID] = msg.Text != ""
+		// Don't overwrite what was typed or prefilled meanwhile
+		if msg.ChatJID == m.open && msg.Text != "" && m.input.Value() == "" {
+			m.input.SetValue(msg.Text)
+			m.input.CursorEnd()
+		}
+	case tuiEventMsg:
+		return m, m.handleEvent(clientEvent(msg))
+	case tuiErrMsg:
+		m.status = msg.err.Error()
+	}
+	return m, nil
+}
+
+func (m *tuiModel) handleKey(key tea.KeyMsg) tea.Cmd {
+	if key.String() == "ctrl+c" {
+		return m.quit()
+	}
+
+	switch m.focus {
+	case tuiFocusChats:
+		switch key.String() {
+		case "q":
+			return m.quit()
+		case "up", "k":
+			m.cursor = max(m.cursor-1, 0)
+		case "down", "j":
+			m.cursor = min(m.cursor+1, max(len(m.chats)-1, 0))
+		case "enter":
+			if len(m.chats) == 0 {
+				return nil
+			}
+			return m.openChat(m.chats[m.cursor].ChatJID)
+		case "tab":
+			if m.open != "" {
+				m.setFocus(tuiFocusInput)
+			}
+		}
+	case tuiFocusMessages:
+		switch key.String() {
+		case "up", "k":
+			m.selected = max(m.selected-1, 0)
+			m.refreshView(false)
+		case "down", "j":
+			m.selected = min(m.selected+1, len(m.lines)-1)
+			m.refreshView(false)
+		case "r":
+			if m.selected >= 0 && m.selected < len(m.lines) && !m.lines[m.selected].Outgoing {
+				line := m.lines[m.selected]
+				m.replyTo = &line
+				m.setFocus(tuiFocusInput)
+			}
+		case "tab":
+			m.setFocus(tuiFocusInput)
+		case "esc", "q":
+			m.setFocus(tuiFocusChats)
+		}
+	case tuiFocusInput:
+		switch key.String() {
+		case "enter":
+			text := strings.TrimSpace(m.input.Value())
+			if text == "" {
+				return nil
+			}
+			m.input.Reset()
+			replyTo := m.replyTo
+			m.replyTo = nil
+			m.layout()
+			return tea.Batch(m.send(m.open, text, replyTo), m.saveDraft(m.open, ""))
+		case "esc":
+			if m.replyTo != nil {
+				m.replyTo = nil
+				m.layout()
+			} else {
+				m.setFocus(tuiFocusChats)
+				return m.saveDraft(m.open, m.input.Value())
+			}
+		case "tab":
+			if len(m.lines) > 0 {
+				m.setFocus(tuiFocusMessages)
+			} else {
+				m.setFocus(tuiFocusChats)
+			}
+		default:
+			var cmd tea.Cmd
+			m.input, cmd = m.input.Update(key)
+			return cmd
+		}
+	}
+	return nil
+}
+
+// openChat switches to a chat, keeping what was typed in the previous one
+// as its draft and restoring the new chat's draft.
+func (m *tuiModel) openChat(chatJID string) tea.Cmd {
+	var save tea.Cmd
+	if chatJID != m.open {
+		save = m.saveDraft(m.open, m.input.Value())
+		m.input.Reset()
+	}
+	m.open = chatJID
+	m.lines = nil
+	m.replyTo = nil
+	delete(m.unread, chatJID)
+	m.refreshView(true)
+	m.setFocus(tuiFocusInput)
+	return tea.Batch(save, m.loadHistory(chatJID), m.loadDraft(chatJID))
+}
+
+// quit saves the compose line as a draft before leaving.
+func (m *tuiModel) quit() tea.Cmd {
+	return tea.Sequence(m.saveDraft(m.open, m.input.Value()), tea.Quit)
+}
+
+func (m *tuiModel) setFocus(focus tuiFocus) {
+	m.focus = focus
+	if focus == tuiFocusInput {
+		m.input.Focus()
+	} else {
+		m.input.Blur()
+	}
+	if focus == tuiFocusMessages {
+		m.selected = min(max(m.selected, 0), len(m.lines)-1)
+	}
+	m.layout()
+}
+
+func (m *tuiModel) handleEvent(evt clientEvent) tea.Cmd {
+	switch evt.Type {
+	case "message", "newsletter_message", "view_once":
+	case "open_chat":
+		// wacli open asks for a chat with the text prefilled
+		var open OpenChat
+		if err := json.Unmarshal(evt.Data, &open); err != nil || open.ChatJID == "" {
+			return nil
+		}
+		cmd := m.openChat(open.ChatJID)
+		m.input.SetValue(open.Text)
+		m.input.CursorEnd()
+		return cmd
+	default:
+		return nil
+	}
+	var msg Message
+	if err := json.Unmarshal(evt.Data, &msg); err != nil {
+		return nil
+	}
+	if msg.ChatJID != m.open {
+		m.unread[msg.ChatJID]++
+	}
+	m.addLine(msg.ChatJID, tuiLineFromMessage(msg), msg.ChatName)
+	return nil
+}
+
+// addLine shows a message in the open chat and moves its chat to the top
+// of the list.
+func (m *tuiModel) addLine(chatJID string, line tuiLine, chatName string) {
+	if chatJID == m.open {
+		follow := m.view.AtBottom()
+		m.lines = append(m.lines, line)
+		if follow || m.focus != tuiFocusMessages {
+			m.selected = len(m.lines) - 1
+		}
+		m.refreshView(follow)
+	}
+
+	summary := ChatSummary{ChatJID: chatJID, ChatName: chatName}
+	var current string
+	if m.cursor < len(m.chats) {
+		current = m.chats[m.cursor].ChatJID
+	}
+	for i, chat := range m.chats {
+		if chat.ChatJID == chatJID {
+			summary = chat
+			m.chats = append(m.chats[:i], m.chats[i+1:]...)
+			break
+		}
+	}
+	summary.LastTimestamp = line.Timestamp
+	summary.LastSender = line.SenderName
+	summary.LastText = line.Text
+	summary.MessageCount++
+	m.chats = append([]ChatSummary{summary}, m.chats...)
+
+	// Keep the cursor on the chat it was on
+	for i, chat := range m.chats {
+		if chat.ChatJID == current {
+			m.cursor = i
+		}
+	}
+}
+
+func (m *tuiModel) chatName(chatJID string) string {
+	for _, chat := range m.chats {
+		if chat.ChatJID == chatJID && chat.ChatName != "" {
+			return chat.ChatName
+		}
+	}
+	return chatJID
+}
+
+// layout sizes the message view to what the window leaves around the
+// chat list, header, compose line and status bar.
+func (m *tuiModel) layout() {
+	if m.width == 0 {
+		return
+	}
+	width := max(m.width-tuiChatListWidth-4, 10)
+	height := m.height - 5
+	if m.replyTo != nil {
+		height--
+	}
+	m.view.Width = width
+	m.view.Height = max(height, 1)
+	m.input.Width = width - len(m.input.Prompt) - 1
+	m.refreshView(false)
+}
+
+func (m *tuiModel) refreshView(bottom bool) {
+	var b strings.Builder
+	for i, line := range m.lines {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(m.renderLine(line, i == m.selected && m.focus == tuiFocusMessages))
+	}
+	m.view.SetContent(b.String())
+	if bottom {
+		m.view.GotoBottom()
+		return
+	}
+	// Scroll just enough to keep the selected message in sight
+	if m.focus == tuiFocusMessages && m.selected >= 0 {
+		offset := 0
+		for i := 0; i < m.selected && i < len(m.lines); i++ {
+			offset += lipgloss.Height(m.renderLine(m.lines[i], false))
+		}
+		if offset < m.view.YOffset {
+			m.view.SetYOffset(offset)
+		} else if end := offset + lipgloss.Height(m.renderLine(m.lines[m.selected], false)); end > m.view.YOffset+m.view.Height {
+			m.view.SetYOffset(end - m.view.Height)
+		}
+	}
+}
+
+func (m *tuiModel) renderLine(line tuiLine, selected bool) string {
+	at := time.Unix(line.Timestamp, 0)
+	stamp := at.Format("15:04")
+	if y, d := at.YearDay(), time.Now().YearDay(); y != d || at.Year() != time.Now().Year() {
+		stamp = at.Format("01-02 15:04")
+	}
+	name := tuiActiveStyle.Render(line.SenderName)
+	if line.Outgoing {
+		name = tuiDimStyle.Render(line.SenderName)
+	}
+	text := line.Text
+	if line.IsMentioned {
+		text = tuiMentionStyle.Render(text)
+	}
+	rendered := lipgloss.NewStyle().Width(m.view.Width).Render(tuiDimStyle.Render(stamp) + " " + name + ": " + text)
+	if selected {
+		return tuiSelectedStyle.Render(rendered)
+	}
+	return rendered
+}
+
+func (m *tuiModel) View() string {
+	if m.width == 0 {
+		return ""
+	}
+	height := m.height - 3
+
+	var list strings.Builder
+	for i, chat := range m.chats {
+		if i >= height {
+			break
+		}
+		if i > 0 {
+			list.WriteByte('\n')
+		}
+		name := chat.ChatName
+		if name == "" {
+			name = chat.ChatJID
+		}
+		badge := ""
+		if n := m.unread[chat.ChatJID]; n > 0 {
+			badge = fmt.Sprintf(" (%d)", n)
+		}
+		if m.drafts[chat.ChatJID] {
+			badge += " ✎"
+		}
+		// truncateRunes adds an ellipsis past the limit
+		name = truncateRunes(name, tuiChatListWidth-3-len([]rune(badge)))
+		row := lipgloss.NewStyle().Width(tuiChatListWidth - 2).Render(name + tuiUnreadStyle.Render(badge))
+		switch {
+		case i == m.cursor && m.focus == tuiFocusChats:
+			row = tuiSelectedStyle.Render(row)
+		case chat.ChatJID == m.open:
+			row = tuiActiveStyle.Render(row)
+		}
+		list.WriteString(row)
+	}
+	chats := tuiPaneStyle.Width(tuiChatListWidth - 2).Height(height).Render(list.String())
+
+	var right []string
+	if m.open == "" {
+		right = append(right, tuiDimStyle.Render("Pick a chat and press enter"))
+	} else {
+		right = append(right, tuiActiveStyle.Render(truncateRunes(m.chatName(m.open), m.view.Width-1)))
+	}
+	right = append(right, m.view.View())
+	if m.replyTo != nil {
+		right = append(right, tuiDimStyle.Render(truncateRunes("Replying to "+m.replyTo.SenderName+": "+m.replyTo.Text, m.view.Width-1)))
+	}
+	right = append(right, m.input.View())
+	messages := tuiPaneStyle.Width(m.view.Width).Height(height).Render(lipgloss.JoinVertical(lipgloss.Left, right...))
+
+	status := tuiDimStyle.Render(m.help())
+	if m.status != "" {
+		status = tuiErrorStyle.Render(m.status)
+	}
+	return lipgloss.JoinVertical(lipgloss.Left, lipgloss.JoinHorizontal(lipgloss.Top, chats, messages), status)
+}
+
+func (m *tuiModel) help() string {
+	switch m.focus {
+	case tuiFocusMessages:
+		return "↑/↓ select  r reply  tab compose  esc chats  ctrl+c quit"
+	case tuiFocusInput:
+		return "enter send  tab messages  esc cancel reply / chats  ctrl+c quit"
+	}
+	return "↑/↓ move  enter open  tab compose  q quit"
+}
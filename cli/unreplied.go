@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const unrepliedCheckInterval = 5 * time.Minute
+
+type UnrepliedChat struct {
+	ChatJID       string `json:"chat_jid"`
+	ChatName      string `json:"chat_name"`
+	IsGroup       bool   `json:"is_group"`
+	LastTimestamp int64  `json:"last_timestamp"`
+	LastSender    string `json:"last_sender"`
+	LastText      string `json:"last_text"`
+}
+
+// unrepliedChats lists chats whose latest message is incoming and older
+// than the given age, oldest first. Group chats only count when that
+// message mentions or replies to you.
+func (a *App) unrepliedChats(olderThan time.Duration) ([]UnrepliedChat, error) {
+	rows, err := a.msgDB.Query(fmt.Sprintf(`
+		SELECT m.chat_jid, m.chat_name, m.is_group, m.timestamp, m.sender_name, m.text, m.media_type, m.file_name
+		FROM %[1]s m
+		JOIN (SELECT chat_jid, MAX(id) AS last_id FROM %[1]s GROUP BY chat_jid) c ON m.id = c.last_id
+		LEFT JOIN chat_activity ca ON ca.chat_jid = m.chat_jid
+		WHERE m.timestamp < ?
+			AND COALESCE(ca.last_outgoing, 0) < m.timestamp
+			AND (m.is_group = 0 OR m.is_mentioned = 1 OR m.is_reply_to_me = 1)
+		ORDER BY m.timestamp
+	`, messagesView), time.Now().Add(-olderThan).Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chats := []UnrepliedChat{}
+	for rows.Next() {
+		var chat UnrepliedChat
+		var mediaType, fileName string
+		err := rows.Scan(&chat.ChatJID, &chat.ChatName, &chat.IsGroup, &chat.LastTimestamp, &chat.LastSender, &chat.LastText, &mediaType, &fileName)
+		if err != nil {
+			return nil, err
+		}
+		chat.LastText = labelMedia(mediaType, fileName, chat.LastText)
+		chats = append(chats, chat)
+	}
+	return chats, rows.Err()
+}
+
+// watchUnreplied raises a reply_owed event and notification once for each
+// message that has gone unanswered for UNREPLIED_NOTIFY_AFTER.
+func (a *App) watchUnreplied() {
+	if a.cfg().UnrepliedNotifyAfter == 0 {
+		return
+	}
+
+	notified := make(map[string]int64)
+	ticker := time.NewTicker(unrepliedCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		chats, err := a.unrepliedChats(a.cfg().UnrepliedNotifyAfter)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to check unreplied chats: %v\n", err)
+			continue
+		}
+		for _, chat := range chats {
+			if notified[chat.ChatJID] == chat.LastTimestamp {
+				continue
+			}
+			notified[chat.ChatJID] = chat.LastTimestamp
+
+			a.broadcastEvent("reply_owed", chat)
+			if a.currentNotifier() != nil {
+				a.sendNotification(Notification{
+					Title:    "Reply owed to " + chat.ChatName,
+					Body:     truncateRunes(chat.LastSender+": "+chat.LastText, notificationBodyLength),
+					Urgency:  "normal",
+					Category: "im",
+				})
+			}
+		}
+	}
+}
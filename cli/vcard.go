@@ -0,0 +1,184 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// SharedContact is a contact card parsed from an incoming vCard.
+type SharedContact struct {
+	Name         string   `json:"name"`
+	Phones       []string `json:"phones"`
+	WhatsAppJIDs []string `json:"whatsapp_jids"`
+	Emails       []string `json:"emails"`
+	Organization string   `json:"organization"`
+}
+
+// SharedContacts is stored as a JSON array in a single TEXT column.
+type SharedContacts []SharedContact
+
+func (c SharedContacts) Value() (driver.Value, error) {
+	if len(c) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(c)
+	return string(data), err
+}
+
+func (c *SharedContacts) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	}
+	*c = nil
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, c)
+}
+
+func (a *App) sendContact(chatJID string, name string, phone string, vcard string, priority sendPriority) (types.MessageID, error) {
+	jid, err := types.ParseJID(chatJID)
+	if err != nil {
+		return "", fmt.Errorf("invalid JID: %w", err)
+	}
+
+	if vcard == "" {
+		digits := strings.Map(func(r rune) rune {
+			if r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, phone)
+		if name == "" || digits == "" {
+			return "", fmt.Errorf("send_contact requires name and phone, or vcard")
+		}
+		vcard = buildVCard(name, digits)
+	} else if name == "" {
+		contact := parseVCard(vcard)
+		if contact.Name == "" {
+			return "", fmt.Errorf("vcard has no FN or N property, pass name")
+		}
+		name = contact.Name
+	}
+
+	msg := &waE2E.Message{ContactMessage: &waE2E.ContactMessage{
+		DisplayName: proto.String(name),
+		Vcard:       proto.String(vcard),
+	}}
+
+	resp, err := a.sendToChat(jid, msg, priority)
+	if err != nil {
+		return "", fmt.Errorf("send contact failed: %w", err)
+	}
+
+	fmt.Printf("Sent contact to %s\n", chatJID)
+	return resp.ID, nil
+}
+
+// buildVCard builds a minimal vCard whose waid parameter lets WhatsApp
+// offer to message the contact directly.
+func buildVCard(name string, digits string) string {
+	escaped := escapeVCard(name)
+	return strings.Join([]string{
+		"BEGIN:VCARD",
+		"VERSION:3.0",
+		"N:;" + escaped + ";;;",
+		"FN:" + escaped,
+		fmt.Sprintf("TEL;type=CELL;waid=%s:+%s", digits, digits),
+		"END:VCARD",
+	}, "\n")
+}
+
+// applyContacts parses shared contact cards into the structured contacts
+// column of the stored message.
+func applyContacts(message *Message, msg *waE2E.Message) {
+	if contact := msg.GetContactMessage(); contact != nil {
+		message.Contacts = SharedContacts{contactFromMessage(contact)}
+	} else if array := msg.GetContactsArrayMessage(); array != nil {
+		for _, contact := range array.GetContacts() {
+			message.Contacts = append(message.Contacts, contactFromMessage(contact))
+		}
+	}
+}
+
+func contactFromMessage(contact *waE2E.ContactMessage) SharedContact {
+	parsed := parseVCard(contact.GetVcard())
+	if name := contact.GetDisplayName(); name != "" {
+		parsed.Name = name
+	}
+	return parsed
+}
+
+// parseVCard extracts the name, phone numbers, WhatsApp IDs, emails and
+// organization from a vCard. Unknown properties are ignored.
+func parseVCard(vcard string) SharedContact {
+	var contact SharedContact
+	var structuredName string
+
+	// Lines starting with whitespace continue the previous line
+	vcard = strings.ReplaceAll(vcard, "\r\n", "\n")
+	vcard = strings.NewReplacer("\n ", "", "\n\t", "").Replace(vcard)
+
+	for _, line := range strings.Split(vcard, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		params := strings.Split(key, ";")
+		// Drop grouping prefixes such as "item1.TEL"
+		name := strings.ToUpper(params[0])
+		if i := strings.LastIndex(name, "."); i >= 0 {
+			name = name[i+1:]
+		}
+
+		switch name {
+		case "FN":
+			contact.Name = unescapeVCard(value)
+		case "N":
+			parts := strings.Split(value, ";")
+			var names []string
+			for _, i := range []int{3, 1, 2, 0, 4} {
+				if i < len(parts) && parts[i] != "" {
+					names = append(names, unescapeVCard(parts[i]))
+				}
+			}
+			structuredName = strings.Join(names, " ")
+		case "TEL":
+			contact.Phones = append(contact.Phones, value)
+			for _, param := range params[1:] {
+				if k, v, ok := strings.Cut(param, "="); ok && strings.EqualFold(k, "waid") && v != "" {
+					contact.WhatsAppJIDs = append(contact.WhatsAppJIDs, types.NewJID(v, types.DefaultUserServer).String())
+				}
+			}
+		case "EMAIL":
+			contact.Emails = append(contact.Emails, value)
+		case "ORG":
+			// Only the organization name, not its units
+			org, _, _ := strings.Cut(value, ";")
+			contact.Organization = unescapeVCard(org)
+		}
+	}
+
+	if contact.Name == "" {
+		contact.Name = structuredName
+	}
+	return contact
+}
+
+func escapeVCard(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\n", `\n`).Replace(s)
+}
+
+func unescapeVCard(s string) string {
+	return strings.NewReplacer(`\\`, `\`, `\,`, ",", `\;`, ";", `\n`, "\n", `\N`, "\n").Replace(s)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/store"
+)
+
+const latestVersionTimeout = 15 * time.Second
+
+type VersionInfo struct {
+	Wacli           string `json:"wacli_version"`
+	Whatsmeow       string `json:"whatsmeow_version"`
+	WAWeb           string `json:"wa_web_version"`
+	LatestWAWeb     string `json:"latest_wa_web_version,omitempty"`
+	ClientOutOfDate bool   `json:"client_outdated"`
+}
+
+func whatsmeowVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == "go.mau.fi/whatsmeow" {
+			return dep.Version
+		}
+	}
+	return "unknown"
+}
+
+func (a *App) versionInfo() VersionInfo {
+	a.versionMu.Lock()
+	defer a.versionMu.Unlock()
+
+	return VersionInfo{
+		Wacli:           version,
+		Whatsmeow:       whatsmeowVersion(),
+		WAWeb:           store.GetWAVersion().String(),
+		LatestWAWeb:     a.latestWAWeb,
+		ClientOutOfDate: a.clientOutdated,
+	}
+}
+
+// checkClientVersion compares the WhatsApp web version whatsmeow identifies
+// as with the one currently served by web.whatsapp.com. A stale version is
+// a common reason for logins suddenly failing, so it gets a loud warning.
+func (a *App) checkClientVersion() {
+	ctx, cancel := context.WithTimeout(a.ctx, latestVersionTimeout)
+	defer cancel()
+
+	latest, err := whatsmeow.GetLatestVersion(ctx, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to check latest WhatsApp web version: %v\n", err)
+		return
+	}
+
+	current := store.GetWAVersion()
+	stale := current.LessThan(*latest)
+
+	a.versionMu.Lock()
+	a.latestWAWeb = latest.String()
+	if stale {
+		a.clientOutdated = true
+	}
+	a.versionMu.Unlock()
+
+	if stale {
+		fmt.Fprintf(os.Stderr, "Warning: whatsmeow identifies as WhatsApp web %s but %s is current; update wacli if logins start failing\n", current, latest)
+	}
+}
+
+func (a *App) handleClientOutdated() {
+	a.versionMu.Lock()
+	a.clientOutdated = true
+	a.versionMu.Unlock()
+
+	fmt.Fprintf(os.Stderr, "WhatsApp rejected this client version (%s) as outdated; update wacli\n", store.GetWAVersion())
+	a.broadcastEvent("client_outdated", a.versionInfo())
+}
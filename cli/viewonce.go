@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// viewOnceExtensions names downloaded view-once media by kind; WhatsApp
+// sends view-once images as JPEG, videos as MP4 and voice notes as Opus.
+var viewOnceExtensions = map[string]string{
+	"image": ".jpg",
+	"video": ".mp4",
+	"audio": ".ogg",
+}
+
+// saveViewOnce marks view-once media in the stored text and, with
+// DOWNLOAD_VIEW_ONCE set, downloads it right away: once it has been opened
+// on a phone the media can't be fetched anymore.
+func (a *App) saveViewOnce(message *Message, evt *events.Message) {
+	if !evt.IsViewOnce {
+		return
+	}
+	message.IsViewOnce = true
+	message.Text = "[View once] " + message.Text
+
+	if !a.cfg().DownloadViewOnce {
+		return
+	}
+	media, mediaType := getMediaMessage(evt.Message)
+	if media == nil {
+		return
+	}
+	path, err := a.downloadMedia(media, "view_once", viewOnceExtensions[mediaType])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to download view-once media: %v\n", err)
+		return
+	}
+	message.MediaPath = path
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	voiceMimetype = "audio/ogg; codecs=opus"
+	// Waveforms are decoded at a low rate, enough for 64 amplitude buckets
+	waveformSampleRate = 8000
+	waveformBuckets    = 64
+)
+
+// sendVoice transcodes a local audio file to Opus with ffmpeg and sends it
+// as a push-to-talk voice note with duration and waveform.
+func (a *App) sendVoice(chatJID string, path string, priority sendPriority) (types.MessageID, error) {
+	jid, err := types.ParseJID(chatJID)
+	if err != nil {
+		return "", fmt.Errorf("invalid JID: %w", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+
+	ogg, err := a.ffmpeg("-i", path, "-vn", "-ac", "1", "-ar", "48000", "-c:a", "libopus", "-b:a", "32k",
+		"-application", "voip", "-f", "ogg", "pipe:1")
+	if err != nil {
+		return "", fmt.Errorf("transcode failed: %w", err)
+	}
+	pcm, err := a.ffmpeg("-i", path, "-vn", "-ac", "1", "-ar", fmt.Sprint(waveformSampleRate), "-f", "s16le", "pipe:1")
+	if err != nil {
+		return "", fmt.Errorf("decode failed: %w", err)
+	}
+	seconds := uint32((len(pcm)/2 + waveformSampleRate - 1) / waveformSampleRate)
+
+	upload, err := a.client.Upload(a.ctx, ogg, whatsmeow.MediaAudio)
+	if err != nil {
+		return "", fmt.Errorf("upload failed: %w", err)
+	}
+
+	msg := &waE2E.Message{AudioMessage: &waE2E.AudioMessage{
+		URL:           proto.String(upload.URL),
+		DirectPath:    proto.String(upload.DirectPath),
+		MediaKey:      upload.MediaKey,
+		FileEncSHA256: upload.FileEncSHA256,
+		FileSHA256:    upload.FileSHA256,
+		FileLength:    proto.Uint64(upload.FileLength),
+		Mimetype:      proto.String(voiceMimetype),
+		Seconds:       proto.Uint32(seconds),
+		PTT:           proto.Bool(true),
+		Waveform:      waveform(pcm),
+	}}
+
+	resp, err := a.sendToChat(jid, msg, priority)
+	if err != nil {
+		return "", fmt.Errorf("send voice failed: %w", err)
+	}
+
+	fmt.Printf("Sent voice note to %s\n", chatJID)
+	return resp.ID, nil
+}
+
+func (a *App) ffmpeg(args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(a.ctx, a.cfg().FFmpegPath, append([]string{"-hide_banner", "-loglevel", "error"}, args...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) > 0 {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// waveform reduces 16-bit PCM to the 64 peak values (0-100) WhatsApp draws
+// under a voice note.
+func waveform(pcm []byte) []byte {
+	samples := len(pcm) / 2
+	peaks := make([]int, waveformBuckets)
+	maxPeak := 1
+	for i := 0; i < samples; i++ {
+		sample := int(int16(binary.LittleEndian.Uint16(pcm[i*2:])))
+		if sample < 0 {
+			sample = -sample
+		}
+		bucket := i * waveformBuckets / samples
+		if sample > peaks[bucket] {
+			peaks[bucket] = sample
+		}
+		if sample > maxPeak {
+			maxPeak = sample
+		}
+	}
+
+	wave := make([]byte, waveformBuckets)
+	for i, peak := range peaks {
+		wave[i] = byte(peak * 100 / maxPeak)
+	}
+	return wave
+}
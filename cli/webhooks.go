@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+const webhookTimeout = 10 * time.Second
+
+// GroupWebhook posts events from one group to a URL. Empty Events means all
+// group events; an empty Template posts the GroupEvent as JSON.
+type GroupWebhook struct {
+	GroupJID    string            `json:"group_jid"`
+	URL         string            `json:"url"`
+	Events      []string          `json:"events,omitempty"`
+	Template    string            `json:"template,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+
+	tmpl *template.Template
+}
+
+// GroupEvent is the data a webhook template is executed with.
+type GroupEvent struct {
+	Event     string      `json:"event"`
+	GroupJID  string      `json:"group_jid"`
+	GroupName string      `json:"group_name"`
+	Timestamp int64       `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+var webhookFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"time": func(ts int64) string {
+		return time.Unix(ts, 0).Format(time.RFC3339)
+	},
+}
+
+func loadWebhooks(path string) ([]GroupWebhook, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var hooks []GroupWebhook
+	if err := json.Unmarshal(data, &hooks); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i := range hooks {
+		if hooks[i].Template == "" {
+			continue
+		}
+		hooks[i].tmpl, err = template.New(hooks[i].GroupJID).Funcs(webhookFuncs).Parse(hooks[i].Template)
+		if err != nil {
+			return nil, fmt.Errorf("parse template for %s: %w", hooks[i].GroupJID, err)
+		}
+	}
+	return hooks, nil
+}
+
+// emitGroupEvent posts an event to the webhooks configured for its group.
+// Deliveries run in the background and failures are only logged.
+func (a *App) emitGroupEvent(event string, group types.JID, data interface{}) {
+	var hooks []*GroupWebhook
+	webhooks := a.currentWebhooks()
+	for i := range webhooks {
+		hook := &webhooks[i]
+		if hook.GroupJID == group.String() && (len(hook.Events) == 0 || containsString(hook.Events, event)) {
+			hooks = append(hooks, hook)
+		}
+	}
+	if len(hooks) == 0 {
+		return
+	}
+
+	payload := GroupEvent{
+		Event:     event,
+		GroupJID:  group.String(),
+		Timestamp: time.Now().Unix(),
+		GroupName: a.groupName(group),
+		Data:      data,
+	}
+
+	for _, hook := range hooks {
+		a.goSafe("group webhook", func() {
+			if err := hook.post(payload); err != nil {
+				fmt.Fprintf(os.Stderr, "Webhook %s for %s failed: %v\n", event, hook.URL, err)
+			}
+		})
+	}
+}
+
+func (h *GroupWebhook) post(payload GroupEvent) error {
+	var body bytes.Buffer
+	contentType := h.ContentType
+	if h.tmpl != nil {
+		if err := h.tmpl.Execute(&body, payload); err != nil {
+			return err
+		}
+		if contentType == "" {
+			contentType = "text/plain; charset=utf-8"
+		}
+	} else {
+		if err := json.NewEncoder(&body).Encode(payload); err != nil {
+			return err
+		}
+		if contentType == "" {
+			contentType = "application/json"
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, h.URL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "wacli/"+strings.TrimPrefix(version, "v"))
+	for key, value := range h.Headers {
+		req.Header.Set(key, value)
+	}
+
+	client := &http.Client{Timeout: webhookTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
@@ -1,6 +1,5 @@
 import asyncio
 import json
-import sqlite3
 
 import pyperclip
 from textual.app import App, ComposeResult
@@ -8,10 +7,16 @@ from textual.binding import Binding
 from textual.widgets import Footer, Header, Input
 
 from tui.models import Call, Entry, Message
-from tui.utils import DB_PATH, SOCKET_PATH, log
+from tui.utils import DB_PATH, REMOTE, SOCKET_TOKEN, connect_db, log, open_socket
 from tui.widgets import ComposeInput, EntryWidget, MessageList
 
 
+def entry_key(entry: Entry) -> str:
+    if isinstance(entry, Call):
+        return entry.call_id
+    return entry.message_id or str(entry.id)
+
+
 class WaCLIApp(App):
     CSS = """
     Screen {
@@ -43,6 +48,12 @@ class WaCLIApp(App):
     ]
 
     HALF_PAGE = 15
+    # Without the database at hand, a remote TUI starts from recent history
+    REMOTE_HISTORY_LIMIT = 500
+    HISTORY_REQUEST_ID = "tui-history"
+    BACKLOG_REQUEST_ID = "tui-backlog"
+    DRAFTS_REQUEST_ID = "tui-drafts"
+    RECONNECT_DELAY = 2
 
     def __init__(self) -> None:
         super().__init__()
@@ -50,6 +61,11 @@ class WaCLIApp(App):
         self.selected_index: int = -1
         self.socket_writer: asyncio.StreamWriter | None = None
         self.compose_mode: str | None = None
+        self.compose_chat_jid: str | None = None
+        # Chat the open compose input is a draft for
+        self.draft_chat_jid: str | None = None
+        self.drafts: dict[str, str] = {}
+        self.history: list[Entry] = []
 
     def compose(self) -> ComposeResult:
         yield Header()
@@ -60,8 +76,9 @@ class WaCLIApp(App):
     async def on_mount(self) -> None:
         log("on_mount: start")
         self.title = "WhatsApp Messages"
-        self.load_entries_from_db()
-        self.render_entries()
+        if not REMOTE:
+            self.load_entries_from_db()
+            self.render_entries()
         log("on_mount: starting worker")
         self.run_worker(self.listen_socket(), exclusive=True)
 
@@ -69,12 +86,17 @@ class WaCLIApp(App):
         if not DB_PATH.exists():
             return
 
-        conn = sqlite3.connect(DB_PATH)
-        conn.row_factory = sqlite3.Row
+        conn = connect_db()
         cursor = conn.cursor()
 
         messages: list[Entry] = []
-        cursor.execute("SELECT * FROM messages")
+        # With PARTITION_BY_MONTH older months live in their own tables,
+        # which the messages_all view unions with the current one
+        cursor.execute(
+            "SELECT 1 FROM sqlite_master WHERE type = 'view' AND name = 'messages_all'"
+        )
+        table = "messages_all" if cursor.fetchone() else "messages"
+        cursor.execute(f"SELECT * FROM {table}")
         for row in cursor.fetchall():
             messages.append(
                 Message(
@@ -89,6 +111,12 @@ class WaCLIApp(App):
                     is_muted=bool(row["is_muted"]),
                     is_reply_to_me=bool(row["is_reply_to_me"]),
                     text=row["text"],
+                    quoted_message_id=row["quoted_message_id"],
+                    quoted_sender_jid=row["quoted_sender_jid"],
+                    quoted_text=row["quoted_text"],
+                    community_name=row["community_name"],
+                    media_type=row["media_type"],
+                    file_name=row["file_name"],
                 )
             )
 
@@ -141,10 +169,33 @@ class WaCLIApp(App):
         widgets[self.selected_index].scroll_visible()
 
     async def listen_socket(self) -> None:
+        while True:
+            try:
+                await self.read_socket()
+            except (ConnectionError, OSError) as e:
+                log(f"listen_socket: {e}")
+            self.socket_writer = None
+            self.notify("Lost connection to the daemon, reconnecting...", severity="warning")
+            await asyncio.sleep(self.RECONNECT_DELAY)
+
+    async def read_socket(self) -> None:
         log("listen_socket: connecting...")
-        reader, writer = await asyncio.open_unix_connection(SOCKET_PATH)
+        reader, writer = await open_socket()
         self.socket_writer = writer
         log("listen_socket: connected")
+        if SOCKET_TOKEN:
+            writer.write((json.dumps({"action": "auth", "token": SOCKET_TOKEN}) + "\n").encode())
+            await writer.drain()
+        # Catch up on whatever arrived since the newest entry we have
+        if self.entries:
+            request = {"action": "backlog", "request_id": self.BACKLOG_REQUEST_ID, "since": self.entries[-1].timestamp}
+        elif REMOTE:
+            request = {"action": "history", "request_id": self.HISTORY_REQUEST_ID, "limit": self.REMOTE_HISTORY_LIMIT}
+        else:
+            request = {"action": "backlog", "request_id": self.BACKLOG_REQUEST_ID, "since": 0}
+        writer.write((json.dumps(request) + "\n").encode())
+        writer.write((json.dumps({"action": "list_drafts", "request_id": self.DRAFTS_REQUEST_ID}) + "\n").encode())
+        await writer.drain()
         while True:
             line = await reader.readline()
             log(f"listen_socket: got line: {line}")
@@ -152,7 +203,21 @@ class WaCLIApp(App):
                 raise ConnectionError("Socket connection closed")
             event = json.loads(line.decode())
             entry_type = event["type"]
-            data = event["data"]
+            data = event.get("data")
+            if event.get("request_id") == self.HISTORY_REQUEST_ID:
+                if entry_type == "row":
+                    self.history.append(self.message_from_data(data))
+                elif entry_type == "result":
+                    self.entries = sorted(self.history + self.entries, key=lambda e: e.timestamp)
+                    self.history = []
+                    self.render_entries()
+                elif entry_type == "error":
+                    self.notify(f"Failed to load history: {event.get('error')}", severity="error")
+                continue
+            if event.get("request_id") == self.DRAFTS_REQUEST_ID:
+                if entry_type == "result":
+                    self.drafts = {draft["chat_jid"]: draft["text"] for draft in data}
+                continue
             entry: Entry
             if entry_type == "call":
                 entry = Call(
@@ -167,22 +232,26 @@ class WaCLIApp(App):
                 )
                 log(f"listen_socket: parsed call from {entry.caller_name}")
             elif entry_type == "message":
-                entry = Message(
-                    id=data.get("id", 0),
-                    message_id=data.get("message_id", ""),
-                    timestamp=data["timestamp"],
-                    chat_jid=data["chat_jid"],
-                    chat_name=data["chat_name"],
-                    sender_jid=data["sender_jid"],
-                    sender_name=data["sender_name"],
-                    is_group=data["is_group"],
-                    is_muted=data["is_muted"],
-                    is_reply_to_me=data["is_reply_to_me"],
-                    text=data["text"],
-                )
+                entry = self.message_from_data(data)
                 log(f"listen_socket: parsed message: {entry.text}")
+            elif entry_type == "logged_out":
+                self.notify(
+                    "WhatsApp logged out, scan the QR code printed by the daemon to link again",
+                    severity="error",
+                    timeout=30,
+                )
+                continue
+            elif entry_type == "open_chat":
+                self.open_chat(data["chat_jid"], data.get("text", ""))
+                continue
+            elif entry_type == "logged_in":
+                self.notify("WhatsApp linked again")
+                continue
             else:
-                raise ValueError(f"Unexpected entry type: {entry_type}")
+                log(f"listen_socket: ignoring {entry_type} event")
+                continue
+            if event.get("request_id") == self.BACKLOG_REQUEST_ID and self.is_known(entry):
+                continue
             self.entries.append(entry)
             message_list = self.query_one(MessageList)
             was_at_end = self.selected_index == len(self.entries) - 2
@@ -191,6 +260,37 @@ class WaCLIApp(App):
                 self.update_selection(len(self.entries) - 1)
             log("listen_socket: widget mounted")
 
+    def is_known(self, entry: Entry) -> bool:
+        # The backlog starts at the newest entry's second, so it repeats those
+        for known in reversed(self.entries):
+            if known.timestamp < entry.timestamp:
+                return False
+            if type(known) is type(entry) and entry_key(known) == entry_key(entry):
+                return True
+        return False
+
+    @staticmethod
+    def message_from_data(data: dict) -> Message:
+        return Message(
+            id=data.get("id", 0),
+            message_id=data.get("message_id", ""),
+            timestamp=data["timestamp"],
+            chat_jid=data["chat_jid"],
+            chat_name=data["chat_name"],
+            sender_jid=data["sender_jid"],
+            sender_name=data["sender_name"],
+            is_group=data["is_group"],
+            is_muted=data["is_muted"],
+            is_reply_to_me=data["is_reply_to_me"],
+            text=data["text"],
+            quoted_message_id=data.get("quoted_message_id", ""),
+            quoted_sender_jid=data.get("quoted_sender_jid", ""),
+            quoted_text=data.get("quoted_text", ""),
+            community_name=data.get("community_name", ""),
+            media_type=data.get("media_type", ""),
+            file_name=data.get("file_name", ""),
+        )
+
     def action_select_next(self) -> None:
         self.update_selection(self.selected_index + 1)
 
@@ -228,8 +328,10 @@ class WaCLIApp(App):
         if isinstance(entry, Call):
             return
         self.compose_mode = "send"
+        self.draft_chat_jid = entry.chat_jid
         compose_input = self.query_one(ComposeInput)
         compose_input.placeholder = f"Message to {entry.chat_name}..."
+        compose_input.value = self.drafts.get(entry.chat_jid, "")
         compose_input.add_class("visible")
         compose_input.focus()
 
@@ -240,8 +342,27 @@ class WaCLIApp(App):
         if isinstance(entry, Call):
             return
         self.compose_mode = "reply"
+        self.draft_chat_jid = entry.chat_jid
         compose_input = self.query_one(ComposeInput)
         compose_input.placeholder = f"Reply to {entry.sender_name}..."
+        compose_input.value = self.drafts.get(entry.chat_jid, "")
+        compose_input.add_class("visible")
+        compose_input.focus()
+
+    def open_chat(self, chat_jid: str, text: str) -> None:
+        name = chat_jid
+        for index in range(len(self.entries) - 1, -1, -1):
+            entry = self.entries[index]
+            if isinstance(entry, Message) and entry.chat_jid == chat_jid:
+                self.update_selection(index)
+                name = entry.chat_name
+                break
+        self.compose_mode = "send"
+        self.compose_chat_jid = chat_jid
+        self.draft_chat_jid = chat_jid
+        compose_input = self.query_one(ComposeInput)
+        compose_input.placeholder = f"Message to {name}..."
+        compose_input.value = text or self.drafts.get(chat_jid, "")
         compose_input.add_class("visible")
         compose_input.focus()
 
@@ -250,6 +371,25 @@ class WaCLIApp(App):
         compose_input.value = ""
         compose_input.remove_class("visible")
         self.compose_mode = None
+        self.compose_chat_jid = None
+        self.draft_chat_jid = None
+
+    # Drafts live in the daemon so they outlast the TUI; empty text clears one
+    def save_draft(self, text: str) -> None:
+        chat_jid = self.draft_chat_jid
+        if not chat_jid or self.drafts.get(chat_jid, "") == text:
+            return
+        if text:
+            self.drafts[chat_jid] = text
+        else:
+            self.drafts.pop(chat_jid, None)
+        if self.socket_writer:
+            payload = {"action": "save_draft", "chat_jid": chat_jid, "text": text}
+            self.socket_writer.write((json.dumps(payload) + "\n").encode())
+
+    def cancel_compose(self) -> None:
+        self.save_draft(self.query_one(ComposeInput).value.strip())
+        self.hide_compose()
 
     async def on_input_submitted(self, event: Input.Submitted) -> None:
         text = event.value.strip()
@@ -258,7 +398,7 @@ class WaCLIApp(App):
             return
 
         entry = self.get_selected_entry()
-        if not entry or isinstance(entry, Call):
+        if not self.compose_chat_jid and (not entry or isinstance(entry, Call)):
             self.hide_compose()
             return
 
@@ -270,10 +410,17 @@ class WaCLIApp(App):
         if self.compose_mode == "send":
             payload = {
                 "action": "send",
-                "chat_jid": entry.chat_jid,
+                "chat_jid": self.compose_chat_jid or entry.chat_jid,
+                "text": text,
+            }
+        elif self.compose_mode == "reply" and entry.id:
+            payload = {
+                "action": "reply_to_id",
+                "id": entry.id,
                 "text": text,
             }
         elif self.compose_mode == "reply":
+            # Broadcast but not stored, so there is no row to refer to
             payload = {
                 "action": "reply",
                 "chat_jid": entry.chat_jid,
@@ -287,5 +434,6 @@ class WaCLIApp(App):
 
         log(f"Sending: {payload}")
         self.socket_writer.write((json.dumps(payload) + "\n").encode())
+        self.save_draft("")
         await self.socket_writer.drain()
         self.hide_compose()
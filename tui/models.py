@@ -2,6 +2,15 @@ from dataclasses import dataclass
 from datetime import datetime
 
 
+MEDIA_LABELS = {
+    "image": "[Image]",
+    "video": "[Video]",
+    "audio": "[Audio]",
+    "document": "[Document]",
+    "sticker": "[Sticker]",
+}
+
+
 @dataclass
 class Message:
     id: int
@@ -15,6 +24,22 @@ class Message:
     is_muted: bool
     is_reply_to_me: bool
     text: str
+    quoted_message_id: str = ""
+    quoted_sender_jid: str = ""
+    quoted_text: str = ""
+    community_name: str = ""
+    media_type: str = ""
+    file_name: str = ""
+
+    @property
+    def display_text(self) -> str:
+        # text only holds the caption of media messages
+        label = MEDIA_LABELS.get(self.media_type)
+        if label is None:
+            return self.text
+        if self.file_name:
+            label += f" {self.file_name}"
+        return f"{label} {self.text}" if self.text else label
 
     @property
     def formatted_time(self) -> str:
@@ -1,13 +1,71 @@
+import asyncio
+import os
+import sqlite3
+import ssl
 from datetime import datetime
 from pathlib import Path
 
-RUNTIME_DIR = Path("/tmp/rlocal/wacli")
+CLI_DIR = Path(__file__).parent.parent / "cli"
+
+
+def _socket_path() -> Path:
+    if path := os.environ.get("WACLI_SOCKET_PATH"):
+        return Path(path)
+    if runtime_dir := os.environ.get("XDG_RUNTIME_DIR"):
+        return Path(runtime_dir) / "wacli" / "wacli.sock"
+    return Path("/tmp/rlocal/wacli/wacli.sock")
+
+
+def _data_dir() -> Path:
+    # Same resolution as the daemon, which runs from cli/
+    if path := os.environ.get("WACLI_DATA_DIR"):
+        return CLI_DIR / path
+    if (CLI_DIR / "wacli.db").exists():
+        return CLI_DIR
+    if data_home := os.environ.get("XDG_DATA_HOME"):
+        return Path(data_home) / "wacli"
+    return Path.home() / ".local" / "share" / "wacli"
+
+
+RUNTIME_DIR = _socket_path().parent
 RUNTIME_DIR.mkdir(parents=True, exist_ok=True)
 
 LOG_FILE = RUNTIME_DIR / "wacli.log"
-SOCKET_PATH = str(RUNTIME_DIR / "wacli.sock")
+SOCKET_PATH = str(_socket_path())
+SOCKET_TOKEN = os.environ.get("WACLI_TOKEN", "")
+
+# A daemon on another machine, reached over its TLS listener
+REMOTE = os.environ.get("WACLI_REMOTE", "")
+REMOTE_CA = os.environ.get("WACLI_REMOTE_CA", "")
+REMOTE_CERT = os.environ.get("WACLI_REMOTE_CERT", "")
+REMOTE_KEY = os.environ.get("WACLI_REMOTE_KEY", "")
+
+DB_PATH = _data_dir() / "messages.db"
+DB_KEY = os.environ.get("WACLI_DB_KEY", "")
+
+
+def connect_db():
+    # An encrypted database needs the sqlcipher3 package
+    if not DB_KEY:
+        conn = sqlite3.connect(DB_PATH)
+        conn.row_factory = sqlite3.Row
+        return conn
+    import sqlcipher3
+
+    conn = sqlcipher3.connect(str(DB_PATH))
+    conn.execute("PRAGMA key = '%s'" % DB_KEY.replace("'", "''"))
+    conn.row_factory = sqlcipher3.Row
+    return conn
+
 
-DB_PATH = Path(__file__).parent.parent / "cli" / "messages.db"
+async def open_socket() -> tuple[asyncio.StreamReader, asyncio.StreamWriter]:
+    if not REMOTE:
+        return await asyncio.open_unix_connection(SOCKET_PATH)
+    host, _, port = REMOTE.rpartition(":")
+    ctx = ssl.create_default_context(cafile=REMOTE_CA or None)
+    if REMOTE_CERT:
+        ctx.load_cert_chain(REMOTE_CERT, REMOTE_KEY or None)
+    return await asyncio.open_connection(host.strip("[]"), int(port), ssl=ctx)
 
 
 def log(msg: str) -> None:
@@ -1,5 +1,6 @@
 from typing import TYPE_CHECKING
 
+from rich.markup import escape
 from textual.binding import Binding
 from textual.containers import ScrollableContainer
 from textual.widgets import Input, Static
@@ -33,8 +34,13 @@ class EntryWidget(Static):
         indicator = ">" if self.has_class("selected") else " "
         if isinstance(self.entry, Message):
             msg = self.entry
-            text_oneline = msg.text.replace("\n", " ")
-            if msg.is_group:
+            text_oneline = msg.display_text.replace("\n", " ")
+            if msg.quoted_text:
+                quoted_oneline = msg.quoted_text.replace("\n", " ")
+                text_oneline = f"[dim]↪ {escape(quoted_oneline)} │[/] {text_oneline}"
+            if msg.is_group and msg.community_name:
+                title = f"{msg.title} [bold magenta]👥[/] [magenta]{msg.community_name} › {msg.chat_name}[/]"
+            elif msg.is_group:
                 title = f"{msg.title} [bold magenta]👥[/] [magenta]{msg.chat_name}[/]"
             else:
                 title = msg.title
@@ -68,4 +74,4 @@ class ComposeInput(Input):
     app: "WaCLIApp"
 
     def action_cancel(self) -> None:
-        self.app.hide_compose()
+        self.app.cancel_compose()